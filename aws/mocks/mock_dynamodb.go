@@ -1,6 +1,8 @@
 package mocks
 
 import (
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/request"
 	"github.com/aws/aws-sdk-go/service/dynamodb"
 	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbiface"
 )
@@ -10,6 +12,12 @@ type MockDynamoDBClient struct {
 
 	PutItemInputs    []*dynamodb.PutItemInput
 	DeleteItemInputs []*dynamodb.DeleteItemInput
+	GetItemInputs    []*dynamodb.GetItemInput
+	UpdateItemInputs []*dynamodb.UpdateItemInput
+
+	GetItemResp    *dynamodb.GetItemOutput
+	UpdateItemResp *dynamodb.UpdateItemOutput
+	Error          error
 }
 
 func (m *MockDynamoDBClient) init() {
@@ -20,14 +28,50 @@ func (m *MockDynamoDBClient) init() {
 	if m.DeleteItemInputs == nil {
 		m.DeleteItemInputs = []*dynamodb.DeleteItemInput{}
 	}
+
+	if m.GetItemResp == nil {
+		m.GetItemResp = &dynamodb.GetItemOutput{}
+	}
+
+	if m.UpdateItemResp == nil {
+		m.UpdateItemResp = &dynamodb.UpdateItemOutput{}
+	}
 }
 
 func (m *MockDynamoDBClient) PutItem(input *dynamodb.PutItemInput) (*dynamodb.PutItemOutput, error) {
 	m.PutItemInputs = append(m.PutItemInputs, input)
-	return &dynamodb.PutItemOutput{}, nil
+	return &dynamodb.PutItemOutput{}, m.Error
+}
+
+func (m *MockDynamoDBClient) PutItemWithContext(_ aws.Context, input *dynamodb.PutItemInput, _ ...request.Option) (*dynamodb.PutItemOutput, error) {
+	return m.PutItem(input)
 }
 
 func (m *MockDynamoDBClient) DeleteItem(input *dynamodb.DeleteItemInput) (*dynamodb.DeleteItemOutput, error) {
 	m.DeleteItemInputs = append(m.DeleteItemInputs, input)
-	return &dynamodb.DeleteItemOutput{}, nil
+	return &dynamodb.DeleteItemOutput{}, m.Error
+}
+
+func (m *MockDynamoDBClient) DeleteItemWithContext(_ aws.Context, input *dynamodb.DeleteItemInput, _ ...request.Option) (*dynamodb.DeleteItemOutput, error) {
+	return m.DeleteItem(input)
+}
+
+func (m *MockDynamoDBClient) GetItem(input *dynamodb.GetItemInput) (*dynamodb.GetItemOutput, error) {
+	m.init()
+	m.GetItemInputs = append(m.GetItemInputs, input)
+	return m.GetItemResp, m.Error
+}
+
+func (m *MockDynamoDBClient) GetItemWithContext(_ aws.Context, input *dynamodb.GetItemInput, _ ...request.Option) (*dynamodb.GetItemOutput, error) {
+	return m.GetItem(input)
+}
+
+func (m *MockDynamoDBClient) UpdateItem(input *dynamodb.UpdateItemInput) (*dynamodb.UpdateItemOutput, error) {
+	m.init()
+	m.UpdateItemInputs = append(m.UpdateItemInputs, input)
+	return m.UpdateItemResp, m.Error
+}
+
+func (m *MockDynamoDBClient) UpdateItemWithContext(_ aws.Context, input *dynamodb.UpdateItemInput, _ ...request.Option) (*dynamodb.UpdateItemOutput, error) {
+	return m.UpdateItem(input)
 }
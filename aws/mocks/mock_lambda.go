@@ -1,6 +1,8 @@
 package mocks
 
 import (
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/request"
 	"github.com/aws/aws-sdk-go/service/lambda"
 	"github.com/aws/aws-sdk-go/service/lambda/lambdaiface"
 )
@@ -10,6 +12,10 @@ type MockLambdaClient struct {
 	UpdateFunctionCodeResp  *lambda.FunctionConfiguration
 	UpdateFunctionCodeError error
 	ListTagsResp            *lambda.ListTagsOutput
+
+	InvokeResp   *lambda.InvokeOutput
+	InvokeError  error
+	InvokeInputs []*lambda.InvokeInput
 }
 
 func (m *MockLambdaClient) init() {
@@ -27,3 +33,15 @@ func (m *MockLambdaClient) ListTags(in *lambda.ListTagsInput) (*lambda.ListTagsO
 	m.init()
 	return m.ListTagsResp, nil
 }
+
+func (m *MockLambdaClient) Invoke(in *lambda.InvokeInput) (*lambda.InvokeOutput, error) {
+	m.InvokeInputs = append(m.InvokeInputs, in)
+	if m.InvokeResp == nil {
+		return &lambda.InvokeOutput{StatusCode: aws.Int64(200)}, m.InvokeError
+	}
+	return m.InvokeResp, m.InvokeError
+}
+
+func (m *MockLambdaClient) InvokeWithContext(_ aws.Context, in *lambda.InvokeInput, _ ...request.Option) (*lambda.InvokeOutput, error) {
+	return m.Invoke(in)
+}
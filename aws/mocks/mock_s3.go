@@ -5,6 +5,7 @@ import (
 	"fmt"
 	"io"
 	"io/ioutil"
+	"sort"
 	"strings"
 	"time"
 
@@ -136,6 +137,37 @@ func (m *MockS3Client) ListObjects(in *s3.ListObjectsInput) (*s3.ListObjectsOutp
 	return nil, nil
 }
 
+// ListObjectsV2 lists the mocked objects with the Prefix in one page, ignoring the Bucket
+func (m *MockS3Client) ListObjectsV2(in *s3.ListObjectsV2Input) (*s3.ListObjectsV2Output, error) {
+	m.init()
+
+	keys := []string{}
+	for key := range m.GetObjectResp {
+		if in.Prefix == nil || strings.HasPrefix(key, *in.Prefix) {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+
+	contents := []*s3.Object{}
+	for _, key := range keys {
+		contents = append(contents, &s3.Object{
+			Key:          to.Strp(key),
+			Size:         to.Int64p(int64(len(m.GetObjectResp[key].Body))),
+			ETag:         to.Strp(fmt.Sprintf("%q", key)),
+			StorageClass: to.Strp("STANDARD"),
+		})
+	}
+
+	return &s3.ListObjectsV2Output{
+		Name:        in.Bucket,
+		Prefix:      in.Prefix,
+		Contents:    contents,
+		KeyCount:    to.Int64p(int64(len(contents))),
+		IsTruncated: to.Boolp(false),
+	}, nil
+}
+
 func (m *MockS3Client) PutObject(in *s3.PutObjectInput) (*s3.PutObjectOutput, error) {
 	m.init()
 
@@ -78,6 +78,7 @@ func Test_DeployHandler_Execution_Errors_BadInput(t *testing.T) {
 	exec, err := state_machine.Execute("{}")
 
 	assert.Error(t, err)
+	assert.Equal(t, "NotifyError", exec.ErrorName)
 	assert.Regexp(t, "BadReleaseError", exec.LastOutputJSON)
 	assertNoRootLockNoReleseLock(t, awsc, release)
 
@@ -412,6 +413,7 @@ func Test_DeployHandler_Execution_Errors_DeployLambdaError(t *testing.T) {
 	exec, err := state_machine.Execute(release)
 
 	assert.Error(t, err)
+	assert.Equal(t, "AlertError", exec.ErrorName)
 	assert.Regexp(t, "DeployLambdaError", exec.LastOutputJSON)
 	assert.Regexp(t, "AWSLambdaError", exec.LastOutputJSON)
 
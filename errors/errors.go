@@ -2,9 +2,55 @@
 package errors
 
 import (
+	"errors"
 	"fmt"
+	"reflect"
 )
 
+//
+// Step Errors
+//
+
+// StepError is an error with an ASL error name and cause, Retry and Catch ErrorEquals match its ErrorName.
+// aws-lambda-go sends the type name of an error as its errorType, so a Lambda handler's StepError should be named after its type
+type StepError interface {
+	error
+	ErrorName() string
+	ErrorCause() string
+}
+
+// AsStepError returns the first StepError in the chain of err
+func AsStepError(err error) (StepError, bool) {
+	var se StepError
+	if err == nil || !errors.As(err, &se) {
+		return nil, false
+	}
+	return se, true
+}
+
+// ErrorName returns the name of the first StepError in the chain of err,
+// otherwise the type name of err like aws-lambda-go
+func ErrorName(err error) string {
+	if se, ok := AsStepError(err); ok {
+		return se.ErrorName()
+	}
+
+	errorType := reflect.TypeOf(err)
+	if errorType.Kind() == reflect.Ptr {
+		return errorType.Elem().Name()
+	}
+	return errorType.Name()
+}
+
+// ErrorCause returns the cause of the first StepError in the chain of err, otherwise its message.
+// The errors in this package use their message, which is the errorMessage aws-lambda-go sends
+func ErrorCause(err error) string {
+	if se, ok := AsStepError(err); ok {
+		return se.ErrorCause()
+	}
+	return err.Error()
+}
+
 //
 // General Errors that represent levels of action to be taken
 //
@@ -17,6 +63,14 @@ func (e AlertError) Error() string {
 	return fmt.Sprintf("AlertError: %v", e.Cause)
 }
 
+func (e AlertError) ErrorName() string {
+	return "AlertError"
+}
+
+func (e AlertError) ErrorCause() string {
+	return e.Error()
+}
+
 type NotifyError struct {
 	Cause string
 }
@@ -25,6 +79,14 @@ func (e NotifyError) Error() string {
 	return fmt.Sprintf("NotifyError: %v", e.Cause)
 }
 
+func (e NotifyError) ErrorName() string {
+	return "NotifyError"
+}
+
+func (e NotifyError) ErrorCause() string {
+	return e.Error()
+}
+
 type LogError struct {
 	Cause string
 }
@@ -33,6 +95,14 @@ func (e LogError) Error() string {
 	return fmt.Sprintf("LogError: %v", e.Cause)
 }
 
+func (e LogError) ErrorName() string {
+	return "LogError"
+}
+
+func (e LogError) ErrorCause() string {
+	return e.Error()
+}
+
 //
 // Low Level Step Errors
 //
@@ -45,6 +115,14 @@ func (e UnmarshalError) Error() string {
 	return fmt.Sprintf("UnmarshalError: %v", e.Cause)
 }
 
+func (e UnmarshalError) ErrorName() string {
+	return "UnmarshalError"
+}
+
+func (e UnmarshalError) ErrorCause() string {
+	return e.Error()
+}
+
 type PanicError struct {
 	Cause string
 }
@@ -53,6 +131,14 @@ func (e PanicError) Error() string {
 	return fmt.Sprintf("PanicError: %v", e.Cause)
 }
 
+func (e PanicError) ErrorName() string {
+	return "PanicError"
+}
+
+func (e PanicError) ErrorCause() string {
+	return e.Error()
+}
+
 //
 // Specific Deploy/Release errors
 //
@@ -66,6 +152,14 @@ func (e BadReleaseError) Error() string {
 	return fmt.Sprintf("BadReleaseError: %v", e.Cause)
 }
 
+func (e BadReleaseError) ErrorName() string {
+	return "BadReleaseError"
+}
+
+func (e BadReleaseError) ErrorCause() string {
+	return e.Error()
+}
+
 // LockExistsError error
 type LockExistsError struct {
 	Cause string
@@ -75,6 +169,14 @@ func (e LockExistsError) Error() string {
 	return fmt.Sprintf("LockExistsError: %v", e.Cause)
 }
 
+func (e LockExistsError) ErrorName() string {
+	return "LockExistsError"
+}
+
+func (e LockExistsError) ErrorCause() string {
+	return e.Error()
+}
+
 // LockError error
 type LockError struct {
 	Cause string
@@ -84,6 +186,14 @@ func (e LockError) Error() string {
 	return fmt.Sprintf("LockError: %v", e.Cause)
 }
 
+func (e LockError) ErrorName() string {
+	return "LockError"
+}
+
+func (e LockError) ErrorCause() string {
+	return e.Error()
+}
+
 // DeployError error
 type DeployError struct {
 	Cause string
@@ -93,6 +203,14 @@ func (e DeployError) Error() string {
 	return fmt.Sprintf("DeployError: %v", e.Cause)
 }
 
+func (e DeployError) ErrorName() string {
+	return "DeployError"
+}
+
+func (e DeployError) ErrorCause() string {
+	return e.Error()
+}
+
 // HealthError error
 type HealthError struct {
 	Cause string
@@ -102,6 +220,14 @@ func (e HealthError) Error() string {
 	return fmt.Sprintf("HealthError: %v", e.Cause)
 }
 
+func (e HealthError) ErrorName() string {
+	return "HealthError"
+}
+
+func (e HealthError) ErrorCause() string {
+	return e.Error()
+}
+
 // HaltError error
 type HaltError struct {
 	Cause string
@@ -111,6 +237,14 @@ func (e HaltError) Error() string {
 	return fmt.Sprintf("HaltError: %v", e.Cause)
 }
 
+func (e HaltError) ErrorName() string {
+	return "HaltError"
+}
+
+func (e HaltError) ErrorCause() string {
+	return e.Error()
+}
+
 // CleanUpError error
 type CleanUpError struct {
 	Cause string
@@ -120,6 +254,14 @@ func (e CleanUpError) Error() string {
 	return fmt.Sprintf("CleanUpError: %v", e.Cause)
 }
 
+func (e CleanUpError) ErrorName() string {
+	return "CleanUpError"
+}
+
+func (e CleanUpError) ErrorCause() string {
+	return e.Error()
+}
+
 func throw(err error) error {
 	fmt.Printf(err.Error())
 	return err
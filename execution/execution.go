@@ -195,6 +195,30 @@ func (e *Execution) GetStateDetails(sfnc sfniface.SFNAPI) (*StateDetails, error)
 	return &sd, nil
 }
 
+// GetHistory returns every event of the execution in order, e.g. to replay it locally
+func (e *Execution) GetHistory(sfnc sfniface.SFNAPI) ([]*sfn.HistoryEvent, error) {
+	events := []*sfn.HistoryEvent{}
+	var next_token *string
+
+	for {
+		history_out, err := sfnc.GetExecutionHistory(&sfn.GetExecutionHistoryInput{
+			ExecutionArn: e.ExecutionArn,
+			NextToken:    next_token,
+		})
+
+		if err != nil {
+			return nil, err
+		}
+
+		events = append(events, history_out.Events...)
+
+		if history_out.NextToken == nil {
+			return events, nil
+		}
+		next_token = history_out.NextToken
+	}
+}
+
 // WaitForExecution allows another application to wait for the execution to finish
 // and process output as it comes in for usability
 func (e *Execution) WaitForExecution(sfnc sfniface.SFNAPI, sleep int, fn ExecutionWaiter) {
@@ -142,6 +142,38 @@ func (message *RawMessage) UnmarshalJSON(data []byte) error {
 	return nil
 }
 
+//////
+// Heartbeat
+//////
+
+type heartbeatKey struct{}
+
+// WithHeartbeat returns a context a handler can send heartbeats through with SendHeartbeat,
+// and the channel the heartbeats are received on
+func WithHeartbeat(ctx context.Context) (context.Context, <-chan struct{}) {
+	heartbeats := make(chan struct{}, 1)
+	return context.WithValue(ctx, heartbeatKey{}, heartbeats), heartbeats
+}
+
+// SendHeartbeat lets a long running handler report it is still alive.
+// Outside of a WithHeartbeat context it does nothing
+func SendHeartbeat(ctx context.Context) {
+	if ctx == nil {
+		return
+	}
+
+	heartbeats, ok := ctx.Value(heartbeatKey{}).(chan struct{})
+	if !ok {
+		return
+	}
+
+	// Never block the handler, one pending heartbeat is enough
+	select {
+	case heartbeats <- struct{}{}:
+	default:
+	}
+}
+
 ///////////
 // Errors
 ///////////
@@ -219,10 +251,20 @@ func recoveryError(r interface{}) error {
 
 }
 
+// lambdaError returns the error as aws-lambda-go should report it. aws-lambda-go sends the type name
+// of an error as its errorType and its message as the errorMessage, so a wrapped StepError is
+// returned itself to be routed on the same name locally and deployed
+func lambdaError(err error) error {
+	if se, ok := errors.AsStepError(err); ok {
+		return se
+	}
+	return err
+}
+
 // HANDLERS
 
-// CallHandler calls a TaskReflections Handler with the correct objects using reflection
-// Mostly borrowed from the aws-lambda-go package
+// CallHandler calls a TaskReflections Handler with the correct objects using reflection,
+// a handler error is returned as aws-lambda-go reports it. Mostly borrowed from the aws-lambda-go package
 func CallHandler(reflection TaskReflection, ctx context.Context, input []byte) (ret interface{}, err error) {
 	defer func() {
 		if r := recover(); r != nil {
@@ -250,7 +292,7 @@ func CallHandler(reflection TaskReflection, ctx context.Context, input []byte) (
 	response := reflection.Handler.Call(args)
 
 	if errVal, ok := response[1].Interface().(error); ok {
-		err = errVal
+		err = lambdaError(errVal)
 	}
 	ret = response[0].Interface()
 
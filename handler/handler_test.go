@@ -3,8 +3,10 @@ package handler
 import (
 	"context"
 	"encoding/json"
+	"fmt"
 	"testing"
 
+	"github.com/cleardataeng/step/errors"
 	"github.com/cleardataeng/step/utils/to"
 	"github.com/stretchr/testify/assert"
 )
@@ -89,3 +91,48 @@ func Test_Handler_Failure(t *testing.T) {
 	_, err = handle(nil, &RawMessage{Task: to.Strp("Tester")})
 	assert.Error(t, err)
 }
+
+func Test_Handler_SendHeartbeat(t *testing.T) {
+	// Noop without heartbeat context
+	SendHeartbeat(nil)
+	SendHeartbeat(context.Background())
+
+	ctx, heartbeats := WithHeartbeat(context.Background())
+
+	// Does not block on multiple heartbeats
+	SendHeartbeat(ctx)
+	SendHeartbeat(ctx)
+
+	select {
+	case <-heartbeats:
+	default:
+		assert.Fail(t, "heartbeat not received")
+	}
+}
+
+func Test_Handler_Wrapped_StepError(t *testing.T) {
+	tm := TaskHandlers{"Tester": func(_ context.Context, _ *TestStruct) (interface{}, error) {
+		return nil, fmt.Errorf("locking: %w", errors.LockExistsError{Cause: "locked"})
+	}}
+	handle, err := CreateHandler(&tm)
+	assert.NoError(t, err)
+
+	_, err = handle(nil, &RawMessage{Task: to.Strp("Tester"), Input: json.RawMessage(`{}`)})
+
+	// errorType and errorMessage as aws-lambda-go sends them
+	assert.Equal(t, "LockExistsError", to.ErrorType(err))
+	assert.Equal(t, "LockExistsError: locked", err.Error())
+	assert.Equal(t, "LockExistsError", errors.ErrorName(err))
+}
+
+func Test_Handler_Error_Unchanged(t *testing.T) {
+	tm := TaskHandlers{"Tester": func(_ context.Context, _ *TestStruct) (interface{}, error) {
+		return nil, fmt.Errorf("failed")
+	}}
+	handle, err := CreateHandler(&tm)
+	assert.NoError(t, err)
+
+	_, err = handle(nil, &RawMessage{Task: to.Strp("Tester"), Input: json.RawMessage(`{}`)})
+	assert.Equal(t, "errorString", to.ErrorType(err))
+	assert.Equal(t, "failed", err.Error())
+}
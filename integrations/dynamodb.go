@@ -0,0 +1,446 @@
+package integrations
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/private/protocol/json/jsonutil"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/cleardataeng/step/aws"
+	"github.com/cleardataeng/step/utils/to"
+)
+
+////////
+// In Memory
+////////
+
+// DynamoDB emulates the getItem, putItem, deleteItem and updateItem integrations with in memory tables.
+// Items use the DynamoDB attribute value JSON, e.g. {"id": {"S": "a"}}.
+//
+// UpdateExpression supports SET and REMOVE of top level attributes,
+// ConditionExpression supports attribute_exists and attribute_not_exists.
+type DynamoDB struct {
+	mu     sync.Mutex
+	tables map[string]*table
+}
+
+type table struct {
+	keys  []string
+	items map[string]map[string]interface{}
+}
+
+type dynamoDBParams struct {
+	TableName                 string
+	Key                       map[string]interface{}
+	Item                      map[string]interface{}
+	UpdateExpression          string
+	ConditionExpression       string
+	ExpressionAttributeNames  map[string]string
+	ExpressionAttributeValues map[string]interface{}
+	ReturnValues              string
+}
+
+var (
+	updateClauseRegex = regexp.MustCompile(`(?i)\b(SET|REMOVE)\s+`)
+	conditionRegex    = regexp.MustCompile(`^\s*(attribute_exists|attribute_not_exists)\s*\(\s*([#\w]+)\s*\)\s*$`)
+)
+
+func NewDynamoDB() *DynamoDB {
+	return &DynamoDB{tables: map[string]*table{}}
+}
+
+// CreateTable creates an empty table with the key attribute names, e.g. the partition and sort key
+func (d *DynamoDB) CreateTable(name string, keys ...string) *DynamoDB {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	d.tables[name] = &table{keys: keys, items: map[string]map[string]interface{}{}}
+	return d
+}
+
+// Items returns the items in a table sorted by key
+func (d *DynamoDB) Items(name string) []map[string]interface{} {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	items := []map[string]interface{}{}
+	t, ok := d.tables[name]
+	if !ok {
+		return items
+	}
+
+	ids := []string{}
+	for id := range t.items {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+
+	for _, id := range ids {
+		items = append(items, t.items[id])
+	}
+	return items
+}
+
+func (d *DynamoDB) Execute(_ context.Context, resource string, input interface{}) (interface{}, error) {
+	var params dynamoDBParams
+	if err := decode("DynamoDB", input, &params); err != nil {
+		return nil, err
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	t, ok := d.tables[params.TableName]
+	if !ok {
+		return nil, serviceError("DynamoDB", "ResourceNotFoundException", "Requested resource not found: Table: %v not found", params.TableName)
+	}
+
+	switch act, _ := action(resource); act {
+	case "getItem":
+		return t.getItem(params)
+	case "putItem":
+		return t.putItem(params)
+	case "deleteItem":
+		return t.deleteItem(params)
+	case "updateItem":
+		return t.updateItem(params)
+	}
+
+	return nil, unsupported("DynamoDB", resource)
+}
+
+func (t *table) getItem(params dynamoDBParams) (interface{}, error) {
+	id, err := t.id(params.Key)
+	if err != nil {
+		return nil, err
+	}
+
+	item, ok := t.items[id]
+	if !ok {
+		return map[string]interface{}{}, nil
+	}
+
+	return map[string]interface{}{"Item": item}, nil
+}
+
+func (t *table) putItem(params dynamoDBParams) (interface{}, error) {
+	id, err := t.id(params.Item)
+	if err != nil {
+		return nil, err
+	}
+
+	old := t.items[id]
+	if err := params.checkCondition(old); err != nil {
+		return nil, err
+	}
+
+	item, err := copyItem(params.Item)
+	if err != nil {
+		return nil, err
+	}
+	t.items[id] = item
+
+	return returnValues(params.ReturnValues, old, nil, nil), nil
+}
+
+func (t *table) deleteItem(params dynamoDBParams) (interface{}, error) {
+	id, err := t.id(params.Key)
+	if err != nil {
+		return nil, err
+	}
+
+	old := t.items[id]
+	if err := params.checkCondition(old); err != nil {
+		return nil, err
+	}
+
+	delete(t.items, id)
+
+	return returnValues(params.ReturnValues, old, nil, nil), nil
+}
+
+func (t *table) updateItem(params dynamoDBParams) (interface{}, error) {
+	id, err := t.id(params.Key)
+	if err != nil {
+		return nil, err
+	}
+
+	old := t.items[id]
+	if err := params.checkCondition(old); err != nil {
+		return nil, err
+	}
+
+	// Updating a missing item creates it from the Key
+	item, err := copyItem(old)
+	if err != nil {
+		return nil, err
+	}
+	if item == nil {
+		if item, err = copyItem(params.Key); err != nil {
+			return nil, err
+		}
+	}
+
+	updated, err := params.update(item)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, name := range updated {
+		if t.isKey(name) {
+			return nil, serviceError("DynamoDB", "AmazonDynamoDBException", "Cannot update attribute %v. This attribute is part of the key", name)
+		}
+	}
+
+	t.items[id] = item
+
+	return returnValues(params.ReturnValues, old, item, updated), nil
+}
+
+// id returns the identity of an item from its key attributes
+func (t *table) id(item map[string]interface{}) (string, error) {
+	key := []interface{}{}
+	for _, name := range t.keys {
+		value, ok := item[name]
+		if !ok {
+			return "", serviceError("DynamoDB", "AmazonDynamoDBException", "The provided key element does not match the schema, missing %v", name)
+		}
+		key = append(key, value)
+	}
+
+	raw, err := json.Marshal(key)
+	if err != nil {
+		return "", err
+	}
+	return string(raw), nil
+}
+
+func (t *table) isKey(name string) bool {
+	for _, key := range t.keys {
+		if key == name {
+			return true
+		}
+	}
+	return false
+}
+
+// name resolves an attribute name, or #placeholder from ExpressionAttributeNames
+func (params dynamoDBParams) name(name string) (string, error) {
+	if !strings.HasPrefix(name, "#") {
+		return name, nil
+	}
+
+	resolved, ok := params.ExpressionAttributeNames[name]
+	if !ok {
+		return "", serviceError("DynamoDB", "AmazonDynamoDBException", "ExpressionAttributeNames missing %v", name)
+	}
+	return resolved, nil
+}
+
+func (params dynamoDBParams) checkCondition(item map[string]interface{}) error {
+	if params.ConditionExpression == "" {
+		return nil
+	}
+
+	match := conditionRegex.FindStringSubmatch(params.ConditionExpression)
+	if match == nil {
+		return serviceError("DynamoDB", "AmazonDynamoDBException", "Unsupported ConditionExpression %q", params.ConditionExpression)
+	}
+
+	name, err := params.name(match[2])
+	if err != nil {
+		return err
+	}
+
+	_, exists := item[name]
+	if exists != (match[1] == "attribute_exists") {
+		return serviceError("DynamoDB", "ConditionalCheckFailedException", "The conditional request failed")
+	}
+
+	return nil
+}
+
+// update applies the UpdateExpression to item, returning the names of the updated attributes
+func (params dynamoDBParams) update(item map[string]interface{}) ([]string, error) {
+	expr := params.UpdateExpression
+	clauses := updateClauseRegex.FindAllStringSubmatchIndex(expr, -1)
+	if len(clauses) == 0 || strings.TrimSpace(expr[:clauses[0][0]]) != "" {
+		return nil, serviceError("DynamoDB", "AmazonDynamoDBException", "Unsupported UpdateExpression %q", expr)
+	}
+
+	updated := []string{}
+	for i, clause := range clauses {
+		end := len(expr)
+		if i+1 < len(clauses) {
+			end = clauses[i+1][0]
+		}
+
+		keyword := strings.ToUpper(expr[clause[2]:clause[3]])
+		for _, update := range strings.Split(expr[clause[1]:end], ",") {
+			name, err := params.updateAction(item, keyword, strings.TrimSpace(update))
+			if err != nil {
+				return nil, err
+			}
+			updated = append(updated, name)
+		}
+	}
+
+	return updated, nil
+}
+
+func (params dynamoDBParams) updateAction(item map[string]interface{}, keyword string, update string) (string, error) {
+	if keyword == "REMOVE" {
+		name, err := params.name(update)
+		if err != nil {
+			return "", err
+		}
+		delete(item, name)
+		return name, nil
+	}
+
+	parts := strings.SplitN(update, "=", 2)
+	if len(parts) != 2 {
+		return "", serviceError("DynamoDB", "AmazonDynamoDBException", "Unsupported UpdateExpression action %q", update)
+	}
+
+	name, err := params.name(strings.TrimSpace(parts[0]))
+	if err != nil {
+		return "", err
+	}
+
+	placeholder := strings.TrimSpace(parts[1])
+	value, ok := params.ExpressionAttributeValues[placeholder]
+	if !ok {
+		return "", serviceError("DynamoDB", "AmazonDynamoDBException", "Unsupported UpdateExpression value %q, must be in ExpressionAttributeValues", placeholder)
+	}
+
+	item[name] = value
+	return name, nil
+}
+
+// returnValues returns the output with Attributes for ReturnValues
+func returnValues(returnValues string, old map[string]interface{}, updatedItem map[string]interface{}, updated []string) map[string]interface{} {
+	var attributes map[string]interface{}
+	switch returnValues {
+	case "ALL_OLD":
+		attributes = old
+	case "ALL_NEW":
+		attributes = updatedItem
+	case "UPDATED_OLD":
+		attributes = pick(old, updated)
+	case "UPDATED_NEW":
+		attributes = pick(updatedItem, updated)
+	}
+
+	if len(attributes) == 0 {
+		return map[string]interface{}{}
+	}
+	return map[string]interface{}{"Attributes": attributes}
+}
+
+func pick(item map[string]interface{}, names []string) map[string]interface{} {
+	picked := map[string]interface{}{}
+	for _, name := range names {
+		if value, ok := item[name]; ok {
+			picked[name] = value
+		}
+	}
+	return picked
+}
+
+// copyItem deep copies an item so stored items do not share values with the Execution
+func copyItem(item map[string]interface{}) (map[string]interface{}, error) {
+	if item == nil {
+		return nil, nil
+	}
+
+	copied, err := to.FromJSON(item)
+	if err != nil {
+		return nil, err
+	}
+	return copied.(map[string]interface{}), nil
+}
+
+////////
+// Client
+////////
+
+// DynamoDBClient calls the DynamoDB API with the integration Parameters, so a mock or real client can be used
+type DynamoDBClient struct {
+	Client aws.DynamoDBAPI
+}
+
+func (d *DynamoDBClient) Execute(ctx context.Context, resource string, input interface{}) (interface{}, error) {
+	raw, err := json.Marshal(input)
+	if err != nil {
+		return nil, err
+	}
+
+	if ctx == nil {
+		ctx = context.Background()
+	}
+
+	var out interface{}
+	switch act, _ := action(resource); act {
+	case "getItem":
+		in := &dynamodb.GetItemInput{}
+		if err = unmarshalInput(raw, in); err == nil {
+			out, err = d.Client.GetItemWithContext(ctx, in)
+		}
+	case "putItem":
+		in := &dynamodb.PutItemInput{}
+		if err = unmarshalInput(raw, in); err == nil {
+			out, err = d.Client.PutItemWithContext(ctx, in)
+		}
+	case "deleteItem":
+		in := &dynamodb.DeleteItemInput{}
+		if err = unmarshalInput(raw, in); err == nil {
+			out, err = d.Client.DeleteItemWithContext(ctx, in)
+		}
+	case "updateItem":
+		in := &dynamodb.UpdateItemInput{}
+		if err = unmarshalInput(raw, in); err == nil {
+			out, err = d.Client.UpdateItemWithContext(ctx, in)
+		}
+	default:
+		return nil, unsupported("DynamoDB", resource)
+	}
+
+	if aerr, ok := err.(awserr.Error); ok {
+		return nil, serviceError("DynamoDB", aerr.Code(), "%v", aerr.Message())
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	return marshalOutput(out)
+}
+
+// unmarshalInput decodes Parameters with the API's own JSON protocol
+func unmarshalInput(raw []byte, in interface{}) error {
+	if err := jsonutil.UnmarshalJSON(in, bytes.NewReader(raw)); err != nil {
+		return serviceError("DynamoDB", "AmazonDynamoDBException", "Invalid Parameters: %v", err)
+	}
+	return nil
+}
+
+func marshalOutput(out interface{}) (interface{}, error) {
+	raw, err := jsonutil.BuildJSON(out)
+	if err != nil {
+		return nil, fmt.Errorf("Invalid Output: %v", err)
+	}
+
+	var result interface{}
+	if err := json.Unmarshal(raw, &result); err != nil {
+		return nil, err
+	}
+	return result, nil
+}
@@ -0,0 +1,219 @@
+package integrations
+
+import (
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/cleardataeng/step/aws/mocks"
+	"github.com/cleardataeng/step/machine"
+	"github.com/cleardataeng/step/utils/to"
+	"github.com/stretchr/testify/assert"
+)
+
+func item(id string, attrs map[string]interface{}) map[string]interface{} {
+	item := map[string]interface{}{"id": map[string]interface{}{"S": id}}
+	for k, v := range attrs {
+		item[k] = v
+	}
+	return item
+}
+
+func Test_DynamoDB_Machine(t *testing.T) {
+	sm, err := machine.FromJSON([]byte(`{
+    "StartAt": "Put",
+    "States": {
+      "Put": {
+        "Type": "Task",
+        "Resource": "arn:aws:states:::dynamodb:putItem",
+        "Parameters": {
+          "TableName": "items",
+          "Item": {"id": {"S.$": "$.id"}, "status": {"S": "new"}},
+          "ConditionExpression": "attribute_not_exists(id)"
+        },
+        "ResultPath": "$.put",
+        "Catch": [{"ErrorEquals": ["DynamoDB.ConditionalCheckFailedException"], "ResultPath": "$.error", "Next": "Exists"}],
+        "Next": "Get"
+      },
+      "Get": {
+        "Type": "Task",
+        "Resource": "arn:aws:states:::dynamodb:getItem",
+        "Parameters": {"TableName": "items", "Key": {"id": {"S.$": "$.id"}}},
+        "ResultSelector": {"status.$": "$.Item.status.S"},
+        "End": true
+      },
+      "Exists": {"Type": "Pass", "End": true}
+    }
+  }`))
+	assert.NoError(t, err)
+
+	db := NewDynamoDB().CreateTable("items", "id")
+	services := &Services{DynamoDB: db}
+	sm.SetResourceResolver(services.Handle(machine.NewResources()))
+
+	exec, err := sm.Execute(map[string]interface{}{"id": "a"})
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]interface{}{"status": "new"}, exec.Output)
+
+	assert.Equal(t, []map[string]interface{}{
+		item("a", map[string]interface{}{"status": map[string]interface{}{"S": "new"}}),
+	}, db.Items("items"))
+
+	// The condition fails the second time
+	exec, err = sm.Execute(map[string]interface{}{"id": "a"})
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"Put", "Exists"}, exec.Path())
+}
+
+func Test_DynamoDB_Items(t *testing.T) {
+	db := NewDynamoDB().CreateTable("items", "id")
+
+	out, err := db.Execute(nil, "arn:aws:states:::dynamodb:getItem", map[string]interface{}{
+		"TableName": "items",
+		"Key":       item("a", nil),
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]interface{}{}, out)
+
+	_, err = db.Execute(nil, "arn:aws:states:::dynamodb:putItem", map[string]interface{}{
+		"TableName": "items",
+		"Item":      item("a", map[string]interface{}{"n": map[string]interface{}{"N": "1"}}),
+	})
+	assert.NoError(t, err)
+
+	out, err = db.Execute(nil, "arn:aws:states:::dynamodb:putItem", map[string]interface{}{
+		"TableName":    "items",
+		"Item":         item("a", map[string]interface{}{"n": map[string]interface{}{"N": "2"}}),
+		"ReturnValues": "ALL_OLD",
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]interface{}{
+		"Attributes": item("a", map[string]interface{}{"n": map[string]interface{}{"N": "1"}}),
+	}, out)
+
+	out, err = db.Execute(nil, "arn:aws:states:::dynamodb:getItem", map[string]interface{}{
+		"TableName": "items",
+		"Key":       item("a", nil),
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]interface{}{
+		"Item": item("a", map[string]interface{}{"n": map[string]interface{}{"N": "2"}}),
+	}, out)
+
+	out, err = db.Execute(nil, "arn:aws:states:::dynamodb:deleteItem", map[string]interface{}{
+		"TableName":           "items",
+		"Key":                 item("a", nil),
+		"ConditionExpression": "attribute_exists(#id)",
+		"ExpressionAttributeNames": map[string]interface{}{
+			"#id": "id",
+		},
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]interface{}{}, out)
+	assert.Equal(t, []map[string]interface{}{}, db.Items("items"))
+}
+
+func Test_DynamoDB_UpdateItem(t *testing.T) {
+	db := NewDynamoDB().CreateTable("items", "id")
+
+	update := func(expr string, returnValues string) (interface{}, error) {
+		return db.Execute(nil, "arn:aws:states:::dynamodb:updateItem", map[string]interface{}{
+			"TableName":                "items",
+			"Key":                      item("a", nil),
+			"UpdateExpression":         expr,
+			"ExpressionAttributeNames": map[string]interface{}{"#s": "status"},
+			"ExpressionAttributeValues": map[string]interface{}{
+				":s": map[string]interface{}{"S": "done"},
+				":n": map[string]interface{}{"N": "1"},
+			},
+			"ReturnValues": returnValues,
+		})
+	}
+
+	// Creates the missing item
+	out, err := update("SET #s = :s, n = :n", "ALL_NEW")
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]interface{}{"Attributes": item("a", map[string]interface{}{
+		"status": map[string]interface{}{"S": "done"},
+		"n":      map[string]interface{}{"N": "1"},
+	})}, out)
+
+	out, err = update("remove n", "UPDATED_OLD")
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]interface{}{"Attributes": map[string]interface{}{
+		"n": map[string]interface{}{"N": "1"},
+	}}, out)
+
+	assert.Equal(t, []map[string]interface{}{
+		item("a", map[string]interface{}{"status": map[string]interface{}{"S": "done"}}),
+	}, db.Items("items"))
+
+	for _, expr := range []string{"SET id = :s", "SET n = n + :n", "ADD n :n", "SET #x = :s", ""} {
+		_, err = update(expr, "NONE")
+		assert.Error(t, err, expr)
+	}
+}
+
+func Test_DynamoDB_Errors(t *testing.T) {
+	db := NewDynamoDB().CreateTable("items", "id", "sort")
+
+	_, err := db.Execute(nil, "arn:aws:states:::dynamodb:getItem", map[string]interface{}{"TableName": "missing"})
+	assert.Error(t, err)
+	assert.Regexp(t, "DynamoDB.ResourceNotFoundException", err.Error())
+
+	_, err = db.Execute(nil, "arn:aws:states:::dynamodb:getItem", map[string]interface{}{
+		"TableName": "items",
+		"Key":       item("a", nil),
+	})
+	assert.Error(t, err)
+	assert.Regexp(t, "missing sort", err.Error())
+
+	_, err = db.Execute(nil, "arn:aws:states:::dynamodb:scan", map[string]interface{}{"TableName": "items"})
+	assert.Error(t, err)
+	assert.Regexp(t, "States.Runtime", err.Error())
+
+	_, err = db.Execute(nil, "arn:aws:states:::dynamodb:putItem", map[string]interface{}{
+		"TableName":           "items",
+		"Item":                item("a", map[string]interface{}{"sort": map[string]interface{}{"N": "1"}}),
+		"ConditionExpression": "size(id) > 1",
+	})
+	assert.Error(t, err)
+	assert.Regexp(t, "Unsupported ConditionExpression", err.Error())
+}
+
+func Test_DynamoDBClient(t *testing.T) {
+	client := &mocks.MockDynamoDBClient{
+		GetItemResp: &dynamodb.GetItemOutput{Item: map[string]*dynamodb.AttributeValue{
+			"id": {S: to.Strp("a")},
+			"n":  {N: to.Strp("1")},
+		}},
+	}
+	db := &DynamoDBClient{Client: client}
+
+	out, err := db.Execute(nil, "arn:aws:states:::dynamodb:getItem", map[string]interface{}{
+		"TableName": "items",
+		"Key":       item("a", nil),
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]interface{}{"Item": item("a", map[string]interface{}{
+		"n": map[string]interface{}{"N": "1"},
+	})}, out)
+	assert.Equal(t, "items", *client.GetItemInputs[0].TableName)
+	assert.Equal(t, "a", *client.GetItemInputs[0].Key["id"].S)
+
+	_, err = db.Execute(nil, "arn:aws:states:::dynamodb:putItem", map[string]interface{}{
+		"TableName": "items",
+		"Item":      item("b", nil),
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, "b", *client.PutItemInputs[0].Item["id"].S)
+
+	// API errors are named after their code
+	client.Error = awserr.New("ConditionalCheckFailedException", "The conditional request failed", nil)
+	_, err = db.Execute(nil, "arn:aws:states:::dynamodb:deleteItem", map[string]interface{}{
+		"TableName": "items",
+		"Key":       item("b", nil),
+	})
+	assert.Error(t, err)
+	assert.Regexp(t, "^DynamoDB.ConditionalCheckFailedException", err.Error())
+}
@@ -0,0 +1,116 @@
+// integrations emulates the optimized service integrations a Task Resource can call,
+// e.g. arn:aws:states:::dynamodb:getItem, so State Machines using them can run locally.
+// The emulators are machine.Executors, routed with machine.Resources:
+//
+//	services := integrations.New()
+//	sm.SetResourceResolver(services.Handle(machine.NewResources()))
+package integrations
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/cleardataeng/step/machine"
+)
+
+const resourcePrefix = "arn:aws:states:::"
+
+// Services are the emulated integrations, a nil Service is not routed
+type Services struct {
+	DynamoDB      machine.Executor // *DynamoDB in memory, or *DynamoDBClient
+	SQS           *SQS
+	SNS           *SNS
+	EventBridge   *EventBridge
+	StepFunctions *StepFunctions
+}
+
+// New returns in memory Services
+func New() *Services {
+	return &Services{
+		DynamoDB:      NewDynamoDB(),
+		SQS:           &SQS{},
+		SNS:           &SNS{},
+		EventBridge:   &EventBridge{},
+		StepFunctions: NewStepFunctions(),
+	}
+}
+
+// Handle routes the integration Resources to the Services
+func (s *Services) Handle(resources *machine.Resources) *machine.Resources {
+	if s.DynamoDB != nil {
+		resources.Handle(resourcePrefix+"dynamodb:*", s.DynamoDB)
+	}
+
+	if s.SQS != nil {
+		resources.Handle(resourcePrefix+"sqs:*", s.SQS)
+	}
+
+	if s.SNS != nil {
+		resources.Handle(resourcePrefix+"sns:*", s.SNS)
+	}
+
+	if s.EventBridge != nil {
+		resources.Handle(resourcePrefix+"events:*", s.EventBridge)
+	}
+
+	if s.StepFunctions != nil {
+		resources.Handle(resourcePrefix+"states:*", s.StepFunctions)
+	}
+
+	return resources
+}
+
+// action returns the API action and integration pattern of a Resource,
+// e.g. arn:aws:states:::states:startExecution.sync:2 is "startExecution" and "sync:2"
+func action(resource string) (string, string) {
+	resource = strings.TrimPrefix(resource, resourcePrefix)
+
+	// Remove the service
+	if i := strings.Index(resource, ":"); i >= 0 {
+		resource = resource[i+1:]
+	}
+
+	parts := strings.SplitN(resource, ".", 2)
+	if len(parts) == 1 {
+		return parts[0], ""
+	}
+	return parts[0], parts[1]
+}
+
+func unsupported(service string, resource string) error {
+	act, _ := action(resource)
+	return machine.NewError("States.Runtime", fmt.Sprintf("%v does not support %q", service, act))
+}
+
+// serviceError is an error named like the integration errors, e.g. DynamoDB.ResourceNotFoundException
+func serviceError(service string, name string, format string, args ...interface{}) error {
+	return machine.NewError(fmt.Sprintf("%v.%v", service, name), fmt.Sprintf(format, args...))
+}
+
+// decode converts the Task input into the Parameters of an action
+func decode(service string, input interface{}, v interface{}) error {
+	raw, err := json.Marshal(input)
+	if err != nil {
+		return err
+	}
+
+	if err := json.Unmarshal(raw, v); err != nil {
+		return serviceError(service, fmt.Sprintf("Amazon%vException", service), "Invalid Parameters: %v", err)
+	}
+
+	return nil
+}
+
+// stringBody returns a string, or other values as JSON, as message bodies can be either
+func stringBody(body interface{}) (string, error) {
+	if str, ok := body.(string); ok {
+		return str, nil
+	}
+
+	raw, err := json.Marshal(body)
+	if err != nil {
+		return "", err
+	}
+	return string(raw), nil
+}
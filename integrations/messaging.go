@@ -0,0 +1,232 @@
+package integrations
+
+import (
+	"context"
+	"crypto/md5"
+	"encoding/hex"
+	"sync"
+
+	"github.com/cleardataeng/step/utils/to"
+)
+
+////////
+// SQS
+////////
+
+// SQS emulates arn:aws:states:::sqs:sendMessage, recording the messages sent
+type SQS struct {
+	mu       sync.Mutex
+	messages []SQSMessage
+}
+
+// SQSMessage is a message sent to a queue
+type SQSMessage struct {
+	MessageId              string
+	QueueUrl               string
+	MessageBody            string
+	MessageAttributes      map[string]interface{}
+	MessageGroupId         string
+	MessageDeduplicationId string
+	DelaySeconds           int
+}
+
+func (q *SQS) Execute(_ context.Context, resource string, input interface{}) (interface{}, error) {
+	if act, _ := action(resource); act != "sendMessage" {
+		return nil, unsupported("SQS", resource)
+	}
+
+	var params struct {
+		SQSMessage
+		MessageBody interface{}
+	}
+	if err := decode("SQS", input, &params); err != nil {
+		return nil, err
+	}
+
+	if params.QueueUrl == "" || params.MessageBody == nil {
+		return nil, serviceError("SQS", "AmazonSQSException", "QueueUrl and MessageBody are required")
+	}
+
+	msg := params.SQSMessage
+	body, err := stringBody(params.MessageBody)
+	if err != nil {
+		return nil, err
+	}
+	msg.MessageBody = body
+	msg.MessageId = to.UUID()
+
+	q.mu.Lock()
+	q.messages = append(q.messages, msg)
+	q.mu.Unlock()
+
+	sum := md5.Sum([]byte(body))
+	return map[string]interface{}{
+		"MD5OfMessageBody": hex.EncodeToString(sum[:]),
+		"MessageId":        msg.MessageId,
+	}, nil
+}
+
+// Messages returns the messages sent to queueURL in order
+func (q *SQS) Messages(queueURL string) []SQSMessage {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	messages := []SQSMessage{}
+	for _, msg := range q.messages {
+		if msg.QueueUrl == queueURL {
+			messages = append(messages, msg)
+		}
+	}
+	return messages
+}
+
+////////
+// SNS
+////////
+
+// SNS emulates arn:aws:states:::sns:publish, recording the messages published
+type SNS struct {
+	mu       sync.Mutex
+	messages []SNSMessage
+}
+
+// SNSMessage is a message published to a topic, target or phone number
+type SNSMessage struct {
+	MessageId         string
+	TopicArn          string
+	TargetArn         string
+	PhoneNumber       string
+	Subject           string
+	Message           string
+	MessageAttributes map[string]interface{}
+	MessageGroupId    string
+}
+
+func (n *SNS) Execute(_ context.Context, resource string, input interface{}) (interface{}, error) {
+	if act, _ := action(resource); act != "publish" {
+		return nil, unsupported("SNS", resource)
+	}
+
+	var params struct {
+		SNSMessage
+		Message interface{}
+	}
+	if err := decode("SNS", input, &params); err != nil {
+		return nil, err
+	}
+
+	msg := params.SNSMessage
+	if msg.TopicArn == "" && msg.TargetArn == "" && msg.PhoneNumber == "" {
+		return nil, serviceError("SNS", "InvalidParameterException", "TopicArn, TargetArn or PhoneNumber is required")
+	}
+
+	if params.Message == nil {
+		return nil, serviceError("SNS", "InvalidParameterException", "Message is required")
+	}
+
+	message, err := stringBody(params.Message)
+	if err != nil {
+		return nil, err
+	}
+	msg.Message = message
+	msg.MessageId = to.UUID()
+
+	n.mu.Lock()
+	n.messages = append(n.messages, msg)
+	n.mu.Unlock()
+
+	return map[string]interface{}{"MessageId": msg.MessageId}, nil
+}
+
+// Published returns the messages published in order
+func (n *SNS) Published() []SNSMessage {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	return append([]SNSMessage{}, n.messages...)
+}
+
+////////
+// EventBridge
+////////
+
+// EventBridge emulates arn:aws:states:::events:putEvents, recording the events put
+type EventBridge struct {
+	mu     sync.Mutex
+	events []Event
+}
+
+// Event is an entry put on an event bus
+type Event struct {
+	EventId      string
+	EventBusName string
+	Source       string
+	DetailType   string
+	Detail       string
+	Resources    []string
+}
+
+func (e *EventBridge) Execute(_ context.Context, resource string, input interface{}) (interface{}, error) {
+	if act, _ := action(resource); act != "putEvents" {
+		return nil, unsupported("EventBridge", resource)
+	}
+
+	var params struct {
+		Entries []struct {
+			Event
+			Detail interface{}
+		}
+	}
+	if err := decode("EventBridge", input, &params); err != nil {
+		return nil, err
+	}
+
+	if len(params.Entries) == 0 {
+		return nil, serviceError("EventBridge", "AmazonEventBridgeException", "Entries are required")
+	}
+
+	events := []Event{}
+	entries := []interface{}{}
+	for i, entry := range params.Entries {
+		event := entry.Event
+		if event.Source == "" || event.DetailType == "" || entry.Detail == nil {
+			return nil, serviceError("EventBridge", "AmazonEventBridgeException", "Entries[%v] requires Source, DetailType and Detail", i)
+		}
+
+		detail, err := stringBody(entry.Detail)
+		if err != nil {
+			return nil, err
+		}
+		event.Detail = detail
+
+		if event.EventBusName == "" {
+			event.EventBusName = "default"
+		}
+		event.EventId = to.UUID()
+
+		events = append(events, event)
+		entries = append(entries, map[string]interface{}{"EventId": event.EventId})
+	}
+
+	e.mu.Lock()
+	e.events = append(e.events, events...)
+	e.mu.Unlock()
+
+	return map[string]interface{}{
+		"Entries":          entries,
+		"FailedEntryCount": 0.0,
+	}, nil
+}
+
+// Events returns the events put on eventBusName in order
+func (e *EventBridge) Events(eventBusName string) []Event {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	events := []Event{}
+	for _, event := range e.events {
+		if event.EventBusName == eventBusName {
+			events = append(events, event)
+		}
+	}
+	return events
+}
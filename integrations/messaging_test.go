@@ -0,0 +1,131 @@
+package integrations
+
+import (
+	"testing"
+
+	"github.com/cleardataeng/step/machine"
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_Messaging_Machine(t *testing.T) {
+	sm, err := machine.FromJSON([]byte(`{
+    "StartAt": "Send",
+    "States": {
+      "Send": {
+        "Type": "Task",
+        "Resource": "arn:aws:states:::sqs:sendMessage",
+        "Parameters": {"QueueUrl": "https://sqs.us-east-1.amazonaws.com/000000000000/jobs", "MessageBody": {"id.$": "$.id"}},
+        "ResultSelector": {"id.$": "$.MessageId"},
+        "ResultPath": "$.sqs",
+        "Next": "Publish"
+      },
+      "Publish": {
+        "Type": "Task",
+        "Resource": "arn:aws:states:::sns:publish",
+        "Parameters": {"TopicArn": "arn:aws:sns:us-east-1:000000000000:jobs", "Message.$": "$.sqs.id", "Subject": "sent"},
+        "ResultPath": "$.sns",
+        "Next": "Put"
+      },
+      "Put": {
+        "Type": "Task",
+        "Resource": "arn:aws:states:::events:putEvents",
+        "Parameters": {"Entries": [{"Source": "step", "DetailType": "Sent", "Detail": {"id": "a"}}]},
+        "End": true
+      }
+    }
+  }`))
+	assert.NoError(t, err)
+
+	services := New()
+	sm.SetResourceResolver(services.Handle(machine.NewResources()))
+
+	exec, err := sm.Execute(map[string]interface{}{"id": "a"})
+	assert.NoError(t, err)
+	assert.Equal(t, 0.0, exec.Output["FailedEntryCount"])
+
+	messages := services.SQS.Messages("https://sqs.us-east-1.amazonaws.com/000000000000/jobs")
+	assert.Equal(t, 1, len(messages))
+	assert.Equal(t, `{"id":"a"}`, messages[0].MessageBody)
+
+	published := services.SNS.Published()
+	assert.Equal(t, 1, len(published))
+	assert.Equal(t, messages[0].MessageId, published[0].Message)
+	assert.Equal(t, "sent", published[0].Subject)
+
+	events := services.EventBridge.Events("default")
+	assert.Equal(t, 1, len(events))
+	assert.Equal(t, `{"id":"a"}`, events[0].Detail)
+	assert.Equal(t, exec.Output["Entries"], []interface{}{map[string]interface{}{"EventId": events[0].EventId}})
+}
+
+func Test_SQS_sendMessage(t *testing.T) {
+	q := &SQS{}
+
+	out, err := q.Execute(nil, "arn:aws:states:::sqs:sendMessage", map[string]interface{}{
+		"QueueUrl":    "jobs",
+		"MessageBody": "hello",
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, "5d41402abc4b2a76b9719d911017c592", out.(map[string]interface{})["MD5OfMessageBody"])
+	assert.Equal(t, "hello", q.Messages("jobs")[0].MessageBody)
+	assert.Equal(t, []SQSMessage{}, q.Messages("other"))
+
+	_, err = q.Execute(nil, "arn:aws:states:::sqs:sendMessage", map[string]interface{}{"QueueUrl": "jobs"})
+	assert.Error(t, err)
+	assert.Regexp(t, "^SQS.AmazonSQSException", err.Error())
+
+	_, err = q.Execute(nil, "arn:aws:states:::sqs:receiveMessage", map[string]interface{}{})
+	assert.Error(t, err)
+}
+
+func Test_SNS_publish(t *testing.T) {
+	n := &SNS{}
+
+	_, err := n.Execute(nil, "arn:aws:states:::sns:publish", map[string]interface{}{"Message": "hello"})
+	assert.Error(t, err)
+	assert.Regexp(t, "^SNS.InvalidParameterException", err.Error())
+
+	_, err = n.Execute(nil, "arn:aws:states:::sns:publish", map[string]interface{}{"PhoneNumber": "+15555555555"})
+	assert.Error(t, err)
+
+	_, err = n.Execute(nil, "arn:aws:states:::sns:publish.waitForTaskToken", map[string]interface{}{
+		"TopicArn": "topic",
+		"Message":  map[string]interface{}{"token": "t"},
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, `{"token":"t"}`, n.Published()[0].Message)
+}
+
+func Test_EventBridge_putEvents(t *testing.T) {
+	e := &EventBridge{}
+
+	_, err := e.Execute(nil, "arn:aws:states:::events:putEvents", map[string]interface{}{
+		"Entries": []interface{}{
+			map[string]interface{}{"Source": "step", "DetailType": "a", "Detail": "{}", "EventBusName": "bus"},
+			map[string]interface{}{"Source": "step", "DetailType": "b", "Detail": map[string]interface{}{}},
+		},
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, "a", e.Events("bus")[0].DetailType)
+	assert.Equal(t, "b", e.Events("default")[0].DetailType)
+
+	_, err = e.Execute(nil, "arn:aws:states:::events:putEvents", map[string]interface{}{
+		"Entries": []interface{}{map[string]interface{}{"Source": "step"}},
+	})
+	assert.Error(t, err)
+	assert.Regexp(t, `^EventBridge.AmazonEventBridgeException: Entries\[0\]`, err.Error())
+
+	_, err = e.Execute(nil, "arn:aws:states:::events:putEvents", map[string]interface{}{})
+	assert.Error(t, err)
+}
+
+func Test_action(t *testing.T) {
+	for resource, expected := range map[string][]string{
+		"arn:aws:states:::dynamodb:getItem":                 {"getItem", ""},
+		"arn:aws:states:::sqs:sendMessage.waitForTaskToken": {"sendMessage", "waitForTaskToken"},
+		"arn:aws:states:::states:startExecution.sync:2":     {"startExecution", "sync:2"},
+	} {
+		act, pattern := action(resource)
+		assert.Equal(t, expected, []string{act, pattern}, resource)
+	}
+}
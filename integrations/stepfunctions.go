@@ -0,0 +1,216 @@
+package integrations
+
+import (
+	"context"
+	"encoding/json"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/cleardataeng/step/machine"
+)
+
+// StepFunctions emulates arn:aws:states:::states:startExecution by starting locally registered State Machines.
+//
+// startExecution returns once the Execution starts, .sync waits for it returning its Input and Output as JSON strings,
+// .sync:2 waits returning them as JSON. A failed Execution fails the Task with States.TaskFailed,
+// and a .sync Execution is stopped if the Task is cancelled first.
+type StepFunctions struct {
+	mu         sync.Mutex
+	machines   map[string]*machine.StateMachine
+	executions []*machine.Execution
+}
+
+type startExecutionParams struct {
+	StateMachineArn string
+	Input           interface{}
+}
+
+func NewStepFunctions() *StepFunctions {
+	return &StepFunctions{machines: map[string]*machine.StateMachine{}}
+}
+
+// Register makes sm available to StateMachineArns with the name, e.g. arn:aws:states:<region>:<account>:stateMachine:<name>
+func (s *StepFunctions) Register(name string, sm *machine.StateMachine) *StepFunctions {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.machines[name] = sm
+	return s
+}
+
+// Executions returns the started Executions in order
+func (s *StepFunctions) Executions() []*machine.Execution {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]*machine.Execution{}, s.executions...)
+}
+
+func (s *StepFunctions) Execute(ctx context.Context, resource string, input interface{}) (interface{}, error) {
+	act, pattern := action(resource)
+	if act != "startExecution" {
+		return nil, unsupported("StepFunctions", resource)
+	}
+
+	var params startExecutionParams
+	if err := decode("StepFunctions", input, &params); err != nil {
+		return nil, err
+	}
+
+	name, sm, err := s.stateMachine(params.StateMachineArn)
+	if err != nil {
+		return nil, err
+	}
+
+	execInput, err := params.input()
+	if err != nil {
+		return nil, err
+	}
+
+	if ctx == nil {
+		ctx = context.Background()
+	}
+
+	sync := pattern == "sync" || pattern == "sync:2"
+
+	// Only a .sync Execution ends with the Task, otherwise it outlives it like in AWS
+	execCtx := ctx
+	if !sync {
+		execCtx = context.Background()
+	}
+
+	started := time.Now()
+	exec, err := sm.StartWithOptions(execCtx, execInput, &machine.ExecutionOptions{StateMachineName: name})
+	if err != nil {
+		return nil, serviceError("StepFunctions", "InvalidExecutionInput", "%v", err)
+	}
+
+	s.mu.Lock()
+	s.executions = append(s.executions, exec)
+	s.mu.Unlock()
+
+	if sync {
+		if err := wait(ctx, exec); err != nil {
+			return nil, err
+		}
+		return describeExecution(params.StateMachineArn, exec, execInput, pattern == "sync:2")
+	}
+
+	// The Task does not wait, including .waitForTaskToken where the child completes the token
+	return map[string]interface{}{
+		"ExecutionArn": exec.ID,
+		"StartDate":    epochMillis(started),
+	}, nil
+}
+
+// wait blocks until exec completes, stopping it if ctx is cancelled first e.g. the Task timed out
+func wait(ctx context.Context, exec *machine.Execution) error {
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		exec.Wait()
+	}()
+
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		exec.Stop("", "Parent Task ended: "+ctx.Err().Error())
+		<-done
+		return ctx.Err()
+	}
+}
+
+// stateMachine returns the registered State Machine of arn and its name
+func (s *StepFunctions) stateMachine(arn string) (string, *machine.StateMachine, error) {
+	name := arn
+	if i := strings.LastIndex(arn, ":stateMachine:"); i >= 0 {
+		name = arn[i+len(":stateMachine:"):]
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	sm, ok := s.machines[name]
+	if !ok {
+		return "", nil, serviceError("StepFunctions", "StateMachineDoesNotExistException", "State Machine Does Not Exist: %q", arn)
+	}
+	return name, sm, nil
+}
+
+// input returns the Execution input, which can be JSON or a JSON string
+func (params startExecutionParams) input() (interface{}, error) {
+	switch input := params.Input.(type) {
+	case nil:
+		return map[string]interface{}{}, nil
+	case string:
+		var decoded interface{}
+		if err := json.Unmarshal([]byte(input), &decoded); err != nil {
+			return nil, serviceError("StepFunctions", "InvalidExecutionInput", "Invalid Input: %v", err)
+		}
+		return decoded, nil
+	}
+	return params.Input, nil
+}
+
+// describeExecution returns the DescribeExecution output of a completed Execution
+func describeExecution(arn string, exec *machine.Execution, input interface{}, asJSON bool) (interface{}, error) {
+	inputJSON, err := json.Marshal(input)
+	if err != nil {
+		return nil, err
+	}
+
+	description := map[string]interface{}{
+		"ExecutionArn":    exec.ID,
+		"Name":            exec.Name,
+		"StateMachineArn": arn,
+		"StartDate":       epochMillis(startDate(exec)),
+		"StopDate":        epochMillis(stopDate(exec)),
+		"Input":           string(inputJSON),
+		"InputDetails":    map[string]interface{}{"Included": true},
+	}
+
+	if exec.Error != nil {
+		description["Status"] = "FAILED"
+		description["Error"] = exec.ErrorName
+		description["Cause"] = exec.ErrorCause
+
+		cause, _ := json.Marshal(description)
+		return nil, machine.NewError("States.TaskFailed", string(cause))
+	}
+
+	outputJSON, err := json.Marshal(exec.Output)
+	if err != nil {
+		return nil, err
+	}
+
+	description["Status"] = "SUCCEEDED"
+	description["Output"] = string(outputJSON)
+	description["OutputDetails"] = map[string]interface{}{"Included": true}
+
+	if asJSON {
+		description["Input"] = input
+		description["Output"] = exec.Output
+	}
+
+	return description, nil
+}
+
+func startDate(exec *machine.Execution) time.Time {
+	if len(exec.ExecutionHistory) == 0 {
+		return time.Time{}
+	}
+	return *exec.ExecutionHistory[0].Timestamp
+}
+
+func stopDate(exec *machine.Execution) time.Time {
+	if len(exec.ExecutionHistory) == 0 {
+		return time.Time{}
+	}
+	return *exec.ExecutionHistory[len(exec.ExecutionHistory)-1].Timestamp
+}
+
+// epochMillis is how the integrations return dates
+func epochMillis(t time.Time) float64 {
+	return float64(t.UnixNano() / int64(time.Millisecond))
+}
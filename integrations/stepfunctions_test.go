@@ -0,0 +1,174 @@
+package integrations
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+
+	"github.com/cleardataeng/step/machine"
+	"github.com/stretchr/testify/assert"
+)
+
+func childMachine(t *testing.T) *machine.StateMachine {
+	sm, err := machine.FromJSON([]byte(`{
+    "StartAt": "Check",
+    "States": {
+      "Check": {
+        "Type": "Choice",
+        "Choices": [{"Variable": "$.fail", "BooleanEquals": true, "Next": "Fail"}],
+        "Default": "Done"
+      },
+      "Done": {"Type": "Pass", "Result": "done", "ResultPath": "$.status", "End": true},
+      "Fail": {"Type": "Fail", "Error": "Failed"}
+    }
+  }`))
+	assert.NoError(t, err)
+	return sm
+}
+
+func parentMachine(t *testing.T, resource string) (*machine.StateMachine, *StepFunctions) {
+	sm, err := machine.FromJSON([]byte(`{
+    "StartAt": "Start",
+    "States": {
+      "Start": {
+        "Type": "Task",
+        "Resource": "` + resource + `",
+        "Parameters": {
+          "StateMachineArn": "arn:aws:states:us-east-1:000000000000:stateMachine:child",
+          "Input": {"id.$": "$.id", "fail.$": "$.fail"}
+        },
+        "ResultPath": "$.child",
+        "Catch": [{"ErrorEquals": ["States.TaskFailed"], "ResultPath": "$.error", "Next": "Failed"}],
+        "End": true
+      },
+      "Failed": {"Type": "Pass", "End": true}
+    }
+  }`))
+	assert.NoError(t, err)
+
+	services := &Services{StepFunctions: NewStepFunctions().Register("child", childMachine(t))}
+	sm.SetResourceResolver(services.Handle(machine.NewResources()))
+	return sm, services.StepFunctions
+}
+
+func Test_StepFunctions_startExecution_sync(t *testing.T) {
+	sm, _ := parentMachine(t, "arn:aws:states:::states:startExecution.sync")
+
+	exec, err := sm.Execute(map[string]interface{}{"id": "a", "fail": false})
+	assert.NoError(t, err)
+
+	child := exec.Output["child"].(map[string]interface{})
+	assert.Equal(t, "SUCCEEDED", child["Status"])
+	assert.Equal(t, "arn:aws:states:us-east-1:000000000000:stateMachine:child", child["StateMachineArn"])
+	assert.JSONEq(t, `{"id": "a", "fail": false, "status": "done"}`, child["Output"].(string))
+	assert.JSONEq(t, `{"id": "a", "fail": false}`, child["Input"].(string))
+	assert.Regexp(t, "^arn:aws:states:us-east-1:000000000000:execution:child:", child["ExecutionArn"])
+	assert.IsType(t, 0.0, child["StartDate"])
+	assert.IsType(t, 0.0, child["StopDate"])
+}
+
+func Test_StepFunctions_startExecution_sync2(t *testing.T) {
+	sm, _ := parentMachine(t, "arn:aws:states:::states:startExecution.sync:2")
+
+	exec, err := sm.Execute(map[string]interface{}{"id": "a", "fail": false})
+	assert.NoError(t, err)
+
+	child := exec.Output["child"].(map[string]interface{})
+	assert.Equal(t, map[string]interface{}{"id": "a", "fail": false, "status": "done"}, child["Output"])
+	assert.Equal(t, map[string]interface{}{"id": "a", "fail": false}, child["Input"])
+}
+
+func Test_StepFunctions_startExecution_sync_Failed(t *testing.T) {
+	sm, _ := parentMachine(t, "arn:aws:states:::states:startExecution.sync")
+
+	exec, err := sm.Execute(map[string]interface{}{"id": "a", "fail": true})
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"Start", "Failed"}, exec.Path())
+
+	caught := exec.Output["error"].(map[string]interface{})
+	assert.Equal(t, "States.TaskFailed", caught["Error"])
+
+	var cause map[string]interface{}
+	assert.NoError(t, json.Unmarshal([]byte(caught["Cause"].(string)), &cause))
+	assert.Equal(t, "FAILED", cause["Status"])
+	assert.Equal(t, "Failed", cause["Error"])
+}
+
+func Test_StepFunctions_startExecution(t *testing.T) {
+	sm, sf := parentMachine(t, "arn:aws:states:::states:startExecution")
+
+	exec, err := sm.Execute(map[string]interface{}{"id": "a", "fail": false})
+	assert.NoError(t, err)
+
+	child := exec.Output["child"].(map[string]interface{})
+	assert.IsType(t, 0.0, child["StartDate"])
+
+	// The Task does not wait for the child Execution
+	started := sf.Executions()
+	assert.Equal(t, 1, len(started))
+	assert.NoError(t, started[0].Wait())
+	assert.Equal(t, started[0].ID, child["ExecutionArn"])
+	assert.Equal(t, "done", started[0].Output["status"])
+}
+
+func Test_StepFunctions_startExecution_sync_Cancel(t *testing.T) {
+	child, err := machine.FromJSON([]byte(`{
+    "StartAt": "Block",
+    "States": {"Block": {"Type": "Task", "Resource": "test", "End": true}}
+  }`))
+	assert.NoError(t, err)
+
+	started := make(chan struct{})
+	child.SetTaskHandler("Block", func(ctx context.Context, _ interface{}) (interface{}, error) {
+		close(started)
+		<-ctx.Done()
+		return nil, ctx.Err()
+	})
+
+	sf := NewStepFunctions().Register("child", child)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	go func() {
+		<-started
+		cancel()
+	}()
+
+	_, err = sf.Execute(ctx, "arn:aws:states:::states:startExecution.sync", map[string]interface{}{
+		"StateMachineArn": "child",
+	})
+	assert.Equal(t, context.Canceled, err)
+
+	// Cancelling the Task stops the child Execution
+	execs := sf.Executions()
+	assert.Equal(t, 1, len(execs))
+	assert.True(t, execs[0].Aborted())
+}
+
+func Test_StepFunctions_Errors(t *testing.T) {
+	sf := NewStepFunctions().Register("child", childMachine(t))
+
+	_, err := sf.Execute(nil, "arn:aws:states:::states:startExecution.sync", map[string]interface{}{
+		"StateMachineArn": "arn:aws:states:us-east-1:000000000000:stateMachine:missing",
+	})
+	assert.Error(t, err)
+	assert.Regexp(t, "^StepFunctions.StateMachineDoesNotExistException", err.Error())
+
+	_, err = sf.Execute(nil, "arn:aws:states:::states:startExecution.sync", map[string]interface{}{
+		"StateMachineArn": "child",
+		"Input":           "not json",
+	})
+	assert.Error(t, err)
+	assert.Regexp(t, "^StepFunctions.InvalidExecutionInput", err.Error())
+
+	// Input can be a JSON string
+	out, err := sf.Execute(nil, "arn:aws:states:::states:startExecution.sync:2", map[string]interface{}{
+		"StateMachineArn": "child",
+		"Input":           `{"id": "b"}`,
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]interface{}{"id": "b", "status": "done"}, out.(map[string]interface{})["Output"])
+	assert.Equal(t, 1, len(sf.Executions()))
+
+	_, err = sf.Execute(nil, "arn:aws:states:::states:describeExecution", map[string]interface{}{})
+	assert.Error(t, err)
+}
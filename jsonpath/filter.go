@@ -0,0 +1,328 @@
+package jsonpath
+
+import (
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+)
+
+// condition is a compiled filter expression e.g. ?(@.price < 10 && @.available)
+type condition interface {
+	match(node interface{}) bool
+}
+
+// operand is a value in a filter, either a literal or a path relative to the current node "@"
+type operand interface {
+	value(node interface{}) (interface{}, bool)
+}
+
+type literal struct {
+	v interface{}
+}
+
+type relativePath struct {
+	segments []segment
+}
+
+type comparison struct {
+	op    string
+	left  operand
+	right operand
+}
+
+// exists matches if the relative path is found
+type exists struct {
+	path relativePath
+}
+
+type logical struct {
+	and   bool
+	left  condition
+	right condition
+}
+
+type not struct {
+	c condition
+}
+
+func (l literal) value(node interface{}) (interface{}, bool) {
+	return l.v, true
+}
+
+func (p relativePath) value(node interface{}) (interface{}, bool) {
+	if definite(p.segments) {
+		v, err := getDefinite(node, p.segments)
+		return v, err == nil
+	}
+
+	nodes := selectAll(node, p.segments)
+	return nodes, len(nodes) > 0
+}
+
+func (e exists) match(node interface{}) bool {
+	_, ok := e.path.value(node)
+	return ok
+}
+
+func (l logical) match(node interface{}) bool {
+	if l.and {
+		return l.left.match(node) && l.right.match(node)
+	}
+	return l.left.match(node) || l.right.match(node)
+}
+
+func (n not) match(node interface{}) bool {
+	return !n.c.match(node)
+}
+
+func (c comparison) match(node interface{}) bool {
+	left, lok := c.left.value(node)
+	right, rok := c.right.value(node)
+
+	if !lok || !rok {
+		// a missing value is never equal to anything
+		return c.op == "!="
+	}
+
+	lnum, lIsNum := toNumber(left)
+	rnum, rIsNum := toNumber(right)
+
+	switch c.op {
+	case "==":
+		if lIsNum && rIsNum {
+			return lnum == rnum
+		}
+		return reflect.DeepEqual(left, right)
+	case "!=":
+		if lIsNum && rIsNum {
+			return lnum != rnum
+		}
+		return !reflect.DeepEqual(left, right)
+	}
+
+	var cmp int
+	lstr, lIsStr := left.(string)
+	rstr, rIsStr := right.(string)
+
+	switch {
+	case lIsNum && rIsNum:
+		cmp = compareFloats(lnum, rnum)
+	case lIsStr && rIsStr:
+		cmp = strings.Compare(lstr, rstr)
+	default:
+		return false
+	}
+
+	switch c.op {
+	case "<":
+		return cmp < 0
+	case "<=":
+		return cmp <= 0
+	case ">":
+		return cmp > 0
+	default: // ">="
+		return cmp >= 0
+	}
+}
+
+func compareFloats(a, b float64) int {
+	switch {
+	case a < b:
+		return -1
+	case a > b:
+		return 1
+	}
+	return 0
+}
+
+func toNumber(v interface{}) (float64, bool) {
+	switch n := v.(type) {
+	case float64:
+		return n, true
+	case int:
+		return float64(n), true
+	}
+	return 0, false
+}
+
+//////
+// Filter Parser
+//////
+
+// filterParser is a recursive descent parser for filter expressions:
+//
+//	or         := and ("||" and)*
+//	and        := unary ("&&" unary)*
+//	unary      := "!" unary | "(" or ")" | comparison
+//	comparison := operand [op operand]
+type filterParser struct {
+	str string
+	pos int
+}
+
+func parseFilter(str string) (condition, error) {
+	p := &filterParser{str: str}
+
+	c, err := p.parseOr()
+	if err != nil {
+		return nil, err
+	}
+
+	p.skipSpace()
+	if p.pos != len(p.str) {
+		return nil, fmt.Errorf("unexpected %q in filter", p.str[p.pos:])
+	}
+
+	return c, nil
+}
+
+func (p *filterParser) skipSpace() {
+	for p.pos < len(p.str) && strings.ContainsRune(" \t\n", rune(p.str[p.pos])) {
+		p.pos++
+	}
+}
+
+// consume skips whitespace then the token if it is next
+func (p *filterParser) consume(token string) bool {
+	p.skipSpace()
+	if strings.HasPrefix(p.str[p.pos:], token) {
+		p.pos += len(token)
+		return true
+	}
+	return false
+}
+
+func (p *filterParser) parseOr() (condition, error) {
+	left, err := p.parseAnd()
+	if err != nil {
+		return nil, err
+	}
+
+	for p.consume("||") {
+		right, err := p.parseAnd()
+		if err != nil {
+			return nil, err
+		}
+		left = logical{and: false, left: left, right: right}
+	}
+
+	return left, nil
+}
+
+func (p *filterParser) parseAnd() (condition, error) {
+	left, err := p.parseUnary()
+	if err != nil {
+		return nil, err
+	}
+
+	for p.consume("&&") {
+		right, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		left = logical{and: true, left: left, right: right}
+	}
+
+	return left, nil
+}
+
+func (p *filterParser) parseUnary() (condition, error) {
+	if p.consume("!") {
+		c, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		return not{c}, nil
+	}
+
+	if p.consume("(") {
+		c, err := p.parseOr()
+		if err != nil {
+			return nil, err
+		}
+
+		if !p.consume(")") {
+			return nil, fmt.Errorf("missing ) in filter")
+		}
+		return c, nil
+	}
+
+	return p.parseComparison()
+}
+
+func (p *filterParser) parseComparison() (condition, error) {
+	left, err := p.parseOperand()
+	if err != nil {
+		return nil, err
+	}
+
+	for _, op := range []string{"==", "!=", "<=", ">=", "<", ">"} {
+		if p.consume(op) {
+			right, err := p.parseOperand()
+			if err != nil {
+				return nil, err
+			}
+			return comparison{op: op, left: left, right: right}, nil
+		}
+	}
+
+	path, ok := left.(relativePath)
+	if !ok {
+		return nil, fmt.Errorf("filter literal must be compared")
+	}
+
+	return exists{path}, nil
+}
+
+func (p *filterParser) parseOperand() (operand, error) {
+	p.skipSpace()
+	if p.pos >= len(p.str) {
+		return nil, fmt.Errorf("unexpected end of filter")
+	}
+
+	rest := p.str[p.pos:]
+
+	switch {
+	case rest[0] == '@':
+		tokens, end, err := tokenize(p.str, p.pos+1, true)
+		if err != nil {
+			return nil, err
+		}
+
+		segments, err := compile(tokens)
+		if err != nil {
+			return nil, err
+		}
+
+		p.pos = end
+		return relativePath{segments}, nil
+	case rest[0] == '\'' || rest[0] == '"':
+		str, end, err := readQuoted(p.str, p.pos)
+		if err != nil {
+			return nil, err
+		}
+		p.pos = end
+		return literal{str}, nil
+	}
+
+	for word, v := range map[string]interface{}{"true": true, "false": false, "null": nil} {
+		if strings.HasPrefix(rest, word) {
+			p.pos += len(word)
+			return literal{v}, nil
+		}
+	}
+
+	end := 0
+	for end < len(rest) && strings.ContainsRune("+-.0123456789eE", rune(rest[end])) {
+		end++
+	}
+
+	n, err := strconv.ParseFloat(rest[:end], 64)
+	if err != nil {
+		return nil, fmt.Errorf("unexpected %q in filter", rest)
+	}
+
+	p.pos += end
+	return literal{n}, nil
+}
@@ -1,4 +1,4 @@
-// Simple Implementation of JSON Path for state machine
+// Implementation of JSON Path for state machine
 package jsonpath
 
 import (
@@ -6,6 +6,7 @@ import (
 	"errors"
 	"fmt"
 	"reflect"
+	"sort"
 	"strings"
 	"time"
 )
@@ -24,15 +25,18 @@ nil for JSON null
 
 var NOT_FOUND_ERROR = errors.New("Not Found")
 
+// Path is a compiled JSON path e.g. $.a['b'][0], $.items[*].id or $..books[?(@.price < 10)]
 type Path struct {
-	path []string
+	path     []string
+	segments []segment
+
+	context bool // starts with $$, selecting from the Context Object
 }
 
 // NewPath takes string returns JSONPath Object
 func NewPath(path_string string) (*Path, error) {
 	path := Path{}
-	path_array, err := ParsePathString(path_string)
-	path.path = path_array
+	err := path.parse(path_string)
 	return &path, err
 }
 
@@ -45,56 +49,63 @@ func (path *Path) UnmarshalJSON(b []byte) error {
 		return err
 	}
 
-	path_array, err := ParsePathString(path_string)
-
-	if err != nil {
-		return err
-	}
-
-	path.path = path_array
-	return nil
+	return path.parse(path_string)
 }
 
 // MarshalJSON converts path to json string
 func (path *Path) MarshalJSON() ([]byte, error) {
-	if len(path.path) == 0 {
-		return json.Marshal("$")
-	}
 	return json.Marshal(path.String())
 }
 
 func (path *Path) String() string {
-	return fmt.Sprintf("$.%v", strings.Join(path.path[:], "."))
+	str := "$"
+	if path.context {
+		str = "$$"
+	}
+	for _, token := range path.path {
+		if strings.HasPrefix(token, "[") || strings.HasPrefix(token, "..") {
+			str += token
+		} else {
+			str += "." + token
+		}
+	}
+	return str
 }
 
-// ParsePathString parses a path string
-func ParsePathString(path_string string) ([]string, error) {
-	// must start with $.<value> otherwise empty path
-	if path_string == "" || path_string[0:1] != "$" {
-		return nil, fmt.Errorf("Bad JSON path: must start with $")
+// IsReferencePath returns true if the path can only identify a single node,
+// i.e. it has no wildcards, slices, unions, filters or descendant segments
+func (path *Path) IsReferencePath() bool {
+	if path == nil {
+		return true
 	}
+	return definite(path.segments)
+}
 
-	if path_string == "$" {
-		// Default is no path
-		return []string{}, nil
-	}
+// IsContextPath returns true if the path starts with $$,
+// the caller must Get it from the Context Object instead of the input
+func (path *Path) IsContextPath() bool {
+	return path != nil && path.context
+}
 
-	if len(path_string) < 2 {
-		// This handles the case for $. or $* which are invalid
-		return nil, fmt.Errorf("Bad JSON path: cannot not be 2 characters")
+func (path *Path) parse(path_string string) error {
+	path.context = strings.HasPrefix(path_string, "$$")
+	if path.context {
+		path_string = path_string[1:]
 	}
 
-	head := path_string[2:len(path_string)]
-	path_array := strings.Split(head, ".")
+	tokens, err := ParsePathString(path_string)
+	if err != nil {
+		return err
+	}
 
-	// if path contains an "" error
-	for _, p := range path_array {
-		if p == "" {
-			return nil, fmt.Errorf("Bad JSON path: has empty element")
-		}
+	segments, err := compile(tokens)
+	if err != nil {
+		return err
 	}
-	// Simple Path Builder
-	return path_array, nil
+
+	path.path = tokens
+	path.segments = segments
+	return nil
 }
 
 // PUBLIC METHODS
@@ -203,12 +214,12 @@ func (path *Path) Get(input interface{}) (value interface{}, err error) {
 	if path == nil {
 		return input, nil // Default is $
 	}
-	return recursiveGet(input, path.path)
+	return get(input, path.segments)
 }
 
 // GetSlice returns array from Path
 
-func (path *Path) GetSlice(input interface{}) (output []interface{}, err error ) {
+func (path *Path) GetSlice(input interface{}) (output []interface{}, err error) {
 	output_value, err := path.Get(input)
 
 	if err != nil {
@@ -225,13 +236,11 @@ func (path *Path) GetSlice(input interface{}) (output []interface{}, err error )
 	return output, nil
 }
 
-// Set sets a Value in a map with Path
+// Set sets a Value in a map with Path, the path must be a reference path
 func (path *Path) Set(input interface{}, value interface{}) (output map[string]interface{}, err error) {
-	var set_path []string
-	if path == nil {
-		set_path = []string{} // default "$"
-	} else {
-		set_path = path.path
+	var set_path []segment
+	if path != nil {
+		set_path = path.segments
 	}
 
 	if len(set_path) == 0 {
@@ -244,12 +253,50 @@ func (path *Path) Set(input interface{}, value interface{}) (output map[string]i
 			return nil, fmt.Errorf("Cannot Set value %q type %q in root JSON path $", value, reflect.TypeOf(value))
 		}
 	}
-	return recursiveSet(input, value, set_path), nil
+
+	if !path.IsReferencePath() {
+		return nil, fmt.Errorf("Cannot Set value with JSON path %v: must be a reference path", path)
+	}
+
+	if len(set_path[0].indexes) > 0 {
+		return nil, fmt.Errorf("Cannot Set value with JSON path %v: root must be an object", path)
+	}
+
+	set, err := recursiveSet(input, value, set_path)
+	if err != nil {
+		return nil, fmt.Errorf("Cannot Set value with JSON path %v: %v", path, err)
+	}
+
+	return set.(map[string]interface{}), nil
 }
 
 // PRIVATE METHODS
 
-func recursiveSet(data interface{}, value interface{}, path []string) (output map[string]interface{}) {
+func recursiveSet(data interface{}, value interface{}, path []segment) (interface{}, error) {
+	if len(path[0].indexes) > 0 {
+		data_slice, ok := data.([]interface{})
+		if !ok {
+			return nil, fmt.Errorf("index %v of non array", path[0].indexes[0])
+		}
+
+		i, ok := normalizeIndex(path[0].indexes[0], len(data_slice))
+		if !ok {
+			return nil, fmt.Errorf("index %v out of range", path[0].indexes[0])
+		}
+
+		if len(path) == 1 {
+			data_slice[i] = value
+			return data_slice, nil
+		}
+
+		v, err := recursiveSet(data_slice[i], value, path[1:])
+		if err != nil {
+			return nil, err
+		}
+		data_slice[i] = v
+		return data_slice, nil
+	}
+
 	var data_map map[string]interface{}
 
 	switch data.(type) {
@@ -261,35 +308,208 @@ func recursiveSet(data interface{}, value interface{}, path []string) (output ma
 		data_map = make(map[string]interface{})
 	}
 
+	key := path[0].keys[0]
 	if len(path) == 1 {
-		data_map[path[0]] = value
-	} else {
-		data_map[path[0]] = recursiveSet(data_map[path[0]], value, path[1:])
+		data_map[key] = value
+		return data_map, nil
 	}
 
-	return data_map
+	v, err := recursiveSet(data_map[key], value, path[1:])
+	if err != nil {
+		return nil, err
+	}
+	data_map[key] = v
+	return data_map, nil
 }
 
-func recursiveGet(data interface{}, path []string) (interface{}, error) {
-	if len(path) == 0 {
-		return data, nil
+// definite returns true if the segments select at most one node
+func definite(segments []segment) bool {
+	for _, s := range segments {
+		if s.recursive || s.wildcard || s.slice != nil || s.filter != nil || len(s.keys)+len(s.indexes) != 1 {
+			return false
+		}
 	}
+	return true
+}
 
-	if data == nil {
-		return nil, errors.New("Not Found")
+// get returns the node for a definite path, otherwise the list of all selected nodes
+func get(data interface{}, segments []segment) (interface{}, error) {
+	if definite(segments) {
+		return getDefinite(data, segments)
 	}
+	return selectAll(data, segments), nil
+}
 
-	switch data.(type) {
-	case map[string]interface{}:
-		value, ok := data.(map[string]interface{})[path[0]]
+func getDefinite(data interface{}, segments []segment) (interface{}, error) {
+	for _, s := range segments {
+		if data == nil {
+			return nil, NOT_FOUND_ERROR
+		}
+
+		var ok bool
+		if len(s.keys) == 1 {
+			data, ok = child(data, s.keys[0])
+		} else {
+			data, ok = element(data, s.indexes[0])
+		}
 
 		if !ok {
-			return data, NOT_FOUND_ERROR
+			return nil, NOT_FOUND_ERROR
 		}
+	}
 
-		return recursiveGet(value, path[1:])
+	return data, nil
+}
+
+func selectAll(data interface{}, segments []segment) []interface{} {
+	nodes := []interface{}{data}
+	for _, s := range segments {
+		nodes = s.apply(nodes)
+	}
+	return nodes
+}
 
+func (s segment) apply(nodes []interface{}) []interface{} {
+	selected := []interface{}{}
+	for _, node := range nodes {
+		if s.recursive {
+			for _, d := range descendants(node, nil) {
+				selected = s.selectFrom(d, selected)
+			}
+		} else {
+			selected = s.selectFrom(node, selected)
+		}
+	}
+	return selected
+}
+
+func (s segment) selectFrom(node interface{}, selected []interface{}) []interface{} {
+	switch {
+	case s.wildcard:
+		return append(selected, children(node)...)
+	case s.filter != nil:
+		for _, c := range children(node) {
+			if s.filter.match(c) {
+				selected = append(selected, c)
+			}
+		}
+	case s.slice != nil:
+		if data_slice, ok := asSlice(node); ok {
+			for _, i := range s.slice.indexes(len(data_slice)) {
+				selected = append(selected, data_slice[i])
+			}
+		}
+	case len(s.keys) > 0:
+		for _, key := range s.keys {
+			if v, ok := child(node, key); ok {
+				selected = append(selected, v)
+			}
+		}
 	default:
-		return data, NOT_FOUND_ERROR
+		for _, index := range s.indexes {
+			if v, ok := element(node, index); ok {
+				selected = append(selected, v)
+			}
+		}
+	}
+	return selected
+}
+
+// descendants returns the node and all nodes under it
+func descendants(node interface{}, all []interface{}) []interface{} {
+	all = append(all, node)
+	for _, c := range children(node) {
+		all = descendants(c, all)
+	}
+	return all
+}
+
+// children returns the elements of an array or the values of an object ordered by key
+func children(node interface{}) []interface{} {
+	if data_slice, ok := asSlice(node); ok {
+		return data_slice
+	}
+
+	data_map, ok := asMap(node)
+	if !ok {
+		return nil
+	}
+
+	keys := make([]string, 0, len(data_map))
+	for k := range data_map {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	values := make([]interface{}, 0, len(keys))
+	for _, k := range keys {
+		values = append(values, data_map[k])
+	}
+	return values
+}
+
+func child(node interface{}, key string) (interface{}, bool) {
+	data_map, ok := asMap(node)
+	if !ok {
+		return nil, false
+	}
+	v, ok := data_map[key]
+	return v, ok
+}
+
+func element(node interface{}, index int) (interface{}, bool) {
+	data_slice, ok := asSlice(node)
+	if !ok {
+		return nil, false
+	}
+
+	i, ok := normalizeIndex(index, len(data_slice))
+	if !ok {
+		return nil, false
+	}
+	return data_slice[i], true
+}
+
+// normalizeIndex converts negative indexes to count from the end
+func normalizeIndex(index int, length int) (int, bool) {
+	if index < 0 {
+		index += length
+	}
+	return index, index >= 0 && index < length
+}
+
+// asMap also accepts maps with string keys not created by JSON Unmarshal
+func asMap(node interface{}) (map[string]interface{}, bool) {
+	if data_map, ok := node.(map[string]interface{}); ok {
+		return data_map, true
+	}
+
+	v := reflect.ValueOf(node)
+	if v.Kind() != reflect.Map || v.Type().Key().Kind() != reflect.String {
+		return nil, false
+	}
+
+	data_map := make(map[string]interface{}, v.Len())
+	for _, k := range v.MapKeys() {
+		data_map[k.String()] = v.MapIndex(k).Interface()
+	}
+	return data_map, true
+}
+
+// asSlice also accepts slices not created by JSON Unmarshal e.g. []map[string]interface{}
+func asSlice(node interface{}) ([]interface{}, bool) {
+	if data_slice, ok := node.([]interface{}); ok {
+		return data_slice, true
+	}
+
+	v := reflect.ValueOf(node)
+	if v.Kind() != reflect.Slice {
+		return nil, false
+	}
+
+	data_slice := make([]interface{}, v.Len())
+	for i := range data_slice {
+		data_slice[i] = v.Index(i).Interface()
 	}
+	return data_slice, true
 }
@@ -124,7 +124,7 @@ func Test_JSONPath_GetString(t *testing.T) {
 }
 
 func Test_JSONPath_GetSplice(t *testing.T) {
-	test := []interface{}{1,2,3}
+	test := []interface{}{1, 2, 3}
 	outer := map[string]interface{}{"x": test}
 
 	path, err := NewPath("$.x")
@@ -135,3 +135,83 @@ func Test_JSONPath_GetSplice(t *testing.T) {
 	assert.Equal(t, out, test)
 
 }
+
+var storeData = map[string]interface{}{
+	"store": map[string]interface{}{
+		"book": []interface{}{
+			map[string]interface{}{"title": "A", "price": 8.95, "tags": []interface{}{"x"}},
+			map[string]interface{}{"title": "B", "price": 12.99},
+			map[string]interface{}{"title": "C", "price": 8.99, "isbn": "0-553"},
+			map[string]interface{}{"title": "D", "price": 22.99, "isbn": "0-395"},
+		},
+		"bicycle":    map[string]interface{}{"color": "red", "price": 19.95},
+		"key.dotted": "dot",
+	},
+}
+
+func Test_JSONPath_Get_Expressions(t *testing.T) {
+	tests := map[string]interface{}{
+		"$.store.book[0].title":                                       "A",
+		"$.store.book[-1].title":                                      "D",
+		"$['store']['key.dotted']":                                    "dot",
+		"$.store[\"bicycle\"].color":                                  "red",
+		"$.store.book[*].title":                                       []interface{}{"A", "B", "C", "D"},
+		"$.store.book[1:3].title":                                     []interface{}{"B", "C"},
+		"$.store.book[::-2].title":                                    []interface{}{"D", "B"},
+		"$.store.book[:2].title":                                      []interface{}{"A", "B"},
+		"$.store.book[-1:].title":                                     []interface{}{"D"},
+		"$.store.book[0,2].title":                                     []interface{}{"A", "C"},
+		"$.store.bicycle['color','price']":                            []interface{}{"red", 19.95},
+		"$.store.bicycle.*":                                           []interface{}{"red", 19.95},
+		"$..isbn":                                                     []interface{}{"0-553", "0-395"},
+		"$.store..price":                                              []interface{}{19.95, 8.95, 12.99, 8.99, 22.99},
+		"$..book[2].title":                                            []interface{}{"C"},
+		"$.store.book[?(@.isbn)].title":                               []interface{}{"C", "D"},
+		"$.store.book[?(!@.isbn)].title":                              []interface{}{"A", "B"},
+		"$.store.book[?(@.price < 10)].title":                         []interface{}{"A", "C"},
+		"$.store.book[?(@.price > 10 && @.title != 'D')].title":       []interface{}{"B"},
+		"$.store.book[?(@.title == 'A' || (@.price >= 22.99))].title": []interface{}{"A", "D"},
+		"$.store.book[?(@.tags[0] == \"x\")].title":                   []interface{}{"A"},
+		"$.store.book[?(@.missing < 1)]":                              []interface{}{},
+		"$.store.book[10:]":                                           []interface{}{},
+	}
+
+	for str, expected := range tests {
+		path, err := NewPath(str)
+		assert.NoError(t, err, str)
+
+		out, err := path.Get(storeData)
+		assert.NoError(t, err, str)
+		assert.Equal(t, expected, out, str)
+	}
+}
+
+func Test_JSONPath_Get_Index_NotFound(t *testing.T) {
+	for _, str := range []string{"$.store.book[4]", "$.store.book[-5]", "$.store.bicycle[0]", "$.store.book.title"} {
+		path, err := NewPath(str)
+		assert.NoError(t, err)
+
+		_, err = path.Get(storeData)
+		assert.Equal(t, NOT_FOUND_ERROR, err, str)
+	}
+}
+
+func Test_JSONPath_Get_NonJSONTypes(t *testing.T) {
+	test := map[string]interface{}{"x": []map[string]interface{}{{"a": "b"}}}
+
+	path, err := NewPath("$.x[0].a")
+	assert.NoError(t, err)
+
+	out, err := path.Get(test)
+	assert.NoError(t, err)
+	assert.Equal(t, "b", out)
+}
+
+func Test_JSONPath_GetSlice_Wildcard(t *testing.T) {
+	path, err := NewPath("$.store.book[*].price")
+	assert.NoError(t, err)
+
+	out, err := path.GetSlice(storeData)
+	assert.NoError(t, err)
+	assert.Equal(t, 4, len(out))
+}
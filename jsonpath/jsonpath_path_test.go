@@ -55,3 +55,87 @@ func Test_JSONPath_Parsing(t *testing.T) {
 	assert.Equal(t, pathstr.path[1], "b")
 	assert.Equal(t, pathstr.path[2], "c")
 }
+
+func Test_JSONPath_Parse_Tokens(t *testing.T) {
+	tests := map[string][]string{
+		"$.a[0].b":              {"a", "[0]", "b"},
+		"$['key with.dot'].b":   {"['key with.dot']", "b"},
+		"$.items[*].id":         {"items", "[*]", "id"},
+		"$..name":               {"..name"},
+		"$..[0]":                {"..[0]"},
+		"$.a[1:3]":              {"a", "[1:3]"},
+		"$.a[?(@.b[0] == ']')]": {"a", "[?(@.b[0] == ']')]"},
+		"$.a.*":                 {"a", "*"},
+	}
+
+	for str, expected := range tests {
+		out, err := ParsePathString(str)
+		assert.NoError(t, err, str)
+		assert.Equal(t, expected, out, str)
+	}
+}
+
+func Test_JSONPath_Parse_Errors(t *testing.T) {
+	bad := []string{
+		"",
+		"a.b",
+		"$.",
+		"$a",
+		"$.a..",
+		"$.a.[0]",
+		"$.a[0",
+		"$.a[]",
+		"$.a[x]",
+		"$.a[1:2:0]",
+		"$.a['b]",
+		"$.a[?(@.b ==)]",
+		"$.a[?(@.b == 1]",
+	}
+
+	for _, str := range bad {
+		_, err := NewPath(str)
+		assert.Error(t, err, str)
+	}
+}
+
+func Test_JSONPath_String(t *testing.T) {
+	for _, str := range []string{"$", "$.a.b", "$.a[0]['b c']", "$..x[*]", "$.a[?(@.b > 1)]", "$$", "$$.Execution.Id"} {
+		path, err := NewPath(str)
+		assert.NoError(t, err)
+		assert.Equal(t, str, path.String())
+	}
+}
+
+func Test_JSONPath_IsReferencePath(t *testing.T) {
+	for _, str := range []string{"$", "$.a.b", "$.a[0]", "$['a b'][-1]"} {
+		path, err := NewPath(str)
+		assert.NoError(t, err)
+		assert.True(t, path.IsReferencePath(), str)
+	}
+
+	for _, str := range []string{"$.a[*]", "$..a", "$.a[0,1]", "$['a','b']", "$.a[0:1]", "$.a[?(@.b)]", "$.*"} {
+		path, err := NewPath(str)
+		assert.NoError(t, err)
+		assert.False(t, path.IsReferencePath(), str)
+	}
+}
+
+func Test_JSONPath_IsContextPath(t *testing.T) {
+	path, err := NewPath("$$.Map.Item.Index")
+	assert.NoError(t, err)
+	assert.True(t, path.IsContextPath())
+
+	index, err := path.Get(map[string]interface{}{"Map": map[string]interface{}{"Item": map[string]interface{}{"Index": 1.0}}})
+	assert.NoError(t, err)
+	assert.Equal(t, 1.0, index)
+
+	path, err = NewPath("$.Map")
+	assert.NoError(t, err)
+	assert.False(t, path.IsContextPath())
+
+	var nilPath *Path
+	assert.False(t, nilPath.IsContextPath())
+
+	_, err = NewPath("$$$")
+	assert.Error(t, err)
+}
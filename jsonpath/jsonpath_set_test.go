@@ -75,3 +75,47 @@ func Test_JSONPath_Set_Overwrite(t *testing.T) {
 	assert.NoError(t, err)
 	assert.Equal(t, "s", out)
 }
+
+func Test_JSONPath_Set_Index(t *testing.T) {
+	test := map[string]interface{}{"a": []interface{}{"x", map[string]interface{}{"b": "c"}}}
+
+	path, err := NewPath("$.a[1].b")
+	assert.NoError(t, err)
+
+	setted, err := path.Set(test, "s")
+	assert.NoError(t, err)
+
+	out, err := path.Get(setted)
+	assert.NoError(t, err)
+	assert.Equal(t, "s", out)
+
+	path, err = NewPath("$.a[-2]")
+	assert.NoError(t, err)
+
+	setted, err = path.Set(test, "y")
+	assert.NoError(t, err)
+	assert.Equal(t, "y", setted["a"].([]interface{})[0])
+}
+
+func Test_JSONPath_Set_Bracket(t *testing.T) {
+	test := map[string]interface{}{}
+
+	path, err := NewPath("$['a.b']['c']")
+	assert.NoError(t, err)
+
+	setted, err := path.Set(test, "s")
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]interface{}{"a.b": map[string]interface{}{"c": "s"}}, setted)
+}
+
+func Test_JSONPath_Set_Errors(t *testing.T) {
+	test := map[string]interface{}{"a": []interface{}{"x"}, "b": "c"}
+
+	for _, str := range []string{"$.a[*]", "$..a", "$.a[0:1]", "$.a[?(@ == 'x')]", "$['a','b']", "$.a[1]", "$.b[0]", "$[0]"} {
+		path, err := NewPath(str)
+		assert.NoError(t, err)
+
+		_, err = path.Set(test, "s")
+		assert.Error(t, err, str)
+	}
+}
@@ -0,0 +1,336 @@
+package jsonpath
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// segment is a compiled path token, it selects nodes from its parent nodes
+type segment struct {
+	recursive bool // ".." descendant segment
+	wildcard  bool
+	keys      []string
+	indexes   []int
+	slice     *slice
+	filter    condition
+}
+
+// slice is an array slice [start:end:step]
+type slice struct {
+	start *int
+	end   *int
+	step  int
+}
+
+// ParsePathString tokenizes a path string
+// e.g. "$.a['b.c'][0]..d" becomes ["a", "['b.c']", "[0]", "..d"]
+func ParsePathString(path_string string) ([]string, error) {
+	// must start with $ otherwise invalid path
+	if path_string == "" || path_string[0:1] != "$" {
+		return nil, fmt.Errorf("Bad JSON path: must start with $")
+	}
+
+	tokens, _, err := tokenize(path_string, 1, false)
+	return tokens, err
+}
+
+// tokenize reads tokens from pos until the end of the string
+// in a filter the path ends at whitespace, operators or a closing parenthesis
+func tokenize(str string, pos int, inFilter bool) ([]string, int, error) {
+	tokens := []string{}
+
+	for pos < len(str) {
+		switch {
+		case strings.HasPrefix(str[pos:], ".."):
+			pos += 2
+			token, end, err := nextToken(str, pos, inFilter)
+			if err != nil {
+				return nil, pos, err
+			}
+			tokens = append(tokens, ".."+token)
+			pos = end
+		case str[pos] == '.':
+			pos++
+			if pos < len(str) && str[pos] == '[' {
+				return nil, pos, fmt.Errorf("Bad JSON path: has empty element")
+			}
+			token, end, err := nextToken(str, pos, inFilter)
+			if err != nil {
+				return nil, pos, err
+			}
+			tokens = append(tokens, token)
+			pos = end
+		case str[pos] == '[':
+			token, end, err := bracketToken(str, pos)
+			if err != nil {
+				return nil, pos, err
+			}
+			tokens = append(tokens, token)
+			pos = end
+		case inFilter:
+			return tokens, pos, nil
+		default:
+			return nil, pos, fmt.Errorf("Bad JSON path: unexpected %q", str[pos:])
+		}
+	}
+
+	return tokens, pos, nil
+}
+
+// nextToken reads a bracket or dot notation name
+func nextToken(str string, pos int, inFilter bool) (string, int, error) {
+	if pos < len(str) && str[pos] == '[' {
+		return bracketToken(str, pos)
+	}
+
+	end := pos
+	for end < len(str) && str[end] != '.' && str[end] != '[' {
+		if inFilter && strings.ContainsRune(" \t\n)=!<>&|,", rune(str[end])) {
+			break
+		}
+		end++
+	}
+
+	if end == pos {
+		return "", pos, fmt.Errorf("Bad JSON path: has empty element")
+	}
+
+	return str[pos:end], end, nil
+}
+
+// bracketToken reads from "[" to the matching "]", skipping quoted strings and parentheses
+func bracketToken(str string, pos int) (string, int, error) {
+	depth := 0
+	var quote byte
+
+	for i := pos + 1; i < len(str); i++ {
+		c := str[i]
+		switch {
+		case quote != 0:
+			if c == '\\' {
+				i++
+			} else if c == quote {
+				quote = 0
+			}
+		case c == '\'' || c == '"':
+			quote = c
+		case c == '(':
+			depth++
+		case c == ')':
+			depth--
+		case c == ']' && depth == 0:
+			if i == pos+1 {
+				return "", pos, fmt.Errorf("Bad JSON path: has empty element")
+			}
+			return str[pos : i+1], i + 1, nil
+		}
+	}
+
+	return "", pos, fmt.Errorf("Bad JSON path: unterminated %q", str[pos:])
+}
+
+// compile turns the tokens into segments
+func compile(tokens []string) ([]segment, error) {
+	segments := []segment{}
+	for _, token := range tokens {
+		seg, err := compileToken(token)
+		if err != nil {
+			return nil, err
+		}
+		segments = append(segments, seg)
+	}
+	return segments, nil
+}
+
+func compileToken(token string) (segment, error) {
+	seg := segment{}
+
+	if strings.HasPrefix(token, "..") {
+		seg.recursive = true
+		token = token[2:]
+	}
+
+	switch {
+	case token == "*":
+		seg.wildcard = true
+	case strings.HasPrefix(token, "["):
+		if err := compileBracket(&seg, strings.TrimSpace(token[1:len(token)-1])); err != nil {
+			return seg, fmt.Errorf("Bad JSON path: %v in %q", err, token)
+		}
+	default:
+		seg.keys = []string{token}
+	}
+
+	return seg, nil
+}
+
+func compileBracket(seg *segment, inner string) error {
+	switch {
+	case inner == "*":
+		seg.wildcard = true
+	case strings.HasPrefix(inner, "?"):
+		filter, err := parseFilter(inner[1:])
+		if err != nil {
+			return err
+		}
+		seg.filter = filter
+	case strings.HasPrefix(inner, "'") || strings.HasPrefix(inner, `"`):
+		keys, err := parseKeys(inner)
+		if err != nil {
+			return err
+		}
+		seg.keys = keys
+	case strings.Contains(inner, ":"):
+		s, err := parseSlice(inner)
+		if err != nil {
+			return err
+		}
+		seg.slice = s
+	default:
+		for _, part := range strings.Split(inner, ",") {
+			index, err := strconv.Atoi(strings.TrimSpace(part))
+			if err != nil {
+				return fmt.Errorf("bad index %q", part)
+			}
+			seg.indexes = append(seg.indexes, index)
+		}
+	}
+
+	return nil
+}
+
+// parseKeys parses a comma separated list of quoted names
+func parseKeys(inner string) ([]string, error) {
+	keys := []string{}
+	pos := 0
+
+	for {
+		key, end, err := readQuoted(inner, pos)
+		if err != nil {
+			return nil, err
+		}
+		keys = append(keys, key)
+
+		rest := strings.TrimLeft(inner[end:], " ")
+		if rest == "" {
+			return keys, nil
+		}
+
+		if rest[0] != ',' {
+			return nil, fmt.Errorf("unexpected %q", rest)
+		}
+
+		rest = strings.TrimLeft(rest[1:], " ")
+		pos = len(inner) - len(rest)
+	}
+}
+
+// readQuoted reads a single or double quoted string starting at pos
+func readQuoted(str string, pos int) (string, int, error) {
+	if pos >= len(str) || (str[pos] != '\'' && str[pos] != '"') {
+		return "", pos, fmt.Errorf("expected quoted string")
+	}
+
+	quote := str[pos]
+	var b strings.Builder
+
+	for i := pos + 1; i < len(str); i++ {
+		c := str[i]
+		switch {
+		case c == '\\' && i+1 < len(str):
+			i++
+			b.WriteByte(str[i])
+		case c == quote:
+			return b.String(), i + 1, nil
+		default:
+			b.WriteByte(c)
+		}
+	}
+
+	return "", pos, fmt.Errorf("unterminated string")
+}
+
+func parseSlice(inner string) (*slice, error) {
+	parts := strings.Split(inner, ":")
+	if len(parts) > 3 {
+		return nil, fmt.Errorf("bad slice %q", inner)
+	}
+
+	s := &slice{step: 1}
+	bounds := []**int{&s.start, &s.end}
+
+	for i, part := range parts {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+
+		n, err := strconv.Atoi(part)
+		if err != nil {
+			return nil, fmt.Errorf("bad slice %q", inner)
+		}
+
+		if i == 2 {
+			s.step = n
+			continue
+		}
+		*bounds[i] = &n
+	}
+
+	if s.step == 0 {
+		return nil, fmt.Errorf("slice step cannot be 0")
+	}
+
+	return s, nil
+}
+
+// indexes returns the selected indexes of an array of length
+func (s *slice) indexes(length int) []int {
+	normalize := func(i int) int {
+		if i < 0 {
+			return length + i
+		}
+		return i
+	}
+
+	clamp := func(i, min, max int) int {
+		if i < min {
+			return min
+		}
+		if i > max {
+			return max
+		}
+		return i
+	}
+
+	indexes := []int{}
+
+	if s.step > 0 {
+		start, end := 0, length
+		if s.start != nil {
+			start = normalize(*s.start)
+		}
+		if s.end != nil {
+			end = normalize(*s.end)
+		}
+
+		for i := clamp(start, 0, length); i < clamp(end, 0, length); i += s.step {
+			indexes = append(indexes, i)
+		}
+		return indexes
+	}
+
+	start, end := length-1, -1
+	if s.start != nil {
+		start = normalize(*s.start)
+	}
+	if s.end != nil {
+		end = normalize(*s.end)
+	}
+
+	for i := clamp(start, -1, length-1); clamp(end, -1, length-1) < i; i += s.step {
+		indexes = append(indexes, i)
+	}
+	return indexes
+}
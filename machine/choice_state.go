@@ -3,11 +3,11 @@ package machine
 import (
 	"context"
 	"fmt"
+	"regexp"
 	"strings"
 	"time"
 
 	"github.com/cleardataeng/step/jsonpath"
-	"github.com/cleardataeng/step/utils/to"
 )
 
 type ChoiceState struct {
@@ -38,6 +38,7 @@ type ChoiceRule struct {
 	StringGreaterThan       *string `json:",omitempty"`
 	StringLessThanEquals    *string `json:",omitempty"`
 	StringGreaterThanEquals *string `json:",omitempty"`
+	StringMatches           *string `json:",omitempty"` // * is a wildcard, \* and \\ are escaped
 
 	NumericEquals            *float64 `json:",omitempty"`
 	NumericLessThan          *float64 `json:",omitempty"`
@@ -53,6 +54,34 @@ type ChoiceRule struct {
 	TimestampLessThanEquals    *time.Time `json:",omitempty"`
 	TimestampGreaterThanEquals *time.Time `json:",omitempty"`
 
+	IsPresent   *bool `json:",omitempty"`
+	IsNull      *bool `json:",omitempty"`
+	IsString    *bool `json:",omitempty"`
+	IsNumeric   *bool `json:",omitempty"`
+	IsBoolean   *bool `json:",omitempty"`
+	IsTimestamp *bool `json:",omitempty"`
+
+	// *Path comparators compare Variable with the value at another path in the input
+	StringEqualsPath            *jsonpath.Path `json:",omitempty"`
+	StringLessThanPath          *jsonpath.Path `json:",omitempty"`
+	StringGreaterThanPath       *jsonpath.Path `json:",omitempty"`
+	StringLessThanEqualsPath    *jsonpath.Path `json:",omitempty"`
+	StringGreaterThanEqualsPath *jsonpath.Path `json:",omitempty"`
+
+	NumericEqualsPath            *jsonpath.Path `json:",omitempty"`
+	NumericLessThanPath          *jsonpath.Path `json:",omitempty"`
+	NumericGreaterThanPath       *jsonpath.Path `json:",omitempty"`
+	NumericLessThanEqualsPath    *jsonpath.Path `json:",omitempty"`
+	NumericGreaterThanEqualsPath *jsonpath.Path `json:",omitempty"`
+
+	BooleanEqualsPath *jsonpath.Path `json:",omitempty"`
+
+	TimestampEqualsPath            *jsonpath.Path `json:",omitempty"`
+	TimestampLessThanPath          *jsonpath.Path `json:",omitempty"`
+	TimestampGreaterThanPath       *jsonpath.Path `json:",omitempty"`
+	TimestampLessThanEqualsPath    *jsonpath.Path `json:",omitempty"`
+	TimestampGreaterThanEqualsPath *jsonpath.Path `json:",omitempty"`
+
 	And []*ChoiceRule `json:",omitempty"`
 	Or  []*ChoiceRule `json:",omitempty"`
 	Not *ChoiceRule   `json:",omitempty"`
@@ -76,7 +105,7 @@ func (cr *ChoiceRule) String() string {
 	}
 
 	if cr.Not != nil {
-		return fmt.Sprintf("!%v", *cr.Variable)
+		return fmt.Sprintf("!(%v)", cr.Not.String())
 	}
 
 	op := ""
@@ -91,6 +120,8 @@ func (cr *ChoiceRule) String() string {
 		op = fmt.Sprintf("<=%v", *cr.StringLessThanEquals)
 	} else if cr.StringGreaterThanEquals != nil {
 		op = fmt.Sprintf(">=%v", *cr.StringGreaterThanEquals)
+	} else if cr.StringMatches != nil {
+		op = fmt.Sprintf("=~%v", *cr.StringMatches)
 	} else if cr.NumericEquals != nil {
 		op = fmt.Sprintf("=%v", *cr.NumericEquals)
 	} else if cr.NumericLessThan != nil {
@@ -113,15 +144,29 @@ func (cr *ChoiceRule) String() string {
 		op = fmt.Sprintf("<=%v", *cr.TimestampLessThanEquals)
 	} else if cr.TimestampGreaterThanEquals != nil {
 		op = fmt.Sprintf(">=%v", *cr.TimestampGreaterThanEquals)
+	} else if cr.IsPresent != nil {
+		op = fmt.Sprintf(" IsPresent=%v", *cr.IsPresent)
+	} else if cr.IsNull != nil {
+		op = fmt.Sprintf(" IsNull=%v", *cr.IsNull)
+	} else if cr.IsString != nil {
+		op = fmt.Sprintf(" IsString=%v", *cr.IsString)
+	} else if cr.IsNumeric != nil {
+		op = fmt.Sprintf(" IsNumeric=%v", *cr.IsNumeric)
+	} else if cr.IsBoolean != nil {
+		op = fmt.Sprintf(" IsBoolean=%v", *cr.IsBoolean)
+	} else if cr.IsTimestamp != nil {
+		op = fmt.Sprintf(" IsTimestamp=%v", *cr.IsTimestamp)
+	} else if path := cr.comparatorPath(); path != nil {
+		op = fmt.Sprintf("%v%v", cr.comparatorPathOp(), path.String())
 	}
 
 	return fmt.Sprintf("%v%v", cr.Variable.String(), op)
 }
 
 func (s *ChoiceState) process(ctx context.Context, input interface{}) (interface{}, *string, error) {
-	next := chooseNextState(input, s.Default, s.Choices)
+	next := chooseNextState(ctx, input, s.Default, s.Choices)
 	if next == nil {
-		return nil, nil, fmt.Errorf("State Choice Error")
+		return nil, nil, &statesError{"States.NoChoiceMatched", "no Choice matched and no Default is defined"}
 	}
 	return input, next, nil
 }
@@ -136,20 +181,21 @@ func (s *ChoiceState) Execute(ctx context.Context, input interface{}) (output in
 	)(ctx, input)
 }
 
-func chooseNextState(input interface{}, default_choice *string, choices []*Choice) *string {
+func chooseNextState(ctx context.Context, input interface{}, default_choice *string, choices []*Choice) *string {
 	for _, choice := range choices {
-		if choiceRulePositive(input, &choice.ChoiceRule) {
+		if choiceRulePositive(ctx, input, &choice.ChoiceRule) {
 			return choice.Next
 		}
 	}
 	return default_choice
 }
 
-func choiceRulePositive(input interface{}, cr *ChoiceRule) bool {
+// choiceRulePositive evaluates the rule, $$ paths are evaluated against the Context Object
+func choiceRulePositive(ctx context.Context, input interface{}, cr *ChoiceRule) bool {
 	if cr.And != nil {
 		for _, a := range cr.And {
 			// if any choices have false then return false
-			if !choiceRulePositive(input, a) {
+			if !choiceRulePositive(ctx, input, a) {
 				return false
 			}
 		}
@@ -159,7 +205,7 @@ func choiceRulePositive(input interface{}, cr *ChoiceRule) bool {
 	if cr.Or != nil {
 		for _, a := range cr.Or {
 			// if any choices have true then return true
-			if choiceRulePositive(input, a) {
+			if choiceRulePositive(ctx, input, a) {
 				return true
 			}
 		}
@@ -167,9 +213,27 @@ func choiceRulePositive(input interface{}, cr *ChoiceRule) bool {
 	}
 
 	if cr.Not != nil {
-		return !choiceRulePositive(input, cr.Not)
+		return !choiceRulePositive(ctx, input, cr.Not)
+	}
+
+	variableInput := pathInput(ctx, cr.Variable, input)
+
+	if cr.IsPresent != nil {
+		_, err := cr.Variable.Get(variableInput)
+		return (err == nil) == *cr.IsPresent
+	}
+
+	if isType := typeTestPositive(variableInput, cr); isType != nil {
+		return *isType
 	}
 
+	cr, err := literalChoiceRule(pathInput(ctx, cr.comparatorPath(), input), cr)
+	if err != nil {
+		return false // *Path not found or bad type
+	}
+
+	input = variableInput
+
 	if cr.StringEquals != nil {
 		vstr, err := cr.Variable.GetString(input)
 		if err != nil {
@@ -210,6 +274,14 @@ func choiceRulePositive(input interface{}, cr *ChoiceRule) bool {
 		return *vstr >= *cr.StringGreaterThanEquals
 	}
 
+	if cr.StringMatches != nil {
+		vstr, err := cr.Variable.GetString(input)
+		if err != nil {
+			return false // either not found or bad type
+		}
+		return stringMatches(*vstr, *cr.StringMatches)
+	}
+
 	// NUMBERs
 	if cr.NumericEquals != nil {
 		vnum, err := cr.Variable.GetNumber(input)
@@ -302,23 +374,172 @@ func choiceRulePositive(input interface{}, cr *ChoiceRule) bool {
 	return false
 }
 
+// typeTestPositive returns the result of an Is* type test, or nil if the rule is not a type test
+// A type test on a Variable that is not present is always false
+func typeTestPositive(input interface{}, cr *ChoiceRule) *bool {
+	var expected *bool
+	var test func(v interface{}) bool
+
+	switch {
+	case cr.IsNull != nil:
+		expected = cr.IsNull
+		test = func(v interface{}) bool { return v == nil }
+	case cr.IsString != nil:
+		expected = cr.IsString
+		test = func(v interface{}) bool { _, ok := v.(string); return ok }
+	case cr.IsNumeric != nil:
+		expected = cr.IsNumeric
+		test = func(v interface{}) bool {
+			switch v.(type) {
+			case float64, int:
+				return true
+			}
+			return false
+		}
+	case cr.IsBoolean != nil:
+		expected = cr.IsBoolean
+		test = func(v interface{}) bool { _, ok := v.(bool); return ok }
+	case cr.IsTimestamp != nil:
+		expected = cr.IsTimestamp
+		test = func(v interface{}) bool {
+			str, ok := v.(string)
+			if !ok {
+				return false
+			}
+			_, err := time.Parse(time.RFC3339, str)
+			return err == nil
+		}
+	default:
+		return nil
+	}
+
+	result := false
+	if v, err := cr.Variable.Get(input); err == nil {
+		result = test(v) == *expected
+	}
+	return &result
+}
+
+// stringMatches matches str against pattern where * matches zero or more characters
+// a literal * or \ is escaped with \
+func stringMatches(str string, pattern string) bool {
+	var b strings.Builder
+	b.WriteString("^")
+
+	for i := 0; i < len(pattern); i++ {
+		switch {
+		case pattern[i] == '\\' && i+1 < len(pattern):
+			i++
+			b.WriteString(regexp.QuoteMeta(pattern[i : i+1]))
+		case pattern[i] == '*':
+			b.WriteString("(?s:.*)")
+		default:
+			b.WriteString(regexp.QuoteMeta(pattern[i : i+1]))
+		}
+	}
+
+	b.WriteString("$")
+	return regexp.MustCompile(b.String()).MatchString(str)
+}
+
+// comparatorPath returns the path of a *Path comparator
+func (cr *ChoiceRule) comparatorPath() *jsonpath.Path {
+	for _, path := range []*jsonpath.Path{
+		cr.StringEqualsPath, cr.StringLessThanPath, cr.StringGreaterThanPath, cr.StringLessThanEqualsPath, cr.StringGreaterThanEqualsPath,
+		cr.NumericEqualsPath, cr.NumericLessThanPath, cr.NumericGreaterThanPath, cr.NumericLessThanEqualsPath, cr.NumericGreaterThanEqualsPath,
+		cr.BooleanEqualsPath,
+		cr.TimestampEqualsPath, cr.TimestampLessThanPath, cr.TimestampGreaterThanPath, cr.TimestampLessThanEqualsPath, cr.TimestampGreaterThanEqualsPath,
+	} {
+		if path != nil {
+			return path
+		}
+	}
+	return nil
+}
+
+func (cr *ChoiceRule) comparatorPathOp() string {
+	switch {
+	case cr.StringLessThanPath != nil, cr.NumericLessThanPath != nil, cr.TimestampLessThanPath != nil:
+		return "<"
+	case cr.StringGreaterThanPath != nil, cr.NumericGreaterThanPath != nil, cr.TimestampGreaterThanPath != nil:
+		return ">"
+	case cr.StringLessThanEqualsPath != nil, cr.NumericLessThanEqualsPath != nil, cr.TimestampLessThanEqualsPath != nil:
+		return "<="
+	case cr.StringGreaterThanEqualsPath != nil, cr.NumericGreaterThanEqualsPath != nil, cr.TimestampGreaterThanEqualsPath != nil:
+		return ">="
+	}
+	return "="
+}
+
+// literalChoiceRule converts a *Path comparator into the literal comparator
+// with the value found in the input, other rules are returned unchanged
+func literalChoiceRule(input interface{}, cr *ChoiceRule) (*ChoiceRule, error) {
+	if cr.comparatorPath() == nil {
+		return cr, nil
+	}
+
+	lit := &ChoiceRule{Variable: cr.Variable}
+	var err error
+
+	switch {
+	case cr.StringEqualsPath != nil:
+		lit.StringEquals, err = cr.StringEqualsPath.GetString(input)
+	case cr.StringLessThanPath != nil:
+		lit.StringLessThan, err = cr.StringLessThanPath.GetString(input)
+	case cr.StringGreaterThanPath != nil:
+		lit.StringGreaterThan, err = cr.StringGreaterThanPath.GetString(input)
+	case cr.StringLessThanEqualsPath != nil:
+		lit.StringLessThanEquals, err = cr.StringLessThanEqualsPath.GetString(input)
+	case cr.StringGreaterThanEqualsPath != nil:
+		lit.StringGreaterThanEquals, err = cr.StringGreaterThanEqualsPath.GetString(input)
+	case cr.NumericEqualsPath != nil:
+		lit.NumericEquals, err = cr.NumericEqualsPath.GetNumber(input)
+	case cr.NumericLessThanPath != nil:
+		lit.NumericLessThan, err = cr.NumericLessThanPath.GetNumber(input)
+	case cr.NumericGreaterThanPath != nil:
+		lit.NumericGreaterThan, err = cr.NumericGreaterThanPath.GetNumber(input)
+	case cr.NumericLessThanEqualsPath != nil:
+		lit.NumericLessThanEquals, err = cr.NumericLessThanEqualsPath.GetNumber(input)
+	case cr.NumericGreaterThanEqualsPath != nil:
+		lit.NumericGreaterThanEquals, err = cr.NumericGreaterThanEqualsPath.GetNumber(input)
+	case cr.BooleanEqualsPath != nil:
+		lit.BooleanEquals, err = cr.BooleanEqualsPath.GetBool(input)
+	case cr.TimestampEqualsPath != nil:
+		lit.TimestampEquals, err = cr.TimestampEqualsPath.GetTime(input)
+	case cr.TimestampLessThanPath != nil:
+		lit.TimestampLessThan, err = cr.TimestampLessThanPath.GetTime(input)
+	case cr.TimestampGreaterThanPath != nil:
+		lit.TimestampGreaterThan, err = cr.TimestampGreaterThanPath.GetTime(input)
+	case cr.TimestampLessThanEqualsPath != nil:
+		lit.TimestampLessThanEquals, err = cr.TimestampLessThanEqualsPath.GetTime(input)
+	case cr.TimestampGreaterThanEqualsPath != nil:
+		lit.TimestampGreaterThanEquals, err = cr.TimestampGreaterThanEqualsPath.GetTime(input)
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	return lit, nil
+}
+
 // VALIDATION LOGIC
 
 func (s *ChoiceState) Validate() error {
-	s.SetType(to.Strp("Choice"))
+	defaultType(s, "Choice")
 
 	if err := ValidateNameAndType(s); err != nil {
 		return fmt.Errorf("%v %v", errorPrefix(s), err)
 	}
 
 	if len(s.Choices) == 0 {
-		return fmt.Errorf("%v Must have Choices", errorPrefix(s))
+		return invalidField(s, "Choices", fmt.Errorf("Must have Choices"))
 	}
 
-	for _, c := range s.Choices {
+	for i, c := range s.Choices {
 		err := validateChoice(c)
 		if err != nil {
-			return fmt.Errorf("%v %v", errorPrefix(s), err)
+			return invalidField(s, fmt.Sprintf("Choices[%v]", i), err)
 		}
 	}
 
@@ -390,6 +611,29 @@ func validateChoiceRule(c *ChoiceRule) error {
 		c.TimestampGreaterThan != nil,
 		c.TimestampLessThanEquals != nil,
 		c.TimestampGreaterThanEquals != nil,
+		c.StringMatches != nil,
+		c.IsPresent != nil,
+		c.IsNull != nil,
+		c.IsString != nil,
+		c.IsNumeric != nil,
+		c.IsBoolean != nil,
+		c.IsTimestamp != nil,
+		c.StringEqualsPath != nil,
+		c.StringLessThanPath != nil,
+		c.StringGreaterThanPath != nil,
+		c.StringLessThanEqualsPath != nil,
+		c.StringGreaterThanEqualsPath != nil,
+		c.NumericEqualsPath != nil,
+		c.NumericLessThanPath != nil,
+		c.NumericGreaterThanPath != nil,
+		c.NumericLessThanEqualsPath != nil,
+		c.NumericGreaterThanEqualsPath != nil,
+		c.BooleanEqualsPath != nil,
+		c.TimestampEqualsPath != nil,
+		c.TimestampLessThanPath != nil,
+		c.TimestampGreaterThanPath != nil,
+		c.TimestampLessThanEqualsPath != nil,
+		c.TimestampGreaterThanEqualsPath != nil,
 	}
 
 	count := 0
@@ -298,6 +298,125 @@ func Test_ChoiceState_StringEquals(t *testing.T) {
 	}, t)
 }
 
+func Test_ChoiceState_TypeTests(t *testing.T) {
+	state := parseChoiceState([]byte(`{
+		"Choices": [
+			{ "Variable": "$.value", "IsPresent": false, "Next": "Missing" },
+			{ "Variable": "$.value", "IsNull": true, "Next": "Null" },
+			{ "Variable": "$.value", "IsTimestamp": true, "Next": "Timestamp" },
+			{ "Variable": "$.value", "IsString": true, "Next": "String" },
+			{ "Variable": "$.value", "IsNumeric": true, "Next": "Numeric" },
+			{ "Variable": "$.value", "IsBoolean": true, "Next": "Boolean" }
+		],
+		"Default": "Other"
+	}`), t)
+
+	tests := map[string]interface{}{
+		"Null":      nil,
+		"Timestamp": "2016-03-14T01:59:00Z",
+		"String":    "public",
+		"Numeric":   float64(1),
+		"Boolean":   false,
+		"Other":     []interface{}{},
+	}
+
+	for next, value := range tests {
+		testState(state, stateTestData{
+			Input: map[string]interface{}{"value": value},
+			Next:  to.Strp(next),
+		}, t)
+	}
+
+	testState(state, stateTestData{
+		Input: map[string]interface{}{"other": "x"},
+		Next:  to.Strp("Missing"),
+	}, t)
+}
+
+func Test_ChoiceState_StringMatches(t *testing.T) {
+	state := parseChoiceState([]byte(`{
+		"Choices": [
+			{ "Variable": "$.value", "StringMatches": "log-*.txt", "Next": "Log" },
+			{ "Variable": "$.value", "StringMatches": "*\\**", "Next": "Star" }
+		],
+		"Default": "Fail"
+	}`), t)
+
+	tests := map[string]string{
+		"log-.txt":        "Log",
+		"log-2020-01.txt": "Log",
+		"log-1.txt.bak":   "Fail",
+		"alog-1.txt":      "Fail",
+		"a*b":             "Star",
+		"ab":              "Fail",
+	}
+
+	for value, next := range tests {
+		testState(state, stateTestData{
+			Input: map[string]interface{}{"value": value},
+			Next:  to.Strp(next),
+		}, t)
+	}
+
+	assert.True(t, stringMatches(`a\\b`, `a\\\\b`))
+	assert.True(t, stringMatches("a.b", "a.b"))
+	assert.False(t, stringMatches("axb", "a.b"))
+}
+
+func Test_ChoiceState_PathComparators(t *testing.T) {
+	state := parseChoiceState([]byte(`{
+		"Choices": [
+			{ "Variable": "$.name", "StringEqualsPath": "$.expected.name", "Next": "String" },
+			{ "Variable": "$.count", "NumericGreaterThanEqualsPath": "$.expected.count", "Next": "Numeric" },
+			{ "Variable": "$.flag", "BooleanEqualsPath": "$.expected.flag", "Next": "Boolean" },
+			{ "Variable": "$.at", "TimestampLessThanPath": "$.expected.at", "Next": "Timestamp" }
+		],
+		"Default": "Fail"
+	}`), t)
+
+	expected := map[string]interface{}{"name": "a", "count": float64(2), "flag": true, "at": "2016-03-14T01:59:00Z"}
+
+	tests := []struct {
+		input map[string]interface{}
+		next  string
+	}{
+		{map[string]interface{}{"name": "a"}, "String"},
+		{map[string]interface{}{"name": "b", "count": float64(2)}, "Numeric"},
+		{map[string]interface{}{"count": float64(1), "flag": true}, "Boolean"},
+		{map[string]interface{}{"at": "2016-03-14T01:58:00Z"}, "Timestamp"},
+		{map[string]interface{}{"at": "2016-03-14T01:59:00Z", "flag": "true"}, "Fail"},
+	}
+
+	for _, test := range tests {
+		test.input["expected"] = expected
+		testState(state, stateTestData{
+			Input: test.input,
+			Next:  to.Strp(test.next),
+		}, t)
+	}
+
+	// the compared path not found
+	testState(state, stateTestData{
+		Input: map[string]interface{}{"name": "a"},
+		Next:  to.Strp("Fail"),
+	}, t)
+}
+
+func Test_ChoiceRule_String(t *testing.T) {
+	state := parseChoiceState([]byte(`{
+		"Choices": [
+			{ "Not": { "Variable": "$.a", "IsPresent": true }, "Next": "A" },
+			{ "Variable": "$.b", "StringMatches": "x*", "Next": "B" },
+			{ "Variable": "$.c", "NumericLessThanPath": "$.d", "Next": "C" }
+		],
+		"Default": "Fail"
+	}`), t)
+
+	assert.Equal(t, "!($.a IsPresent=true)", state.Choices[0].String())
+	assert.Equal(t, "$.b=~x*", state.Choices[1].String())
+	assert.Equal(t, "$.c<$.d", state.Choices[2].String())
+}
+
 // Logical Comparisons
 
 func Test_ChoiceState_Not(t *testing.T) {
@@ -492,3 +611,16 @@ func Test_ChoiceState_NotAllowed2DeeplyEmbeddedComparisonOperators(t *testing.T)
 	assert.Error(t, err)
 	assert.Regexp(t, "Not Exactly One comparison Operator", err.Error())
 }
+
+func Test_ChoiceState_NotAllowedTypeTestAndComparator(t *testing.T) {
+	state := parseChoiceState([]byte(`{
+		"Choices": [
+			{ "Variable": "$.value", "IsPresent": true, "StringEqualsPath": "$.other", "Next": "Pass" }
+		],
+		"Default": "Fail"
+	}`), t)
+
+	err := state.Validate()
+	assert.Error(t, err)
+	assert.Regexp(t, "Not Exactly One comparison Operator", err.Error())
+}
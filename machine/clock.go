@@ -0,0 +1,93 @@
+package machine
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// Clock is the source of time for an Execution, used by Wait states, Retry intervals, the Executions TimeoutSeconds
+// and history timestamps. Task TimeoutSeconds and HeartbeatSeconds use real time as handlers run in real time
+type Clock interface {
+	Now() time.Time
+	Sleep(ctx context.Context, d time.Duration) error
+}
+
+// RealClock uses the system time and actually sleeps
+type RealClock struct{}
+
+func (RealClock) Now() time.Time {
+	return time.Now()
+}
+
+func (RealClock) Sleep(ctx context.Context, d time.Duration) error {
+	if ctx == nil {
+		ctx = context.Background()
+	}
+
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// VirtualClock simulates time, Sleep advances the clock instantly
+// This is the default Clock so tests never actually wait
+type VirtualClock struct {
+	mu  sync.Mutex
+	now time.Time
+}
+
+// NewVirtualClock returns a VirtualClock starting at start
+func NewVirtualClock(start time.Time) *VirtualClock {
+	return &VirtualClock{now: start}
+}
+
+func (c *VirtualClock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.now
+}
+
+func (c *VirtualClock) Sleep(ctx context.Context, d time.Duration) error {
+	if ctx != nil {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+	}
+
+	c.Advance(d)
+	return nil
+}
+
+// Advance moves the clock forward by d
+func (c *VirtualClock) Advance(d time.Duration) {
+	if d <= 0 {
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.now = c.now.Add(d)
+}
+
+type clockKey struct{}
+
+func withClock(ctx context.Context, clock Clock) context.Context {
+	return context.WithValue(ctx, clockKey{}, clock)
+}
+
+// clockFrom returns the Clock of the current Execution, or a new VirtualClock
+func clockFrom(ctx context.Context) Clock {
+	if ctx != nil {
+		if clock, ok := ctx.Value(clockKey{}).(Clock); ok {
+			return clock
+		}
+	}
+	return NewVirtualClock(time.Now())
+}
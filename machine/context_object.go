@@ -0,0 +1,184 @@
+package machine
+
+import (
+	"context"
+	"encoding/json"
+	"reflect"
+	"time"
+
+	"github.com/cleardataeng/step/jsonpath"
+)
+
+// The Context Object "$$" has information about the current Execution
+
+const (
+	// stateMachineName is the default $$.StateMachine.Name, the ARNs use the ExecutionOptions Region and AccountID
+	stateMachineName = "StateMachine"
+
+	// contextTimeFormat is how AWS formats Context Object times e.g. 2019-03-26T20:14:13.192Z
+	contextTimeFormat = "2006-01-02T15:04:05.000Z"
+)
+
+type executionContextKey struct{}
+type stateContextKey struct{}
+type mapItemKey struct{}
+
+// contextExecution is the Execution shared by its Parallel Branches and inline Map Iterations
+type contextExecution struct {
+	id        string
+	name      string
+	startTime time.Time
+	input     interface{}
+
+	tokens *taskTokens // shared with Distributed Map child Executions
+}
+
+// contextState is the State being executed
+type contextState struct {
+	name        string
+	enteredTime time.Time
+	retryCount  int
+}
+
+// mapItem is the Map state item being processed
+type mapItem struct {
+	index int
+	value interface{}
+}
+
+func newContextExecution(id string, name string, startTime time.Time, input interface{}) *contextExecution {
+	return &contextExecution{
+		id:        id,
+		name:      name,
+		startTime: startTime,
+		input:     copyInput(input),
+		tokens:    newTaskTokens(),
+	}
+}
+
+// copyInput returns a deep copy of the Execution input, so States writing to their input do not change $$.Execution.Input
+func copyInput(input interface{}) interface{} {
+	raw, err := json.Marshal(input)
+	if err != nil {
+		return input
+	}
+
+	var copied interface{}
+	if err := json.Unmarshal(raw, &copied); err != nil {
+		return input
+	}
+	return copied
+}
+
+// executionScope is the context of a new Execution started with ctx, e.g. from a Task handler,
+// it keeps the cancellation and values of ctx but not those of the Execution ctx belongs to
+type executionScope struct {
+	context.Context
+}
+
+var machinePkgPath = reflect.TypeOf(executionScope{}).PkgPath()
+
+func newExecutionScope(ctx context.Context) context.Context {
+	return executionScope{ctx}
+}
+
+func (ctx executionScope) Value(key interface{}) interface{} {
+	if t := reflect.TypeOf(key); t != nil && t.PkgPath() == machinePkgPath {
+		return nil
+	}
+	return ctx.Context.Value(key)
+}
+
+func withExecutionContext(ctx context.Context, ec *contextExecution) context.Context {
+	return context.WithValue(ctx, executionContextKey{}, ec)
+}
+
+// executionContextFrom returns the current Execution context, or nil outside of an Execution
+func executionContextFrom(ctx context.Context) *contextExecution {
+	if ctx == nil {
+		return nil
+	}
+	ec, _ := ctx.Value(executionContextKey{}).(*contextExecution)
+	return ec
+}
+
+func withStateContext(ctx context.Context, sc *contextState) context.Context {
+	return context.WithValue(ctx, stateContextKey{}, sc)
+}
+
+// withRetryCount sets $$.State.RetryCount for an attempt of the current State
+func withRetryCount(ctx context.Context, retryCount int) context.Context {
+	if ctx == nil {
+		return ctx
+	}
+
+	sc, ok := ctx.Value(stateContextKey{}).(*contextState)
+	if !ok || sc.retryCount == retryCount {
+		return ctx
+	}
+	return withStateContext(ctx, &contextState{sc.name, sc.enteredTime, retryCount})
+}
+
+func withMapItem(ctx context.Context, index int, value interface{}) context.Context {
+	return context.WithValue(ctx, mapItemKey{}, &mapItem{index, value})
+}
+
+// ContextObject returns the Context Object "$$" for the current point in an Execution,
+// Task handlers can use it to get e.g. the Execution Id from their context
+func ContextObject(ctx context.Context) map[string]interface{} {
+	return contextObject(ctx)
+}
+
+// contextObject returns the Context Object for the current point in the Execution
+func contextObject(ctx context.Context) map[string]interface{} {
+	obj := map[string]interface{}{}
+	if ctx == nil {
+		return obj
+	}
+
+	opts := executionOptionsFrom(ctx)
+	obj["StateMachine"] = map[string]interface{}{
+		"Id":   opts.stateMachineArn(),
+		"Name": opts.stateMachineName(),
+	}
+
+	if ec := executionContextFrom(ctx); ec != nil {
+		obj["Execution"] = map[string]interface{}{
+			"Id":        ec.id,
+			"Name":      ec.name,
+			"StartTime": ec.startTime.UTC().Format(contextTimeFormat),
+			"Input":     ec.input,
+		}
+	}
+
+	if sc, ok := ctx.Value(stateContextKey{}).(*contextState); ok {
+		obj["State"] = map[string]interface{}{
+			"Name":        sc.name,
+			"EnteredTime": sc.enteredTime.UTC().Format(contextTimeFormat),
+			"RetryCount":  float64(sc.retryCount),
+		}
+	}
+
+	if token := taskToken(ctx); token != "" {
+		obj["Task"] = map[string]interface{}{"Token": token}
+	}
+
+	if item, ok := ctx.Value(mapItemKey{}).(*mapItem); ok {
+		obj["Map"] = map[string]interface{}{
+			"Item": map[string]interface{}{
+				"Index": float64(item.index),
+				"Value": item.value,
+			},
+		}
+	}
+
+	return obj
+}
+
+// pathInput returns the data a path selects from, the Context Object for $$ paths otherwise the input
+func pathInput(ctx context.Context, path *jsonpath.Path, input interface{}) interface{} {
+	if path.IsContextPath() {
+		return contextObject(ctx)
+	}
+	return input
+}
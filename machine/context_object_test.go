@@ -0,0 +1,178 @@
+package machine
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_ContextObject_Parameters(t *testing.T) {
+	sm, err := FromJSON([]byte(`{
+    "StartAt": "Task",
+    "States": {
+      "Task": {
+        "Type": "Task",
+        "Resource": "test",
+        "Parameters": {
+          "execution_id.$": "$$.Execution.Id",
+          "execution_name.$": "$$.Execution.Name",
+          "start_time.$": "$$.Execution.StartTime",
+          "execution_input.$": "$$.Execution.Input.a",
+          "state_name.$": "$$.State.Name",
+          "entered_time.$": "$$.State.EnteredTime",
+          "retry_count.$": "$$.State.RetryCount",
+          "state_machine_id.$": "$$.StateMachine.Id",
+          "id.$": "States.Format('id-{}', $$.Execution.Name)"
+        },
+        "ResultSelector": {
+          "result.$": "$",
+          "state.$": "$$.State.Name"
+        },
+        "End": true
+      }
+    }
+  }`))
+	assert.NoError(t, err)
+	sm.SetTaskHandler("Task", ReturnInputHandler)
+
+	exec, err := sm.Execute(map[string]interface{}{"a": "b"})
+	assert.NoError(t, err)
+
+	assert.Equal(t, "Task", exec.Output["state"])
+
+	res := exec.Output["result"].(map[string]interface{})
+	assert.Equal(t, exec.ID, res["execution_id"])
+	assert.Equal(t, exec.Name, res["execution_name"])
+	assert.Equal(t, "arn:aws:states:us-east-1:000000000000:execution:StateMachine:"+exec.Name, exec.ID)
+	assert.Equal(t, "b", res["execution_input"])
+	assert.Equal(t, "Task", res["state_name"])
+	assert.Equal(t, 0.0, res["retry_count"])
+	assert.Equal(t, "arn:aws:states:us-east-1:000000000000:stateMachine:StateMachine", res["state_machine_id"])
+	assert.Equal(t, "id-"+exec.Name, res["id"])
+	assert.Regexp(t, `^\d{4}-\d{2}-\d{2}T\d{2}:\d{2}:\d{2}\.\d{3}Z$`, res["start_time"])
+	assert.Regexp(t, `^\d{4}-\d{2}-\d{2}T\d{2}:\d{2}:\d{2}\.\d{3}Z$`, res["entered_time"])
+
+	// Every Execution has its own Id
+	exec2, err := sm.Execute(map[string]interface{}{"a": "b"})
+	assert.NoError(t, err)
+	assert.NotEqual(t, exec.ID, exec2.ID)
+}
+
+func Test_ContextObject_Handler(t *testing.T) {
+	sm, err := FromJSON([]byte(`{
+    "StartAt": "Task",
+    "States": {
+      "Task": {
+        "Type": "Task",
+        "Resource": "test",
+        "Retry": [{"ErrorEquals": ["States.ALL"], "MaxAttempts": 2}],
+        "End": true
+      }
+    }
+  }`))
+	assert.NoError(t, err)
+
+	retries := []interface{}{}
+	sm.SetTaskHandler("Task", func(ctx context.Context, input interface{}) (interface{}, error) {
+		obj := ContextObject(ctx)
+		retries = append(retries, obj["State"].(map[string]interface{})["RetryCount"])
+		if len(retries) < 3 {
+			return nil, &TestError{}
+		}
+		return map[string]interface{}{"id": obj["Execution"].(map[string]interface{})["Id"]}, nil
+	})
+
+	sm.SetClock(NewVirtualClock(time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)))
+
+	exec, err := sm.Execute(map[string]interface{}{})
+	assert.NoError(t, err)
+	assert.Equal(t, exec.ID, exec.Output["id"])
+	assert.Equal(t, []interface{}{0.0, 1.0, 2.0}, retries)
+}
+
+func Test_ContextObject_Choice(t *testing.T) {
+	sm, err := FromJSON([]byte(`{
+    "StartAt": "Choice",
+    "States": {
+      "Choice": {
+        "Type": "Choice",
+        "Choices": [
+          {"Variable": "$$.State.Name", "StringEqualsPath": "$.name", "Next": "Matched"},
+          {"Variable": "$.name", "StringEqualsPath": "$$.StateMachine.Name", "Next": "MatchedPath"}
+        ],
+        "Default": "Default"
+      },
+      "Matched": {"Type": "Pass", "Result": "Matched", "End": true},
+      "MatchedPath": {"Type": "Pass", "Result": "MatchedPath", "End": true},
+      "Default": {"Type": "Pass", "Result": "Default", "End": true}
+    }
+  }`))
+	assert.NoError(t, err)
+
+	for name, expected := range map[string]string{
+		"Choice":       "Matched",
+		"StateMachine": "MatchedPath",
+		"other":        "Default",
+	} {
+		exec, err := sm.Execute(map[string]interface{}{"name": name})
+		assert.NoError(t, err)
+		assert.Equal(t, []string{"Choice", expected}, exec.Path(), name)
+	}
+}
+
+func Test_ContextObject_Parallel_SharesExecution(t *testing.T) {
+	sm, err := FromJSON([]byte(`{
+    "StartAt": "Parallel",
+    "States": {
+      "Parallel": {
+        "Type": "Parallel",
+        "Branches": [
+          {"StartAt": "A", "States": {"A": {"Type": "Task", "Resource": "test", "Parameters": {"id.$": "$$.Execution.Id"}, "End": true}}},
+          {"StartAt": "B", "States": {"B": {"Type": "Task", "Resource": "test", "Parameters": {"id.$": "$$.Execution.Id"}, "End": true}}}
+        ],
+        "ResultPath": "$.ids",
+        "End": true
+      }
+    }
+  }`))
+	assert.NoError(t, err)
+	sm.SetTaskHandler("A", ReturnInputHandler)
+	sm.SetTaskHandler("B", ReturnInputHandler)
+
+	exec, err := sm.Execute(map[string]interface{}{})
+	assert.NoError(t, err)
+	assert.Equal(t, []interface{}{
+		map[string]interface{}{"id": exec.ID},
+		map[string]interface{}{"id": exec.ID},
+	}, exec.Output["ids"])
+}
+
+func Test_ContextObject_Execution_Input_Unchanged(t *testing.T) {
+	sm, err := FromJSON([]byte(`{
+    "StartAt": "Pass",
+    "States": {
+      "Pass": {"Type": "Pass", "Result": "x", "ResultPath": "$.added", "Next": "Task"},
+      "Task": {"Type": "Task", "Resource": "test", "Parameters": {"orig.$": "$$.Execution.Input"}, "End": true}
+    }
+  }`))
+	assert.NoError(t, err)
+	sm.SetTaskHandler("Task", ReturnInputHandler)
+
+	input := map[string]interface{}{"a": "b"}
+	exec, err := sm.Execute(input)
+	assert.NoError(t, err)
+
+	// The Pass ResultPath writes to the State input, not the Execution input
+	assert.Equal(t, map[string]interface{}{"a": "b"}, exec.Output["orig"])
+}
+
+func Test_ContextObject_Outside_Execution(t *testing.T) {
+	assert.Equal(t, map[string]interface{}{}, ContextObject(nil))
+
+	obj := ContextObject(context.Background())
+	assert.Nil(t, obj["Execution"])
+	assert.Nil(t, obj["State"])
+	assert.NotNil(t, obj["StateMachine"])
+}
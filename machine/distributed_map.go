@@ -0,0 +1,572 @@
+package machine
+
+import (
+	"bytes"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/cleardataeng/step/aws"
+	s3h "github.com/cleardataeng/step/aws/s3"
+	"github.com/cleardataeng/step/jsonpath"
+	"github.com/cleardataeng/step/utils/to"
+)
+
+// Distributed Map reads items from S3 with an ItemReader, runs each item as a child Execution,
+// and writes the child Executions to S3 with a ResultWriter.
+
+const (
+	s3GetObjectResource      = "arn:aws:states:::s3:getObject"
+	s3ListObjectsV2Resource  = "arn:aws:states:::s3:listObjectsV2"
+	s3PutObjectResource      = "arn:aws:states:::s3:putObject"
+	distributedMode          = "DISTRIBUTED"
+	inlineMode               = "INLINE"
+	resultWriterManifestFile = "manifest.json"
+)
+
+// ItemProcessor is the State Machine run for each item, it replaces Iterator
+type ItemProcessor struct {
+	ProcessorConfig *ProcessorConfig `json:",omitempty"`
+
+	StateMachine
+}
+
+// ProcessorConfig Mode is INLINE (default) or DISTRIBUTED, ExecutionType is STANDARD or EXPRESS
+type ProcessorConfig struct {
+	Mode          *string `json:",omitempty"`
+	ExecutionType *string `json:",omitempty"`
+}
+
+// ItemReader reads the items from S3 instead of the input
+//
+// Resource "arn:aws:states:::s3:getObject" reads a CSV, JSON or JSONL file from Parameters Bucket and Key,
+// Resource "arn:aws:states:::s3:listObjectsV2" lists the objects under Parameters Bucket and Prefix.
+type ItemReader struct {
+	Resource     *string
+	ReaderConfig *ReaderConfig `json:",omitempty"`
+	Parameters   interface{}   `json:",omitempty"`
+}
+
+type ReaderConfig struct {
+	InputType         *string  `json:",omitempty"` // CSV, JSON or JSONL
+	CSVHeaderLocation *string  `json:",omitempty"` // FIRST_ROW (default) or GIVEN
+	CSVHeaders        []string `json:",omitempty"`
+	CSVDelimiter      *string  `json:",omitempty"` // COMMA (default), PIPE, SEMICOLON, SPACE or TAB
+
+	MaxItems     *int           `json:",omitempty"`
+	MaxItemsPath *jsonpath.Path `json:",omitempty"`
+}
+
+// ResultWriter writes the child Executions to Parameters Bucket and Prefix
+type ResultWriter struct {
+	Resource   *string
+	Parameters interface{} `json:",omitempty"`
+}
+
+var csvDelimiters = map[string]rune{
+	"COMMA":     ',',
+	"PIPE":      '|',
+	"SEMICOLON": ';',
+	"SPACE":     ' ',
+	"TAB":       '\t',
+}
+
+////////
+// S3 Client
+////////
+
+type s3ClientKey struct{}
+
+func withS3Client(ctx context.Context, s3c aws.S3API) context.Context {
+	return context.WithValue(ctx, s3ClientKey{}, s3c)
+}
+
+// s3ClientFrom returns the S3 client of the current Execution, or nil
+func s3ClientFrom(ctx context.Context) aws.S3API {
+	if ctx == nil {
+		return nil
+	}
+	s3c, _ := ctx.Value(s3ClientKey{}).(aws.S3API)
+	return s3c
+}
+
+////////
+// Map State
+////////
+
+// iterator returns the ItemProcessor State Machine, or the legacy Iterator
+func (s *MapState) iterator() *StateMachine {
+	if s.ItemProcessor != nil {
+		return &s.ItemProcessor.StateMachine
+	}
+	return s.Iterator
+}
+
+// iteratorField is the field of the Map State with its iterator
+func (s *MapState) iteratorField() string {
+	if s.ItemProcessor != nil {
+		return "ItemProcessor"
+	}
+	return "Iterator"
+}
+
+func (s *MapState) distributed() bool {
+	return s.ItemProcessor != nil && s.ItemProcessor.ProcessorConfig != nil &&
+		s.ItemProcessor.ProcessorConfig.Mode != nil && *s.ItemProcessor.ProcessorConfig.Mode == distributedMode
+}
+
+// mapRun is a Distributed Map execution of the Map State
+type mapRun struct {
+	id           string
+	arn          string
+	executionArn string // the prefix of the child Execution ARNs
+}
+
+func newMapRun(ctx context.Context, s *MapState) *mapRun {
+	id := to.UUID()
+	opts := executionOptionsFrom(ctx)
+	return &mapRun{
+		id:           id,
+		arn:          fmt.Sprintf("%v/%v:%v", opts.arn("mapRun"), *s.Name(), id),
+		executionArn: fmt.Sprintf("%v/%v", opts.arn("execution"), id),
+	}
+}
+
+// childArn is the ARN of the child Execution named name
+func (run *mapRun) childArn(name string) string {
+	return fmt.Sprintf("%v:%v", run.executionArn, name)
+}
+
+// childName is the name of the child Execution of the item at index
+func (run *mapRun) childName(index int) string {
+	return fmt.Sprintf("%v-%v", run.id[:8], index)
+}
+
+// childContext starts a new Execution for the item at index, as Distributed Map items are not part of the parent Execution
+func (run *mapRun) childContext(ctx context.Context, index int, input interface{}) context.Context {
+	name := run.childName(index)
+	ec := newContextExecution(run.childArn(name), name, clockFrom(ctx).Now(), input)
+	if parent := executionContextFrom(ctx); parent != nil {
+		ec.tokens = parent.tokens
+	}
+
+	// Child Executions have their own history
+	ctx = withExecution(ctx, nil)
+	return withExecutionContext(ctx, ec)
+}
+
+// writeResults writes the child Executions with the ResultWriter, returning the Map output
+func (s *MapState) writeResults(ctx context.Context, input interface{}, run *mapRun, runs []*itemRun) (interface{}, error) {
+	s3c := s3ClientFrom(ctx)
+	if s3c == nil {
+		return nil, fmt.Errorf("ResultWriter requires an S3 client, see SetS3Client")
+	}
+
+	bucket, prefix, err := s3Location(ctx, s.ResultWriter.Parameters, input, "Prefix")
+	if err != nil {
+		return nil, fmt.Errorf("ResultWriter %v", err)
+	}
+
+	executions := map[string][]interface{}{"SUCCEEDED": {}, "FAILED": {}, "PENDING": {}}
+	for i, r := range runs {
+		status, details := r.details(run, i)
+		executions[status] = append(executions[status], details)
+	}
+
+	dir := path.Join(prefix, run.id)
+
+	files := map[string]interface{}{}
+	for _, status := range []string{"SUCCEEDED", "FAILED", "PENDING"} {
+		files[status] = []interface{}{}
+		if len(executions[status]) == 0 {
+			continue
+		}
+
+		key := path.Join(dir, fmt.Sprintf("%v_0.json", status))
+		size, err := putJSON(s3c, bucket, key, executions[status])
+		if err != nil {
+			return nil, err
+		}
+
+		files[status] = []interface{}{map[string]interface{}{"Key": key, "Size": float64(size)}}
+	}
+
+	manifest := path.Join(dir, resultWriterManifestFile)
+	_, err = putJSON(s3c, bucket, manifest, map[string]interface{}{
+		"DestinationBucket": bucket,
+		"MapRunArn":         run.arn,
+		"ResultFiles":       files,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return map[string]interface{}{
+		"MapRunArn": run.arn,
+		"ResultWriterDetails": map[string]interface{}{
+			"Bucket": bucket,
+			"Key":    manifest,
+		},
+	}, nil
+}
+
+func putJSON(s3c aws.S3API, bucket string, key string, value interface{}) (int, error) {
+	raw, err := json.Marshal(value)
+	if err != nil {
+		return 0, err
+	}
+
+	if err := s3h.PutWithType(s3c, &bucket, &key, &raw, to.Strp("application/json")); err != nil {
+		return 0, fmt.Errorf("ResultWriter %v", err)
+	}
+
+	return len(raw), nil
+}
+
+// details describes the child Execution of an item in the ResultWriter layout
+func (r *itemRun) details(run *mapRun, index int) (string, map[string]interface{}) {
+	name := run.childName(index)
+	details := map[string]interface{}{
+		"ExecutionArn": run.childArn(name),
+		"Name":         name,
+		"Input":        jsonString(r.input),
+		"InputDetails": map[string]interface{}{"Included": true},
+		"Status":       "PENDING",
+	}
+
+	if r.execution == nil && r.err == nil {
+		return "PENDING", details
+	}
+
+	if r.execution != nil && len(r.execution.ExecutionHistory) > 0 {
+		history := r.execution.ExecutionHistory
+		details["StartDate"] = history[0].Timestamp.UTC().Format(contextTimeFormat)
+		details["StopDate"] = history[len(history)-1].Timestamp.UTC().Format(contextTimeFormat)
+	}
+
+	if r.err != nil {
+		details["Status"] = "FAILED"
+		details["Error"] = errorName(r.err)
+		details["Cause"] = errorCause(r.err)
+		return "FAILED", details
+	}
+
+	details["Status"] = "SUCCEEDED"
+	details["Output"] = jsonString(r.execution.Output)
+	details["OutputDetails"] = map[string]interface{}{"Included": true}
+	return "SUCCEEDED", details
+}
+
+func jsonString(value interface{}) string {
+	raw, err := json.Marshal(value)
+	if err != nil {
+		return ""
+	}
+	return string(raw)
+}
+
+////////
+// Item Reader
+////////
+
+// read returns the items from S3
+func (r *ItemReader) read(ctx context.Context, input interface{}) ([]interface{}, error) {
+	s3c := s3ClientFrom(ctx)
+	if s3c == nil {
+		return nil, fmt.Errorf("ItemReader requires an S3 client, see SetS3Client")
+	}
+
+	maxItems, err := r.maxItems(input)
+	if err != nil {
+		return nil, fmt.Errorf("ItemReader MaxItemsPath %v", err)
+	}
+
+	var items []interface{}
+	switch *r.Resource {
+	case s3ListObjectsV2Resource:
+		items, err = r.listObjects(ctx, s3c, input, maxItems)
+	default:
+		items, err = r.getObject(ctx, s3c, input)
+	}
+
+	if err != nil {
+		return nil, fmt.Errorf("ItemReader %v", err)
+	}
+
+	if maxItems > 0 && len(items) > maxItems {
+		items = items[:maxItems]
+	}
+
+	return items, nil
+}
+
+// maxItems returns the limit from ReaderConfig, 0 is no limit
+func (r *ItemReader) maxItems(input interface{}) (int, error) {
+	if r.ReaderConfig == nil {
+		return 0, nil
+	}
+	return batcherLimit(r.ReaderConfig.MaxItems, r.ReaderConfig.MaxItemsPath, input)
+}
+
+func (r *ItemReader) inputType() string {
+	if r.ReaderConfig == nil || r.ReaderConfig.InputType == nil {
+		return ""
+	}
+	return *r.ReaderConfig.InputType
+}
+
+func (r *ItemReader) getObject(ctx context.Context, s3c aws.S3API, input interface{}) ([]interface{}, error) {
+	bucket, key, err := s3Location(ctx, r.Parameters, input, "Key")
+	if err != nil {
+		return nil, err
+	}
+
+	body, err := s3h.Get(s3c, &bucket, &key)
+	if err != nil {
+		return nil, err
+	}
+
+	switch r.inputType() {
+	case "CSV":
+		return r.parseCSV(*body)
+	case "JSONL":
+		return parseJSONL(*body)
+	default:
+		var items []interface{}
+		if err := json.Unmarshal(*body, &items); err != nil {
+			return nil, fmt.Errorf("%v/%v must be a JSON array: %v", bucket, key, err)
+		}
+		return items, nil
+	}
+}
+
+// parseCSV returns a map of header to value for every row
+func (r *ItemReader) parseCSV(body []byte) ([]interface{}, error) {
+	reader := csv.NewReader(bytes.NewReader(body))
+	if r.ReaderConfig.CSVDelimiter != nil {
+		reader.Comma = csvDelimiters[*r.ReaderConfig.CSVDelimiter]
+	}
+
+	rows, err := reader.ReadAll()
+	if err != nil {
+		return nil, err
+	}
+
+	headers := r.ReaderConfig.CSVHeaders
+	if r.ReaderConfig.CSVHeaderLocation == nil || *r.ReaderConfig.CSVHeaderLocation == "FIRST_ROW" {
+		if len(rows) == 0 {
+			return []interface{}{}, nil
+		}
+		headers, rows = rows[0], rows[1:]
+	}
+
+	items := []interface{}{}
+	for _, row := range rows {
+		if len(row) != len(headers) {
+			return nil, fmt.Errorf("CSV row has %v fields but there are %v headers", len(row), len(headers))
+		}
+
+		item := map[string]interface{}{}
+		for i, header := range headers {
+			item[header] = row[i]
+		}
+		items = append(items, item)
+	}
+
+	return items, nil
+}
+
+// parseJSONL returns a item for every line, ignoring blank lines
+func parseJSONL(body []byte) ([]interface{}, error) {
+	items := []interface{}{}
+	for i, line := range strings.Split(string(body), "\n") {
+		if strings.TrimSpace(line) == "" {
+			continue
+		}
+
+		var item interface{}
+		if err := json.Unmarshal([]byte(line), &item); err != nil {
+			return nil, fmt.Errorf("JSONL line %v: %v", i+1, err)
+		}
+		items = append(items, item)
+	}
+	return items, nil
+}
+
+// listObjects returns an item for each object, like {"Key": "", "Size": 0, ...}
+func (r *ItemReader) listObjects(ctx context.Context, s3c aws.S3API, input interface{}, maxItems int) ([]interface{}, error) {
+	bucket, prefix, err := s3Location(ctx, r.Parameters, input, "Prefix")
+	if err != nil {
+		return nil, err
+	}
+
+	items := []interface{}{}
+	req := &s3.ListObjectsV2Input{Bucket: &bucket, Prefix: &prefix}
+	for {
+		out, err := s3c.ListObjectsV2(req)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, obj := range out.Contents {
+			item := map[string]interface{}{
+				"Key":          to.Strs(obj.Key),
+				"Etag":         to.Strs(obj.ETag),
+				"StorageClass": to.Strs(obj.StorageClass),
+			}
+			if obj.Size != nil {
+				item["Size"] = float64(*obj.Size)
+			}
+			if obj.LastModified != nil {
+				item["LastModified"] = obj.LastModified.UTC().Format(time.RFC3339)
+			}
+			items = append(items, item)
+		}
+
+		if maxItems > 0 && len(items) >= maxItems {
+			return items, nil
+		}
+
+		if out.IsTruncated == nil || !*out.IsTruncated || out.NextContinuationToken == nil {
+			return items, nil
+		}
+
+		req.ContinuationToken = out.NextContinuationToken
+	}
+}
+
+// s3Location resolves the Bucket and the location field (Key or Prefix) from Parameters
+func s3Location(ctx context.Context, params interface{}, input interface{}, field string) (string, string, error) {
+	resolved, err := replaceParamsJSONPath(ctx, params, input)
+	if err != nil {
+		return "", "", err
+	}
+
+	values, _ := resolved.(map[string]interface{})
+
+	bucket, _ := values["Bucket"].(string)
+	if bucket == "" {
+		return "", "", fmt.Errorf("Parameters requires Bucket")
+	}
+
+	location, _ := values[field].(string)
+	if location == "" && field == "Key" {
+		return "", "", fmt.Errorf("Parameters requires Key")
+	}
+
+	return bucket, location, nil
+}
+
+////////
+// Validation
+////////
+
+func (p *ItemProcessor) validate() error {
+	if p == nil || p.ProcessorConfig == nil {
+		return nil
+	}
+
+	c := p.ProcessorConfig
+	if c.Mode != nil && *c.Mode != inlineMode && *c.Mode != distributedMode {
+		return fmt.Errorf("ProcessorConfig Mode must be INLINE or DISTRIBUTED")
+	}
+
+	if c.ExecutionType != nil && *c.ExecutionType != "STANDARD" && *c.ExecutionType != "EXPRESS" {
+		return fmt.Errorf("ProcessorConfig ExecutionType must be STANDARD or EXPRESS")
+	}
+
+	return nil
+}
+
+func (r *ItemReader) validate() error {
+	if r == nil {
+		return nil
+	}
+
+	if r.Resource == nil || (*r.Resource != s3GetObjectResource && *r.Resource != s3ListObjectsV2Resource) {
+		return fmt.Errorf("ItemReader Resource must be %v or %v", s3GetObjectResource, s3ListObjectsV2Resource)
+	}
+
+	if r.Parameters == nil {
+		return fmt.Errorf("ItemReader requires Parameters")
+	}
+
+	if *r.Resource == s3ListObjectsV2Resource {
+		if r.inputType() != "" {
+			return fmt.Errorf("ItemReader InputType not allowed with %v", s3ListObjectsV2Resource)
+		}
+	} else {
+		switch r.inputType() {
+		case "CSV", "JSON", "JSONL":
+		default:
+			return fmt.Errorf("ItemReader InputType must be CSV, JSON or JSONL")
+		}
+	}
+
+	c := r.ReaderConfig
+	if c == nil {
+		return nil
+	}
+
+	if c.MaxItems != nil && c.MaxItemsPath != nil {
+		return fmt.Errorf("ItemReader only one of MaxItems and MaxItemsPath allowed")
+	}
+
+	if c.MaxItems != nil && *c.MaxItems < 0 {
+		return fmt.Errorf("ItemReader MaxItems must be positive")
+	}
+
+	if c.InputType == nil || *c.InputType != "CSV" {
+		if c.CSVHeaderLocation != nil || c.CSVHeaders != nil || c.CSVDelimiter != nil {
+			return fmt.Errorf("ItemReader CSV settings require InputType CSV")
+		}
+		return nil
+	}
+
+	if c.CSVDelimiter != nil {
+		if _, ok := csvDelimiters[*c.CSVDelimiter]; !ok {
+			return fmt.Errorf("ItemReader CSVDelimiter must be COMMA, PIPE, SEMICOLON, SPACE or TAB")
+		}
+	}
+
+	location := "FIRST_ROW"
+	if c.CSVHeaderLocation != nil {
+		location = *c.CSVHeaderLocation
+	}
+
+	switch location {
+	case "FIRST_ROW":
+		if c.CSVHeaders != nil {
+			return fmt.Errorf("ItemReader CSVHeaders requires CSVHeaderLocation GIVEN")
+		}
+	case "GIVEN":
+		if len(c.CSVHeaders) == 0 {
+			return fmt.Errorf("ItemReader CSVHeaderLocation GIVEN requires CSVHeaders")
+		}
+	default:
+		return fmt.Errorf("ItemReader CSVHeaderLocation must be FIRST_ROW or GIVEN")
+	}
+
+	return nil
+}
+
+func (w *ResultWriter) validate() error {
+	if w == nil {
+		return nil
+	}
+
+	if w.Resource == nil || *w.Resource != s3PutObjectResource {
+		return fmt.Errorf("ResultWriter Resource must be %v", s3PutObjectResource)
+	}
+
+	if w.Parameters == nil {
+		return fmt.Errorf("ResultWriter requires Parameters")
+	}
+
+	return nil
+}
@@ -0,0 +1,274 @@
+package machine
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"testing"
+
+	"github.com/cleardataeng/step/aws/mocks"
+	"github.com/stretchr/testify/assert"
+)
+
+/////////
+// Helpers
+/////////
+
+func distributedMapMachine(t *testing.T, reader string, settings string) (*StateMachine, *mocks.MockS3Client) {
+	sm, err := FromJSON([]byte(fmt.Sprintf(`{
+    "StartAt": "Map",
+    "States": {
+      "Map": {
+        "Type": "Map",
+        "ItemReader": %v,
+        %v
+        "ItemProcessor": {
+          "ProcessorConfig": {"Mode": "DISTRIBUTED", "ExecutionType": "STANDARD"},
+          "StartAt": "Task",
+          "States": {"Task": {"Type": "Task", "Resource": "test", "End": true}}
+        },
+        "End": true
+      }
+    }
+  }`, reader, settings)))
+	assert.NoError(t, err)
+
+	assert.NoError(t, sm.SetTaskHandler("Task", func(_ context.Context, input interface{}) (interface{}, error) {
+		if input.(map[string]interface{})["fail"] == "true" {
+			return nil, &TestError{}
+		}
+		return input, nil
+	}))
+
+	s3c := &mocks.MockS3Client{}
+	sm.SetS3Client(s3c)
+	return sm, s3c
+}
+
+func getS3JSON(t *testing.T, s3c *mocks.MockS3Client, key string) interface{} {
+	resp, ok := s3c.GetObjectResp[key]
+	assert.True(t, ok, key)
+
+	var value interface{}
+	assert.NoError(t, json.Unmarshal([]byte(resp.Body), &value))
+	return value
+}
+
+/////////
+// Tests
+/////////
+
+func Test_DistributedMap_CSV_ResultWriter(t *testing.T) {
+	sm, s3c := distributedMapMachine(t, `{
+    "Resource": "arn:aws:states:::s3:getObject",
+    "ReaderConfig": {"InputType": "CSV", "CSVHeaderLocation": "FIRST_ROW"},
+    "Parameters": {"Bucket": "bucket", "Key.$": "$.key"}
+  }`, `"ResultWriter": {
+    "Resource": "arn:aws:states:::s3:putObject",
+    "Parameters": {"Bucket": "results", "Prefix": "runs"}
+  },`)
+
+	s3c.AddGetObject("items.csv", "id,fail\na,false\nb,false\n", nil)
+
+	exec, err := sm.Execute(map[string]interface{}{"key": "items.csv"})
+	assert.NoError(t, err)
+
+	assert.Regexp(t, "^arn:aws:states:us-east-1:000000000000:mapRun:StateMachine/Map:", exec.Output["MapRunArn"])
+
+	details := exec.Output["ResultWriterDetails"].(map[string]interface{})
+	assert.Equal(t, "results", details["Bucket"])
+	assert.Regexp(t, "^runs/.+/manifest.json$", details["Key"])
+
+	manifest := getS3JSON(t, s3c, details["Key"].(string)).(map[string]interface{})
+	assert.Equal(t, "results", manifest["DestinationBucket"])
+	assert.Equal(t, exec.Output["MapRunArn"], manifest["MapRunArn"])
+
+	files := manifest["ResultFiles"].(map[string]interface{})
+	assert.Equal(t, []interface{}{}, files["FAILED"])
+	assert.Equal(t, []interface{}{}, files["PENDING"])
+	assert.Len(t, files["SUCCEEDED"], 1)
+
+	file := files["SUCCEEDED"].([]interface{})[0].(map[string]interface{})
+	assert.Regexp(t, "^runs/.+/SUCCEEDED_0.json$", file["Key"])
+
+	executions := getS3JSON(t, s3c, file["Key"].(string)).([]interface{})
+	assert.Len(t, executions, 2)
+
+	first := executions[0].(map[string]interface{})
+	assert.Equal(t, "SUCCEEDED", first["Status"])
+	assert.JSONEq(t, `{"id": "a", "fail": "false"}`, first["Input"].(string))
+	assert.JSONEq(t, `{"id": "a", "fail": "false"}`, first["Output"].(string))
+	assert.NotEmpty(t, first["ExecutionArn"])
+	assert.NotEmpty(t, first["StartDate"])
+	assert.NotEmpty(t, first["StopDate"])
+}
+
+func Test_DistributedMap_CSV_GivenHeaders(t *testing.T) {
+	sm, s3c := distributedMapMachine(t, `{
+    "Resource": "arn:aws:states:::s3:getObject",
+    "ReaderConfig": {"InputType": "CSV", "CSVHeaderLocation": "GIVEN", "CSVHeaders": ["id", "fail"], "CSVDelimiter": "PIPE", "MaxItems": 2},
+    "Parameters": {"Bucket": "bucket", "Key": "items.csv"}
+  }`, `"ResultPath": "$.results",`)
+
+	s3c.AddGetObject("items.csv", "a|false\nb|false\nc|true\n", nil)
+
+	exec, err := sm.Execute(map[string]interface{}{})
+	assert.NoError(t, err)
+
+	// Without a ResultWriter the output is the results, MaxItems skips the failing item
+	assert.Equal(t, []map[string]interface{}{
+		{"id": "a", "fail": "false"},
+		{"id": "b", "fail": "false"},
+	}, exec.Output["results"])
+}
+
+func Test_DistributedMap_JSON_JSONL(t *testing.T) {
+	for inputType, body := range map[string]string{
+		"JSON":  `[{"id": 1}, {"id": 2}]`,
+		"JSONL": "{\"id\": 1}\n\n{\"id\": 2}\n",
+	} {
+		sm, s3c := distributedMapMachine(t, fmt.Sprintf(`{
+      "Resource": "arn:aws:states:::s3:getObject",
+      "ReaderConfig": {"InputType": %q},
+      "Parameters": {"Bucket": "bucket", "Key": "items"}
+    }`, inputType), `"ResultPath": "$.results",`)
+
+		s3c.AddGetObject("items", body, nil)
+
+		exec, err := sm.Execute(map[string]interface{}{})
+		assert.NoError(t, err, inputType)
+		assert.Equal(t, []map[string]interface{}{
+			{"id": 1.0},
+			{"id": 2.0},
+		}, exec.Output["results"], inputType)
+	}
+}
+
+func Test_DistributedMap_ListObjectsV2(t *testing.T) {
+	sm, s3c := distributedMapMachine(t, `{
+    "Resource": "arn:aws:states:::s3:listObjectsV2",
+    "Parameters": {"Bucket": "bucket", "Prefix": "data/"}
+  }`, `"ResultPath": "$.results", "ItemSelector": {"key.$": "$$.Map.Item.Value.Key", "size.$": "$$.Map.Item.Value.Size"},`)
+
+	s3c.AddGetObject("data/b", "bb", nil)
+	s3c.AddGetObject("data/a", "a", nil)
+	s3c.AddGetObject("other/c", "c", nil)
+
+	exec, err := sm.Execute(map[string]interface{}{})
+	assert.NoError(t, err)
+	assert.Equal(t, []map[string]interface{}{
+		{"key": "data/a", "size": 1.0},
+		{"key": "data/b", "size": 2.0},
+	}, exec.Output["results"])
+}
+
+func Test_DistributedMap_Failures(t *testing.T) {
+	sm, s3c := distributedMapMachine(t, `{
+    "Resource": "arn:aws:states:::s3:getObject",
+    "ReaderConfig": {"InputType": "CSV"},
+    "Parameters": {"Bucket": "bucket", "Key": "items.csv"}
+  }`, `"ToleratedFailureCount": 1, "ResultWriter": {
+    "Resource": "arn:aws:states:::s3:putObject",
+    "Parameters": {"Bucket": "results", "Prefix": "runs"}
+  },`)
+
+	s3c.AddGetObject("items.csv", "id,fail\na,true\nb,false\n", nil)
+
+	exec, err := sm.Execute(map[string]interface{}{})
+	assert.NoError(t, err)
+
+	manifest := getS3JSON(t, s3c, exec.Output["ResultWriterDetails"].(map[string]interface{})["Key"].(string))
+	files := manifest.(map[string]interface{})["ResultFiles"].(map[string]interface{})
+	assert.Len(t, files["SUCCEEDED"], 1)
+	assert.Len(t, files["FAILED"], 1)
+
+	file := files["FAILED"].([]interface{})[0].(map[string]interface{})
+	failed := getS3JSON(t, s3c, file["Key"].(string)).([]interface{})[0].(map[string]interface{})
+	assert.Equal(t, "FAILED", failed["Status"])
+	assert.Regexp(t, "This is a Test Error", failed["Cause"])
+	assert.Nil(t, failed["Output"])
+
+	// Exceeding the tolerance fails the Map Run
+	s3c.AddGetObject("items.csv", "id,fail\na,true\nb,true\n", nil)
+	_, err = sm.Execute(map[string]interface{}{})
+	assert.Error(t, err)
+}
+
+func Test_DistributedMap_ExceedToleratedFailureThreshold(t *testing.T) {
+	sm, s3c := distributedMapMachine(t, `{
+    "Resource": "arn:aws:states:::s3:getObject",
+    "ReaderConfig": {"InputType": "CSV"},
+    "Parameters": {"Bucket": "bucket", "Key": "items.csv"}
+  }`, ``)
+
+	s3c.AddGetObject("items.csv", "id,fail\na,true\n", nil)
+
+	_, err := sm.Execute(map[string]interface{}{})
+	assert.Error(t, err)
+	assert.Regexp(t, "States.ExceedToleratedFailureThreshold", err.Error())
+}
+
+func Test_DistributedMap_Errors(t *testing.T) {
+	sm, _ := distributedMapMachine(t, `{
+    "Resource": "arn:aws:states:::s3:getObject",
+    "ReaderConfig": {"InputType": "JSON"},
+    "Parameters": {"Bucket": "bucket", "Key": "missing"}
+  }`, ``)
+
+	_, err := sm.Execute(map[string]interface{}{})
+	assert.Error(t, err)
+	assert.Regexp(t, "ItemReader Not Found bucket missing", err.Error())
+
+	sm.SetS3Client(nil)
+	_, err = sm.Execute(map[string]interface{}{})
+	assert.Error(t, err)
+	assert.Regexp(t, "requires an S3 client", err.Error())
+}
+
+func Test_DistributedMap_Validate(t *testing.T) {
+	distributed := `{"Mode": "DISTRIBUTED"}`
+	reader := `"ItemReader": {"Resource": "arn:aws:states:::s3:getObject", "ReaderConfig": {"InputType": "CSV"}, "Parameters": {"Bucket": "b", "Key": "k"}},`
+	writer := `"ResultWriter": {"Resource": "arn:aws:states:::s3:putObject", "Parameters": {"Bucket": "b"}},`
+
+	parse := func(config string, settings string) *MapState {
+		return parseMapState([]byte(fmt.Sprintf(`{
+      %v
+      "ItemProcessor": {
+        "ProcessorConfig": %v,
+        "StartAt": "Pass",
+        "States": {"Pass": {"Type": "Pass", "End": true}}
+      },
+      "End": true
+    }`, settings, config)), t)
+	}
+
+	assert.NoError(t, parse(distributed, reader+writer).Validate())
+	assert.NoError(t, parse(`{"Mode": "INLINE"}`, `"ItemsPath": "$.items",`).Validate())
+
+	bad := []struct {
+		config   string
+		settings string
+	}{
+		{`{}`, reader},
+		{`{"Mode": "INLINE"}`, writer},
+		{`{"Mode": "BAD"}`, ``},
+		{`{"Mode": "DISTRIBUTED", "ExecutionType": "BAD"}`, ``},
+		{distributed, `"ItemsPath": "$.items", ` + reader},
+		{distributed, `"ItemReader": {"Resource": "arn:aws:states:::s3:bad", "Parameters": {}},`},
+		{distributed, `"ItemReader": {"Resource": "arn:aws:states:::s3:getObject"},`},
+		{distributed, `"ItemReader": {"Resource": "arn:aws:states:::s3:getObject", "ReaderConfig": {"InputType": "MANIFEST"}, "Parameters": {}},`},
+		{distributed, `"ItemReader": {"Resource": "arn:aws:states:::s3:getObject", "ReaderConfig": {"InputType": "CSV", "CSVHeaderLocation": "GIVEN"}, "Parameters": {}},`},
+		{distributed, `"ItemReader": {"Resource": "arn:aws:states:::s3:getObject", "ReaderConfig": {"InputType": "JSON", "CSVHeaders": ["a"]}, "Parameters": {}},`},
+		{distributed, `"ItemReader": {"Resource": "arn:aws:states:::s3:getObject", "ReaderConfig": {"InputType": "CSV", "CSVDelimiter": "DASH"}, "Parameters": {}},`},
+		{distributed, `"ItemReader": {"Resource": "arn:aws:states:::s3:listObjectsV2", "ReaderConfig": {"InputType": "CSV"}, "Parameters": {}},`},
+		{distributed, `"ResultWriter": {"Resource": "arn:aws:states:::s3:getObject", "Parameters": {}},`},
+	}
+
+	for _, b := range bad {
+		assert.Error(t, parse(b.config, b.settings).Validate(), b.settings)
+	}
+
+	// Only one of ItemProcessor and Iterator
+	state := parse(`{}`, `"Iterator": {"StartAt": "Pass", "States": {"Pass": {"Type": "Pass", "End": true}}},`)
+	assert.Error(t, state.Validate())
+}
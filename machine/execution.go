@@ -1,8 +1,10 @@
 package machine
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
+	"sync"
 	"time"
 
 	"github.com/aws/aws-sdk-go/service/sfn"
@@ -14,15 +16,112 @@ type HistoryEvent struct {
 }
 
 type Execution struct {
+	ID   string // Execution ARN, $$.Execution.Id
+	Name string
+
 	Output     map[string]interface{}
 	OutputJSON string
 	Error      error
+	ErrorName  string // the ASL error name of Error, e.g. the Error of a Fail State
+	ErrorCause string
 
 	LastOutput     map[string]interface{} // interim output
 	LastOutputJSON string
 	LastError      error // interim error
 
 	ExecutionHistory []HistoryEvent
+
+	historyMu   sync.Mutex // Branches and Iterations record concurrently
+	root        *Execution // the Execution recording the history of a Branch or Iteration
+	lastEventID int64      // the event the next event of this Execution follows
+	path        []string   // the States entered by this Execution
+	stateEvents int        // StateEntered and StateExited events of this Execution
+
+	clock Clock // timestamps history events
+
+	tokens *taskTokens   // callback Tasks waiting for SendTaskSuccess or SendTaskFailure
+	done   chan struct{} // closed when a Started Execution completes
+
+	stopMu  sync.Mutex
+	cancel  context.CancelFunc // cancels a Started Execution
+	stopped *statesError       // the error and cause a Started Execution was stopped with
+
+	options *ExecutionOptions // set on the Execution that started a run, not its Branches or Iterations
+	expired bool              // the Execution timed out after its TimeoutSeconds
+}
+
+type executionKey struct{}
+
+func withExecution(ctx context.Context, exec *Execution) context.Context {
+	return context.WithValue(ctx, executionKey{}, exec)
+}
+
+// executionFrom returns the running Execution, or nil outside of an Execution
+func executionFrom(ctx context.Context) *Execution {
+	if ctx == nil {
+		return nil
+	}
+	exec, _ := ctx.Value(executionKey{}).(*Execution)
+	return exec
+}
+
+// Wait blocks until an Execution from StateMachine.Start completes, returning its Error
+func (sm *Execution) Wait() error {
+	if sm.done != nil {
+		<-sm.done
+	}
+	return sm.Error
+}
+
+// Stop aborts an Execution from StateMachine.Start like StopExecution, errorName and cause are optional.
+// The Execution ends with the stop error once its current State returns
+func (sm *Execution) Stop(errorName string, cause string) error {
+	if sm.cancel == nil {
+		return fmt.Errorf("Only Started Executions can be stopped")
+	}
+
+	select {
+	case <-sm.done:
+		return fmt.Errorf("Execution has already completed")
+	default:
+	}
+
+	sm.stop(errorName, cause)
+	sm.cancel()
+	return nil
+}
+
+// stop records the error and cause the Execution was stopped with, the first stop wins
+func (sm *Execution) stop(errorName string, cause string) {
+	sm.stopMu.Lock()
+	defer sm.stopMu.Unlock()
+	if sm.stopped == nil {
+		sm.stopped = &statesError{errorName, cause}
+	}
+}
+
+// stopError returns the error the Execution was stopped with, or nil
+func (sm *Execution) stopError() *statesError {
+	sm.stopMu.Lock()
+	defer sm.stopMu.Unlock()
+	return sm.stopped
+}
+
+// Aborted is true if the Execution ended because it was stopped
+func (sm *Execution) Aborted() bool {
+	return sm.Error != nil && sm.stopError() != nil
+}
+
+// TimedOut is true if the Execution ended because it ran past its TimeoutSeconds
+func (sm *Execution) TimedOut() bool {
+	return sm.expired
+}
+
+func (sm *Execution) now() time.Time {
+	if sm.clock == nil {
+		return time.Now()
+	}
+	return sm.clock.Now()
 }
 
 func (sm *Execution) SetOutput(output interface{}, err error) {
@@ -34,6 +133,7 @@ func (sm *Execution) SetOutput(output interface{}, err error) {
 
 	if err != nil {
 		sm.Error = err
+		sm.ErrorName, sm.ErrorCause = errorName(err), errorCause(err)
 	}
 }
 
@@ -50,39 +150,38 @@ func (sm *Execution) SetLastOutput(output interface{}, err error) {
 }
 
 func (sm *Execution) EnteredEvent(s State, input interface{}) {
-	sm.ExecutionHistory = append(sm.ExecutionHistory, createEnteredEvent(s, input))
+	sm.path = append(sm.path, *s.Name())
+	sm.stateEvents++
+	sm.addEvent(createEnteredEvent(sm.now(), s, input))
 }
 
 func (sm *Execution) ExitedEvent(s State, output interface{}) {
-	sm.ExecutionHistory = append(sm.ExecutionHistory, createExitedEvent(s, output))
+	sm.stateEvents++
+	sm.addEvent(createExitedEvent(sm.now(), s, output))
 }
 
 func (sm *Execution) Start() {
-	sm.ExecutionHistory = []HistoryEvent{createEvent("ExecutionStarted")}
+	opts := sm.options
+	if opts == nil {
+		opts = &ExecutionOptions{}
+	}
+	sm.started(nil, opts)
 }
 
 func (sm *Execution) Failed() {
-	sm.ExecutionHistory = append(sm.ExecutionHistory, createEvent("ExecutionFailed"))
+	sm.failed(sm.Error)
 }
 
 func (sm *Execution) Succeeded() {
-	sm.ExecutionHistory = append(sm.ExecutionHistory, createEvent("ExecutionSucceeded"))
+	sm.succeeded(sm.Output)
 }
 
-// Path returns the Path of States, ignoreing TaskFn states
+// Path returns the Path of States, ignoreing TaskFn states and the States of Branches and Iterations
 func (sm *Execution) Path() []string {
-	path := []string{}
-	for _, er := range sm.ExecutionHistory {
-		if er.StateEnteredEventDetails != nil {
-			name := *er.StateEnteredEventDetails.Name
-			path = append(path, name)
-		}
-	}
-	return path
+	return append([]string{}, sm.path...)
 }
 
-func createEvent(name string) HistoryEvent {
-	t := time.Now()
+func createEvent(t time.Time, name string) HistoryEvent {
 	return HistoryEvent{
 		sfn.HistoryEvent{
 			Type:      to.Strp(name),
@@ -91,8 +190,8 @@ func createEvent(name string) HistoryEvent {
 	}
 }
 
-func createEnteredEvent(state State, input interface{}) HistoryEvent {
-	event := createEvent(fmt.Sprintf("%vStateEntered", *state.GetType()))
+func createEnteredEvent(t time.Time, state State, input interface{}) HistoryEvent {
+	event := createEvent(t, fmt.Sprintf("%vStateEntered", *state.GetType()))
 	json_raw, err := json.Marshal(input)
 
 	if err != nil {
@@ -107,8 +206,8 @@ func createEnteredEvent(state State, input interface{}) HistoryEvent {
 	return event
 }
 
-func createExitedEvent(state State, output interface{}) HistoryEvent {
-	event := createEvent(fmt.Sprintf("%vStateExited", *state.GetType()))
+func createExitedEvent(t time.Time, state State, output interface{}) HistoryEvent {
+	event := createEvent(t, fmt.Sprintf("%vStateExited", *state.GetType()))
 	json_raw, err := json.Marshal(output)
 
 	if err != nil {
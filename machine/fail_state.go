@@ -4,6 +4,7 @@ import (
 	"context"
 	"fmt"
 
+	"github.com/cleardataeng/step/jsonpath"
 	"github.com/cleardataeng/step/utils/is"
 	"github.com/cleardataeng/step/utils/to"
 )
@@ -16,23 +17,90 @@ type FailState struct {
 
 	Error *string `json:",omitempty"`
 	Cause *string `json:",omitempty"`
+
+	// ErrorPath and CausePath are JSON paths or Intrinsic Functions evaluated against the input
+	ErrorPath *string `json:",omitempty"`
+	CausePath *string `json:",omitempty"`
 }
 
-func (s *FailState) Execute(_ context.Context, input interface{}) (output interface{}, next *string, err error) {
-	return errorOutput(s.Error, s.Cause), nil, fmt.Errorf("Fail")
+// Execute fails with the Error and Cause, the output is the error output for the Execution
+func (s *FailState) Execute(ctx context.Context, input interface{}) (output interface{}, next *string, err error) {
+	name, err := failValue(ctx, s.Error, s.ErrorPath, input)
+	if err != nil {
+		return nil, nil, &stateError{errorPrefix(s) + " ErrorPath", err}
+	}
+
+	cause, err := failValue(ctx, s.Cause, s.CausePath, input)
+	if err != nil {
+		return nil, nil, &stateError{errorPrefix(s) + " CausePath", err}
+	}
+
+	return errorOutput(&name, &cause), nil, &statesError{name, cause}
+}
+
+// failValue returns the value, otherwise the string the path evaluates to
+func failValue(ctx context.Context, value *string, path *string, input interface{}) (string, error) {
+	if path == nil {
+		return to.Strs(value), nil
+	}
+
+	result, err := evaluateParamValue(ctx, *path, input)
+	if err != nil {
+		return "", &statesError{"States.Runtime", errorCause(err)}
+	}
+
+	str, ok := result.(string)
+	if !ok {
+		return "", &statesError{"States.Runtime", fmt.Sprintf("%q must be a string", *path)}
+	}
+
+	return str, nil
 }
 
 func (s *FailState) Validate() error {
-	s.SetType(to.Strp("Fail"))
+	defaultType(s, "Fail")
 
 	if err := ValidateNameAndType(s); err != nil {
 		return fmt.Errorf("%v %v", errorPrefix(s), err)
 	}
 
-	if is.EmptyStr(s.Error) {
-		return fmt.Errorf("%v %v", errorPrefix(s), "must contain Error")
+	if is.EmptyStr(s.Error) && s.ErrorPath == nil {
+		return invalidField(s, "Error", fmt.Errorf("must contain Error"))
+	}
+
+	if s.Error != nil && s.ErrorPath != nil {
+		return invalidField(s, "ErrorPath", fmt.Errorf("Only one of Error and ErrorPath allowed"))
 	}
 
+	if s.Cause != nil && s.CausePath != nil {
+		return invalidField(s, "CausePath", fmt.Errorf("Only one of Cause and CausePath allowed"))
+	}
+
+	if err := failPathValid(s.ErrorPath); err != nil {
+		return invalidField(s, "ErrorPath", fmt.Errorf("ErrorPath %v", err))
+	}
+
+	if err := failPathValid(s.CausePath); err != nil {
+		return invalidField(s, "CausePath", fmt.Errorf("CausePath %v", err))
+	}
+
+	return nil
+}
+
+// failPathValid checks a path is a reference path or an Intrinsic Function
+func failPathValid(path *string) error {
+	if path == nil || isIntrinsic(*path) {
+		return nil
+	}
+
+	p, err := jsonpath.NewPath(*path)
+	if err != nil {
+		return err
+	}
+
+	if !p.IsReferencePath() {
+		return fmt.Errorf("%q must be a Reference Path", *path)
+	}
 	return nil
 }
 
@@ -0,0 +1,87 @@
+package machine
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+
+	"github.com/cleardataeng/step/utils/to"
+	"github.com/stretchr/testify/assert"
+)
+
+func parseFailState(b []byte, t *testing.T) *FailState {
+	var p FailState
+	err := json.Unmarshal(b, &p)
+	assert.NoError(t, err)
+	p.SetName(to.Strp("TestState"))
+	p.SetType(to.Strp("Fail"))
+	return &p
+}
+
+func Test_FailState_Error(t *testing.T) {
+	state := parseFailState([]byte(`{"Error": "NotifyError", "Cause": "deploy failed"}`), t)
+	assert.NoError(t, state.Validate())
+
+	output, _, err := state.Execute(nil, map[string]interface{}{})
+	assert.Equal(t, "NotifyError", errorName(err))
+	assert.Equal(t, "deploy failed", errorCause(err))
+	assert.Equal(t, map[string]interface{}{"Error": "NotifyError", "Cause": "deploy failed"}, output)
+}
+
+func Test_FailState_ErrorPath_CausePath(t *testing.T) {
+	state := parseFailState([]byte(`{
+		"ErrorPath": "$.error.name",
+		"CausePath": "States.Format('{} failed: {}', $$.State.Name, $.error.cause)"
+	}`), t)
+	assert.NoError(t, state.Validate())
+
+	ctx := withStateContext(context.Background(), &contextState{name: "Fail"})
+	_, _, err := state.Execute(ctx, map[string]interface{}{
+		"error": map[string]interface{}{"name": "AlertError", "cause": "locked"},
+	})
+	assert.Equal(t, "AlertError", errorName(err))
+	assert.Equal(t, "Fail failed: locked", errorCause(err))
+
+	_, _, err = state.Execute(ctx, map[string]interface{}{"error": map[string]interface{}{"name": 1}})
+	assert.Equal(t, "States.Runtime", errorName(err))
+	assert.Regexp(t, `^FailState\(TestState\) Error: ErrorPath`, err.Error())
+}
+
+func Test_FailState_Validate(t *testing.T) {
+	invalid := []string{
+		`{}`,
+		`{"Error": "A", "ErrorPath": "$.a"}`,
+		`{"Error": "A", "Cause": "B", "CausePath": "$.b"}`,
+		`{"ErrorPath": "$.a[*]"}`,
+		`{"ErrorPath": "a"}`,
+	}
+
+	for _, raw := range invalid {
+		assert.Error(t, parseFailState([]byte(raw), t).Validate(), raw)
+	}
+}
+
+func Test_FailState_Execution_Error(t *testing.T) {
+	sm, err := FromJSON([]byte(`{
+		"StartAt": "Fan",
+		"States": {
+			"Fan": {
+				"Type": "Parallel",
+				"Branches": [{"StartAt": "Fail", "States": {"Fail": {"Type": "Fail", "Error": "NotifyError", "Cause": "branch"}}}],
+				"Catch": [{"ErrorEquals": ["AlertError"], "Next": "Done"}],
+				"End": true
+			},
+			"Done": {"Type": "Succeed"}
+		}
+	}`))
+	assert.NoError(t, err)
+
+	exec, err := sm.Execute(map[string]interface{}{})
+	assert.Error(t, err)
+	assert.Equal(t, "NotifyError", exec.ErrorName)
+	assert.Equal(t, "branch", exec.ErrorCause)
+
+	failed := eventsByType(exec, "ExecutionFailed")
+	assert.Equal(t, "NotifyError", *failed[0].ExecutionFailedEventDetails.Error)
+	assert.Equal(t, "branch", *failed[0].ExecutionFailedEventDetails.Cause)
+}
@@ -0,0 +1,438 @@
+package machine
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/private/protocol/json/jsonutil"
+	"github.com/aws/aws-sdk-go/service/sfn"
+	"github.com/cleardataeng/step/aws"
+	"github.com/cleardataeng/step/utils/to"
+)
+
+// The ExecutionHistory is recorded like GetExecutionHistory returns it,
+// every event has an Id and the PreviousEventId of the event it follows.
+// Parallel Branches and inline Map Iterations record into their parents history.
+
+type previousEventKey struct{}
+
+// withPreviousEvent sets the event the first event of a nested Execution follows
+func withPreviousEvent(ctx context.Context, id int64) context.Context {
+	return context.WithValue(ctx, previousEventKey{}, id)
+}
+
+func previousEventFrom(ctx context.Context) (int64, bool) {
+	if ctx == nil {
+		return 0, false
+	}
+	id, ok := ctx.Value(previousEventKey{}).(int64)
+	return id, ok
+}
+
+// nest records the events of a Branch or Iteration into the parents history
+func (sm *Execution) nest(ctx context.Context) {
+	parent := executionFrom(ctx)
+	if parent == nil {
+		return
+	}
+
+	sm.root = parent.historyRoot()
+	sm.lastEventID = parent.lastEventID
+	if id, ok := previousEventFrom(ctx); ok {
+		sm.lastEventID = id
+	}
+}
+
+func (sm *Execution) nested() bool {
+	return sm.root != nil
+}
+
+// historyRoot is the Execution whose ExecutionHistory records the events
+func (sm *Execution) historyRoot() *Execution {
+	if sm.root != nil {
+		return sm.root
+	}
+	return sm
+}
+
+// addEvent records an event following the last event of this Execution
+func (sm *Execution) addEvent(event HistoryEvent) int64 {
+	if sm == nil {
+		return 0
+	}
+	sm.lastEventID = sm.recordEvent(sm.lastEventID, event)
+	return sm.lastEventID
+}
+
+// recordEvent records an event following the previous event, returning its Id
+func (sm *Execution) recordEvent(previous int64, event HistoryEvent) int64 {
+	if sm == nil {
+		return 0
+	}
+
+	root := sm.historyRoot()
+	root.historyMu.Lock()
+	defer root.historyMu.Unlock()
+
+	id := int64(len(root.ExecutionHistory) + 1)
+	event.Id, event.PreviousEventId = &id, &previous
+	root.ExecutionHistory = append(root.ExecutionHistory, event)
+	return id
+}
+
+// followLastEvent continues from the latest event in the history, e.g. after the Branches of a Parallel State complete
+func (sm *Execution) followLastEvent() {
+	if sm == nil {
+		return
+	}
+
+	root := sm.historyRoot()
+	root.historyMu.Lock()
+	defer root.historyMu.Unlock()
+	sm.lastEventID = int64(len(root.ExecutionHistory))
+}
+
+// History returns the events like the GetExecutionHistory API
+func (sm *Execution) History() []*sfn.HistoryEvent {
+	root := sm.historyRoot()
+	root.historyMu.Lock()
+	defer root.historyMu.Unlock()
+
+	events := []*sfn.HistoryEvent{}
+	for i := range root.ExecutionHistory {
+		event := root.ExecutionHistory[i].HistoryEvent
+		events = append(events, &event)
+	}
+	return events
+}
+
+// HistoryJSON returns the history as the JSON output of GetExecutionHistory
+func (sm *Execution) HistoryJSON() (string, error) {
+	raw, err := jsonutil.BuildJSON(&sfn.GetExecutionHistoryOutput{Events: sm.History()})
+	if err != nil {
+		return "", err
+	}
+	return string(raw), nil
+}
+
+// HistoryFromJSON parses the JSON output of GetExecutionHistory, e.g. from the aws cli
+func HistoryFromJSON(raw []byte) ([]*sfn.HistoryEvent, error) {
+	var history struct {
+		Events []map[string]interface{} `json:"events"`
+	}
+	if err := json.Unmarshal(raw, &history); err != nil {
+		return nil, err
+	}
+
+	// The aws cli prints timestamps with a UTC offset the SDK cannot parse, so they are converted to epoch seconds
+	for _, event := range history.Events {
+		if timestamp, ok := event["timestamp"].(string); ok {
+			t, err := time.Parse(time.RFC3339Nano, timestamp)
+			if err != nil {
+				return nil, err
+			}
+			event["timestamp"] = float64(t.UnixNano()) / float64(time.Second)
+		}
+	}
+
+	raw, err := json.Marshal(history)
+	if err != nil {
+		return nil, err
+	}
+
+	var out sfn.GetExecutionHistoryOutput
+	if err := jsonutil.UnmarshalJSON(&out, bytes.NewReader(raw)); err != nil {
+		return nil, err
+	}
+	return out.Events, nil
+}
+
+// HistoryClient returns an SFN client serving GetExecutionHistory for this Execution,
+// so tools like execution.GetStateDetails work with local Executions. Other methods are not implemented
+func (sm *Execution) HistoryClient() aws.SFNAPI {
+	return &historyClient{exec: sm}
+}
+
+type historyClient struct {
+	aws.SFNAPI
+	exec *Execution
+}
+
+func (c *historyClient) GetExecutionHistory(in *sfn.GetExecutionHistoryInput) (*sfn.GetExecutionHistoryOutput, error) {
+	if in.ExecutionArn == nil || *in.ExecutionArn != c.exec.ID {
+		return nil, awserr.New(sfn.ErrCodeExecutionDoesNotExist, fmt.Sprintf("Execution Does Not Exist: '%v'", to.Strs(in.ExecutionArn)), nil)
+	}
+
+	events := c.exec.History()
+	if in.ReverseOrder != nil && *in.ReverseOrder {
+		for i, j := 0, len(events)-1; i < j; i, j = i+1, j-1 {
+			events[i], events[j] = events[j], events[i]
+		}
+	}
+
+	if in.MaxResults != nil && *in.MaxResults > 0 && int(*in.MaxResults) < len(events) {
+		events = events[:*in.MaxResults]
+	}
+
+	return &sfn.GetExecutionHistoryOutput{Events: events}, nil
+}
+
+////////
+// Execution Events
+////////
+
+func (sm *Execution) started(input interface{}, opts *ExecutionOptions) {
+	event := createEvent(sm.now(), "ExecutionStarted")
+	event.ExecutionStartedEventDetails = &sfn.ExecutionStartedEventDetails{
+		Input:   to.Strp(jsonString(input)),
+		RoleArn: to.Strp(opts.roleArn()),
+	}
+	sm.addEvent(event)
+}
+
+func (sm *Execution) succeeded(output interface{}) {
+	if sm.nested() {
+		return
+	}
+
+	event := createEvent(sm.now(), "ExecutionSucceeded")
+	event.ExecutionSucceededEventDetails = &sfn.ExecutionSucceededEventDetails{
+		Output: to.Strp(jsonString(output)),
+	}
+	sm.addEvent(event)
+}
+
+func (sm *Execution) failed(err error) {
+	if sm.nested() {
+		return
+	}
+
+	event := createEvent(sm.now(), "ExecutionFailed")
+	if err != nil {
+		event.ExecutionFailedEventDetails = &sfn.ExecutionFailedEventDetails{
+			Error: to.Strp(errorName(err)),
+			Cause: to.Strp(errorCause(err)),
+		}
+	}
+	sm.addEvent(event)
+}
+
+func (sm *Execution) timedOut(err error) {
+	sm.expired = true
+
+	event := createEvent(sm.now(), "ExecutionTimedOut")
+	event.ExecutionTimedOutEventDetails = &sfn.ExecutionTimedOutEventDetails{
+		Error: to.Strp(errorName(err)),
+		Cause: to.Strp(errorCause(err)),
+	}
+	sm.addEvent(event)
+}
+
+func (sm *Execution) aborted(err *statesError) {
+	event := createEvent(sm.now(), "ExecutionAborted")
+	details := &sfn.ExecutionAbortedEventDetails{}
+	if err.name != "" {
+		details.Error = to.Strp(err.name)
+	}
+	if err.cause != "" {
+		details.Cause = to.Strp(err.cause)
+	}
+	event.ExecutionAbortedEventDetails = details
+	sm.addEvent(event)
+}
+
+////////
+// Task Events
+////////
+
+// taskEvents records the events of a Task Resource, LambdaFunction events for Lambda functions
+// and Task events for service integrations like arn:aws:states:::lambda:invoke
+type taskEvents struct {
+	exec   *Execution
+	state  *TaskState
+	region string
+}
+
+func (s *TaskState) events(ctx context.Context) *taskEvents {
+	return &taskEvents{executionFrom(ctx), s, executionOptionsFrom(ctx).Lambda.region()}
+}
+
+func (e *taskEvents) integration() bool {
+	return e.state.Resource != nil && strings.HasPrefix(*e.state.Resource, "arn:aws:states:::")
+}
+
+// resource returns the resource type and resource of an integration, e.g. "lambda" and "invoke"
+func (e *taskEvents) resource() (*string, *string) {
+	parts := strings.SplitN(strings.TrimPrefix(*e.state.Resource, "arn:aws:states:::"), ":", 2)
+	if len(parts) == 1 {
+		return to.Strp(parts[0]), to.Strp("")
+	}
+	return to.Strp(parts[0]), to.Strp(parts[1])
+}
+
+func (e *taskEvents) add(eventType string, details func(*HistoryEvent)) {
+	if e.exec == nil {
+		return
+	}
+
+	prefix := "LambdaFunction"
+	if e.integration() {
+		prefix = "Task"
+	}
+
+	event := createEvent(e.exec.now(), prefix+eventType)
+	details(&event)
+	e.exec.addEvent(event)
+}
+
+func (e *taskEvents) scheduled(input interface{}) {
+	timeout := int64(e.state.timeoutSeconds())
+	e.add("Scheduled", func(event *HistoryEvent) {
+		if !e.integration() {
+			event.LambdaFunctionScheduledEventDetails = &sfn.LambdaFunctionScheduledEventDetails{
+				Resource:         e.state.Resource,
+				Input:            to.Strp(jsonString(input)),
+				TimeoutInSeconds: &timeout,
+			}
+			return
+		}
+
+		resourceType, resource := e.resource()
+		event.TaskScheduledEventDetails = &sfn.TaskScheduledEventDetails{
+			ResourceType:     resourceType,
+			Resource:         resource,
+			Region:           to.Strp(e.region),
+			Parameters:       to.Strp(jsonString(input)),
+			TimeoutInSeconds: &timeout,
+		}
+	})
+}
+
+func (e *taskEvents) started() {
+	e.add("Started", func(event *HistoryEvent) {
+		if e.integration() {
+			resourceType, resource := e.resource()
+			event.TaskStartedEventDetails = &sfn.TaskStartedEventDetails{ResourceType: resourceType, Resource: resource}
+		}
+	})
+}
+
+// submitted is recorded when a callback Task has been called and waits for its token
+func (e *taskEvents) submitted(output interface{}) {
+	if !e.integration() {
+		return
+	}
+
+	e.add("Submitted", func(event *HistoryEvent) {
+		resourceType, resource := e.resource()
+		event.TaskSubmittedEventDetails = &sfn.TaskSubmittedEventDetails{
+			ResourceType: resourceType,
+			Resource:     resource,
+			Output:       to.Strp(jsonString(output)),
+		}
+	})
+}
+
+func (e *taskEvents) succeeded(output interface{}) {
+	e.add("Succeeded", func(event *HistoryEvent) {
+		if !e.integration() {
+			event.LambdaFunctionSucceededEventDetails = &sfn.LambdaFunctionSucceededEventDetails{
+				Output: to.Strp(jsonString(output)),
+			}
+			return
+		}
+
+		resourceType, resource := e.resource()
+		event.TaskSucceededEventDetails = &sfn.TaskSucceededEventDetails{
+			ResourceType: resourceType,
+			Resource:     resource,
+			Output:       to.Strp(jsonString(output)),
+		}
+	})
+}
+
+// failed records TimedOut for States.Timeout and States.HeartbeatTimeout errors, otherwise Failed
+func (e *taskEvents) failed(err error) {
+	name, cause := to.Strp(errorName(err)), to.Strp(errorCause(err))
+	timedOut := *name == "States.Timeout" || *name == "States.HeartbeatTimeout"
+
+	eventType := "Failed"
+	if timedOut {
+		eventType = "TimedOut"
+	}
+
+	e.add(eventType, func(event *HistoryEvent) {
+		if !e.integration() {
+			if timedOut {
+				event.LambdaFunctionTimedOutEventDetails = &sfn.LambdaFunctionTimedOutEventDetails{Error: name, Cause: cause}
+			} else {
+				event.LambdaFunctionFailedEventDetails = &sfn.LambdaFunctionFailedEventDetails{Error: name, Cause: cause}
+			}
+			return
+		}
+
+		resourceType, resource := e.resource()
+		if timedOut {
+			event.TaskTimedOutEventDetails = &sfn.TaskTimedOutEventDetails{ResourceType: resourceType, Resource: resource, Error: name, Cause: cause}
+		} else {
+			event.TaskFailedEventDetails = &sfn.TaskFailedEventDetails{ResourceType: resourceType, Resource: resource, Error: name, Cause: cause}
+		}
+	})
+}
+
+////////
+// Parallel and Map Events
+////////
+
+func (sm *Execution) stateEvent(eventType string) int64 {
+	if sm == nil {
+		return 0
+	}
+	return sm.addEvent(createEvent(sm.now(), eventType))
+}
+
+func (sm *Execution) mapStateStarted(length int) int64 {
+	if sm == nil {
+		return 0
+	}
+
+	event := createEvent(sm.now(), "MapStateStarted")
+	event.MapStateStartedEventDetails = &sfn.MapStateStartedEventDetails{Length: to.Int64p(int64(length))}
+	return sm.addEvent(event)
+}
+
+func (sm *Execution) mapStateEnded(err error) {
+	if err != nil {
+		sm.stateEvent("MapStateFailed")
+		return
+	}
+	sm.stateEvent("MapStateSucceeded")
+}
+
+// mapIterationEvent records a MapIteration event following previous
+func (sm *Execution) mapIterationEvent(previous int64, eventType string, name *string, index int) int64 {
+	if sm == nil {
+		return 0
+	}
+
+	event := createEvent(sm.now(), "MapIteration"+eventType)
+	details := &sfn.MapIterationEventDetails{Name: name, Index: to.Int64p(int64(index))}
+
+	switch eventType {
+	case "Started":
+		event.MapIterationStartedEventDetails = details
+	case "Succeeded":
+		event.MapIterationSucceededEventDetails = details
+	case "Failed":
+		event.MapIterationFailedEventDetails = details
+	case "Aborted":
+		event.MapIterationAbortedEventDetails = details
+	}
+
+	return sm.recordEvent(previous, event)
+}
@@ -0,0 +1,314 @@
+package machine
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/cleardataeng/step/execution"
+	"github.com/cleardataeng/step/utils/to"
+	"github.com/stretchr/testify/assert"
+)
+
+func eventTypes(exec *Execution) []string {
+	types := []string{}
+	for _, event := range exec.ExecutionHistory {
+		types = append(types, *event.Type)
+	}
+	return types
+}
+
+// eventsByType returns the events with a type
+func eventsByType(exec *Execution, eventType string) []HistoryEvent {
+	events := []HistoryEvent{}
+	for _, event := range exec.ExecutionHistory {
+		if *event.Type == eventType {
+			events = append(events, event)
+		}
+	}
+	return events
+}
+
+func Test_History_Lambda(t *testing.T) {
+	sm, err := FromJSON([]byte(`{
+    "StartAt": "Task",
+    "States": {
+      "Task": {"Type": "Task", "Resource": "arn:aws:lambda:us-east-1:000000000000:function:test", "End": true}
+    }
+  }`))
+	assert.NoError(t, err)
+	sm.SetTaskHandler("Task", ReturnInputHandler)
+
+	exec, err := sm.Execute(map[string]interface{}{"a": "b"})
+	assert.NoError(t, err)
+
+	assert.Equal(t, []string{
+		"ExecutionStarted",
+		"TaskStateEntered",
+		"LambdaFunctionScheduled",
+		"LambdaFunctionStarted",
+		"LambdaFunctionSucceeded",
+		"TaskStateExited",
+		"ExecutionSucceeded",
+	}, eventTypes(exec))
+
+	for i, event := range exec.ExecutionHistory {
+		assert.Equal(t, int64(i+1), *event.Id)
+		assert.Equal(t, int64(i), *event.PreviousEventId)
+	}
+
+	history := exec.ExecutionHistory
+	assert.Equal(t, `{"a":"b"}`, *history[0].ExecutionStartedEventDetails.Input)
+	assert.Equal(t, "arn:aws:lambda:us-east-1:000000000000:function:test", *history[2].LambdaFunctionScheduledEventDetails.Resource)
+	assert.Equal(t, `{"a":"b"}`, *history[2].LambdaFunctionScheduledEventDetails.Input)
+	assert.Equal(t, `{"a":"b"}`, *history[4].LambdaFunctionSucceededEventDetails.Output)
+	assert.Equal(t, `{"a":"b"}`, *history[6].ExecutionSucceededEventDetails.Output)
+}
+
+func Test_History_Integration_Failed(t *testing.T) {
+	sm, err := FromJSON([]byte(`{
+    "StartAt": "Put",
+    "States": {
+      "Put": {
+        "Type": "Task",
+        "Resource": "arn:aws:states:::dynamodb:putItem",
+        "Parameters": {"TableName": "items"},
+        "Catch": [{"ErrorEquals": ["DynamoDB.ResourceNotFoundException"], "Next": "Fail"}],
+        "End": true
+      },
+      "Fail": {"Type": "Fail", "Error": "Failed", "Cause": "no table"}
+    }
+  }`))
+	assert.NoError(t, err)
+	sm.SetResourceResolver(NewResources().Handle("arn:aws:states:::dynamodb:*", &Fake{
+		Error: NewError("DynamoDB.ResourceNotFoundException", "Requested resource not found"),
+	}))
+
+	exec, err := sm.Execute(map[string]interface{}{})
+	assert.Error(t, err)
+
+	assert.Equal(t, []string{
+		"ExecutionStarted",
+		"TaskStateEntered",
+		"TaskScheduled",
+		"TaskStarted",
+		"TaskFailed",
+		"TaskStateExited",
+		"FailStateEntered",
+		"ExecutionFailed",
+	}, eventTypes(exec))
+
+	scheduled := exec.ExecutionHistory[2].TaskScheduledEventDetails
+	assert.Equal(t, "dynamodb", *scheduled.ResourceType)
+	assert.Equal(t, "putItem", *scheduled.Resource)
+	assert.Equal(t, `{"TableName":"items"}`, *scheduled.Parameters)
+	assert.Equal(t, int64(DefaultTaskTimeoutSeconds), *scheduled.TimeoutInSeconds)
+
+	failed := exec.ExecutionHistory[4].TaskFailedEventDetails
+	assert.Equal(t, "DynamoDB.ResourceNotFoundException", *failed.Error)
+	assert.Equal(t, "Requested resource not found", *failed.Cause)
+
+	assert.NotNil(t, exec.ExecutionHistory[7].ExecutionFailedEventDetails)
+}
+
+func Test_History_Callback_Submitted(t *testing.T) {
+	sm := callbackMachine(t, `{
+    "Type": "Task",
+    "Resource": "arn:aws:states:::sqs:sendMessage.waitForTaskToken",
+    "Parameters": {"token.$": "$$.Task.Token"},
+    "Catch": [{"ErrorEquals": ["Rejected"], "Next": "Rejected"}],
+    "End": true
+  }`)
+
+	tokens := make(chan string, 1)
+	sm.SetTaskHandler("Approve", tokenHandler(tokens))
+
+	exec, err := sm.Start(map[string]interface{}{})
+	assert.NoError(t, err)
+	assert.NoError(t, exec.SendTaskSuccess(<-tokens, map[string]interface{}{"ok": true}))
+	assert.NoError(t, exec.Wait())
+
+	submitted := eventsByType(exec, "TaskSubmitted")
+	assert.Equal(t, 1, len(submitted))
+	assert.Equal(t, "sendMessage.waitForTaskToken", *submitted[0].TaskSubmittedEventDetails.Resource)
+	assert.Equal(t, `{"ok":true}`, *eventsByType(exec, "TaskSucceeded")[0].TaskSucceededEventDetails.Output)
+}
+
+func Test_History_Parallel(t *testing.T) {
+	sm, err := FromJSON([]byte(`{
+    "StartAt": "Parallel",
+    "States": {
+      "Parallel": {
+        "Type": "Parallel",
+        "Branches": [
+          {"StartAt": "A", "States": {"A": {"Type": "Pass", "End": true}}},
+          {"StartAt": "B", "States": {"B": {"Type": "Pass", "End": true}}}
+        ],
+        "End": true
+      }
+    }
+  }`))
+	assert.NoError(t, err)
+
+	exec, err := sm.Execute(map[string]interface{}{})
+	assert.NoError(t, err)
+
+	// Branch states are in the history but not the Path
+	assert.Equal(t, []string{"Parallel"}, exec.Path())
+	assert.Equal(t, 2, len(eventsByType(exec, "PassStateEntered")))
+	assert.Equal(t, 2, len(eventsByType(exec, "PassStateExited")))
+
+	started := eventsByType(exec, "ParallelStateStarted")[0]
+	for _, entered := range eventsByType(exec, "PassStateEntered") {
+		assert.Equal(t, *started.Id, *entered.PreviousEventId)
+	}
+
+	types := eventTypes(exec)
+	assert.Equal(t, []string{"ExecutionStarted", "ParallelStateEntered", "ParallelStateStarted"}, types[:3])
+	assert.Equal(t, []string{"ParallelStateSucceeded", "ParallelStateExited", "ExecutionSucceeded"}, types[len(types)-3:])
+
+	// The Succeeded event follows the last Branch event
+	succeeded := eventsByType(exec, "ParallelStateSucceeded")[0]
+	assert.Equal(t, *succeeded.Id-1, *succeeded.PreviousEventId)
+}
+
+func Test_History_Map(t *testing.T) {
+	sm, err := FromJSON([]byte(`{
+    "StartAt": "Map",
+    "States": {
+      "Map": {
+        "Type": "Map",
+        "Iterator": {"StartAt": "Item", "States": {"Item": {"Type": "Task", "Resource": "test", "End": true}}},
+        "ItemsPath": "$.items",
+        "ResultPath": "$.results",
+        "Catch": [{"ErrorEquals": ["States.ALL"], "Next": "Caught"}],
+        "End": true
+      },
+      "Caught": {"Type": "Pass", "End": true}
+    }
+  }`))
+	assert.NoError(t, err)
+	sm.SetTaskHandler("Item", func(_ context.Context, input interface{}) (interface{}, error) {
+		if input.(map[string]interface{})["fail"] == true {
+			return nil, &TestError{}
+		}
+		return input, nil
+	})
+
+	exec, err := sm.Execute(map[string]interface{}{"items": []interface{}{map[string]interface{}{}, map[string]interface{}{}}})
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"Map"}, exec.Path())
+
+	mapStarted := eventsByType(exec, "MapStateStarted")
+	assert.Equal(t, int64(2), *mapStarted[0].MapStateStartedEventDetails.Length)
+
+	iterations := eventsByType(exec, "MapIterationStarted")
+	assert.Equal(t, 2, len(iterations))
+	for _, iteration := range iterations {
+		assert.Equal(t, *mapStarted[0].Id, *iteration.PreviousEventId)
+		assert.Equal(t, "Map", *iteration.MapIterationStartedEventDetails.Name)
+	}
+
+	// Each Iteration's events follow its MapIterationStarted
+	for _, entered := range eventsByType(exec, "TaskStateEntered") {
+		previous := exec.ExecutionHistory[*entered.PreviousEventId-1]
+		assert.Equal(t, "MapIterationStarted", *previous.Type)
+	}
+
+	assert.Equal(t, 2, len(eventsByType(exec, "MapIterationSucceeded")))
+	assert.Equal(t, 1, len(eventsByType(exec, "MapStateSucceeded")))
+
+	exec, err = sm.Execute(map[string]interface{}{"items": []interface{}{map[string]interface{}{"fail": true}}})
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"Map", "Caught"}, exec.Path())
+	assert.Equal(t, 1, len(eventsByType(exec, "MapIterationFailed")))
+	assert.Equal(t, 1, len(eventsByType(exec, "MapStateFailed")))
+	assert.Equal(t, 1, len(eventsByType(exec, "LambdaFunctionFailed")))
+}
+
+func Test_History_Retry(t *testing.T) {
+	sm, err := FromJSON([]byte(`{
+    "StartAt": "Task",
+    "States": {
+      "Task": {
+        "Type": "Task",
+        "Resource": "test",
+        "Retry": [{"ErrorEquals": ["States.ALL"]}],
+        "End": true
+      }
+    }
+  }`))
+	assert.NoError(t, err)
+
+	calls := 0
+	sm.SetTaskHandler("Task", func(_ context.Context, input interface{}) (interface{}, error) {
+		calls++
+		if calls == 1 {
+			return nil, &TestError{}
+		}
+		return input, nil
+	})
+	sm.SetClock(NewVirtualClock(time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)))
+
+	exec, err := sm.Execute(map[string]interface{}{})
+	assert.NoError(t, err)
+
+	// A retry is an attempt inside the State, not a new transition
+	assert.Equal(t, 1, len(eventsByType(exec, "TaskStateEntered")))
+	assert.Equal(t, 1, len(eventsByType(exec, "TaskStateExited")))
+
+	failed := eventsByType(exec, "LambdaFunctionFailed")
+	assert.Equal(t, 1, len(failed))
+	assert.Equal(t, "TestError", *failed[0].LambdaFunctionFailedEventDetails.Error)
+	assert.Equal(t, 2, len(eventsByType(exec, "LambdaFunctionScheduled")))
+	assert.Equal(t, 1, len(eventsByType(exec, "LambdaFunctionSucceeded")))
+}
+
+func Test_History_GetStateDetails(t *testing.T) {
+	sm, err := FromJSON([]byte(`{
+    "StartAt": "Task",
+    "States": {
+      "Task": {"Type": "Task", "Resource": "test", "Next": "Done"},
+      "Done": {"Type": "Pass", "Result": {"done": true}, "End": true}
+    }
+  }`))
+	assert.NoError(t, err)
+	sm.SetTaskHandler("Task", ReturnInputHandler)
+
+	exec, err := sm.Execute(map[string]interface{}{})
+	assert.NoError(t, err)
+
+	details, err := (&execution.Execution{ExecutionArn: &exec.ID}).GetStateDetails(exec.HistoryClient())
+	assert.NoError(t, err)
+	assert.Equal(t, "Done", *details.LastStateName)
+	assert.Equal(t, "Task", *details.LastTaskName)
+	assert.Equal(t, `{"done":true}`, *details.LastOutput)
+
+	// Other Executions do not exist
+	_, err = (&execution.Execution{ExecutionArn: to.Strp("arn:aws:states:us-east-1:000000000000:execution:other")}).GetStateDetails(exec.HistoryClient())
+	assert.Error(t, err)
+	assert.Regexp(t, "ExecutionDoesNotExist", err.Error())
+}
+
+func Test_History_JSON(t *testing.T) {
+	sm, err := FromJSON([]byte(`{"StartAt": "Pass", "States": {"Pass": {"Type": "Pass", "End": true}}}`))
+	assert.NoError(t, err)
+
+	exec, err := sm.Execute(map[string]interface{}{})
+	assert.NoError(t, err)
+
+	raw, err := exec.HistoryJSON()
+	assert.NoError(t, err)
+
+	var history struct {
+		Events []map[string]interface{} `json:"events"`
+	}
+	assert.NoError(t, json.Unmarshal([]byte(raw), &history))
+	assert.Equal(t, 4, len(history.Events))
+	assert.Equal(t, "PassStateEntered", history.Events[1]["type"])
+	assert.Equal(t, 2.0, history.Events[1]["id"])
+	assert.Equal(t, 1.0, history.Events[1]["previousEventId"])
+	assert.Equal(t, map[string]interface{}{"name": "Pass", "input": "{}"}, history.Events[1]["stateEnteredEventDetails"])
+	assert.IsType(t, 0.0, history.Events[1]["timestamp"])
+}
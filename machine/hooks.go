@@ -0,0 +1,181 @@
+package machine
+
+import (
+	"context"
+	"sync"
+)
+
+// Hooks observe and intercept an Execution, nil hooks are skipped
+//
+// Hooks are called synchronously so blocking in a hook pauses the Execution.
+// A hook can modify the fields of the event it is given, e.g. the Input of a State,
+// and returning an error aborts the Execution with that error (it cannot be caught by a Catcher).
+// States in Parallel Branches call hooks concurrently.
+type Hooks struct {
+	// BeforeState is called when a State is entered, before its InputPath
+	BeforeState func(ctx context.Context, e *StateEvent) error
+
+	// AfterState is called when a State completes without an error
+	AfterState func(ctx context.Context, e *StateEvent) error
+
+	// OnError is called when a State fails after Retriers and Catchers are exhausted
+	// Setting Error to nil recovers the State with Output and Next
+	OnError func(ctx context.Context, e *StateEvent) error
+
+	// OnRetry is called before a State is retried
+	OnRetry func(ctx context.Context, e *StateEvent) error
+
+	// OnCatch is called when a Catcher handles an error, Output and Next are the Catchers
+	OnCatch func(ctx context.Context, e *StateEvent) error
+
+	// OnStage is called after each InputPath, Parameters, ResultSelector, ResultPath and OutputPath
+	// Value can be modified but not aborted
+	OnStage func(ctx context.Context, e *StageEvent)
+}
+
+// StateEvent describes a State at a point in an Execution
+type StateEvent struct {
+	Name string
+	Type string
+
+	Input  interface{}
+	Output interface{}
+	Next   *string
+	Error  error
+
+	Attempt int // retry attempt, starting at 1, for OnRetry
+}
+
+// StageEvent is the value after a stage of a States input output processing
+type StageEvent struct {
+	Name  string
+	Stage string // InputPath, Parameters, ResultSelector, ResultPath or OutputPath
+	Value interface{}
+}
+
+// hookRun is the Hooks of a running Execution, and the error it was aborted with
+type hookRun struct {
+	hooks *Hooks
+
+	mu      sync.Mutex
+	aborted error
+}
+
+type hooksKey struct{}
+
+type currentStateKey struct{}
+
+func withHooks(ctx context.Context, hooks *Hooks) context.Context {
+	return context.WithValue(ctx, hooksKey{}, &hookRun{hooks: hooks})
+}
+
+// hooksFrom returns the Hooks of the current Execution, nil if there are none
+func hooksFrom(ctx context.Context) *hookRun {
+	if ctx == nil {
+		return nil
+	}
+	run, _ := ctx.Value(hooksKey{}).(*hookRun)
+	return run
+}
+
+func withCurrentState(ctx context.Context, s State) context.Context {
+	return context.WithValue(ctx, currentStateKey{}, s)
+}
+
+// currentState returns the State being executed, or nil
+func currentState(ctx context.Context) State {
+	if ctx == nil {
+		return nil
+	}
+	s, _ := ctx.Value(currentStateKey{}).(State)
+	return s
+}
+
+func newStateEvent(s State, input interface{}) *StateEvent {
+	e := &StateEvent{Input: input}
+	if s != nil {
+		if s.Name() != nil {
+			e.Name = *s.Name()
+		}
+		if s.GetType() != nil {
+			e.Type = *s.GetType()
+		}
+	}
+	return e
+}
+
+// abort records the first error a hook aborted the Execution with
+func (r *hookRun) abort(err error) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.aborted == nil {
+		r.aborted = err
+	}
+	return r.aborted
+}
+
+// abortedErr returns the error the Execution was aborted with
+func (r *hookRun) abortedErr() error {
+	if r == nil {
+		return nil
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.aborted
+}
+
+func (r *hookRun) call(ctx context.Context, hook func(context.Context, *StateEvent) error, e *StateEvent) error {
+	if r == nil || hook == nil {
+		return nil
+	}
+
+	if err := hook(ctx, e); err != nil {
+		return r.abort(err)
+	}
+
+	return nil
+}
+
+func (r *hookRun) hooksOrEmpty() *Hooks {
+	if r == nil || r.hooks == nil {
+		return &Hooks{}
+	}
+	return r.hooks
+}
+
+func (r *hookRun) beforeState(ctx context.Context, e *StateEvent) error {
+	return r.call(ctx, r.hooksOrEmpty().BeforeState, e)
+}
+
+func (r *hookRun) afterState(ctx context.Context, e *StateEvent) error {
+	return r.call(ctx, r.hooksOrEmpty().AfterState, e)
+}
+
+func (r *hookRun) onError(ctx context.Context, e *StateEvent) error {
+	return r.call(ctx, r.hooksOrEmpty().OnError, e)
+}
+
+func (r *hookRun) onRetry(ctx context.Context, e *StateEvent) error {
+	return r.call(ctx, r.hooksOrEmpty().OnRetry, e)
+}
+
+func (r *hookRun) onCatch(ctx context.Context, e *StateEvent) error {
+	return r.call(ctx, r.hooksOrEmpty().OnCatch, e)
+}
+
+// stage calls OnStage returning the possibly modified value
+func (r *hookRun) stage(ctx context.Context, stage string, value interface{}) interface{} {
+	if r == nil || r.hooks == nil || r.hooks.OnStage == nil {
+		return value
+	}
+
+	e := &StageEvent{Stage: stage, Value: value}
+	if s := currentState(ctx); s != nil && s.Name() != nil {
+		e.Name = *s.Name()
+	}
+
+	r.hooks.OnStage(ctx, e)
+	return e.Value
+}
@@ -0,0 +1,206 @@
+package machine
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func hooksMachine(t *testing.T) *StateMachine {
+	sm, err := FromJSON([]byte(`{
+		"StartAt": "Start",
+		"States": {
+			"Start": {
+				"Type": "Pass",
+				"Result": "b",
+				"ResultPath": "$.a",
+				"Next": "Task"
+			},
+			"Task": {
+				"Type": "Task",
+				"Resource": "test",
+				"InputPath": "$",
+				"Parameters": {"a.$": "$.a"},
+				"Retry": [{"ErrorEquals": ["TestError"], "MaxAttempts": 1}],
+				"Catch": [{"ErrorEquals": ["States.ALL"], "ResultPath": "$.error", "Next": "Caught"}],
+				"End": true
+			},
+			"Caught": {
+				"Type": "Pass",
+				"End": true
+			}
+		}
+	}`))
+	assert.NoError(t, err)
+
+	sm.SetTaskHandler("Task", ThrowTestErrorHandler)
+	return sm
+}
+
+func Test_Hooks_Order(t *testing.T) {
+	sm := hooksMachine(t)
+
+	calls := []string{}
+	sm.SetHooks(&Hooks{
+		BeforeState: func(_ context.Context, e *StateEvent) error {
+			calls = append(calls, "before "+e.Name)
+			return nil
+		},
+		AfterState: func(_ context.Context, e *StateEvent) error {
+			next := "End"
+			if e.Next != nil {
+				next = *e.Next
+			}
+			calls = append(calls, fmt.Sprintf("after %v -> %v", e.Name, next))
+			return nil
+		},
+		OnRetry: func(_ context.Context, e *StateEvent) error {
+			calls = append(calls, fmt.Sprintf("retry %v %v", e.Name, e.Attempt))
+			return nil
+		},
+		OnCatch: func(_ context.Context, e *StateEvent) error {
+			calls = append(calls, fmt.Sprintf("catch %v -> %v", e.Name, *e.Next))
+			return nil
+		},
+		OnStage: func(_ context.Context, e *StageEvent) {
+			calls = append(calls, fmt.Sprintf("stage %v %v", e.Name, e.Stage))
+		},
+	})
+
+	exec, err := sm.Execute(map[string]interface{}{})
+	assert.NoError(t, err)
+	assert.Equal(t, "b", exec.Output["a"])
+
+	assert.Equal(t, []string{
+		"before Start",
+		"stage Start InputPath",
+		"stage Start ResultPath",
+		"stage Start OutputPath",
+		"after Start -> Task",
+		"before Task",
+		"stage Task InputPath",
+		"stage Task Parameters",
+		"retry Task 1",
+		"stage Task InputPath",
+		"stage Task Parameters",
+		"catch Task -> Caught",
+		"after Task -> Caught",
+		"before Caught",
+		"stage Caught InputPath",
+		"stage Caught ResultPath",
+		"stage Caught OutputPath",
+		"after Caught -> End",
+	}, calls)
+}
+
+func Test_Hooks_Modify(t *testing.T) {
+	sm := hooksMachine(t)
+	sm.SetTaskHandler("Task", ReturnInputHandler)
+
+	sm.SetHooks(&Hooks{
+		BeforeState: func(_ context.Context, e *StateEvent) error {
+			if e.Name == "Task" {
+				e.Input.(map[string]interface{})["a"] = "modified"
+			}
+			return nil
+		},
+		OnStage: func(_ context.Context, e *StageEvent) {
+			if e.Stage == "ResultPath" {
+				e.Value.(map[string]interface{})["stage"] = true
+			}
+		},
+	})
+
+	exec, err := sm.Execute(map[string]interface{}{})
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]interface{}{"a": "modified", "stage": true}, exec.Output)
+}
+
+func Test_Hooks_OnError_Recover(t *testing.T) {
+	sm, err := FromJSON([]byte(`{
+		"StartAt": "Task",
+		"States": {
+			"Task": {"Type": "Task", "Resource": "test", "End": true}
+		}
+	}`))
+	assert.NoError(t, err)
+	sm.SetTaskHandler("Task", ThrowTestErrorHandler)
+
+	var seen error
+	sm.SetHooks(&Hooks{
+		OnError: func(_ context.Context, e *StateEvent) error {
+			seen = e.Error
+			e.Error = nil
+			e.Output = map[string]interface{}{"recovered": true}
+			return nil
+		},
+	})
+
+	exec, err := sm.Execute(map[string]interface{}{})
+	assert.NoError(t, err)
+	assert.Regexp(t, "Test Error", seen.Error())
+	assert.Equal(t, map[string]interface{}{"recovered": true}, exec.Output)
+}
+
+func Test_Hooks_Abort(t *testing.T) {
+	abort := fmt.Errorf("abort")
+
+	// Aborting in OnRetry is not caught by the Catcher
+	sm := hooksMachine(t)
+	sm.SetHooks(&Hooks{
+		OnRetry: func(_ context.Context, e *StateEvent) error {
+			return abort
+		},
+	})
+
+	exec, err := sm.Execute(map[string]interface{}{})
+	assert.Equal(t, abort, err)
+	assert.Equal(t, []string{"Start", "Task"}, exec.Path())
+
+	// Aborting BeforeState stops before the State executes
+	sm = hooksMachine(t)
+	sm.SetHooks(&Hooks{
+		BeforeState: func(_ context.Context, e *StateEvent) error {
+			if e.Name == "Task" {
+				return abort
+			}
+			return nil
+		},
+	})
+
+	_, err = sm.Execute(map[string]interface{}{})
+	assert.Equal(t, abort, err)
+}
+
+func Test_Hooks_Parallel_Abort(t *testing.T) {
+	sm, err := FromJSON([]byte(`{
+		"StartAt": "Parallel",
+		"States": {
+			"Parallel": {
+				"Type": "Parallel",
+				"Catch": [{"ErrorEquals": ["States.ALL"], "Next": "Caught"}],
+				"Branches": [
+					{"StartAt": "A", "States": {"A": {"Type": "Pass", "End": true}}}
+				],
+				"End": true
+			},
+			"Caught": {"Type": "Pass", "End": true}
+		}
+	}`))
+	assert.NoError(t, err)
+
+	abort := fmt.Errorf("abort")
+	sm.SetHooks(&Hooks{
+		BeforeState: func(_ context.Context, e *StateEvent) error {
+			if e.Name == "A" {
+				return abort
+			}
+			return nil
+		},
+	})
+
+	_, err = sm.Execute(map[string]interface{}{})
+	assert.Equal(t, abort, err)
+}
@@ -0,0 +1,797 @@
+package machine
+
+import (
+	"crypto/md5"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"hash"
+	"math"
+	mrand "math/rand"
+	"reflect"
+	"strconv"
+	"strings"
+
+	"github.com/cleardataeng/step/utils/to"
+)
+
+// Intrinsic Functions e.g. States.Format('{}-{}', $.a, $.b)
+// are allowed as values of ".$" keys in Parameters and ResultSelector
+
+// pathResolver returns the value of a JSON path argument
+type pathResolver func(path string) (interface{}, error)
+
+type intrinsicFn func(args []*intrinsicValue) (interface{}, error)
+
+var intrinsicFunctions = map[string]intrinsicFn{
+	"States.Format":         intrinsicFormat,
+	"States.StringToJson":   intrinsicStringToJSON,
+	"States.JsonToString":   intrinsicJSONToString,
+	"States.Array":          intrinsicArray,
+	"States.ArrayPartition": intrinsicArrayPartition,
+	"States.ArrayContains":  intrinsicArrayContains,
+	"States.ArrayRange":     intrinsicArrayRange,
+	"States.ArrayGetItem":   intrinsicArrayGetItem,
+	"States.ArrayLength":    intrinsicArrayLength,
+	"States.ArrayUnique":    intrinsicArrayUnique,
+	"States.Base64Encode":   intrinsicBase64Encode,
+	"States.Base64Decode":   intrinsicBase64Decode,
+	"States.Hash":           intrinsicHash,
+	"States.JsonMerge":      intrinsicJSONMerge,
+	"States.MathRandom":     intrinsicMathRandom,
+	"States.MathAdd":        intrinsicMathAdd,
+	"States.StringSplit":    intrinsicStringSplit,
+	"States.UUID":           intrinsicUUID,
+}
+
+// isIntrinsic returns true if the string is an Intrinsic Function call
+func isIntrinsic(str string) bool {
+	return strings.HasPrefix(strings.TrimSpace(str), "States.")
+}
+
+// evaluateIntrinsic parses and evaluates an Intrinsic Function call
+func evaluateIntrinsic(str string, resolve pathResolver) (interface{}, error) {
+	p := &intrinsicParser{str: str}
+
+	call, err := p.parseCall()
+	if err != nil {
+		return nil, fmt.Errorf("Intrinsic Error: %q %v", str, err)
+	}
+
+	p.skipSpace()
+	if !p.done() {
+		return nil, fmt.Errorf("Intrinsic Error: %q unexpected %q at %v", str, p.str[p.pos:], p.pos)
+	}
+
+	value, err := call.evaluate(resolve)
+	if err != nil {
+		return nil, fmt.Errorf("Intrinsic Error: %v", err)
+	}
+
+	return value, nil
+}
+
+//////
+// Parser
+//////
+
+type intrinsicCall struct {
+	name string
+	args []*intrinsicArg
+}
+
+// intrinsicArg is exactly one of a literal, path or nested call
+type intrinsicArg struct {
+	literal interface{}
+	raw     *string // string literals keep escaped braces for States.Format
+	path    *string
+	call    *intrinsicCall
+}
+
+// intrinsicValue is an evaluated argument
+type intrinsicValue struct {
+	value interface{}
+	raw   *string
+}
+
+type intrinsicParser struct {
+	str string
+	pos int
+}
+
+func (p *intrinsicParser) done() bool {
+	return p.pos >= len(p.str)
+}
+
+func (p *intrinsicParser) peek() byte {
+	if p.done() {
+		return 0
+	}
+	return p.str[p.pos]
+}
+
+func (p *intrinsicParser) skipSpace() {
+	for !p.done() && (p.peek() == ' ' || p.peek() == '\t' || p.peek() == '\n') {
+		p.pos++
+	}
+}
+
+func (p *intrinsicParser) parseCall() (*intrinsicCall, error) {
+	p.skipSpace()
+
+	start := p.pos
+	for !p.done() && p.peek() != '(' && p.peek() != ' ' {
+		p.pos++
+	}
+	name := p.str[start:p.pos]
+
+	if _, ok := intrinsicFunctions[name]; !ok {
+		return nil, fmt.Errorf("unknown function %q", name)
+	}
+
+	p.skipSpace()
+	if p.peek() != '(' {
+		return nil, fmt.Errorf("expected ( after %v", name)
+	}
+	p.pos++
+
+	call := &intrinsicCall{name: name}
+
+	p.skipSpace()
+	if p.peek() == ')' {
+		p.pos++
+		return call, nil
+	}
+
+	for {
+		arg, err := p.parseArg()
+		if err != nil {
+			return nil, err
+		}
+		call.args = append(call.args, arg)
+
+		p.skipSpace()
+		switch p.peek() {
+		case ',':
+			p.pos++
+		case ')':
+			p.pos++
+			return call, nil
+		default:
+			return nil, fmt.Errorf("expected , or ) at %v", p.pos)
+		}
+	}
+}
+
+func (p *intrinsicParser) parseArg() (*intrinsicArg, error) {
+	p.skipSpace()
+
+	switch c := p.peek(); {
+	case c == '\'':
+		return p.parseString()
+	case c == '$':
+		return p.parsePath()
+	case c == '-' || (c >= '0' && c <= '9'):
+		return p.parseNumber()
+	case strings.HasPrefix(p.str[p.pos:], "States."):
+		call, err := p.parseCall()
+		if err != nil {
+			return nil, err
+		}
+		return &intrinsicArg{call: call}, nil
+	case strings.HasPrefix(p.str[p.pos:], "true"):
+		p.pos += len("true")
+		return &intrinsicArg{literal: true}, nil
+	case strings.HasPrefix(p.str[p.pos:], "false"):
+		p.pos += len("false")
+		return &intrinsicArg{literal: false}, nil
+	case strings.HasPrefix(p.str[p.pos:], "null"):
+		p.pos += len("null")
+		return &intrinsicArg{literal: nil}, nil
+	}
+
+	return nil, fmt.Errorf("bad argument at %v", p.pos)
+}
+
+// parseString reads a single quoted string, \' and \\ are unescaped
+// \{ and \} are only unescaped in the literal as the raw value is used as a States.Format template
+func (p *intrinsicParser) parseString() (*intrinsicArg, error) {
+	p.pos++ // opening quote
+
+	var raw strings.Builder
+	var literal strings.Builder
+
+	for !p.done() {
+		c := p.peek()
+		p.pos++
+
+		switch c {
+		case '\\':
+			if p.done() {
+				return nil, fmt.Errorf("unterminated escape")
+			}
+			e := p.peek()
+			p.pos++
+			switch e {
+			case '\'', '\\':
+				raw.WriteByte(e)
+			default:
+				raw.WriteByte('\\')
+				raw.WriteByte(e)
+			}
+			literal.WriteByte(e)
+		case '\'':
+			rawStr := raw.String()
+			return &intrinsicArg{literal: literal.String(), raw: &rawStr}, nil
+		default:
+			raw.WriteByte(c)
+			literal.WriteByte(c)
+		}
+	}
+
+	return nil, fmt.Errorf("unterminated string")
+}
+
+// parsePath reads a path until the end of the argument, allowing brackets and quotes in the path
+func (p *intrinsicParser) parsePath() (*intrinsicArg, error) {
+	start := p.pos
+	depth := 0
+	var quote byte
+
+	for !p.done() {
+		c := p.peek()
+
+		if quote != 0 {
+			if c == quote {
+				quote = 0
+			}
+			p.pos++
+			continue
+		}
+
+		switch c {
+		case '\'', '"':
+			quote = c
+		case '[':
+			depth++
+		case ']':
+			depth--
+		case ',', ')':
+			if depth == 0 {
+				path := strings.TrimSpace(p.str[start:p.pos])
+				return &intrinsicArg{path: &path}, nil
+			}
+		}
+		p.pos++
+	}
+
+	return nil, fmt.Errorf("unterminated path")
+}
+
+func (p *intrinsicParser) parseNumber() (*intrinsicArg, error) {
+	start := p.pos
+	for !p.done() && strings.IndexByte("+-.0123456789eE", p.peek()) >= 0 {
+		p.pos++
+	}
+
+	num, err := strconv.ParseFloat(p.str[start:p.pos], 64)
+	if err != nil {
+		return nil, fmt.Errorf("bad number %q", p.str[start:p.pos])
+	}
+
+	return &intrinsicArg{literal: num}, nil
+}
+
+//////
+// Evaluation
+//////
+
+func (c *intrinsicCall) evaluate(resolve pathResolver) (interface{}, error) {
+	args := []*intrinsicValue{}
+
+	for _, arg := range c.args {
+		switch {
+		case arg.call != nil:
+			value, err := arg.call.evaluate(resolve)
+			if err != nil {
+				return nil, err
+			}
+			args = append(args, &intrinsicValue{value: value})
+		case arg.path != nil:
+			value, err := resolve(*arg.path)
+			if err != nil {
+				return nil, fmt.Errorf("%v path %q %v", c.name, *arg.path, err)
+			}
+			args = append(args, &intrinsicValue{value: value})
+		default:
+			args = append(args, &intrinsicValue{value: arg.literal, raw: arg.raw})
+		}
+	}
+
+	value, err := intrinsicFunctions[c.name](args)
+	if err != nil {
+		return nil, fmt.Errorf("%v %v", c.name, err)
+	}
+
+	return value, nil
+}
+
+func argCount(args []*intrinsicValue, min int, max int) error {
+	if len(args) < min || len(args) > max {
+		if min == max {
+			return fmt.Errorf("requires %v arguments, got %v", min, len(args))
+		}
+		return fmt.Errorf("requires %v to %v arguments, got %v", min, max, len(args))
+	}
+	return nil
+}
+
+func argString(args []*intrinsicValue, i int) (string, error) {
+	str, ok := args[i].value.(string)
+	if !ok {
+		return "", fmt.Errorf("argument %v must be a string", i+1)
+	}
+	return str, nil
+}
+
+func argInt(args []*intrinsicValue, i int) (int, error) {
+	num, ok := args[i].value.(float64)
+	if !ok || num != math.Trunc(num) {
+		return 0, fmt.Errorf("argument %v must be an integer", i+1)
+	}
+	return int(num), nil
+}
+
+func argArray(args []*intrinsicValue, i int) ([]interface{}, error) {
+	arr, ok := args[i].value.([]interface{})
+	if !ok {
+		return nil, fmt.Errorf("argument %v must be an array", i+1)
+	}
+	return arr, nil
+}
+
+func argMap(args []*intrinsicValue, i int) (map[string]interface{}, error) {
+	m, ok := args[i].value.(map[string]interface{})
+	if !ok {
+		return nil, fmt.Errorf("argument %v must be an object", i+1)
+	}
+	return m, nil
+}
+
+func intrinsicFormat(args []*intrinsicValue) (interface{}, error) {
+	if len(args) == 0 {
+		return nil, fmt.Errorf("requires a template")
+	}
+
+	template, err := argString(args, 0)
+	if err != nil {
+		return nil, err
+	}
+
+	// Literal templates can escape braces
+	if args[0].raw != nil {
+		template = *args[0].raw
+	}
+
+	var out strings.Builder
+	next := 1
+
+	for i := 0; i < len(template); i++ {
+		c := template[i]
+		switch {
+		case c == '\\' && i+1 < len(template) && (template[i+1] == '{' || template[i+1] == '}'):
+			out.WriteByte(template[i+1])
+			i++
+		case c == '{' && i+1 < len(template) && template[i+1] == '}':
+			if next >= len(args) {
+				return nil, fmt.Errorf("not enough arguments for template")
+			}
+			str, err := formatValue(args[next].value)
+			if err != nil {
+				return nil, fmt.Errorf("argument %v %v", next+1, err)
+			}
+			out.WriteString(str)
+			next++
+			i++
+		default:
+			out.WriteByte(c)
+		}
+	}
+
+	if next != len(args) {
+		return nil, fmt.Errorf("too many arguments for template")
+	}
+
+	return out.String(), nil
+}
+
+func formatValue(value interface{}) (string, error) {
+	switch v := value.(type) {
+	case string:
+		return v, nil
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64), nil
+	case bool:
+		return strconv.FormatBool(v), nil
+	case nil:
+		return "null", nil
+	}
+	return "", fmt.Errorf("must be a string, number, boolean or null")
+}
+
+func intrinsicStringToJSON(args []*intrinsicValue) (interface{}, error) {
+	if err := argCount(args, 1, 1); err != nil {
+		return nil, err
+	}
+
+	str, err := argString(args, 0)
+	if err != nil {
+		return nil, err
+	}
+
+	var value interface{}
+	if err := json.Unmarshal([]byte(str), &value); err != nil {
+		return nil, err
+	}
+
+	return value, nil
+}
+
+func intrinsicJSONToString(args []*intrinsicValue) (interface{}, error) {
+	if err := argCount(args, 1, 1); err != nil {
+		return nil, err
+	}
+
+	var b strings.Builder
+	enc := json.NewEncoder(&b)
+	enc.SetEscapeHTML(false)
+
+	if err := enc.Encode(args[0].value); err != nil {
+		return nil, err
+	}
+
+	return strings.TrimSuffix(b.String(), "\n"), nil
+}
+
+func intrinsicArray(args []*intrinsicValue) (interface{}, error) {
+	arr := []interface{}{}
+	for _, arg := range args {
+		arr = append(arr, arg.value)
+	}
+	return arr, nil
+}
+
+func intrinsicArrayPartition(args []*intrinsicValue) (interface{}, error) {
+	if err := argCount(args, 2, 2); err != nil {
+		return nil, err
+	}
+
+	arr, err := argArray(args, 0)
+	if err != nil {
+		return nil, err
+	}
+
+	size, err := argInt(args, 1)
+	if err != nil {
+		return nil, err
+	}
+
+	if size <= 0 {
+		return nil, fmt.Errorf("chunk size must be positive")
+	}
+
+	chunks := []interface{}{}
+	for i := 0; i < len(arr); i += size {
+		end := i + size
+		if end > len(arr) {
+			end = len(arr)
+		}
+		chunk := make([]interface{}, end-i)
+		copy(chunk, arr[i:end])
+		chunks = append(chunks, chunk)
+	}
+
+	return chunks, nil
+}
+
+func intrinsicArrayContains(args []*intrinsicValue) (interface{}, error) {
+	if err := argCount(args, 2, 2); err != nil {
+		return nil, err
+	}
+
+	arr, err := argArray(args, 0)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, item := range arr {
+		if reflect.DeepEqual(item, args[1].value) {
+			return true, nil
+		}
+	}
+
+	return false, nil
+}
+
+func intrinsicArrayRange(args []*intrinsicValue) (interface{}, error) {
+	if err := argCount(args, 3, 3); err != nil {
+		return nil, err
+	}
+
+	nums := []int{}
+	for i := range args {
+		num, err := argInt(args, i)
+		if err != nil {
+			return nil, err
+		}
+		nums = append(nums, num)
+	}
+
+	start, end, step := nums[0], nums[1], nums[2]
+
+	if step == 0 {
+		return nil, fmt.Errorf("step cannot be 0")
+	}
+
+	arr := []interface{}{}
+	for i := start; (step > 0 && i <= end) || (step < 0 && i >= end); i += step {
+		if len(arr) == 1000 {
+			return nil, fmt.Errorf("range cannot exceed 1000 items")
+		}
+		arr = append(arr, float64(i))
+	}
+
+	return arr, nil
+}
+
+func intrinsicArrayGetItem(args []*intrinsicValue) (interface{}, error) {
+	if err := argCount(args, 2, 2); err != nil {
+		return nil, err
+	}
+
+	arr, err := argArray(args, 0)
+	if err != nil {
+		return nil, err
+	}
+
+	index, err := argInt(args, 1)
+	if err != nil {
+		return nil, err
+	}
+
+	if index < 0 || index >= len(arr) {
+		return nil, fmt.Errorf("index %v out of bounds", index)
+	}
+
+	return arr[index], nil
+}
+
+func intrinsicArrayLength(args []*intrinsicValue) (interface{}, error) {
+	if err := argCount(args, 1, 1); err != nil {
+		return nil, err
+	}
+
+	arr, err := argArray(args, 0)
+	if err != nil {
+		return nil, err
+	}
+
+	return float64(len(arr)), nil
+}
+
+func intrinsicArrayUnique(args []*intrinsicValue) (interface{}, error) {
+	if err := argCount(args, 1, 1); err != nil {
+		return nil, err
+	}
+
+	arr, err := argArray(args, 0)
+	if err != nil {
+		return nil, err
+	}
+
+	unique := []interface{}{}
+	for _, item := range arr {
+		found := false
+		for _, u := range unique {
+			if reflect.DeepEqual(item, u) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			unique = append(unique, item)
+		}
+	}
+
+	return unique, nil
+}
+
+func intrinsicBase64Encode(args []*intrinsicValue) (interface{}, error) {
+	if err := argCount(args, 1, 1); err != nil {
+		return nil, err
+	}
+
+	str, err := argString(args, 0)
+	if err != nil {
+		return nil, err
+	}
+
+	return base64.StdEncoding.EncodeToString([]byte(str)), nil
+}
+
+func intrinsicBase64Decode(args []*intrinsicValue) (interface{}, error) {
+	if err := argCount(args, 1, 1); err != nil {
+		return nil, err
+	}
+
+	str, err := argString(args, 0)
+	if err != nil {
+		return nil, err
+	}
+
+	decoded, err := base64.StdEncoding.DecodeString(str)
+	if err != nil {
+		return nil, err
+	}
+
+	return string(decoded), nil
+}
+
+func intrinsicHash(args []*intrinsicValue) (interface{}, error) {
+	if err := argCount(args, 2, 2); err != nil {
+		return nil, err
+	}
+
+	data, err := formatValue(args[0].value)
+	if err != nil {
+		return nil, fmt.Errorf("argument 1 %v", err)
+	}
+
+	algorithm, err := argString(args, 1)
+	if err != nil {
+		return nil, err
+	}
+
+	var h hash.Hash
+	switch algorithm {
+	case "MD5":
+		h = md5.New()
+	case "SHA-1":
+		h = sha1.New()
+	case "SHA-256":
+		h = sha256.New()
+	case "SHA-384":
+		h = sha512.New384()
+	case "SHA-512":
+		h = sha512.New()
+	default:
+		return nil, fmt.Errorf("unknown algorithm %q", algorithm)
+	}
+
+	h.Write([]byte(data))
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+func intrinsicJSONMerge(args []*intrinsicValue) (interface{}, error) {
+	if err := argCount(args, 3, 3); err != nil {
+		return nil, err
+	}
+
+	left, err := argMap(args, 0)
+	if err != nil {
+		return nil, err
+	}
+
+	right, err := argMap(args, 1)
+	if err != nil {
+		return nil, err
+	}
+
+	deep, ok := args[2].value.(bool)
+	if !ok {
+		return nil, fmt.Errorf("argument 3 must be a boolean")
+	}
+
+	if deep {
+		return nil, fmt.Errorf("only shallow merge is supported")
+	}
+
+	merged := map[string]interface{}{}
+	for k, v := range left {
+		merged[k] = v
+	}
+	for k, v := range right {
+		merged[k] = v
+	}
+
+	return merged, nil
+}
+
+func intrinsicMathRandom(args []*intrinsicValue) (interface{}, error) {
+	if err := argCount(args, 2, 3); err != nil {
+		return nil, err
+	}
+
+	start, err := argInt(args, 0)
+	if err != nil {
+		return nil, err
+	}
+
+	end, err := argInt(args, 1)
+	if err != nil {
+		return nil, err
+	}
+
+	if end <= start {
+		return nil, fmt.Errorf("end must be greater than start")
+	}
+
+	if len(args) == 3 {
+		seed, err := argInt(args, 2)
+		if err != nil {
+			return nil, err
+		}
+		r := mrand.New(mrand.NewSource(int64(seed)))
+		return float64(start + r.Intn(end-start)), nil
+	}
+
+	return float64(start + mrand.Intn(end-start)), nil
+}
+
+func intrinsicMathAdd(args []*intrinsicValue) (interface{}, error) {
+	if err := argCount(args, 2, 2); err != nil {
+		return nil, err
+	}
+
+	a, err := argInt(args, 0)
+	if err != nil {
+		return nil, err
+	}
+
+	b, err := argInt(args, 1)
+	if err != nil {
+		return nil, err
+	}
+
+	return float64(a + b), nil
+}
+
+func intrinsicStringSplit(args []*intrinsicValue) (interface{}, error) {
+	if err := argCount(args, 2, 2); err != nil {
+		return nil, err
+	}
+
+	str, err := argString(args, 0)
+	if err != nil {
+		return nil, err
+	}
+
+	delimiters, err := argString(args, 1)
+	if err != nil {
+		return nil, err
+	}
+
+	// Split on any of the delimiter characters
+	parts := strings.FieldsFunc(str, func(r rune) bool {
+		return strings.ContainsRune(delimiters, r)
+	})
+
+	arr := []interface{}{}
+	for _, part := range parts {
+		arr = append(arr, part)
+	}
+
+	return arr, nil
+}
+
+func intrinsicUUID(args []*intrinsicValue) (interface{}, error) {
+	if err := argCount(args, 0, 0); err != nil {
+		return nil, err
+	}
+
+	return to.UUID(), nil
+}
@@ -0,0 +1,136 @@
+package machine
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+var intrinsicInput = map[string]interface{}{
+	"a":      "x",
+	"b":      float64(2),
+	"arr":    []interface{}{float64(1), float64(2), float64(2), float64(3)},
+	"obj":    map[string]interface{}{"k": "v", "n": float64(1)},
+	"other":  map[string]interface{}{"n": float64(2)},
+	"json":   `{"z": [1, true]}`,
+	"csv":    "a,b;c",
+	"nested": map[string]interface{}{"value": "deep"},
+}
+
+func evaluateTestIntrinsic(str string) (interface{}, error) {
+	return evaluateParamValue(nil, str, intrinsicInput)
+}
+
+func Test_Intrinsic_Functions(t *testing.T) {
+	tests := map[string]interface{}{
+		`States.Format('{}-{}', $.a, $.b)`:                     "x-2",
+		`States.Format('Hello, {}!', $.nested.value)`:          "Hello, deep!",
+		`States.Format('\{{}\}', 'it\'s')`:                     "{it's}",
+		`States.Format('{} {} {}', true, null, 1.5)`:           "true null 1.5",
+		`States.StringToJson($.json)`:                          map[string]interface{}{"z": []interface{}{float64(1), true}},
+		`States.JsonToString($.obj)`:                           `{"k":"v","n":1}`,
+		`States.Array('a', 1, $.a, States.Array())`:            []interface{}{"a", float64(1), "x", []interface{}{}},
+		`States.ArrayPartition($.arr, 3)`:                      []interface{}{[]interface{}{float64(1), float64(2), float64(2)}, []interface{}{float64(3)}},
+		`States.ArrayContains($.arr, 3)`:                       true,
+		`States.ArrayContains($.arr, 4)`:                       false,
+		`States.ArrayRange(1, 9, 3)`:                           []interface{}{float64(1), float64(4), float64(7)},
+		`States.ArrayRange(3, 1, -1)`:                          []interface{}{float64(3), float64(2), float64(1)},
+		`States.ArrayGetItem($.arr, 3)`:                        float64(3),
+		`States.ArrayLength($.arr)`:                            float64(4),
+		`States.ArrayUnique($.arr)`:                            []interface{}{float64(1), float64(2), float64(3)},
+		`States.Base64Encode('hello')`:                         "aGVsbG8=",
+		`States.Base64Decode('aGVsbG8=')`:                      "hello",
+		`States.Hash('hello', 'SHA-256')`:                      "2cf24dba5fb0a30e26e83b2ac5b9e29e1b161e5c1fa7425e73043362938b9824",
+		`States.Hash('hello', 'MD5')`:                          "5d41402abc4b2a76b9719d911017c592",
+		`States.JsonMerge($.obj, $.other, false)`:              map[string]interface{}{"k": "v", "n": float64(2)},
+		`States.MathAdd($.b, -5)`:                              float64(-3),
+		`States.StringSplit($.csv, ',;')`:                      []interface{}{"a", "b", "c"},
+		`States.ArrayLength(States.StringSplit($.csv, ','))`:   float64(2),
+		`States.Format('{}', States.ArrayGetItem($.arr, 0))`:   "1",
+		`  States.Format( '{}' , $.a )  `:                      "x",
+		`States.MathRandom(1, 2)`:                              float64(1),
+		`States.StringToJson(States.JsonToString($.nested))`:   map[string]interface{}{"value": "deep"},
+		`States.ArrayContains(States.Array($.obj), $.obj)`:     true,
+		`States.Format('{}', States.MathAdd(1, $.b))`:          "3",
+		`States.ArrayPartition(States.ArrayRange(1, 4, 1), 2)`: []interface{}{[]interface{}{float64(1), float64(2)}, []interface{}{float64(3), float64(4)}},
+	}
+
+	for str, expected := range tests {
+		value, err := evaluateTestIntrinsic(str)
+		assert.NoError(t, err, str)
+		if expected != nil {
+			assert.Equal(t, expected, value, str)
+		}
+	}
+
+	// Seeded random is deterministic
+	first, _ := evaluateTestIntrinsic(`States.MathRandom(0, 100, 7)`)
+	second, _ := evaluateTestIntrinsic(`States.MathRandom(0, 100, 7)`)
+	assert.Equal(t, first, second)
+
+	uuid, err := evaluateTestIntrinsic(`States.UUID()`)
+	assert.NoError(t, err)
+	assert.Regexp(t, "^[0-9a-f]{8}-[0-9a-f]{4}-4[0-9a-f]{3}-[89ab][0-9a-f]{3}-[0-9a-f]{12}$", uuid)
+}
+
+func Test_Intrinsic_Errors(t *testing.T) {
+	bad := []string{
+		`States.Unknown()`,
+		`States.Format('{}', $.a`,
+		`States.Format('{}')`,
+		`States.Format('{}', $.a, $.b)`,
+		`States.Format('{}', $.obj)`,
+		`States.Format('{}', $.missing)`,
+		`States.Format('unterminated)`,
+		`States.ArrayGetItem($.arr, 10)`,
+		`States.ArrayRange(1, 2, 0)`,
+		`States.ArrayRange(1, 2000, 1)`,
+		`States.ArrayLength($.a)`,
+		`States.MathAdd(1.5, 1)`,
+		`States.JsonMerge($.obj, $.other, true)`,
+		`States.Hash('a', 'SHA-3')`,
+		`States.StringToJson('{bad')`,
+		`States.Base64Decode('%%%')`,
+		`States.UUID(1)`,
+		`States.UUID() trailing`,
+	}
+
+	for _, str := range bad {
+		_, err := evaluateTestIntrinsic(str)
+		assert.Error(t, err, str)
+		if err != nil {
+			assert.Regexp(t, "Intrinsic Error", err.Error(), str)
+		}
+	}
+}
+
+func Test_Intrinsic_Parameters(t *testing.T) {
+	params := map[string]interface{}{
+		"id.$":   "States.Format('{}-{}', $.a, $.b)",
+		"static": "States.Format('not evaluated')",
+		"deep": map[string]interface{}{
+			"len.$": "States.ArrayLength($.arr)",
+		},
+	}
+
+	output, err := replaceParamsJSONPath(nil, params, intrinsicInput)
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]interface{}{
+		"id":     "x-2",
+		"static": "States.Format('not evaluated')",
+		"deep":   map[string]interface{}{"len": float64(4)},
+	}, output)
+}
+
+func Test_TaskState_Parameters_Intrinsic(t *testing.T) {
+	state := parseValidTaskState([]byte(`{
+		"Next": "Pass",
+		"Resource": "test",
+		"Parameters": {"Task": "Noop", "Input.$": "States.Format('{}/{}', $.bucket, $.key)"}
+	}`), ReturnInputHandler, t)
+
+	testState(state, stateTestData{
+		Input:  map[string]interface{}{"bucket": "b", "key": "k"},
+		Output: map[string]interface{}{"Task": "Noop", "Input": "b/k"},
+	}, t)
+}
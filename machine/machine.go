@@ -4,10 +4,11 @@ package machine
 import (
 	"context"
 	"encoding/json"
-	"errors"
 	"fmt"
+	"sort"
 
 	"github.com/aws/aws-lambda-go/lambdacontext"
+	"github.com/cleardataeng/step/aws"
 	"github.com/cleardataeng/step/handler"
 	"github.com/cleardataeng/step/utils/is"
 	"github.com/cleardataeng/step/utils/to"
@@ -35,6 +36,24 @@ type StateMachine struct {
 	StartAt *string
 
 	States States
+
+	// TimeoutSeconds is how long an Execution can run before it times out, no limit if 0
+	TimeoutSeconds int `json:",omitempty"`
+
+	// Clock used by Executions, defaults to a VirtualClock
+	Clock Clock `json:"-"`
+
+	// Hooks observe and intercept Executions
+	Hooks *Hooks `json:"-"`
+
+	// S3Client is used by Distributed Map ItemReaders and ResultWriters
+	S3Client aws.S3API `json:"-"`
+
+	// Resolver routes Tasks without a TaskHandler by their Resource
+	Resolver ResourceResolver `json:"-"`
+
+	// Mocks replace Tasks with the mocked responses of a test case
+	Mocks *Mocks `json:"-"`
 }
 
 // Global Methods
@@ -61,12 +80,25 @@ func (sm *StateMachine) FindTask(name string) (*TaskState, error) {
 	return task, nil
 }
 
+// Tasks returns all Task states including those nested in Parallel Branches and Map Iterators
 func (sm *StateMachine) Tasks() map[string]*TaskState {
 	tasks := map[string]*TaskState{}
 	for name, s := range sm.States {
 		switch s.(type) {
 		case *TaskState:
 			tasks[name] = s.(*TaskState)
+		case *ParallelState:
+			for _, branch := range s.(*ParallelState).Branches {
+				for bname, task := range branch.Tasks() {
+					tasks[bname] = task
+				}
+			}
+		case *MapState:
+			if iterator := s.(*MapState).iterator(); iterator != nil {
+				for iname, task := range iterator.Tasks() {
+					tasks[iname] = task
+				}
+			}
 		}
 	}
 	return tasks
@@ -104,6 +136,26 @@ func (sm *StateMachine) SetTaskFnHandlers(tfs *handler.TaskHandlers) error {
 	return nil
 }
 
+// SetClock sets the Clock Executions use to Wait, Retry and timestamp history
+func (sm *StateMachine) SetClock(clock Clock) {
+	sm.Clock = clock
+}
+
+// SetHooks sets the Hooks called as Executions move through States
+func (sm *StateMachine) SetHooks(hooks *Hooks) {
+	sm.Hooks = hooks
+}
+
+// SetS3Client sets the S3 client Distributed Maps read items from and write results to
+func (sm *StateMachine) SetS3Client(s3c aws.S3API) {
+	sm.S3Client = s3c
+}
+
+// SetResourceResolver sets the ResourceResolver for Tasks without a TaskHandler
+func (sm *StateMachine) SetResourceResolver(resolver ResourceResolver) {
+	sm.Resolver = resolver
+}
+
 func (sm *StateMachine) SetTaskHandler(task_name string, resource_fn interface{}) error {
 	task, err := sm.FindTask(task_name)
 	if err != nil {
@@ -114,40 +166,59 @@ func (sm *StateMachine) SetTaskHandler(task_name string, resource_fn interface{}
 	return nil
 }
 
+// Validate checks every State and the transitions between them,
+// returning ValidationErrors with all problems found
 func (sm *StateMachine) Validate() error {
 	if is.EmptyStr(sm.StartAt) {
-		return errors.New("State Machine requires StartAt")
+		return ValidationErrors{{Field: "StartAt", Message: "State Machine requires StartAt"}}
 	}
 
-	if sm.States == nil {
-		return errors.New("State Machine must have States")
+	if len(sm.States) == 0 {
+		return ValidationErrors{{Field: "States", Message: "State Machine must have States"}}
 	}
 
-	if len(sm.States) == 0 {
-		return errors.New("State Machine must have States")
+	errs := ValidationErrors{}
+
+	if sm.TimeoutSeconds < 0 {
+		errs = append(errs, ValidationError{Field: "TimeoutSeconds", Message: "TimeoutSeconds must be positive"})
 	}
 
-	state_errors := []string{}
+	if _, ok := sm.States[*sm.StartAt]; !ok {
+		errs = append(errs, ValidationError{Field: "StartAt", Message: fmt.Sprintf("Unknown State %q", *sm.StartAt)})
+	}
 
-	for _, state := range sm.States {
-		err := state.Validate()
-		if err != nil {
-			state_errors = append(state_errors, err.Error())
+	names := []string{}
+	for name := range sm.States {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		if err := stateNameValid(name); err != nil {
+			errs = append(errs, ValidationError{name, "Name", err.Error()})
+		}
+
+		if err := sm.States[name].Validate(); err != nil {
+			errs = append(errs, stateValidationError(name, sm.States[name], err))
 		}
 	}
 
-	if len(state_errors) != 0 {
-		return fmt.Errorf("State Errors %q", state_errors)
+	errs = append(errs, sm.graphErrors()...)
+
+	if len(errs) != 0 {
+		return errs
 	}
 
-	// TODO: validate all states are reachable
 	return nil
 }
 
 func (sm *StateMachine) DefaultLambdaContext(lambda_name string) context.Context {
-	return lambdacontext.NewContext(context.Background(), &lambdacontext.LambdaContext{
-		InvokedFunctionArn: fmt.Sprintf("arn:aws:lambda:us-east-1:000000000000:function:%v", lambda_name),
-	})
+	return lambdaContext(context.Background(), lambda_name)
+}
+
+// lambdaContext returns the Lambda context a handler is called with, configured by the ExecutionOptions
+func lambdaContext(ctx context.Context, lambda_name string) context.Context {
+	return lambdacontext.NewContext(ctx, executionOptionsFrom(ctx).Lambda.lambdaContext(lambda_name))
 }
 
 func processInput(input interface{}) (interface{}, error) {
@@ -172,50 +243,239 @@ func processInput(input interface{}) (interface{}, error) {
 }
 
 func (sm *StateMachine) Execute(input interface{}) (*Execution, error) {
+	return sm.ExecuteWithOptions(context.Background(), input, nil)
+}
+
+// ExecuteWithOptions runs an Execution configured by opts, cancelling ctx aborts it like StopExecution.
+// It is a new Execution even if ctx is a Task handler's, e.g. a nested State Machine
+func (sm *StateMachine) ExecuteWithOptions(ctx context.Context, input interface{}, opts *ExecutionOptions) (*Execution, error) {
+	return sm.execute(withExecutionOptions(newExecutionScope(ctx), opts), input)
+}
+
+// clock returns the Clock of the ExecutionOptions or the StateMachine, otherwise the Clock of the parent execution
+func (sm *StateMachine) clock(ctx context.Context) Clock {
+	if opts := executionOptionsFrom(ctx); opts.Clock != nil {
+		return opts.Clock
+	}
+
+	if sm.Clock != nil {
+		return sm.Clock
+	}
+	return clockFrom(ctx)
+}
+
+func (sm *StateMachine) execute(ctx context.Context, input interface{}) (*Execution, error) {
 	if err := sm.Validate(); err != nil {
 		return nil, err
 	}
 
-	input, err := processInput(input)
+	return sm.executeValid(ctx, input)
+}
+
+// executeValid runs an Execution of a StateMachine that has already been validated,
+// e.g. a Map Iterator validated once by its MapState instead of for every item
+func (sm *StateMachine) executeValid(ctx context.Context, input interface{}) (*Execution, error) {
+	ctx, exec, input, err := sm.startExecution(ctx, input)
 	if err != nil {
 		return nil, err
 	}
 
+	return exec, sm.runExecution(ctx, exec, input)
+}
+
+// Start validates the input and runs the Execution in the background,
+// so callback Tasks can be completed while it runs. Wait returns when it completes
+func (sm *StateMachine) Start(input interface{}) (*Execution, error) {
+	return sm.StartWithOptions(context.Background(), input, nil)
+}
+
+// StartWithOptions is Start for an Execution configured by opts, cancelling ctx aborts it like Stop
+func (sm *StateMachine) StartWithOptions(ctx context.Context, input interface{}, opts *ExecutionOptions) (*Execution, error) {
+	if err := sm.Validate(); err != nil {
+		return nil, err
+	}
+
+	ctx, exec, input, err := sm.startExecution(withExecutionOptions(newExecutionScope(ctx), opts), input)
+	if err != nil {
+		return nil, err
+	}
+
+	ctx, exec.cancel = context.WithCancel(ctx)
+	exec.done = make(chan struct{})
+	go func() {
+		defer close(exec.done)
+		defer exec.cancel()
+		sm.runExecution(ctx, exec, input)
+	}()
+
+	return exec, nil
+}
+
+// startExecution validates the input, returning a new Execution and its context
+func (sm *StateMachine) startExecution(ctx context.Context, input interface{}) (context.Context, *Execution, interface{}, error) {
+	input, err := processInput(input)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+
+	if sm.Hooks != nil {
+		ctx = withHooks(ctx, sm.Hooks)
+	}
+
+	if sm.S3Client != nil {
+		ctx = withS3Client(ctx, sm.S3Client)
+	}
+
+	if sm.Resolver != nil {
+		ctx = withResolver(ctx, sm.Resolver)
+	}
+
+	if sm.Mocks != nil {
+		ctx = withMocks(ctx, sm.Mocks)
+	}
+
 	// Start Execution (records the history, inputs, outputs...)
-	exec := &Execution{}
-	exec.Start()
+	// inline Map Iterations record into their parents history
+	exec := &Execution{clock: sm.clock(ctx)}
+	exec.nest(ctx)
+
+	// Parallel Branches and inline Map Iterations are part of their parents Execution
+	ec := executionContextFrom(ctx)
+	if ec == nil {
+		exec.options = sm.executionOptions(executionOptionsFrom(ctx), exec.now())
+		ctx = withExecutionOptions(ctx, exec.options)
+
+		id := exec.options.ID
+		if id == "" {
+			id = exec.options.executionArn(exec.options.Name)
+		}
+		ec = newContextExecution(id, exec.options.Name, exec.now(), input)
+		ctx = withExecutionContext(ctx, ec)
+	}
+
+	if !exec.nested() {
+		exec.started(input, executionOptionsFrom(ctx))
+	}
+	exec.ID, exec.Name, exec.tokens = ec.id, ec.name, ec.tokens
+
+	return ctx, exec, input, nil
+}
+
+func (sm *StateMachine) runExecution(ctx context.Context, exec *Execution, input interface{}) error {
+	runCtx := ctx
+	if exec.options != nil && exec.options.TimeoutSeconds > 0 {
+		var cancel context.CancelFunc
+		runCtx, cancel = context.WithTimeout(ctx, exec.options.timeout())
+		defer cancel()
+	}
 
 	// Execute Start State
-	output, err := sm.stateLoop(exec, sm.StartAt, input)
+	output, err := sm.stateLoop(withClock(runCtx, exec.clock), exec, sm.StartAt, input)
+
+	// Cancelling the context of an Execution aborts it, Branches and Iterations are cancelled by their parent
+	if err != nil && exec.options != nil && ctx.Err() != nil {
+		exec.stop("", ctx.Err().Error())
+	}
+
+	// A stopped Execution ends with its stop error instead of the cancellation
+	stopped := exec.stopError()
+	if err != nil && stopped != nil {
+		err = stopped
+	}
+
+	// An Execution past its TimeoutSeconds times out even if its last State succeeded
+	timedOut := stopped == nil && exec.options != nil &&
+		(runCtx.Err() == context.DeadlineExceeded || exec.options.timedOut(exec.now()))
+	if timedOut {
+		output, err = nil, exec.options.timeoutError()
+	}
 
 	// Set Final Output
 	exec.SetOutput(output, err)
 
-	if err != nil {
-		exec.Failed()
-	} else {
-		exec.Succeeded()
+	switch {
+	case err != nil && stopped != nil:
+		exec.aborted(stopped)
+	case timedOut:
+		exec.timedOut(err)
+	case err != nil:
+		exec.failed(err)
+	default:
+		exec.succeeded(output)
 	}
 
-	return exec, err
+	return err
 }
 
-func (sm *StateMachine) stateLoop(exec *Execution, next *string, input interface{}) (output interface{}, err error) {
+// executeBranch runs a nested state machine (e.g. a Parallel Branch) returning its raw output
+func (sm *StateMachine) executeBranch(ctx context.Context, input interface{}) (interface{}, error) {
+	exec := &Execution{clock: sm.clock(ctx)}
+	exec.nest(ctx)
+
+	return sm.stateLoop(withClock(ctx, exec.clock), exec, sm.StartAt, input)
+}
+
+func (sm *StateMachine) stateLoop(ctx context.Context, exec *Execution, next *string, input interface{}) (output interface{}, err error) {
+	ctx = withExecution(ctx, exec)
+
 	// Flat loop instead of recursion to better implement timeouts
 	for {
+		// Stop if the execution has been cancelled e.g. a sibling Branch failed
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+
+		// Stop if a hook aborted the execution e.g. in another Branch
+		if err := hooksFrom(ctx).abortedErr(); err != nil {
+			return nil, err
+		}
+
 		s, ok := sm.States[*next]
 
 		if !ok {
-			return nil, fmt.Errorf("Unknown State: %v", *next)
+			return nil, &statesError{"States.Runtime", fmt.Sprintf("Unknown State: %v", *next)}
 		}
 
-		if len(exec.ExecutionHistory) > 250 {
-			return nil, fmt.Errorf("State Overflow")
+		// Entering a State records its StateEntered and StateExited events
+		opts := executionOptionsFrom(ctx)
+		if exec.stateEvents+2 > opts.maxStateEvents() {
+			return nil, &statesError{"States.Runtime", fmt.Sprintf("Execution exceeded %v State events", opts.maxStateEvents())}
+		}
+
+		// Stop once the Execution has run past its TimeoutSeconds, e.g. after a Wait on a VirtualClock
+		if opts.timedOut(exec.now()) {
+			return nil, opts.timeoutError()
 		}
 
 		exec.EnteredEvent(s, input)
 
-		output, next, err = s.Execute(sm.DefaultLambdaContext(*s.Name()), input)
+		stateCtx := withCurrentState(lambdaContext(ctx, *s.Name()), s)
+		stateCtx = withStateContext(stateCtx, &contextState{*s.Name(), exec.now(), 0})
+		hooks := hooksFrom(ctx)
+
+		event := newStateEvent(s, input)
+		if err := hooks.beforeState(stateCtx, event); err != nil {
+			return nil, err
+		}
+
+		output, next, err = s.Execute(stateCtx, event.Input)
+
+		// A hook aborted the Execution from inside the State
+		if err := hooks.abortedErr(); err != nil {
+			return nil, err
+		}
+
+		event.Output, event.Next, event.Error = output, next, err
+		if err != nil {
+			if err := hooks.onError(stateCtx, event); err != nil {
+				return nil, err
+			}
+		} else {
+			if err := hooks.afterState(stateCtx, event); err != nil {
+				return nil, err
+			}
+		}
+		output, next, err = event.Output, event.Next, event.Error
 
 		if *s.GetType() != "Fail" {
 			// Failure States Dont exit.
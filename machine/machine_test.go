@@ -1,9 +1,14 @@
 package machine
 
 import (
+	"context"
 	"encoding/json"
+	"fmt"
 	"io/ioutil"
+	"strings"
+	"sync"
 	"testing"
+	"time"
 
 	"github.com/cleardataeng/step/utils/to"
 	"github.com/stretchr/testify/assert"
@@ -95,3 +100,347 @@ func Test_Machine_MarshallAllTypes(t *testing.T) {
 
 	assert.JSONEq(t, string(raw_json), string(marshalled_json))
 }
+
+func Test_Machine_VirtualClock_RetryBackoff(t *testing.T) {
+	sm, err := FromJSON([]byte(`{
+		"StartAt": "Task",
+		"States": {
+			"Task": {
+				"Type": "Task",
+				"Resource": "test",
+				"Retry": [{
+					"ErrorEquals": ["States.ALL"],
+					"IntervalSeconds": 10,
+					"BackoffRate": 2,
+					"MaxAttempts": 3
+				}],
+				"End": true
+			}
+		}
+	}`))
+	assert.NoError(t, err)
+
+	calls := 0
+	err = sm.SetTaskHandler("Task", func(_ context.Context, input interface{}) (interface{}, error) {
+		calls++
+		if calls <= 3 {
+			return nil, &TestError{}
+		}
+		return input, nil
+	})
+	assert.NoError(t, err)
+
+	start := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	sm.SetClock(NewVirtualClock(start))
+
+	exec, err := sm.Execute(map[string]interface{}{})
+	assert.NoError(t, err)
+	assert.Equal(t, 4, calls)
+
+	// Waits of 10 + 20 + 40 seconds
+	first := exec.ExecutionHistory[0]
+	last := exec.ExecutionHistory[len(exec.ExecutionHistory)-1]
+	assert.Equal(t, start, *first.Timestamp)
+	assert.Equal(t, 70*time.Second, last.Timestamp.Sub(*first.Timestamp))
+}
+
+func Test_Machine_VirtualClock_Wait(t *testing.T) {
+	sm, err := FromJSON([]byte(`{
+		"StartAt": "Wait",
+		"States": {
+			"Wait": { "Type": "Wait", "Seconds": 3600, "End": true }
+		}
+	}`))
+	assert.NoError(t, err)
+
+	start := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	sm.SetClock(NewVirtualClock(start))
+
+	exec, err := sm.Execute(map[string]interface{}{})
+	assert.NoError(t, err)
+
+	assert.Equal(t, "WaitStateEntered", *exec.ExecutionHistory[1].Type)
+	assert.Equal(t, start, *exec.ExecutionHistory[1].Timestamp)
+	assert.Equal(t, "WaitStateExited", *exec.ExecutionHistory[2].Type)
+	assert.Equal(t, start.Add(time.Hour), *exec.ExecutionHistory[2].Timestamp)
+}
+
+var retryMachine = []byte(`{
+	"StartAt": "Task",
+	"States": {
+		"Task": {
+			"Type": "Task",
+			"Resource": "test",
+			"Retry": [{ "ErrorEquals": ["States.ALL"], "MaxAttempts": 2 }],
+			"End": true
+		}
+	}
+}`)
+
+func Test_Machine_Retry_IndependentExecutions(t *testing.T) {
+	sm, err := FromJSON(retryMachine)
+	assert.NoError(t, err)
+
+	th, calls := countCalls(ThrowTestErrorHandler)
+	assert.NoError(t, sm.SetTaskHandler("Task", th))
+
+	_, err = sm.Execute(map[string]interface{}{})
+	assert.Error(t, err)
+	assert.Equal(t, 3, *calls)
+
+	// Second execution of the same definition retries again
+	_, err = sm.Execute(map[string]interface{}{})
+	assert.Error(t, err)
+	assert.Equal(t, 6, *calls)
+}
+
+func Test_Machine_Retry_ConcurrentExecutions(t *testing.T) {
+	sm, err := FromJSON(retryMachine)
+	assert.NoError(t, err)
+
+	// Each execution fails twice before succeeding
+	var mu sync.Mutex
+	attempts := map[string]int{}
+	err = sm.SetTaskHandler("Task", func(_ context.Context, input map[string]interface{}) (interface{}, error) {
+		id := input["id"].(string)
+
+		mu.Lock()
+		attempts[id]++
+		count := attempts[id]
+		mu.Unlock()
+
+		if count <= 2 {
+			return nil, &TestError{}
+		}
+		return input, nil
+	})
+	assert.NoError(t, err)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func(id string) {
+			defer wg.Done()
+
+			exec, err := sm.Execute(map[string]interface{}{"id": id})
+			assert.NoError(t, err)
+			assert.Equal(t, []string{"Task"}, exec.Path())
+		}(fmt.Sprintf("exec-%v", i))
+	}
+	wg.Wait()
+
+	for _, count := range attempts {
+		assert.Equal(t, 3, count)
+	}
+	assert.Equal(t, 10, len(attempts))
+}
+
+func Test_Machine_Retry_ResetOnTransition(t *testing.T) {
+	sm, err := FromJSON([]byte(`{
+		"StartAt": "Task",
+		"States": {
+			"Task": {
+				"Type": "Task",
+				"Resource": "test",
+				"Retry": [{ "ErrorEquals": ["States.ALL"], "MaxAttempts": 1 }],
+				"Next": "Loop"
+			},
+			"Loop": {
+				"Type": "Choice",
+				"Choices": [{ "Variable": "$.done", "BooleanEquals": true, "Next": "Done" }],
+				"Default": "Task"
+			},
+			"Done": { "Type": "Succeed" }
+		}
+	}`))
+	assert.NoError(t, err)
+
+	// Every visit fails once then succeeds, second success ends the loop
+	calls := 0
+	err = sm.SetTaskHandler("Task", func(_ context.Context, input interface{}) (interface{}, error) {
+		calls++
+		if calls%2 == 1 {
+			return nil, &TestError{}
+		}
+		return map[string]interface{}{"done": calls == 4}, nil
+	})
+	assert.NoError(t, err)
+
+	exec, err := sm.Execute(map[string]interface{}{})
+	assert.NoError(t, err)
+	assert.Equal(t, 4, calls)
+	assert.Equal(t, []string{"Task", "Loop", "Task", "Loop", "Done"}, exec.Path())
+}
+
+func Test_Machine_Validate_Graph(t *testing.T) {
+	sm, err := FromJSON([]byte(`{
+		"StartAt": "Start",
+		"States": {
+			"Start": {"Type": "Task", "Resource": "a", "Next": "Choice", "Catch": [{"ErrorEquals": ["States.ALL"], "Next": "Missing"}]},
+			"Choice": {"Type": "Choice", "Choices": [{"Variable": "$.a", "IsPresent": true, "Next": "LoopA"}]},
+			"LoopA": {"Type": "Pass", "Next": "LoopB"},
+			"LoopB": {"Type": "Wait", "Seconds": 1, "Next": "LoopA"},
+			"Orphan": {"Type": "Succeed"},
+			"Bad\nName": {"Type": "Fail", "Error": "E"}
+		}
+	}`))
+	assert.NoError(t, err)
+
+	err = sm.Validate()
+	assert.Error(t, err)
+
+	verrs, ok := err.(ValidationErrors)
+	assert.True(t, ok)
+
+	assert.Equal(t, ValidationErrors{
+		{"Bad\nName", "Name", `State name contains control character '\n'`},
+		{"Start", "Catch[0].Next", `Unknown State "Missing"`},
+		{"Bad\nName", "", "State is unreachable from StartAt"},
+		{"Orphan", "", "State is unreachable from StartAt"},
+		{"Choice", "", "State can never reach an End, Succeed or Fail State"},
+		{"LoopA", "", "State can never reach an End, Succeed or Fail State"},
+		{"LoopB", "", "State can never reach an End, Succeed or Fail State"},
+		{"Start", "", "State can never reach an End, Succeed or Fail State"},
+	}, verrs)
+
+	assert.Regexp(t, `Start.Catch\[0\].Next: Unknown State \\"Missing\\"`, err.Error())
+
+	// A missing Default is only a warning, States.NoChoiceMatched is raised at runtime
+	assert.Equal(t, ValidationErrors{{"Choice", "Default", "Choice State has no Default"}}, sm.Warnings())
+}
+
+func Test_Machine_Validate_Choice_Without_Default(t *testing.T) {
+	sm, err := FromJSON([]byte(`{
+		"StartAt": "Choice",
+		"States": {
+			"Choice": {"Type": "Choice", "Choices": [{"Variable": "$.a", "IsPresent": true, "Next": "Done"}]},
+			"Done": {"Type": "Succeed"}
+		}
+	}`))
+	assert.NoError(t, err)
+	assert.NoError(t, sm.Validate())
+	assert.Equal(t, 1, len(sm.Warnings()))
+
+	exec, err := sm.Execute(map[string]interface{}{})
+	assert.Error(t, err)
+	assert.Equal(t, "States.NoChoiceMatched", exec.ErrorName)
+}
+
+func Test_Machine_Validate_State_Fields(t *testing.T) {
+	sm, err := FromJSON([]byte(`{
+		"StartAt": "Task",
+		"States": {
+			"Task": {"Type": "Task", "Resource": "a", "TimeoutSeconds": -1, "Next": "Wait"},
+			"Wait": {"Type": "Wait", "Seconds": 1, "SecondsPath": "$.s", "Next": "Parallel"},
+			"Parallel": {"Type": "Parallel", "Branches": [{"StartAt": "A", "States": {"A": {"Type": "Pass"}}}], "End": true}
+		}
+	}`))
+	assert.NoError(t, err)
+
+	verrs, ok := sm.Validate().(ValidationErrors)
+	assert.True(t, ok)
+	assert.Equal(t, 3, len(verrs))
+
+	assert.Equal(t, ValidationError{"Task", "TimeoutSeconds", "TimeoutSeconds must be positive"}, verrs[1])
+	assert.Equal(t, ValidationError{"Wait", "Seconds", "Exactly One (Seconds,SecondsPath,TimeStamp,TimeStampPath)"}, verrs[2])
+
+	assert.Equal(t, "Parallel", verrs[0].State)
+	assert.Equal(t, "Branches[0]", verrs[0].Field)
+	assert.Regexp(t, `^State Machine Errors \["A.Next: End and Next both undefined"`, verrs[0].Message)
+
+	// The State is named once, not again in the message
+	assert.Equal(t, "Task.TimeoutSeconds: TimeoutSeconds must be positive", verrs[1].Error())
+}
+
+func Test_Machine_Warnings_Nested(t *testing.T) {
+	sm, err := FromJSON([]byte(`{
+		"StartAt": "Parallel",
+		"States": {
+			"Parallel": {
+				"Type": "Parallel",
+				"Branches": [
+					{"StartAt": "P", "States": {"P": {"Type": "Pass", "End": true}}},
+					{"StartAt": "Choice", "States": {
+						"Choice": {"Type": "Choice", "Choices": [{"Variable": "$.a", "IsPresent": true, "Next": "Done"}]},
+						"Done": {"Type": "Succeed"}
+					}}
+				],
+				"Next": "Map"
+			},
+			"Map": {
+				"Type": "Map",
+				"ItemProcessor": {"StartAt": "Choice", "States": {
+					"Choice": {"Type": "Choice", "Choices": [{"Variable": "$.a", "IsPresent": true, "Next": "Done"}]},
+					"Done": {"Type": "Succeed"}
+				}},
+				"End": true
+			}
+		}
+	}`))
+	assert.NoError(t, err)
+	assert.NoError(t, sm.Validate())
+
+	assert.Equal(t, ValidationErrors{
+		{"Map", "ItemProcessor.States.Choice.Default", "Choice State has no Default"},
+		{"Parallel", "Branches[1].States.Choice.Default", "Choice State has no Default"},
+	}, sm.Warnings())
+}
+
+func Test_Machine_Validate_StartAtAndNames(t *testing.T) {
+	sm := &StateMachine{}
+	assert.Equal(t, ValidationErrors{{Field: "StartAt", Message: "State Machine requires StartAt"}}, sm.Validate())
+
+	sm.StartAt = to.Strp("Start")
+	assert.Equal(t, ValidationErrors{{Field: "States", Message: "State Machine must have States"}}, sm.Validate())
+
+	long := strings.Repeat("a", 81)
+	sm, err := FromJSON([]byte(fmt.Sprintf(`{
+		"StartAt": "Start",
+		"States": {"%v": {"Type": "Succeed"}}
+	}`, long)))
+	assert.NoError(t, err)
+
+	err = sm.Validate()
+	assert.Equal(t, ValidationErrors{
+		{Field: "StartAt", Message: `Unknown State "Start"`},
+		{long, "Name", "State name must be at most 80 characters"},
+		{long, "", "State is unreachable from StartAt"},
+	}, err)
+}
+
+func Test_Machine_Stop(t *testing.T) {
+	sm := callbackMachine(t, `{
+    "Type": "Task",
+    "Resource": "arn:aws:states:::lambda:invoke.waitForTaskToken",
+    "Catch": [{"ErrorEquals": ["States.ALL"], "Next": "Rejected"}],
+    "End": true
+  }`)
+
+	exec, err := sm.Start(map[string]interface{}{})
+	assert.NoError(t, err)
+	waitingToken(t, exec)
+
+	assert.NoError(t, exec.Stop("Stopped", "by test"))
+
+	err = exec.Wait()
+	assert.Error(t, err)
+	assert.Equal(t, "Stopped: by test", err.Error())
+	assert.True(t, exec.Aborted())
+
+	last := exec.ExecutionHistory[len(exec.ExecutionHistory)-1]
+	assert.Equal(t, "ExecutionAborted", *last.Type)
+	assert.Equal(t, "Stopped", *last.ExecutionAbortedEventDetails.Error)
+	assert.Equal(t, "by test", *last.ExecutionAbortedEventDetails.Cause)
+
+	// Completed Executions cannot be stopped
+	assert.Error(t, exec.Stop("", ""))
+
+	// Only Started Executions can be stopped
+	sm, err = FromJSON([]byte(`{"StartAt": "Pass", "States": {"Pass": {"Type": "Pass", "End": true}}}`))
+	assert.NoError(t, err)
+
+	exec, err = sm.Execute(map[string]interface{}{})
+	assert.NoError(t, err)
+	assert.False(t, exec.Aborted())
+	assert.Error(t, exec.Stop("", ""))
+}
@@ -2,9 +2,11 @@ package machine
 
 import (
 	"context"
+	"encoding/json"
 	"fmt"
+	"sync"
+
 	"github.com/cleardataeng/step/jsonpath"
-	"github.com/cleardataeng/step/utils/to"
 )
 
 type MapState struct {
@@ -13,16 +15,27 @@ type MapState struct {
 	Type    *string
 	Comment *string `json:",omitempty"`
 
-	Iterator   *StateMachine
-	ItemsPath  *jsonpath.Path `json:",omitempty"`
-	Parameters interface{}    `json:",omitempty"`
+	Iterator      *StateMachine  `json:",omitempty"` // Legacy ItemProcessor
+	ItemProcessor *ItemProcessor `json:",omitempty"`
+
+	ItemReader   *ItemReader    `json:",omitempty"`
+	ResultWriter *ResultWriter  `json:",omitempty"`
+	ItemsPath    *jsonpath.Path `json:",omitempty"`
+	ItemSelector interface{}    `json:",omitempty"`
+	Parameters   interface{}    `json:",omitempty"` // Legacy ItemSelector
+	ItemBatcher  *ItemBatcher   `json:",omitempty"`
 
 	MaxConcurrency *float64 `json:",omitempty"`
 
+	ToleratedFailureCount      *int     `json:",omitempty"`
+	ToleratedFailurePercentage *float64 `json:",omitempty"`
+
 	InputPath  *jsonpath.Path `json:",omitempty"`
 	OutputPath *jsonpath.Path `json:",omitempty"`
 	ResultPath *jsonpath.Path `json:",omitempty"`
 
+	ResultSelector interface{} `json:",omitempty"`
+
 	Catch []*Catcher `json:",omitempty"`
 	Retry []*Retrier `json:",omitempty"`
 
@@ -30,35 +43,362 @@ type MapState struct {
 	End  *bool   `json:",omitempty"`
 }
 
+// ItemBatcher groups items so each Iterator execution gets {"BatchInput": ..., "Items": [...]}
+type ItemBatcher struct {
+	MaxItemsPerBatch          *int           `json:",omitempty"`
+	MaxItemsPerBatchPath      *jsonpath.Path `json:",omitempty"`
+	MaxInputBytesPerBatch     *int           `json:",omitempty"`
+	MaxInputBytesPerBatchPath *jsonpath.Path `json:",omitempty"`
+
+	BatchInput interface{} `json:",omitempty"`
+}
+
 func (s *MapState) process(ctx context.Context, input interface{}) (interface{}, *string, error) {
-	output, err := s.ItemsPath.GetSlice(input)
+	if ctx == nil {
+		ctx = context.Background()
+	}
+
+	items, err := s.items(ctx, input)
 	if err != nil {
 		return input, nextState(s.Next, s.End), err
 	}
-	var res []map[string]interface{}
 
-	for _, item := range output {
-		execution, err := s.Iterator.Execute(item)
+	items, err = s.selectItems(ctx, input, items)
+	if err != nil {
+		return input, nextState(s.Next, s.End), err
+	}
+
+	items, err = s.ItemBatcher.batch(ctx, input, items)
+	if err != nil {
+		return input, nextState(s.Next, s.End), err
+	}
+
+	var run *mapRun
+	if s.distributed() {
+		run = newMapRun(ctx, s)
+	}
+
+	exec := executionFrom(ctx)
+	exec.mapStateStarted(len(items))
+
+	runs, err := s.iterate(ctx, run, items)
+
+	// err is the final error of the Map, including writing its results
+	exec.followLastEvent()
+	defer func() { exec.mapStateEnded(err) }()
+
+	if s.ResultWriter != nil && ctx.Err() == nil {
+		// Results are written even when the Map Run fails
+		output, writeErr := s.writeResults(ctx, input, run, runs)
+		if err == nil {
+			err = writeErr
+		}
 		if err != nil {
 			return input, nextState(s.Next, s.End), err
 		}
-		res = append(res, execution.Output)
+		return output, nextState(s.Next, s.End), nil
 	}
 
-	return res, nextState(s.Next, s.End), nil
+	if err != nil {
+		return input, nextState(s.Next, s.End), err
+	}
+
+	return outputs(runs), nextState(s.Next, s.End), nil
+}
+
+// items returns the items from the ItemReader, otherwise from the ItemsPath of the input
+func (s *MapState) items(ctx context.Context, input interface{}) ([]interface{}, error) {
+	if s.ItemReader != nil {
+		return s.ItemReader.read(ctx, input)
+	}
+	return s.ItemsPath.GetSlice(input)
+}
+
+// itemRun is the Execution of an item, execution is nil if the item never started
+type itemRun struct {
+	input     interface{}
+	execution *Execution
+	err       error
+}
+
+// outputs returns the Execution outputs in order, the error output for failed items
+func outputs(runs []*itemRun) []map[string]interface{} {
+	res := make([]map[string]interface{}, len(runs))
+	for i, r := range runs {
+		switch {
+		case r.err != nil:
+			res[i] = errorOutputFromError(r.err)
+		case r.execution != nil:
+			res[i] = r.execution.Output
+		}
+	}
+	return res
+}
+
+// selectItems applies the ItemSelector (or legacy Parameters) to each item,
+// where $$.Map.Item.Index and $$.Map.Item.Value are the item
+func (s *MapState) selectItems(ctx context.Context, input interface{}, items []interface{}) ([]interface{}, error) {
+	selector := s.ItemSelector
+	if selector == nil {
+		selector = s.Parameters
+	}
+
+	if selector == nil {
+		return items, nil
+	}
+
+	selected := make([]interface{}, len(items))
+	for i, item := range items {
+		value, err := replaceParamsJSONPath(withMapItem(ctx, i, item), selector, input)
+		if err != nil {
+			return nil, err
+		}
+		selected[i] = value
+	}
+
+	return selected, nil
+}
+
+// iterate executes the Iterator for every item, at most MaxConcurrency at a time, keeping results in order
+// each item is a child Execution of the Distributed Map run, if run is not nil
+func (s *MapState) iterate(parent context.Context, run *mapRun, items []interface{}) ([]*itemRun, error) {
+	// Exceeding the tolerated failures cancels the remaining items
+	ctx, cancel := context.WithCancel(parent)
+	defer cancel()
+
+	runs := make([]*itemRun, len(items))
+	for i, item := range items {
+		runs[i] = &itemRun{input: item}
+	}
+
+	iterator := s.iterator()
+	workers := make(chan struct{}, s.maxConcurrency(len(items)))
+
+	// Inline Iterations record their events following MapStateStarted
+	exec := executionFrom(parent)
+	var started int64
+	if exec != nil {
+		started = exec.lastEventID
+	}
+
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	var failed int
+	var firstErr error
+
+	for i, item := range items {
+		select {
+		case workers <- struct{}{}:
+		case <-ctx.Done():
+		}
+
+		if ctx.Err() != nil {
+			break
+		}
+
+		wg.Add(1)
+		go func(i int, item interface{}) {
+			defer wg.Done()
+			defer func() { <-workers }()
+
+			itemCtx := ctx
+			var iteration int64
+			if run != nil {
+				itemCtx = run.childContext(ctx, i, item)
+			} else {
+				iteration = exec.mapIterationEvent(started, "Started", s.Name(), i)
+				itemCtx = withPreviousEvent(ctx, iteration)
+			}
+
+			// The Iterator is validated by MapState.Validate, not for every item
+			execution, err := iterator.executeValid(itemCtx, item)
+
+			if run == nil {
+				s.iterationEnded(ctx, exec, iteration, execution, err, i)
+			}
+
+			mu.Lock()
+			defer mu.Unlock()
+
+			if err == nil {
+				runs[i].execution = execution
+				return
+			}
+
+			if firstErr != nil {
+				return // cancelled by an earlier failure
+			}
+
+			failed++
+			runs[i].execution, runs[i].err = execution, err
+
+			if s.toleratedFailureExceeded(failed, len(items)) {
+				firstErr = s.failureError(err, failed, len(items))
+				cancel()
+			}
+		}(i, item)
+	}
+
+	wg.Wait()
+
+	if err := parent.Err(); err != nil {
+		return nil, err
+	}
+
+	return runs, firstErr
+}
+
+// iterationEnded records the end of an inline Iteration following its last event
+func (s *MapState) iterationEnded(ctx context.Context, exec *Execution, iteration int64, execution *Execution, err error, index int) {
+	last := iteration
+	if execution != nil {
+		last = execution.lastEventID
+	}
+
+	status := "Succeeded"
+	if err != nil {
+		status = "Failed"
+		if ctx.Err() != nil {
+			status = "Aborted"
+		}
+	}
+
+	exec.mapIterationEvent(last, status, s.Name(), index)
+}
+
+// maxConcurrency of 0 or undefined means no limit
+func (s *MapState) maxConcurrency(items int) int {
+	if s.MaxConcurrency == nil || *s.MaxConcurrency < 1 || int(*s.MaxConcurrency) > items {
+		if items == 0 {
+			return 1
+		}
+		return items
+	}
+	return int(*s.MaxConcurrency)
+}
+
+func (s *MapState) toleratesFailures() bool {
+	return s.ToleratedFailureCount != nil || s.ToleratedFailurePercentage != nil
+}
+
+// toleratedFailureExceeded is true if either threshold is exceeded, by default no failures are tolerated
+func (s *MapState) toleratedFailureExceeded(failed int, total int) bool {
+	if !s.toleratesFailures() {
+		return failed > 0
+	}
+
+	if s.ToleratedFailureCount != nil && failed > *s.ToleratedFailureCount {
+		return true
+	}
+
+	if s.ToleratedFailurePercentage != nil && float64(failed)*100/float64(total) > *s.ToleratedFailurePercentage {
+		return true
+	}
+
+	return false
+}
+
+// failureError is the error the Map fails with, a Distributed Map always fails with States.ExceedToleratedFailureThreshold
+func (s *MapState) failureError(err error, failed int, total int) error {
+	if !s.toleratesFailures() && !s.distributed() {
+		return err
+	}
+
+	return &statesError{
+		"States.ExceedToleratedFailureThreshold",
+		fmt.Sprintf("%v of %v items failed, last error: %v", failed, total, err),
+	}
+}
+
+// batch groups the items, a nil ItemBatcher does not batch
+func (b *ItemBatcher) batch(ctx context.Context, input interface{}, items []interface{}) ([]interface{}, error) {
+	if b == nil {
+		return items, nil
+	}
+
+	maxItems, err := batcherLimit(b.MaxItemsPerBatch, b.MaxItemsPerBatchPath, input)
+	if err != nil {
+		return nil, fmt.Errorf("ItemBatcher MaxItemsPerBatchPath %v", err)
+	}
+
+	maxBytes, err := batcherLimit(b.MaxInputBytesPerBatch, b.MaxInputBytesPerBatchPath, input)
+	if err != nil {
+		return nil, fmt.Errorf("ItemBatcher MaxInputBytesPerBatchPath %v", err)
+	}
+
+	batchInput, err := replaceParamsJSONPath(ctx, b.BatchInput, input)
+	if err != nil {
+		return nil, err
+	}
+
+	batches := []interface{}{}
+	current := []interface{}{}
+	size := 0
+
+	flush := func() {
+		if len(current) == 0 {
+			return
+		}
+
+		batch := map[string]interface{}{"Items": current}
+		if batchInput != nil {
+			batch["BatchInput"] = batchInput
+		}
+
+		batches = append(batches, batch)
+		current = []interface{}{}
+		size = 0
+	}
+
+	for _, item := range items {
+		raw, err := json.Marshal(item)
+		if err != nil {
+			return nil, err
+		}
+
+		if maxBytes > 0 && len(raw) > maxBytes {
+			return nil, fmt.Errorf("ItemBatcher item of %v bytes is larger than MaxInputBytesPerBatch %v", len(raw), maxBytes)
+		}
+
+		if (maxItems > 0 && len(current) == maxItems) || (maxBytes > 0 && size+len(raw) > maxBytes) {
+			flush()
+		}
+
+		current = append(current, item)
+		size += len(raw)
+	}
+
+	flush()
+
+	return batches, nil
+}
+
+// batcherLimit returns the limit from the value or path, 0 is no limit
+func batcherLimit(value *int, path *jsonpath.Path, input interface{}) (int, error) {
+	if value != nil {
+		return *value, nil
+	}
+
+	if path == nil {
+		return 0, nil
+	}
+
+	num, err := path.GetNumber(input)
+	if err != nil {
+		return 0, err
+	}
+
+	return int(*num), nil
 }
 
 func (s *MapState) Execute(ctx context.Context, input interface{}) (output interface{}, next *string, err error) {
 	return processError(s,
 		processCatcher(s.Catch,
-			processRetrier(s.Name(), s.Retry,
+			processRetrier(s.Retry,
 				inputOutput(
 					s.InputPath,
 					s.OutputPath,
-					withParams(
-						s.Parameters,
-						result(s.ResultPath, s.process),
-					),
+					result(s.ResultPath, withResultSelector(s.ResultSelector, s.process)),
 				),
 			),
 		),
@@ -66,23 +406,95 @@ func (s *MapState) Execute(ctx context.Context, input interface{}) (output inter
 }
 
 func (s *MapState) Validate() error {
-	s.SetType(to.Strp("Map"))
+	defaultType(s, "Map")
 
 	if err := ValidateNameAndType(s); err != nil {
 		return fmt.Errorf("%v %v", errorPrefix(s), err)
 	}
 
 	if err := endValid(s.Next, s.End); err != nil {
-		return fmt.Errorf("%v %v", errorPrefix(s), err)
+		return invalidField(s, "Next", err)
 	}
 
-	if s.Iterator == nil {
-		return fmt.Errorf("%v Requires Iterator", errorPrefix(s))
+	if err := resultPathValid(s.ResultPath); err != nil {
+		return invalidField(s, "ResultPath", err)
 	}
 
-	if err := s.Iterator.Validate(); err != nil {
-		return fmt.Errorf("%v %v", errorPrefix(s), err)
+	if s.ItemSelector != nil && s.Parameters != nil {
+		return invalidField(s, "ItemSelector", fmt.Errorf("Only one of ItemSelector and Parameters allowed"))
+	}
+
+	if s.MaxConcurrency != nil && *s.MaxConcurrency < 0 {
+		return invalidField(s, "MaxConcurrency", fmt.Errorf("MaxConcurrency must be positive"))
+	}
+
+	if s.ToleratedFailureCount != nil && *s.ToleratedFailureCount < 0 {
+		return invalidField(s, "ToleratedFailureCount", fmt.Errorf("ToleratedFailureCount must be positive"))
+	}
+
+	if p := s.ToleratedFailurePercentage; p != nil && (*p < 0 || *p > 100) {
+		return invalidField(s, "ToleratedFailurePercentage", fmt.Errorf("ToleratedFailurePercentage must be between 0 and 100"))
+	}
+
+	if err := s.ItemBatcher.validate(); err != nil {
+		return invalidField(s, "ItemBatcher", err)
+	}
+
+	if err := s.ItemProcessor.validate(); err != nil {
+		return invalidField(s, "ItemProcessor", err)
+	}
+
+	if (s.ItemReader != nil || s.ResultWriter != nil) && !s.distributed() {
+		return invalidField(s, "ItemProcessor", fmt.Errorf("ItemReader and ResultWriter require ProcessorConfig Mode DISTRIBUTED"))
+	}
+
+	if s.ItemReader != nil && s.ItemsPath != nil {
+		return invalidField(s, "ItemReader", fmt.Errorf("Only one of ItemReader and ItemsPath allowed"))
+	}
+
+	if err := s.ItemReader.validate(); err != nil {
+		return invalidField(s, "ItemReader", err)
+	}
+
+	if err := s.ResultWriter.validate(); err != nil {
+		return invalidField(s, "ResultWriter", err)
+	}
+
+	if s.Iterator != nil && s.ItemProcessor != nil {
+		return invalidField(s, "Iterator", fmt.Errorf("Only one of ItemProcessor and Iterator allowed"))
+	}
+
+	if s.iterator() == nil {
+		return invalidField(s, "ItemProcessor", fmt.Errorf("Requires ItemProcessor"))
+	}
+
+	if err := s.iterator().Validate(); err != nil {
+		return invalidField(s, s.iteratorField(), err)
+	}
+	return nil
+}
+
+func (b *ItemBatcher) validate() error {
+	if b == nil {
+		return nil
+	}
+
+	if b.MaxItemsPerBatch == nil && b.MaxItemsPerBatchPath == nil && b.MaxInputBytesPerBatch == nil && b.MaxInputBytesPerBatchPath == nil {
+		return fmt.Errorf("ItemBatcher requires MaxItemsPerBatch or MaxInputBytesPerBatch")
+	}
+
+	if b.MaxItemsPerBatch != nil && b.MaxItemsPerBatchPath != nil {
+		return fmt.Errorf("ItemBatcher only one of MaxItemsPerBatch and MaxItemsPerBatchPath allowed")
 	}
+
+	if b.MaxInputBytesPerBatch != nil && b.MaxInputBytesPerBatchPath != nil {
+		return fmt.Errorf("ItemBatcher only one of MaxInputBytesPerBatch and MaxInputBytesPerBatchPath allowed")
+	}
+
+	if (b.MaxItemsPerBatch != nil && *b.MaxItemsPerBatch < 1) || (b.MaxInputBytesPerBatch != nil && *b.MaxInputBytesPerBatch < 1) {
+		return fmt.Errorf("ItemBatcher limits must be positive")
+	}
+
 	return nil
 }
 
@@ -1,9 +1,14 @@
 package machine
 
 import (
+	"context"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
 	"github.com/cleardataeng/step/utils/to"
 	"github.com/stretchr/testify/assert"
-	"testing"
 )
 
 /////////
@@ -11,11 +16,11 @@ import (
 /////////
 
 func initialize_state_machine(state *StateMachine, t *testing.T) {
-	state.StartAt = to.Strp("Start")
+	state.StartAt = to.Strp("start")
 	state.States = States{}
 	sm := parseTaskState([]byte(`{
 		"Resource": "asd",
-		"Next": "Pass",
+		"End": true,
 		"Retry": [{ "ErrorEquals": ["States.ALL"] }]
 	}`), t)
 	state.States["start"] = sm
@@ -144,3 +149,236 @@ func Test_MapState_Integration(t *testing.T) {
 		Output: outputResults,
 	}, t)
 }
+
+func Test_MapState_ResultSelector(t *testing.T) {
+	state := parseMapState([]byte(`{
+      "Type": "Map",
+      "ItemsPath": "$.shipped",
+      "ResultSelector": {"results.$": "$", "source": "map"},
+      "ResultPath": "$.output",
+      "Iterator": {
+        "StartAt": "Validate",
+        "States": {
+          "Validate": {
+            "Type": "Pass",
+            "Result": {"key": "value"},
+            "End": true
+          }
+        }
+      },
+      "End": true
+    }`), t)
+
+	output, _, err := state.Execute(nil, map[string]interface{}{"shipped": []interface{}{1, 2}})
+	assert.NoError(t, err)
+
+	selected := output.(map[string]interface{})["output"].(map[string]interface{})
+	assert.Equal(t, "map", selected["source"])
+	assert.Equal(t, 2, len(selected["results"].([]map[string]interface{})))
+}
+
+func Test_MapState_MaxConcurrency(t *testing.T) {
+	state := parseMapState([]byte(`{
+      "ItemsPath": "$.items",
+      "MaxConcurrency": 2,
+      "Iterator": {
+        "StartAt": "Task",
+        "States": {"Task": {"Type": "Task", "Resource": "test", "End": true}}
+      },
+      "End": true
+    }`), t)
+
+	var mu sync.Mutex
+	running, maxRunning := 0, 0
+
+	state.Iterator.SetTaskHandler("Task", func(_ context.Context, input interface{}) (interface{}, error) {
+		mu.Lock()
+		running++
+		if running > maxRunning {
+			maxRunning = running
+		}
+		mu.Unlock()
+
+		time.Sleep(10 * time.Millisecond)
+
+		mu.Lock()
+		running--
+		mu.Unlock()
+
+		return map[string]interface{}{"item": input}, nil
+	})
+
+	items := []interface{}{}
+	expected := []map[string]interface{}{}
+	for i := 0; i < 8; i++ {
+		items = append(items, float64(i))
+		expected = append(expected, map[string]interface{}{"item": float64(i)})
+	}
+
+	output, _, err := state.Execute(nil, map[string]interface{}{"items": items})
+	assert.NoError(t, err)
+	assert.Equal(t, expected, output)
+	assert.Equal(t, 2, maxRunning)
+}
+
+func Test_MapState_ItemSelector(t *testing.T) {
+	for _, field := range []string{"ItemSelector", "Parameters"} {
+		state := parseMapState([]byte(fmt.Sprintf(`{
+      "ItemsPath": "$.items",
+      "%v": {
+        "index.$": "$$.Map.Item.Index",
+        "value.$": "$$.Map.Item.Value",
+        "label.$": "States.Format('{}-{}', $.prefix, $$.Map.Item.Value)"
+      },
+      "Iterator": {
+        "StartAt": "Pass",
+        "States": {"Pass": {"Type": "Pass", "End": true}}
+      },
+      "End": true
+    }`, field)), t)
+
+		output, _, err := state.Execute(nil, map[string]interface{}{"prefix": "p", "items": []interface{}{"a", "b"}})
+		assert.NoError(t, err)
+		assert.Equal(t, []map[string]interface{}{
+			{"index": float64(0), "value": "a", "label": "p-a"},
+			{"index": float64(1), "value": "b", "label": "p-b"},
+		}, output)
+	}
+}
+
+func Test_MapState_ItemBatcher(t *testing.T) {
+	state := parseMapState([]byte(`{
+      "ItemsPath": "$.items",
+      "ItemBatcher": {
+        "MaxItemsPerBatch": 2,
+        "BatchInput": {"source.$": "$.source"}
+      },
+      "Iterator": {
+        "StartAt": "Pass",
+        "States": {"Pass": {"Type": "Pass", "End": true}}
+      },
+      "End": true
+    }`), t)
+
+	output, _, err := state.Execute(nil, map[string]interface{}{"source": "s", "items": []interface{}{1, 2, 3}})
+	assert.NoError(t, err)
+	assert.Equal(t, []map[string]interface{}{
+		{"BatchInput": map[string]interface{}{"source": "s"}, "Items": []interface{}{float64(1), float64(2)}},
+		{"BatchInput": map[string]interface{}{"source": "s"}, "Items": []interface{}{float64(3)}},
+	}, output)
+
+	// Batch by bytes, each item is 5 bytes
+	batcher := &ItemBatcher{MaxInputBytesPerBatch: to.Intp(12)}
+	batches, err := batcher.batch(nil, nil, []interface{}{"abc", "def", "ghi"})
+	assert.NoError(t, err)
+	assert.Equal(t, []interface{}{
+		map[string]interface{}{"Items": []interface{}{"abc", "def"}},
+		map[string]interface{}{"Items": []interface{}{"ghi"}},
+	}, batches)
+
+	_, err = (&ItemBatcher{MaxInputBytesPerBatch: to.Intp(2)}).batch(nil, nil, []interface{}{"abc"})
+	assert.Error(t, err)
+}
+
+func Test_MapState_ToleratedFailures(t *testing.T) {
+	parse := func(tolerated string) *MapState {
+		state := parseMapState([]byte(fmt.Sprintf(`{
+      "ItemsPath": "$.items",
+      %v
+      "Iterator": {
+        "StartAt": "Task",
+        "States": {"Task": {"Type": "Task", "Resource": "test", "End": true}}
+      },
+      "End": true
+    }`, tolerated)), t)
+
+		state.Iterator.SetTaskHandler("Task", func(_ context.Context, input interface{}) (interface{}, error) {
+			if input.(map[string]interface{})["fail"] == true {
+				return nil, &TestError{}
+			}
+			return input, nil
+		})
+		return state
+	}
+
+	input := map[string]interface{}{"items": []interface{}{
+		map[string]interface{}{"fail": true},
+		map[string]interface{}{"fail": false},
+		map[string]interface{}{"fail": true},
+		map[string]interface{}{"fail": false},
+	}}
+
+	// No tolerance fails with the items error
+	_, _, err := parse(``).Execute(nil, input)
+	assert.Error(t, err)
+	assert.Regexp(t, "This is a Test Error", err.Error())
+
+	output, _, err := parse(`"ToleratedFailureCount": 2,`).Execute(nil, input)
+	assert.NoError(t, err)
+	results := output.([]map[string]interface{})
+	assert.Regexp(t, "This is a Test Error", results[0]["Cause"])
+	assert.Equal(t, map[string]interface{}{"fail": false}, results[1])
+
+	output, _, err = parse(`"ToleratedFailurePercentage": 50,`).Execute(nil, input)
+	assert.NoError(t, err)
+
+	for _, tolerated := range []string{`"ToleratedFailureCount": 1,`, `"ToleratedFailurePercentage": 25,`} {
+		_, _, err = parse(tolerated).Execute(nil, input)
+		assert.Error(t, err)
+		assert.Regexp(t, "States.ExceedToleratedFailureThreshold", err.Error())
+	}
+
+	// The threshold error can be caught
+	state := parse(`"ToleratedFailureCount": 0, "Catch": [{"ErrorEquals": ["States.ExceedToleratedFailureThreshold"], "Next": "Caught"}],`)
+	_, next, err := state.Execute(nil, input)
+	assert.NoError(t, err)
+	assert.Equal(t, "Caught", *next)
+}
+
+func Test_MapState_Validate_Settings(t *testing.T) {
+	bad := []string{
+		`"ItemSelector": {}, "Parameters": {},`,
+		`"MaxConcurrency": -1,`,
+		`"ToleratedFailureCount": -1,`,
+		`"ToleratedFailurePercentage": 101,`,
+		`"ItemBatcher": {},`,
+		`"ItemBatcher": {"MaxItemsPerBatch": 0},`,
+	}
+
+	for _, settings := range bad {
+		state := parseMapState([]byte(fmt.Sprintf(`{
+      %v
+      "Iterator": {
+        "StartAt": "Pass",
+        "States": {"Pass": {"Type": "Pass", "End": true}}
+      },
+      "End": true
+    }`, settings)), t)
+
+		assert.Error(t, state.Validate(), settings)
+	}
+}
+
+func Test_MapState_Items_Skip_Validation(t *testing.T) {
+	state := parseMapState([]byte(`{
+      "Type": "Map",
+      "ItemsPath": "$.items",
+      "Iterator": {
+        "StartAt": "Item",
+        "States": {
+          "Item": {"Type": "Pass", "End": true},
+          "Orphan": {"Type": "Succeed"}
+        }
+      },
+      "End": true
+    }`), t)
+
+	// The Iterator is validated once by the MapState
+	assert.Error(t, state.Validate())
+
+	// Items run the Iterator without validating it again
+	items := []interface{}{map[string]interface{}{"a": 1.0}, map[string]interface{}{"a": 2.0}}
+	output, _, err := state.Execute(context.Background(), map[string]interface{}{"items": items})
+	assert.NoError(t, err)
+	assert.Equal(t, []map[string]interface{}{{"a": 1.0}, {"a": 2.0}}, output)
+}
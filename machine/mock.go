@@ -0,0 +1,260 @@
+package machine
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// Mocks use the Step Functions Local MockConfigFile format. A test case of a State Machine
+// names the MockedResponses of its Task States, which replace their handler or Resource.
+//
+// A MockedResponse maps 0 based invocation ranges, e.g. "0" or "1-2", to a Return or Throw.
+// A Task invoked more often than its responses cover repeats its last response.
+
+// MockConfig is a MockConfigFile
+type MockConfig struct {
+	StateMachines   map[string]*MockStateMachine
+	MockedResponses map[string]MockedResponse
+}
+
+// MockStateMachine has the test cases of a State Machine
+type MockStateMachine struct {
+	TestCases map[string]MockTestCase
+}
+
+// MockTestCase maps Task State names to the name of their MockedResponse
+type MockTestCase map[string]string
+
+// MockedResponse maps invocation ranges to the response of those invocations
+type MockedResponse map[string]*MockResponse
+
+// MockResponse either Returns a result or Throws an error
+type MockResponse struct {
+	Return interface{} `json:",omitempty"`
+	Throw  *MockThrow  `json:",omitempty"`
+}
+
+// MockThrow is the error a MockResponse Throws
+type MockThrow struct {
+	Error string
+	Cause string
+}
+
+// ParseMockConfigFile reads and validates a MockConfigFile
+func ParseMockConfigFile(file string) (*MockConfig, error) {
+	raw, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	return MockConfigFromJSON(raw)
+}
+
+// MockConfigFromJSON parses and validates a MockConfigFile
+func MockConfigFromJSON(raw []byte) (*MockConfig, error) {
+	var config MockConfig
+	if err := json.Unmarshal(raw, &config); err != nil {
+		return nil, err
+	}
+
+	if err := config.Validate(); err != nil {
+		return nil, err
+	}
+	return &config, nil
+}
+
+// Validate checks the invocation ranges and responses of each MockedResponse and that test cases reference them
+func (c *MockConfig) Validate() error {
+	for name, response := range c.MockedResponses {
+		if _, err := response.ranges(); err != nil {
+			return fmt.Errorf("MockedResponse(%v) Error: %v", name, err)
+		}
+	}
+
+	for smName, sm := range c.StateMachines {
+		if sm == nil {
+			return fmt.Errorf("StateMachine(%v) Error: Requires TestCases", smName)
+		}
+
+		for caseName, testCase := range sm.TestCases {
+			for state, response := range testCase {
+				if _, ok := c.MockedResponses[response]; !ok {
+					return fmt.Errorf("StateMachine(%v) TestCase(%v) Error: State %q has unknown MockedResponse %q", smName, caseName, state, response)
+				}
+			}
+		}
+	}
+
+	return nil
+}
+
+// TestCase returns the Mocks of a State Machines test case
+func (c *MockConfig) TestCase(stateMachine string, testCase string) (*Mocks, error) {
+	sm, ok := c.StateMachines[stateMachine]
+	if !ok {
+		return nil, fmt.Errorf("Unknown Mocked StateMachine %q", stateMachine)
+	}
+
+	states, ok := sm.TestCases[testCase]
+	if !ok {
+		return nil, fmt.Errorf("Unknown TestCase %q for StateMachine %q", testCase, stateMachine)
+	}
+
+	mocks := &Mocks{responses: map[string][]mockRange{}}
+	for state, response := range states {
+		ranges, err := c.MockedResponses[response].ranges()
+		if err != nil {
+			return nil, err
+		}
+		mocks.responses[state] = ranges
+	}
+
+	return mocks, nil
+}
+
+////////
+// Invocation Ranges
+////////
+
+type mockRange struct {
+	from, to int
+	response *MockResponse
+}
+
+// ranges returns the sorted invocation ranges, which must cover 0 to the last invocation without overlapping
+func (r MockedResponse) ranges() ([]mockRange, error) {
+	if len(r) == 0 {
+		return nil, fmt.Errorf("Requires a response")
+	}
+
+	ranges := []mockRange{}
+	for key, response := range r {
+		from, to, err := parseInvocations(key)
+		if err != nil {
+			return nil, err
+		}
+
+		if response == nil || (response.Return == nil) == (response.Throw == nil) {
+			return nil, fmt.Errorf("Invocations %q require either Return or Throw", key)
+		}
+
+		if response.Throw != nil && response.Throw.Error == "" {
+			return nil, fmt.Errorf("Invocations %q Throw requires Error", key)
+		}
+
+		ranges = append(ranges, mockRange{from, to, response})
+	}
+
+	sort.Slice(ranges, func(i, j int) bool { return ranges[i].from < ranges[j].from })
+
+	next := 0
+	for _, r := range ranges {
+		if r.from != next {
+			return nil, fmt.Errorf("Invocations must be consecutive from 0, missing %v", next)
+		}
+		next = r.to + 1
+	}
+
+	return ranges, nil
+}
+
+// parseInvocations parses "1" or "1-2"
+func parseInvocations(key string) (int, int, error) {
+	parts := strings.SplitN(key, "-", 2)
+
+	from, err := strconv.Atoi(strings.TrimSpace(parts[0]))
+	if err != nil || from < 0 {
+		return 0, 0, fmt.Errorf("Invalid invocations %q", key)
+	}
+
+	to := from
+	if len(parts) == 2 {
+		to, err = strconv.Atoi(strings.TrimSpace(parts[1]))
+		if err != nil || to < from {
+			return 0, 0, fmt.Errorf("Invalid invocations %q", key)
+		}
+	}
+
+	return from, to, nil
+}
+
+////////
+// Mocks
+////////
+
+// Mocks are the mocked responses of a test case by Task State name
+type Mocks struct {
+	responses map[string][]mockRange
+}
+
+// SetMocks replaces the Tasks of a test case with their mocked responses, see MockConfig.TestCase
+func (sm *StateMachine) SetMocks(mocks *Mocks) error {
+	if mocks != nil {
+		tasks := sm.Tasks()
+		for name := range mocks.responses {
+			if _, ok := tasks[name]; !ok {
+				return fmt.Errorf("Mocked State %q is not a Task", name)
+			}
+		}
+	}
+
+	sm.Mocks = mocks
+	return nil
+}
+
+type mocksKey struct{}
+
+// mockCalls counts the invocations of the mocked Tasks of an Execution
+type mockCalls struct {
+	mocks *Mocks
+
+	mu    sync.Mutex // Branches and Iterations call Tasks concurrently
+	calls map[string]int
+}
+
+func withMocks(ctx context.Context, mocks *Mocks) context.Context {
+	return context.WithValue(ctx, mocksKey{}, &mockCalls{mocks: mocks, calls: map[string]int{}})
+}
+
+func mocksFrom(ctx context.Context) *mockCalls {
+	if ctx == nil {
+		return nil
+	}
+	m, _ := ctx.Value(mocksKey{}).(*mockCalls)
+	return m
+}
+
+// call returns the response for the next invocation of a Task, ok is false if the Task is not mocked
+func (m *mockCalls) call(name string) (result interface{}, err error, ok bool) {
+	if m == nil {
+		return nil, nil, false
+	}
+
+	ranges, ok := m.mocks.responses[name]
+	if !ok {
+		return nil, nil, false
+	}
+
+	m.mu.Lock()
+	invocation := m.calls[name]
+	m.calls[name]++
+	m.mu.Unlock()
+
+	response := ranges[len(ranges)-1].response
+	for _, r := range ranges {
+		if invocation >= r.from && invocation <= r.to {
+			response = r.response
+			break
+		}
+	}
+
+	if response.Throw != nil {
+		return nil, &statesError{response.Throw.Error, response.Throw.Cause}, true
+	}
+	return response.Return, nil, true
+}
@@ -0,0 +1,164 @@
+package machine
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+var mockConfig = []byte(`{
+  "StateMachines": {
+    "Order": {
+      "TestCases": {
+        "HappyPath": {"Charge": "ChargeSuccess", "Notify": "NotifySuccess"},
+        "RetryPath": {"Charge": "ChargeRetry", "Notify": "NotifySuccess"},
+        "FailPath": {"Charge": "ChargeFail"}
+      }
+    }
+  },
+  "MockedResponses": {
+    "ChargeSuccess": {"0": {"Return": {"charged": true}}},
+    "ChargeRetry": {
+      "0": {"Throw": {"Error": "Charge.Busy", "Cause": "busy"}},
+      "1-2": {"Throw": {"Error": "Charge.Busy", "Cause": "still busy"}},
+      "3": {"Return": {"charged": true, "attempts": 4}}
+    },
+    "ChargeFail": {"0": {"Throw": {"Error": "Charge.Declined", "Cause": "declined"}}},
+    "NotifySuccess": {"0": {"Return": {"sent": true}}}
+  }
+}`)
+
+func mockMachine(t *testing.T, testCase string) *StateMachine {
+	sm, err := FromJSON([]byte(`{
+    "StartAt": "Charge",
+    "States": {
+      "Charge": {
+        "Type": "Task",
+        "Resource": "arn:aws:lambda:us-east-1:000000000000:function:charge",
+        "ResultPath": "$.charge",
+        "Retry": [{"ErrorEquals": ["Charge.Busy"], "MaxAttempts": 3}],
+        "Catch": [{"ErrorEquals": ["Charge.Declined"], "ResultPath": "$.error", "Next": "Declined"}],
+        "Next": "Notify"
+      },
+      "Notify": {
+        "Type": "Task",
+        "Resource": "arn:aws:states:::sqs:sendMessage.waitForTaskToken",
+        "ResultPath": "$.notify",
+        "End": true
+      },
+      "Declined": {"Type": "Pass", "End": true}
+    }
+  }`))
+	assert.NoError(t, err)
+
+	config, err := MockConfigFromJSON(mockConfig)
+	assert.NoError(t, err)
+
+	mocks, err := config.TestCase("Order", testCase)
+	assert.NoError(t, err)
+	assert.NoError(t, sm.SetMocks(mocks))
+
+	sm.SetClock(NewVirtualClock(time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)))
+	return sm
+}
+
+func Test_Mock_HappyPath(t *testing.T) {
+	exec, err := mockMachine(t, "HappyPath").Execute(map[string]interface{}{"id": "a"})
+	assert.NoError(t, err)
+
+	// Notify returns without waiting for its task token
+	assert.Equal(t, map[string]interface{}{
+		"id":     "a",
+		"charge": map[string]interface{}{"charged": true},
+		"notify": map[string]interface{}{"sent": true},
+	}, exec.Output)
+}
+
+func Test_Mock_Retry(t *testing.T) {
+	exec, err := mockMachine(t, "RetryPath").Execute(map[string]interface{}{})
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]interface{}{"charged": true, "attempts": 4.0}, exec.Output["charge"])
+	assert.Equal(t, []string{"Charge", "Notify"}, exec.Path())
+}
+
+func Test_Mock_Catch(t *testing.T) {
+	exec, err := mockMachine(t, "FailPath").Execute(map[string]interface{}{})
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"Charge", "Declined"}, exec.Path())
+	assert.Equal(t, map[string]interface{}{"Error": "Charge.Declined", "Cause": "declined"}, exec.Output["error"])
+}
+
+func Test_Mock_RepeatsLastResponse(t *testing.T) {
+	config, err := MockConfigFromJSON(mockConfig)
+	assert.NoError(t, err)
+
+	mocks, err := config.TestCase("Order", "HappyPath")
+	assert.NoError(t, err)
+
+	calls := withMocks(context.Background(), mocks)
+	for i := 0; i < 3; i++ {
+		result, err, ok := mocksFrom(calls).call("Charge")
+		assert.True(t, ok)
+		assert.NoError(t, err)
+		assert.Equal(t, map[string]interface{}{"charged": true}, result)
+	}
+
+	_, _, ok := mocksFrom(calls).call("Unmocked")
+	assert.False(t, ok)
+}
+
+func Test_Mock_SetMocks_Nil_Calls_Handlers(t *testing.T) {
+	sm := mockMachine(t, "HappyPath")
+	assert.NoError(t, sm.SetMocks(nil))
+
+	calls := 0
+	sm.SetTaskHandler("Charge", func(_ context.Context, input interface{}) (interface{}, error) {
+		calls++
+		return nil, NewError("Charge.Declined", "handled")
+	})
+
+	exec, err := sm.Execute(map[string]interface{}{})
+	assert.NoError(t, err)
+	assert.Equal(t, 1, calls)
+	assert.Equal(t, []string{"Charge", "Declined"}, exec.Path())
+}
+
+func Test_Mock_TestCase_Errors(t *testing.T) {
+	config, err := MockConfigFromJSON(mockConfig)
+	assert.NoError(t, err)
+
+	_, err = config.TestCase("Unknown", "HappyPath")
+	assert.Error(t, err)
+
+	_, err = config.TestCase("Order", "Unknown")
+	assert.Error(t, err)
+
+	sm, err := FromJSON([]byte(`{"StartAt": "Charge", "States": {"Charge": {"Type": "Pass", "End": true}}}`))
+	assert.NoError(t, err)
+
+	mocks, err := config.TestCase("Order", "HappyPath")
+	assert.NoError(t, err)
+	assert.Error(t, sm.SetMocks(mocks))
+}
+
+func Test_Mock_Validate(t *testing.T) {
+	invalid := map[string]string{
+		"Unknown Response": `{"StateMachines": {"A": {"TestCases": {"T": {"S": "Missing"}}}}}`,
+		"Empty Response":   `{"MockedResponses": {"R": {}}}`,
+		"Both":             `{"MockedResponses": {"R": {"0": {"Return": 1, "Throw": {"Error": "E"}}}}}`,
+		"Neither":          `{"MockedResponses": {"R": {"0": {}}}}`,
+		"Throw No Error":   `{"MockedResponses": {"R": {"0": {"Throw": {"Cause": "c"}}}}}`,
+		"Not From 0":       `{"MockedResponses": {"R": {"1": {"Return": 1}}}}`,
+		"Gap":              `{"MockedResponses": {"R": {"0": {"Return": 1}, "2": {"Return": 1}}}}`,
+		"Overlap":          `{"MockedResponses": {"R": {"0-2": {"Return": 1}, "1": {"Return": 1}}}}`,
+		"Bad Range":        `{"MockedResponses": {"R": {"2-1": {"Return": 1}}}}`,
+		"Not A Number":     `{"MockedResponses": {"R": {"a": {"Return": 1}}}}`,
+	}
+
+	for name, raw := range invalid {
+		_, err := MockConfigFromJSON([]byte(raw))
+		assert.Error(t, err, name)
+	}
+}
@@ -0,0 +1,168 @@
+package machine
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/aws/aws-lambda-go/lambdacontext"
+	"github.com/cleardataeng/step/utils/to"
+)
+
+// DefaultMaxStateEvents is the MaxStateEvents used when ExecutionOptions do not set one
+const DefaultMaxStateEvents = 250
+
+// ExecutionOptions configure an Execution from ExecuteWithOptions or StartWithOptions,
+// nil options and zero values use the defaults
+type ExecutionOptions struct {
+	// Name is $$.Execution.Name, a new UUID by default
+	Name string
+
+	// ID is the Execution ARN and $$.Execution.Id, by default an ARN ending with the Name
+	ID string
+
+	// StateMachineName is $$.StateMachine.Name, used in the ARNs of the Execution. StateMachine by default
+	StateMachineName string
+
+	// TimeoutSeconds overrides the TimeoutSeconds of the StateMachine, after which the Execution times out
+	TimeoutSeconds int
+
+	// Clock is the time of the Execution, e.g. RealClock to actually wait. By default the StateMachines Clock
+	Clock Clock
+
+	// MaxStateEvents limits the StateEntered and StateExited events of the Execution and each of its Branches,
+	// an Execution that exceeds it fails with States.Runtime
+	MaxStateEvents int
+
+	// Lambda configures the Lambda context Task handlers are called with,
+	// its Region and AccountID are also used for the ARNs and history of the Execution
+	Lambda LambdaOptions
+
+	deadline time.Time // when the Execution times out, zero without a timeout
+}
+
+// LambdaOptions configure the lambdacontext.LambdaContext passed to Task handlers
+type LambdaOptions struct {
+	Region    string // us-east-1 by default
+	AccountID string // 000000000000 by default
+
+	// FunctionArn is the InvokedFunctionArn, by default the ARN of a function named after the State or Lambda
+	FunctionArn string
+
+	// RequestID is the AwsRequestID, by default a new UUID for every invocation
+	RequestID string
+}
+
+type executionOptionsKey struct{}
+
+func withExecutionOptions(ctx context.Context, opts *ExecutionOptions) context.Context {
+	return context.WithValue(ctx, executionOptionsKey{}, opts)
+}
+
+// executionOptionsFrom returns the options of the current Execution, or the defaults
+func executionOptionsFrom(ctx context.Context) *ExecutionOptions {
+	if ctx != nil {
+		if opts, ok := ctx.Value(executionOptionsKey{}).(*ExecutionOptions); ok && opts != nil {
+			return opts
+		}
+	}
+	return &ExecutionOptions{}
+}
+
+// executionOptions returns a copy of opts with the StateMachines TimeoutSeconds and the deadline from start
+func (sm *StateMachine) executionOptions(opts *ExecutionOptions, start time.Time) *ExecutionOptions {
+	resolved := ExecutionOptions{}
+	if opts != nil {
+		resolved = *opts
+	}
+
+	if resolved.Name == "" {
+		resolved.Name = to.UUID()
+	}
+
+	if resolved.TimeoutSeconds == 0 {
+		resolved.TimeoutSeconds = sm.TimeoutSeconds
+	}
+
+	if resolved.TimeoutSeconds > 0 {
+		resolved.deadline = start.Add(resolved.timeout())
+	}
+
+	return &resolved
+}
+
+func (opts *ExecutionOptions) stateMachineName() string {
+	if opts.StateMachineName == "" {
+		return stateMachineName
+	}
+	return opts.StateMachineName
+}
+
+// arn returns the ARN of a Step Functions resource of the State Machine, e.g. its stateMachine or an execution
+func (opts *ExecutionOptions) arn(resourceType string) string {
+	return fmt.Sprintf("arn:aws:states:%v:%v:%v:%v",
+		opts.Lambda.region(), opts.Lambda.accountID(), resourceType, opts.stateMachineName())
+}
+
+func (opts *ExecutionOptions) stateMachineArn() string {
+	return opts.arn("stateMachine")
+}
+
+// executionArn returns the ARN of the Execution named name
+func (opts *ExecutionOptions) executionArn(name string) string {
+	return fmt.Sprintf("%v:%v", opts.arn("execution"), name)
+}
+
+// roleArn is the role the Execution runs as in its history
+func (opts *ExecutionOptions) roleArn() string {
+	return fmt.Sprintf("arn:aws:iam::%v:role/%v", opts.Lambda.accountID(), opts.stateMachineName())
+}
+
+func (opts *ExecutionOptions) timeout() time.Duration {
+	return time.Duration(opts.TimeoutSeconds) * time.Second
+}
+
+func (opts *ExecutionOptions) maxStateEvents() int {
+	if opts.MaxStateEvents <= 0 {
+		return DefaultMaxStateEvents
+	}
+	return opts.MaxStateEvents
+}
+
+// timedOut is true once the Execution has run past its TimeoutSeconds on its clock
+func (opts *ExecutionOptions) timedOut(now time.Time) bool {
+	return !opts.deadline.IsZero() && !now.Before(opts.deadline)
+}
+
+func (opts *ExecutionOptions) timeoutError() error {
+	return &statesError{"States.Timeout", fmt.Sprintf("Execution timed out after %v seconds", opts.TimeoutSeconds)}
+}
+
+func (opts *LambdaOptions) region() string {
+	if opts.Region == "" {
+		return "us-east-1"
+	}
+	return opts.Region
+}
+
+func (opts *LambdaOptions) accountID() string {
+	if opts.AccountID == "" {
+		return "000000000000"
+	}
+	return opts.AccountID
+}
+
+// lambdaContext returns the Lambda context for an invocation of the function named name
+func (opts *LambdaOptions) lambdaContext(name string) *lambdacontext.LambdaContext {
+	arn := opts.FunctionArn
+	if arn == "" {
+		arn = fmt.Sprintf("arn:aws:lambda:%v:%v:function:%v", opts.region(), opts.accountID(), name)
+	}
+
+	requestID := opts.RequestID
+	if requestID == "" {
+		requestID = to.UUID()
+	}
+
+	return &lambdacontext.LambdaContext{AwsRequestID: requestID, InvokedFunctionArn: arn}
+}
@@ -0,0 +1,247 @@
+package machine
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-lambda-go/lambdacontext"
+	"github.com/stretchr/testify/assert"
+)
+
+func optionsMachine(t *testing.T, handler interface{}) *StateMachine {
+	sm, err := FromJSON([]byte(`{
+    "StartAt": "Task",
+    "States": {
+      "Task": {"Type": "Task", "Resource": "test", "End": true}
+    }
+  }`))
+	assert.NoError(t, err)
+	assert.NoError(t, sm.SetTaskHandler("Task", handler))
+	return sm
+}
+
+func lastEventType(exec *Execution) string {
+	history := exec.History()
+	return *history[len(history)-1].Type
+}
+
+func Test_Options_Name_And_ID(t *testing.T) {
+	sm := optionsMachine(t, func(ctx context.Context, _ interface{}) (interface{}, error) {
+		return ContextObject(ctx)["Execution"], nil
+	})
+
+	exec, err := sm.ExecuteWithOptions(context.Background(), map[string]interface{}{}, &ExecutionOptions{Name: "named"})
+	assert.NoError(t, err)
+	assert.Equal(t, "named", exec.Name)
+	assert.Equal(t, "arn:aws:states:us-east-1:000000000000:execution:StateMachine:named", exec.ID)
+	assert.Equal(t, exec.ID, exec.Output["Id"])
+
+	id := "arn:aws:states:eu-west-1:123456789012:execution:Order:named"
+	exec, err = sm.ExecuteWithOptions(context.Background(), map[string]interface{}{}, &ExecutionOptions{Name: "named", ID: id})
+	assert.NoError(t, err)
+	assert.Equal(t, id, exec.ID)
+	assert.Equal(t, id, exec.Output["Id"])
+}
+
+func Test_Options_LambdaContext(t *testing.T) {
+	contexts := []*lambdacontext.LambdaContext{}
+	sm := optionsMachine(t, func(ctx context.Context, _ interface{}) (interface{}, error) {
+		lc, ok := lambdacontext.FromContext(ctx)
+		assert.True(t, ok)
+		contexts = append(contexts, lc)
+		return map[string]interface{}{}, nil
+	})
+
+	_, err := sm.Execute(map[string]interface{}{})
+	assert.NoError(t, err)
+
+	_, err = sm.ExecuteWithOptions(context.Background(), map[string]interface{}{}, &ExecutionOptions{
+		Lambda: LambdaOptions{Region: "eu-west-1", AccountID: "123456789012"},
+	})
+	assert.NoError(t, err)
+
+	_, err = sm.ExecuteWithOptions(context.Background(), map[string]interface{}{}, &ExecutionOptions{
+		Lambda: LambdaOptions{FunctionArn: "arn:aws:lambda:eu-west-1:123456789012:function:handler", RequestID: "request"},
+	})
+	assert.NoError(t, err)
+
+	assert.Equal(t, "arn:aws:lambda:us-east-1:000000000000:function:Task", contexts[0].InvokedFunctionArn)
+	assert.NotEqual(t, "", contexts[0].AwsRequestID)
+	assert.Equal(t, "arn:aws:lambda:eu-west-1:123456789012:function:Task", contexts[1].InvokedFunctionArn)
+	assert.Equal(t, "arn:aws:lambda:eu-west-1:123456789012:function:handler", contexts[2].InvokedFunctionArn)
+	assert.Equal(t, "request", contexts[2].AwsRequestID)
+}
+
+func Test_Options_Cancel_Aborts(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	sm := optionsMachine(t, func(ctx context.Context, _ interface{}) (interface{}, error) {
+		cancel()
+		<-ctx.Done()
+		return nil, ctx.Err()
+	})
+
+	exec, err := sm.ExecuteWithOptions(ctx, map[string]interface{}{}, nil)
+	assert.Error(t, err)
+	assert.True(t, exec.Aborted())
+	assert.False(t, exec.TimedOut())
+	assert.Equal(t, "ExecutionAborted", lastEventType(exec))
+}
+
+func Test_Options_Cancel_Started(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	sm := optionsMachine(t, func(ctx context.Context, _ interface{}) (interface{}, error) {
+		<-ctx.Done()
+		return nil, ctx.Err()
+	})
+
+	exec, err := sm.StartWithOptions(ctx, map[string]interface{}{}, nil)
+	assert.NoError(t, err)
+
+	cancel()
+	assert.Error(t, exec.Wait())
+	assert.True(t, exec.Aborted())
+}
+
+func Test_Options_Started_From_Task(t *testing.T) {
+	child := optionsMachine(t, ReturnInputHandler)
+
+	var childExec *Execution
+	sm := optionsMachine(t, func(ctx context.Context, input interface{}) (interface{}, error) {
+		var err error
+		childExec, err = child.ExecuteWithOptions(ctx, input, &ExecutionOptions{StateMachineName: "child"})
+		return childExec.Output, err
+	})
+
+	exec, err := sm.Execute(map[string]interface{}{"a": "b"})
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]interface{}{"a": "b"}, exec.Output)
+
+	// The child is its own Execution, not nested in the Task's
+	assert.NotEqual(t, exec.ID, childExec.ID)
+	assert.Regexp(t, ":execution:child:", childExec.ID)
+	assert.Equal(t, "ExecutionStarted", *childExec.History()[0].Type)
+	assert.Equal(t, "ExecutionSucceeded", lastEventType(childExec))
+	assert.Equal(t, len(childExec.History()), len(exec.History()))
+}
+
+func Test_Options_TimeoutSeconds_VirtualClock(t *testing.T) {
+	sm, err := FromJSON([]byte(`{
+    "TimeoutSeconds": 10,
+    "StartAt": "Wait",
+    "States": {
+      "Wait": {"Type": "Wait", "Seconds": 60, "Next": "Pass"},
+      "Pass": {"Type": "Pass", "End": true}
+    }
+  }`))
+	assert.NoError(t, err)
+	sm.SetClock(NewVirtualClock(time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)))
+
+	exec, err := sm.Execute(map[string]interface{}{})
+	assert.Error(t, err)
+	assert.Equal(t, "States.Timeout", exec.ErrorName)
+	assert.True(t, exec.TimedOut())
+	assert.False(t, exec.Aborted())
+	assert.Equal(t, []string{"Wait"}, exec.Path())
+	assert.Equal(t, "ExecutionTimedOut", lastEventType(exec))
+
+	// The options override the StateMachines TimeoutSeconds
+	exec, err = sm.ExecuteWithOptions(context.Background(), map[string]interface{}{}, &ExecutionOptions{TimeoutSeconds: 120})
+	assert.NoError(t, err)
+	assert.False(t, exec.TimedOut())
+}
+
+func Test_Options_Clock(t *testing.T) {
+	sm, err := FromJSON([]byte(`{
+    "StartAt": "Wait",
+    "States": {"Wait": {"Type": "Wait", "Seconds": 60, "End": true}}
+  }`))
+	assert.NoError(t, err)
+	sm.SetClock(NewVirtualClock(time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)))
+
+	// The options Clock overrides the StateMachines
+	start := time.Date(2021, 1, 1, 0, 0, 0, 0, time.UTC)
+	exec, err := sm.ExecuteWithOptions(context.Background(), map[string]interface{}{}, &ExecutionOptions{Clock: NewVirtualClock(start)})
+	assert.NoError(t, err)
+
+	history := exec.History()
+	assert.Equal(t, start, *history[0].Timestamp)
+	assert.Equal(t, start.Add(60*time.Second), *history[len(history)-1].Timestamp)
+}
+
+func Test_Options_TimeoutSeconds_Handler(t *testing.T) {
+	sm := optionsMachine(t, func(ctx context.Context, _ interface{}) (interface{}, error) {
+		<-ctx.Done()
+		return nil, ctx.Err()
+	})
+
+	exec, err := sm.ExecuteWithOptions(context.Background(), map[string]interface{}{}, &ExecutionOptions{TimeoutSeconds: 1})
+	assert.Error(t, err)
+	assert.Equal(t, "States.Timeout", errorName(err))
+	assert.True(t, exec.TimedOut())
+}
+
+func Test_Options_MaxStateEvents(t *testing.T) {
+	sm, err := FromJSON([]byte(`{
+    "StartAt": "Loop",
+    "States": {
+      "Loop": {"Type": "Pass", "Next": "Again"},
+      "Again": {
+        "Type": "Choice",
+        "Choices": [{"Variable": "$.done", "IsPresent": true, "Next": "Done"}],
+        "Default": "Loop"
+      },
+      "Done": {"Type": "Succeed"}
+    }
+  }`))
+	assert.NoError(t, err)
+
+	exec, err := sm.ExecuteWithOptions(context.Background(), map[string]interface{}{}, &ExecutionOptions{MaxStateEvents: 10})
+	assert.Error(t, err)
+	assert.Equal(t, "States.Runtime", exec.ErrorName)
+	assert.Equal(t, 5, len(exec.Path()))
+	assert.Equal(t, 10, exec.stateEvents)
+
+	exec, err = sm.Execute(map[string]interface{}{})
+	assert.Error(t, err)
+	assert.Equal(t, DefaultMaxStateEvents/2, len(exec.Path()))
+}
+
+func Test_Options_Validate_TimeoutSeconds(t *testing.T) {
+	sm, err := FromJSON([]byte(`{"TimeoutSeconds": -1, "StartAt": "Pass", "States": {"Pass": {"Type": "Pass", "End": true}}}`))
+	assert.NoError(t, err)
+	assert.Error(t, sm.Validate())
+}
+
+func Test_Options_Region_And_AccountID(t *testing.T) {
+	sm, err := FromJSON([]byte(`{
+    "StartAt": "Task",
+    "States": {
+      "Task": {"Type": "Task", "Resource": "arn:aws:states:::lambda:invoke", "End": true}
+    }
+  }`))
+	assert.NoError(t, err)
+	assert.NoError(t, sm.SetTaskHandler("Task", func(ctx context.Context, _ interface{}) (interface{}, error) {
+		return ContextObject(ctx)["StateMachine"], nil
+	}))
+
+	exec, err := sm.ExecuteWithOptions(context.Background(), map[string]interface{}{}, &ExecutionOptions{
+		Name:             "named",
+		StateMachineName: "Order",
+		Lambda:           LambdaOptions{Region: "eu-west-1", AccountID: "123456789012"},
+	})
+	assert.NoError(t, err)
+
+	assert.Equal(t, "arn:aws:states:eu-west-1:123456789012:execution:Order:named", exec.ID)
+	assert.Equal(t, map[string]interface{}{
+		"Id":   "arn:aws:states:eu-west-1:123456789012:stateMachine:Order",
+		"Name": "Order",
+	}, exec.Output)
+
+	history := exec.History()
+	assert.Equal(t, "arn:aws:iam::123456789012:role/Order", *history[0].ExecutionStartedEventDetails.RoleArn)
+
+	scheduled := eventsByType(exec, "TaskScheduled")
+	assert.Equal(t, 1, len(scheduled))
+	assert.Equal(t, "eu-west-1", *scheduled[0].TaskScheduledEventDetails.Region)
+}
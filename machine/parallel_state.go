@@ -3,7 +3,9 @@ package machine
 import (
 	"context"
 	"fmt"
+	"sync"
 
+	"github.com/cleardataeng/step/jsonpath"
 	"github.com/cleardataeng/step/utils/to"
 )
 
@@ -12,19 +14,136 @@ type ParallelState struct {
 
 	Type    *string
 	Comment *string `json:",omitempty"`
+
+	Branches []*StateMachine
+
+	InputPath  *jsonpath.Path `json:",omitempty"`
+	OutputPath *jsonpath.Path `json:",omitempty"`
+	ResultPath *jsonpath.Path `json:",omitempty"`
+	Parameters interface{}    `json:",omitempty"`
+
+	ResultSelector interface{} `json:",omitempty"`
+
+	Catch []*Catcher `json:",omitempty"`
+	Retry []*Retrier `json:",omitempty"`
+
+	Next *string `json:",omitempty"`
+	End  *bool   `json:",omitempty"`
 }
 
-func (s *ParallelState) Execute(_ context.Context, input interface{}) (output interface{}, next *string, err error) {
-	return input, nil, nil
+func (s *ParallelState) process(ctx context.Context, input interface{}) (interface{}, *string, error) {
+	if ctx == nil {
+		ctx = context.Background()
+	}
+
+	// Branches record their events following ParallelStateStarted
+	exec := executionFrom(ctx)
+	exec.stateEvent("ParallelStateStarted")
+
+	// The first branch to fail cancels the rest
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	res := make([]interface{}, len(s.Branches))
+
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	var firstErr error
+
+	for i, branch := range s.Branches {
+		wg.Add(1)
+		go func(i int, branch *StateMachine) {
+			defer wg.Done()
+
+			// Each branch gets its own copy of the input as states modify it in place
+			branchInput, err := to.FromJSON(input)
+			var output interface{}
+			if err == nil {
+				output, err = branch.executeBranch(ctx, branchInput)
+			}
+
+			mu.Lock()
+			defer mu.Unlock()
+
+			if err != nil {
+				if firstErr == nil {
+					firstErr = &stateFailure{"States.BranchFailed", err}
+					cancel()
+				}
+				return
+			}
+
+			res[i] = output
+		}(i, branch)
+	}
+
+	wg.Wait()
+
+	exec.followLastEvent()
+	if firstErr != nil {
+		exec.stateEvent("ParallelStateFailed")
+		return nil, nil, firstErr
+	}
+	exec.stateEvent("ParallelStateSucceeded")
+
+	return res, nextState(s.Next, s.End), nil
+}
+
+func (s *ParallelState) Execute(ctx context.Context, input interface{}) (output interface{}, next *string, err error) {
+	return processError(s,
+		processCatcher(s.Catch,
+			processRetrier(s.Retry,
+				inputOutput(
+					s.InputPath,
+					s.OutputPath,
+					// InputPath, Parameters, ResultSelector, ResultPath then OutputPath,
+					// ResultPath places the result in the state input, not the Parameters
+					result(s.ResultPath,
+						withParams(s.Parameters, withResultSelector(s.ResultSelector, s.process)),
+					),
+				),
+			),
+		),
+	)(ctx, input)
 }
 
 func (s *ParallelState) Validate() error {
-	s.SetType(to.Strp("Parallel"))
+	defaultType(s, "Parallel")
 
 	if err := ValidateNameAndType(s); err != nil {
 		return fmt.Errorf("%v %v", errorPrefix(s), err)
 	}
 
+	if err := endValid(s.Next, s.End); err != nil {
+		return invalidField(s, "Next", err)
+	}
+
+	if err := resultPathValid(s.ResultPath); err != nil {
+		return invalidField(s, "ResultPath", err)
+	}
+
+	if len(s.Branches) == 0 {
+		return invalidField(s, "Branches", fmt.Errorf("Requires Branches"))
+	}
+
+	for i, branch := range s.Branches {
+		if branch == nil {
+			return invalidField(s, fmt.Sprintf("Branches[%v]", i), fmt.Errorf("Branch is nil"))
+		}
+
+		if err := branch.Validate(); err != nil {
+			return invalidField(s, fmt.Sprintf("Branches[%v]", i), err)
+		}
+	}
+
+	if err := catchValid(s.Catch); err != nil {
+		return invalidField(s, "Catch", err)
+	}
+
+	if err := retryValid(s.Retry); err != nil {
+		return invalidField(s, "Retry", err)
+	}
+
 	return nil
 }
 
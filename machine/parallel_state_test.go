@@ -0,0 +1,210 @@
+package machine
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/cleardataeng/step/utils/to"
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_ParallelState_Validate(t *testing.T) {
+	state := parseParallelState([]byte(`{ "Next": "Pass"}`), t)
+	err := state.Validate()
+	assert.Error(t, err)
+	assert.Regexp(t, "Requires Branches", err.Error())
+
+	state = parseParallelState([]byte(`{
+		"Branches": [{ "StartAt": "A", "States": {"A": {"Type": "Pass", "End": true}}}]
+	}`), t)
+	err = state.Validate()
+	assert.Error(t, err)
+	assert.Regexp(t, "End and Next both undefined", err.Error())
+
+	state = parseParallelState([]byte(`{
+		"Next": "Pass",
+		"Branches": [{ "StartAt": "A", "States": {}}]
+	}`), t)
+	assert.Error(t, state.Validate())
+
+	state = parseParallelState([]byte(`{
+		"Next": "Pass",
+		"Branches": [{ "StartAt": "A", "States": {"A": {"Type": "Pass", "End": true}}}]
+	}`), t)
+	assert.NoError(t, state.Validate())
+}
+
+func Test_ParallelState_BranchOrder(t *testing.T) {
+	state := parseParallelState([]byte(`{
+		"Next": "Pass",
+		"ResultPath": "$.results",
+		"Branches": [
+			{ "StartAt": "A", "States": {"A": {"Type": "Pass", "Result": {"branch": "a"}, "End": true}}},
+			{ "StartAt": "B", "States": {"B": {"Type": "Pass", "Result": {"branch": "b"}, "End": true}}},
+			{ "StartAt": "C", "States": {"C": {"Type": "Pass", "ResultPath": "$.branch", "Result": "c", "End": true}}}
+		]
+	}`), t)
+
+	testState(state, stateTestData{
+		Input: map[string]interface{}{"x": "y"},
+		Output: map[string]interface{}{
+			"x": "y",
+			"results": []interface{}{
+				map[string]interface{}{"branch": "a"},
+				map[string]interface{}{"branch": "b"},
+				map[string]interface{}{"x": "y", "branch": "c"},
+			},
+		},
+		Next: to.Strp("Pass"),
+	}, t)
+}
+
+func Test_ParallelState_Parameters(t *testing.T) {
+	state := parseParallelState([]byte(`{
+		"End": true,
+		"InputPath": "$.in",
+		"Parameters": {"value.$": "$.a"},
+		"ResultPath": "$.results",
+		"OutputPath": "$.results",
+		"Branches": [
+			{ "StartAt": "A", "States": {"A": {"Type": "Pass", "End": true}}}
+		]
+	}`), t)
+
+	output, next, err := state.Execute(nil, map[string]interface{}{"in": map[string]interface{}{"a": "b"}})
+	assert.NoError(t, err)
+	assert.Nil(t, next)
+	assert.Equal(t, []interface{}{map[string]interface{}{"value": "b"}}, output)
+}
+
+func Test_ParallelState_Parameters_and_ResultPath(t *testing.T) {
+	state := parseParallelState([]byte(`{
+		"Next": "Pass",
+		"Parameters": {"value.$": "$.a"},
+		"ResultPath": "$.results",
+		"Branches": [
+			{ "StartAt": "A", "States": {"A": {"Type": "Pass", "End": true}}}
+		]
+	}`), t)
+
+	testState(state, stateTestData{
+		Input: map[string]interface{}{"a": "b", "c": "d"},
+		Output: map[string]interface{}{
+			"a":       "b",
+			"c":       "d",
+			"results": []interface{}{map[string]interface{}{"value": "b"}},
+		},
+	}, t)
+}
+
+func Test_ParallelState_FailureCancelsBranches(t *testing.T) {
+	state := parseParallelState([]byte(`{
+		"Next": "Pass",
+		"Catch": [{
+			"ErrorEquals": ["States.ALL"],
+			"Next": "Fail"
+		}],
+		"Branches": [
+			{ "StartAt": "Slow", "States": {"Slow": {"Type": "Task", "Resource": "slow", "Next": "After"}, "After": {"Type": "Pass", "End": true}}},
+			{ "StartAt": "Broken", "States": {"Broken": {"Type": "Task", "Resource": "broken", "End": true}}}
+		]
+	}`), t)
+
+	started := make(chan bool)
+	cancelled := make(chan bool, 1)
+	state.Branches[0].SetTaskHandler("Slow", func(ctx context.Context, input interface{}) (interface{}, error) {
+		close(started)
+		select {
+		case <-ctx.Done():
+			cancelled <- true
+		case <-time.After(5 * time.Second):
+			cancelled <- false
+		}
+		return input, nil
+	})
+	state.Branches[1].SetTaskHandler("Broken", func(ctx context.Context, input interface{}) (interface{}, error) {
+		<-started
+		return ThrowTestErrorHandler(ctx, input)
+	})
+
+	testState(state, stateTestData{
+		Input: map[string]interface{}{"a": "c"},
+		Next:  to.Strp("Fail"),
+	}, t)
+
+	assert.True(t, <-cancelled)
+}
+
+func Test_ParallelState_Machine(t *testing.T) {
+	sm, err := FromJSON([]byte(`{
+		"StartAt": "Fan",
+		"States": {
+			"Fan": {
+				"Type": "Parallel",
+				"ResultPath": "$.results",
+				"Branches": [
+					{ "StartAt": "Add", "States": {"Add": {"Type": "Task", "Resource": "add", "End": true}}},
+					{ "StartAt": "Echo", "States": {"Echo": {"Type": "Task", "Resource": "echo", "End": true}}}
+				],
+				"Next": "Done"
+			},
+			"Done": {"Type": "Succeed"}
+		}
+	}`))
+	assert.NoError(t, err)
+
+	assert.NoError(t, sm.SetTaskHandler("Add", ReturnMapTestHandler))
+	assert.NoError(t, sm.SetTaskHandler("Echo", ReturnInputHandler))
+
+	exec, err := sm.Execute(map[string]interface{}{"a": "b"})
+	assert.NoError(t, err)
+
+	assert.Equal(t, []string{"Fan", "Done"}, exec.Path())
+	assert.Equal(t, []interface{}{
+		map[string]interface{}{"z": "y"},
+		map[string]interface{}{"a": "b"},
+	}, exec.Output["results"])
+}
+
+func Test_ParallelState_ResultSelector(t *testing.T) {
+	state := parseParallelState([]byte(`{
+		"Next": "Pass",
+		"ResultSelector": {"count.$": "States.ArrayLength($)"},
+		"ResultPath": "$.summary",
+		"Branches": [
+			{ "StartAt": "A", "States": {"A": {"Type": "Pass", "End": true}}},
+			{ "StartAt": "B", "States": {"B": {"Type": "Pass", "End": true}}}
+		]
+	}`), t)
+
+	testState(state, stateTestData{
+		Input:  map[string]interface{}{"a": "b"},
+		Output: map[string]interface{}{"a": "b", "summary": map[string]interface{}{"count": float64(2)}},
+	}, t)
+}
+
+func Test_ParallelState_Catch_Branch_Error_Name(t *testing.T) {
+	state := parseParallelState([]byte(`{
+		"Next": "Pass",
+		"Catch": [{
+			"ErrorEquals": ["TestError"],
+			"ResultPath": "$.error",
+			"Next": "Caught"
+		}],
+		"Branches": [
+			{ "StartAt": "Broken", "States": {"Broken": {"Type": "Task", "Resource": "broken", "End": true}}}
+		]
+	}`), t)
+
+	state.Branches[0].SetTaskHandler("Broken", ThrowTestErrorHandler)
+
+	testState(state, stateTestData{
+		Input: map[string]interface{}{"a": "c"},
+		Output: map[string]interface{}{
+			"a":     "c",
+			"error": map[string]interface{}{"Error": "TestError", "Cause": "This is a Test Error"},
+		},
+		Next: to.Strp("Caught"),
+	}, t)
+}
@@ -5,7 +5,6 @@ import (
 	"fmt"
 
 	"github.com/cleardataeng/step/jsonpath"
-	"github.com/cleardataeng/step/utils/to"
 )
 
 type PassState struct {
@@ -39,7 +38,7 @@ func (s *PassState) process(ctx context.Context, input interface{}) (output inte
 }
 
 func (s *PassState) Validate() error {
-	s.SetType(to.Strp("Pass"))
+	defaultType(s, "Pass")
 
 	if err := ValidateNameAndType(s); err != nil {
 		return fmt.Errorf("%v %v", errorPrefix(s), err)
@@ -47,7 +46,11 @@ func (s *PassState) Validate() error {
 
 	// Next xor End
 	if err := endValid(s.Next, s.End); err != nil {
-		return fmt.Errorf("%v %v", errorPrefix(s), err)
+		return invalidField(s, "Next", err)
+	}
+
+	if err := resultPathValid(s.ResultPath); err != nil {
+		return invalidField(s, "ResultPath", err)
 	}
 
 	return nil
@@ -36,6 +36,14 @@ func Test_PassState_EndNextBothUnDefined(t *testing.T) {
 	assert.Regexp(t, "End and Next both undefined", err.Error())
 }
 
+func Test_PassState_ResultPathNotReference(t *testing.T) {
+	state := parsePassState([]byte(`{ "Next": "Pass", "ResultPath": "$.a[*]"}`), t)
+	err := state.Validate()
+	assert.Error(t, err)
+
+	assert.Regexp(t, "must be a Reference Path", err.Error())
+}
+
 // Execution
 
 func Test_PassState_ResultPath(t *testing.T) {
@@ -94,3 +102,19 @@ func Test_PassState_BadOutputPath(t *testing.T) {
 		Error: to.Strp("Output Error"),
 	}, t)
 }
+
+func Test_PassState_ExpressionPaths(t *testing.T) {
+	state := parsePassState([]byte(`{
+		"Next": "Pass",
+		"InputPath": "$.groups[0]",
+		"Result": "x",
+		"ResultPath": "$['group items'][-1]"
+	}`), t)
+
+	testState(state, stateTestData{
+		Input: map[string]interface{}{"groups": []interface{}{
+			map[string]interface{}{"id": "a", "group items": []interface{}{1, 2}},
+		}},
+		Output: map[string]interface{}{"id": "a", "group items": []interface{}{1, "x"}},
+	}, t)
+}
@@ -0,0 +1,380 @@
+package machine
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"sort"
+	"sync"
+
+	"github.com/aws/aws-sdk-go/service/sfn"
+	"github.com/cleardataeng/step/utils/to"
+)
+
+// Replay runs a recorded execution, e.g. a failed production execution from GetExecutionHistory,
+// against local code. Each Task is given the input it was recorded with, so the local
+// handler outputs can be compared to the recorded LambdaFunctionSucceeded and TaskSucceeded outputs.
+
+// Replay is a local Execution of a recorded execution history
+type Replay struct {
+	Execution  *Execution
+	Divergence *Divergence // the first difference to the recording, nil if there is none
+}
+
+// Divergence is where a replayed Execution differs from its recording
+type Divergence struct {
+	Kind     string // path, output or error
+	State    string // empty if the Executions end differently
+	EventID  int64  // the recorded event, 0 if only the local Execution has it
+	Recorded string
+	Local    string
+}
+
+func (d *Divergence) String() string {
+	at := "Execution"
+	if d.State != "" {
+		at = fmt.Sprintf("State %q", d.State)
+	}
+	return fmt.Sprintf("%v diverged at %v (event %v)\n  recorded: %v\n  local:    %v", d.Kind, at, d.EventID, d.Recorded, d.Local)
+}
+
+// Replay executes the StateMachine with the input of the ExecutionStarted event of a history,
+// returning the Execution and its first Divergence. The Executions error is not returned as
+// reproducing a failure is the point, only an invalid StateMachine or history returns an error
+func (sm *StateMachine) Replay(events []*sfn.HistoryEvent) (*Replay, error) {
+	events = sortedEvents(events)
+
+	input, err := recordedInput(events)
+	if err != nil {
+		return nil, err
+	}
+
+	recorded := replaySteps(events)
+
+	exec, err := sm.execute(withReplay(context.Background(), newReplayInputs(recorded)), input)
+	if exec == nil {
+		return nil, err
+	}
+
+	history := exec.History()
+	divergence := diverge(recorded, replaySteps(history))
+	if divergence == nil {
+		divergence = divergeEnd(events, history)
+	}
+
+	return &Replay{Execution: exec, Divergence: divergence}, nil
+}
+
+// sortedEvents orders a history by Id, e.g. if it was fetched with ReverseOrder
+func sortedEvents(events []*sfn.HistoryEvent) []*sfn.HistoryEvent {
+	sorted := append([]*sfn.HistoryEvent{}, events...)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		return eventID(sorted[i]) < eventID(sorted[j])
+	})
+	return sorted
+}
+
+func eventID(event *sfn.HistoryEvent) int64 {
+	if event == nil || event.Id == nil {
+		return 0
+	}
+	return *event.Id
+}
+
+func recordedInput(events []*sfn.HistoryEvent) (*string, error) {
+	for _, event := range events {
+		if event.ExecutionStartedEventDetails != nil {
+			if event.ExecutionStartedEventDetails.Input == nil {
+				return to.Strp("{}"), nil
+			}
+			return event.ExecutionStartedEventDetails.Input, nil
+		}
+	}
+	return nil, fmt.Errorf("History has no ExecutionStarted event")
+}
+
+////////
+// Steps
+////////
+
+// replayStep is a State entered in an execution, and the input and result of each of its Task attempts
+type replayStep struct {
+	name    string
+	eventID int64
+	inputs  []*string
+	results []*replayResult
+}
+
+type replayResult struct {
+	eventID int64
+	output  *string
+	err     *string
+	cause   *string
+}
+
+func (r *replayResult) String() string {
+	if r == nil {
+		return "no result"
+	}
+	if r.err != nil {
+		return fmt.Sprintf("%v: %v", *r.err, to.Strs(r.cause))
+	}
+	return to.Strs(r.output)
+}
+
+// replaySteps returns the States entered in a history with their Task events,
+// Task events are matched to their State by following their PreviousEventIds
+func replaySteps(events []*sfn.HistoryEvent) []*replayStep {
+	byID := map[int64]*sfn.HistoryEvent{}
+	for _, event := range events {
+		byID[eventID(event)] = event
+	}
+
+	steps := []*replayStep{}
+	entered := map[int64]*replayStep{}
+
+	for _, event := range events {
+		if event.StateEnteredEventDetails != nil {
+			step := &replayStep{name: to.Strs(event.StateEnteredEventDetails.Name), eventID: eventID(event)}
+			entered[step.eventID] = step
+			steps = append(steps, step)
+			continue
+		}
+
+		input, result := taskEvent(event)
+		if input == nil && result == nil {
+			continue
+		}
+
+		step := entered[enteredID(byID, event)]
+		if step == nil {
+			continue
+		}
+
+		if input != nil {
+			step.inputs = append(step.inputs, input)
+		}
+
+		if result != nil {
+			result.eventID = eventID(event)
+			step.results = append(step.results, result)
+		}
+	}
+
+	return steps
+}
+
+// taskEvent returns the input of a scheduled Task, or the result of a completed Task
+func taskEvent(event *sfn.HistoryEvent) (*string, *replayResult) {
+	switch {
+	case event.LambdaFunctionScheduledEventDetails != nil:
+		return event.LambdaFunctionScheduledEventDetails.Input, nil
+	case event.TaskScheduledEventDetails != nil:
+		return event.TaskScheduledEventDetails.Parameters, nil
+	case event.LambdaFunctionSucceededEventDetails != nil:
+		return nil, &replayResult{output: event.LambdaFunctionSucceededEventDetails.Output}
+	case event.TaskSucceededEventDetails != nil:
+		return nil, &replayResult{output: event.TaskSucceededEventDetails.Output}
+	case event.LambdaFunctionFailedEventDetails != nil:
+		details := event.LambdaFunctionFailedEventDetails
+		return nil, &replayResult{err: details.Error, cause: details.Cause}
+	case event.LambdaFunctionTimedOutEventDetails != nil:
+		details := event.LambdaFunctionTimedOutEventDetails
+		return nil, &replayResult{err: details.Error, cause: details.Cause}
+	case event.TaskFailedEventDetails != nil:
+		details := event.TaskFailedEventDetails
+		return nil, &replayResult{err: details.Error, cause: details.Cause}
+	case event.TaskTimedOutEventDetails != nil:
+		details := event.TaskTimedOutEventDetails
+		return nil, &replayResult{err: details.Error, cause: details.Cause}
+	}
+	return nil, nil
+}
+
+// enteredID follows the PreviousEventIds of an event back to the StateEntered event of its State
+func enteredID(byID map[int64]*sfn.HistoryEvent, event *sfn.HistoryEvent) int64 {
+	for event != nil && event.PreviousEventId != nil {
+		previous := byID[*event.PreviousEventId]
+		if previous == nil || eventID(previous) >= eventID(event) {
+			return 0
+		}
+
+		if previous.StateEnteredEventDetails != nil {
+			return eventID(previous)
+		}
+		event = previous
+	}
+	return 0
+}
+
+////////
+// Recorded Inputs
+////////
+
+type replayKey struct{}
+
+// replayInputs gives each Task attempt the input it was recorded with
+type replayInputs struct {
+	mu     sync.Mutex // Branches and Iterations call Tasks concurrently
+	inputs map[string][]*string
+	calls  map[string]int
+}
+
+func newReplayInputs(steps []*replayStep) *replayInputs {
+	r := &replayInputs{inputs: map[string][]*string{}, calls: map[string]int{}}
+	for _, step := range steps {
+		r.inputs[step.name] = append(r.inputs[step.name], step.inputs...)
+	}
+	return r
+}
+
+func withReplay(ctx context.Context, r *replayInputs) context.Context {
+	return context.WithValue(ctx, replayKey{}, r)
+}
+
+func replayFrom(ctx context.Context) *replayInputs {
+	if ctx == nil {
+		return nil
+	}
+	r, _ := ctx.Value(replayKey{}).(*replayInputs)
+	return r
+}
+
+// taskInput returns the recorded input for the next attempt of a Task,
+// otherwise the local input if the Task was not called as often in the recording
+func (r *replayInputs) taskInput(name string, input interface{}) interface{} {
+	if r == nil {
+		return input
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	i := r.calls[name]
+	r.calls[name]++
+
+	if i >= len(r.inputs[name]) || r.inputs[name][i] == nil {
+		return input
+	}
+
+	var recorded interface{}
+	if err := json.Unmarshal([]byte(*r.inputs[name][i]), &recorded); err != nil {
+		return input
+	}
+	return recorded
+}
+
+////////
+// Divergence
+////////
+
+// diverge returns the first difference in the recorded States, States in Parallel Branches and
+// Map Iterations run concurrently so they are matched by name and occurrence, not position
+func diverge(recorded []*replayStep, local []*replayStep) *Divergence {
+	locals := map[string][]*replayStep{}
+	for _, step := range local {
+		locals[step.name] = append(locals[step.name], step)
+	}
+
+	occurrences := map[string]int{}
+	for _, step := range recorded {
+		i := occurrences[step.name]
+		occurrences[step.name]++
+
+		if i >= len(locals[step.name]) {
+			return &Divergence{Kind: "path", State: step.name, EventID: step.eventID, Recorded: "entered", Local: "not entered"}
+		}
+
+		if d := divergeResults(step, locals[step.name][i]); d != nil {
+			return d
+		}
+	}
+
+	for _, step := range local {
+		occurrences[step.name]--
+		if occurrences[step.name] < 0 {
+			return &Divergence{Kind: "path", State: step.name, Recorded: "not entered", Local: "entered"}
+		}
+	}
+
+	return nil
+}
+
+// divergeResults compares each Task attempt of a State, outputs by their JSON value and errors by name
+func divergeResults(recorded *replayStep, local *replayStep) *Divergence {
+	for i := 0; i < len(recorded.results) || i < len(local.results); i++ {
+		var r, l *replayResult
+		if i < len(recorded.results) {
+			r = recorded.results[i]
+		}
+		if i < len(local.results) {
+			l = local.results[i]
+		}
+
+		eventID := recorded.eventID
+		if r != nil {
+			eventID = r.eventID
+		}
+
+		if (r != nil && r.err != nil) || (l != nil && l.err != nil) {
+			if r == nil || l == nil || to.Strs(r.err) != to.Strs(l.err) {
+				return &Divergence{Kind: "error", State: recorded.name, EventID: eventID, Recorded: r.String(), Local: l.String()}
+			}
+			continue
+		}
+
+		if r == nil || l == nil || !jsonEqual(r.output, l.output) {
+			return &Divergence{Kind: "output", State: recorded.name, EventID: eventID, Recorded: r.String(), Local: l.String()}
+		}
+	}
+
+	return nil
+}
+
+// divergeEnd compares how the recorded and local Executions ended
+func divergeEnd(recorded []*sfn.HistoryEvent, local []*sfn.HistoryEvent) *Divergence {
+	r, l := executionEnd(recorded), executionEnd(local)
+	if r == nil {
+		return nil // the recorded execution was still running
+	}
+
+	kind := "output"
+	if r.err != nil || (l != nil && l.err != nil) {
+		kind = "error"
+		if l != nil && to.Strs(r.err) == to.Strs(l.err) {
+			return nil
+		}
+	} else if l != nil && jsonEqual(r.output, l.output) {
+		return nil
+	}
+
+	return &Divergence{Kind: kind, EventID: r.eventID, Recorded: r.String(), Local: l.String()}
+}
+
+// executionEnd returns the output or error an Execution ended with
+func executionEnd(events []*sfn.HistoryEvent) *replayResult {
+	for _, event := range events {
+		if details := event.ExecutionSucceededEventDetails; details != nil {
+			return &replayResult{eventID: eventID(event), output: details.Output}
+		}
+
+		if details := event.ExecutionFailedEventDetails; details != nil {
+			return &replayResult{eventID: eventID(event), err: details.Error, cause: details.Cause}
+		}
+	}
+	return nil
+}
+
+// jsonEqual compares JSON strings by value, so the order of keys does not matter
+func jsonEqual(a *string, b *string) bool {
+	if a == nil || b == nil {
+		return a == b
+	}
+
+	var av, bv interface{}
+	if json.Unmarshal([]byte(*a), &av) != nil || json.Unmarshal([]byte(*b), &bv) != nil {
+		return *a == *b
+	}
+	return reflect.DeepEqual(av, bv)
+}
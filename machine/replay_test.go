@@ -0,0 +1,200 @@
+package machine
+
+import (
+	"context"
+	"testing"
+
+	"github.com/cleardataeng/step/utils/to"
+	"github.com/stretchr/testify/assert"
+)
+
+func replayMachine(t *testing.T, handler interface{}) *StateMachine {
+	sm, err := FromJSON([]byte(`{
+    "StartAt": "Choose",
+    "States": {
+      "Choose": {
+        "Type": "Choice",
+        "Choices": [{"Variable": "$.skip", "BooleanEquals": true, "Next": "Done"}],
+        "Default": "Task"
+      },
+      "Task": {
+        "Type": "Task",
+        "Resource": "test",
+        "Parameters": {"count.$": "$.count"},
+        "Next": "Done"
+      },
+      "Done": {"Type": "Pass", "End": true}
+    }
+  }`))
+	assert.NoError(t, err)
+	sm.SetTaskHandler("Task", handler)
+	return sm
+}
+
+func double(_ context.Context, input map[string]interface{}) (interface{}, error) {
+	return map[string]interface{}{"count": input["count"].(float64) * 2}, nil
+}
+
+func Test_Replay_Matches(t *testing.T) {
+	recorded, err := replayMachine(t, double).Execute(map[string]interface{}{"count": 1})
+	assert.NoError(t, err)
+
+	replay, err := replayMachine(t, double).Replay(recorded.History())
+	assert.NoError(t, err)
+	assert.Nil(t, replay.Divergence)
+	assert.Equal(t, map[string]interface{}{"count": 2.0}, replay.Execution.Output)
+}
+
+func Test_Replay_Output(t *testing.T) {
+	recorded, err := replayMachine(t, double).Execute(map[string]interface{}{"count": 1})
+	assert.NoError(t, err)
+
+	triple := func(_ context.Context, input map[string]interface{}) (interface{}, error) {
+		return map[string]interface{}{"count": input["count"].(float64) * 3}, nil
+	}
+
+	replay, err := replayMachine(t, triple).Replay(recorded.History())
+	assert.NoError(t, err)
+	assert.Equal(t, &Divergence{
+		Kind:     "output",
+		State:    "Task",
+		EventID:  7,
+		Recorded: `{"count":2}`,
+		Local:    `{"count":3}`,
+	}, replay.Divergence)
+	assert.Regexp(t, `^output diverged at State "Task" \(event 7\)`, replay.Divergence.String())
+}
+
+func Test_Replay_Recorded_Inputs(t *testing.T) {
+	recorded, err := replayMachine(t, double).Execute(map[string]interface{}{"count": 1})
+	assert.NoError(t, err)
+
+	events := recorded.History()
+	for _, event := range events {
+		if event.LambdaFunctionScheduledEventDetails != nil {
+			event.LambdaFunctionScheduledEventDetails.Input = to.Strp(`{"count": 10}`)
+		}
+	}
+
+	var input interface{}
+	sm := replayMachine(t, func(ctx context.Context, in map[string]interface{}) (interface{}, error) {
+		input = in
+		return double(ctx, in)
+	})
+
+	replay, err := sm.Replay(events)
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]interface{}{"count": 10.0}, input)
+	assert.Equal(t, "output", replay.Divergence.Kind)
+	assert.Equal(t, `{"count":20}`, replay.Divergence.Local)
+}
+
+func Test_Replay_Error(t *testing.T) {
+	failing := func(_ context.Context, input map[string]interface{}) (interface{}, error) {
+		return nil, &TestError{}
+	}
+
+	recorded, err := replayMachine(t, failing).Execute(map[string]interface{}{"count": 1})
+	assert.Error(t, err)
+
+	// Reproduces the failure
+	replay, err := replayMachine(t, failing).Replay(recorded.History())
+	assert.NoError(t, err)
+	assert.Nil(t, replay.Divergence)
+	assert.Error(t, replay.Execution.Error)
+
+	// The local code is fixed
+	replay, err = replayMachine(t, double).Replay(recorded.History())
+	assert.NoError(t, err)
+	assert.Equal(t, "error", replay.Divergence.Kind)
+	assert.Equal(t, "Task", replay.Divergence.State)
+	assert.Regexp(t, "^TestError", replay.Divergence.Recorded)
+	assert.Equal(t, `{"count":2}`, replay.Divergence.Local)
+}
+
+func Test_Replay_Path(t *testing.T) {
+	input := map[string]interface{}{"count": 1, "skip": false}
+	recorded, err := replayMachine(t, double).Execute(input)
+	assert.NoError(t, err)
+
+	sm := replayMachine(t, double)
+	sm.States["Choose"].(*ChoiceState).Choices[0].BooleanEquals = to.Boolp(false)
+
+	replay, err := sm.Replay(recorded.History())
+	assert.NoError(t, err)
+	assert.Equal(t, &Divergence{Kind: "path", State: "Task", EventID: 4, Recorded: "entered", Local: "not entered"}, replay.Divergence)
+
+	// States only entered locally
+	recorded, err = sm.Execute(input)
+	assert.NoError(t, err)
+
+	replay, err = replayMachine(t, double).Replay(recorded.History())
+	assert.NoError(t, err)
+	assert.Equal(t, &Divergence{Kind: "path", State: "Task", Recorded: "not entered", Local: "entered"}, replay.Divergence)
+}
+
+func Test_Replay_Parallel(t *testing.T) {
+	sm, err := FromJSON([]byte(`{
+    "StartAt": "Parallel",
+    "States": {
+      "Parallel": {
+        "Type": "Parallel",
+        "Branches": [
+          {"StartAt": "A", "States": {"A": {"Type": "Task", "Resource": "test", "End": true}}},
+          {"StartAt": "B", "States": {"B": {"Type": "Task", "Resource": "test", "End": true}}}
+        ],
+        "End": true
+      }
+    }
+  }`))
+	assert.NoError(t, err)
+	sm.SetTaskHandler("A", ReturnInputHandler)
+	sm.SetTaskHandler("B", ReturnInputHandler)
+
+	recorded, err := sm.Execute(map[string]interface{}{"a": "b"})
+	assert.NoError(t, err)
+
+	replay, err := sm.Replay(recorded.History())
+	assert.NoError(t, err)
+	assert.Nil(t, replay.Divergence)
+}
+
+func Test_Replay_Invalid(t *testing.T) {
+	_, err := replayMachine(t, double).Replay(nil)
+	assert.Error(t, err)
+}
+
+func Test_HistoryFromJSON(t *testing.T) {
+	recorded, err := replayMachine(t, double).Execute(map[string]interface{}{"count": 1})
+	assert.NoError(t, err)
+
+	raw, err := recorded.HistoryJSON()
+	assert.NoError(t, err)
+
+	events, err := HistoryFromJSON([]byte(raw))
+	assert.NoError(t, err)
+	assert.Equal(t, len(recorded.History()), len(events))
+
+	parsed, err := (&Execution{ExecutionHistory: recorded.ExecutionHistory}).HistoryJSON()
+	assert.NoError(t, err)
+	assert.Equal(t, raw, parsed)
+
+	// The aws cli prints ISO 8601 timestamps
+	events, err = HistoryFromJSON([]byte(`{"events": [{
+    "timestamp": "2020-01-02T03:04:05.678000+00:00",
+    "type": "ExecutionStarted",
+    "id": 1,
+    "previousEventId": 0,
+    "executionStartedEventDetails": {"input": "{\"count\": 1}"}
+  }]}`))
+	assert.NoError(t, err)
+	assert.Equal(t, 2020, events[0].Timestamp.Year())
+
+	replay, err := replayMachine(t, double).Replay(events)
+	assert.NoError(t, err)
+	assert.Equal(t, "path", replay.Divergence.Kind)
+	assert.Equal(t, map[string]interface{}{"count": 2.0}, replay.Execution.Output)
+
+	_, err = HistoryFromJSON([]byte(`not json`))
+	assert.Error(t, err)
+}
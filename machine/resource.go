@@ -0,0 +1,292 @@
+package machine
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"sync"
+
+	"github.com/aws/aws-sdk-go/service/lambda"
+	"github.com/cleardataeng/step/aws"
+	"github.com/cleardataeng/step/handler"
+	"github.com/cleardataeng/step/utils/to"
+)
+
+// Resource Resolvers route a Task to an Executor by its Resource ARN,
+// Tasks with a TaskHandler set call it instead.
+
+const lambdaInvokeResource = "arn:aws:states:::lambda:invoke"
+
+// Executor runs a Task Resource with the Tasks effective input, returning its result
+type Executor interface {
+	Execute(ctx context.Context, resource string, input interface{}) (interface{}, error)
+}
+
+// ExecutorFunc is a function Executor
+type ExecutorFunc func(ctx context.Context, resource string, input interface{}) (interface{}, error)
+
+func (fn ExecutorFunc) Execute(ctx context.Context, resource string, input interface{}) (interface{}, error) {
+	return fn(ctx, resource, input)
+}
+
+// ResourceResolver returns the Executor for a Task Resource
+type ResourceResolver interface {
+	Resolve(resource string) (Executor, error)
+}
+
+type resolverKey struct{}
+
+func withResolver(ctx context.Context, resolver ResourceResolver) context.Context {
+	return context.WithValue(ctx, resolverKey{}, resolver)
+}
+
+// resolverFrom returns the ResourceResolver of the current Execution, or nil
+func resolverFrom(ctx context.Context) ResourceResolver {
+	if ctx == nil {
+		return nil
+	}
+	resolver, _ := ctx.Value(resolverKey{}).(ResourceResolver)
+	return resolver
+}
+
+////////
+// Resources
+////////
+
+// Resources is a ResourceResolver routing Resource ARN patterns to Executors,
+// the first pattern to match wins. A * in a pattern matches any characters.
+//
+// {{aws_region}}, {{aws_account}} and {{lambda_name}} in a Resource are replaced
+// with Region, AccountID and LambdaName before matching.
+type Resources struct {
+	Region     string
+	AccountID  string
+	LambdaName string
+
+	routes []resourceRoute
+}
+
+type resourceRoute struct {
+	pattern  string
+	executor Executor
+}
+
+// NewResources returns Resources with the default local Region and AccountID
+func NewResources() *Resources {
+	return &Resources{Region: "us-east-1", AccountID: "000000000000"}
+}
+
+// Handle routes Resources matching pattern to executor
+func (r *Resources) Handle(pattern string, executor Executor) *Resources {
+	r.routes = append(r.routes, resourceRoute{pattern, executor})
+	return r
+}
+
+// HandleFunc routes Resources matching pattern to fn
+func (r *Resources) HandleFunc(pattern string, fn func(ctx context.Context, resource string, input interface{}) (interface{}, error)) *Resources {
+	return r.Handle(pattern, ExecutorFunc(fn))
+}
+
+func (r *Resources) Resolve(resource string) (Executor, error) {
+	resource = r.interpolate(resource)
+
+	for _, route := range r.routes {
+		if stringMatches(resource, r.interpolate(route.pattern)) {
+			return interpolatedExecutor{resource, route.executor}, nil
+		}
+	}
+
+	return nil, fmt.Errorf("No Executor for Resource %q", resource)
+}
+
+func (r *Resources) interpolate(resource string) string {
+	for template, value := range map[string]string{
+		"{{aws_region}}":  r.Region,
+		"{{aws_account}}": r.AccountID,
+		"{{lambda_name}}": r.LambdaName,
+	} {
+		if value != "" {
+			resource = strings.Replace(resource, template, value, -1)
+		}
+	}
+	return resource
+}
+
+// interpolatedExecutor calls the Executor with the interpolated Resource
+type interpolatedExecutor struct {
+	resource string
+	executor Executor
+}
+
+func (e interpolatedExecutor) Execute(ctx context.Context, _ string, input interface{}) (interface{}, error) {
+	return e.executor.Execute(ctx, e.resource, input)
+}
+
+////////
+// Lambda Executors
+////////
+
+// GoHandlers is an Executor calling in process Go handlers by Lambda function name,
+// for Resources like arn:aws:lambda:<region>:<account>:function:<name> and arn:aws:states:::lambda:invoke
+type GoHandlers map[string]interface{}
+
+func (h GoHandlers) Execute(ctx context.Context, resource string, input interface{}) (interface{}, error) {
+	name, payload, err := lambdaInvocation(resource, input)
+	if err != nil {
+		return nil, err
+	}
+
+	fn, ok := h[name]
+	if !ok {
+		return nil, fmt.Errorf("No Go handler for Lambda %q", name)
+	}
+
+	result, err := handler.CallHandlerFunction(fn, lambdaContext(ctx, name), payload)
+	if err != nil {
+		return nil, err
+	}
+
+	return lambdaResult(resource, result)
+}
+
+// LambdaInvoker is an Executor invoking Lambda functions with the Lambda API,
+// a function error fails the Task with its errorType and errorMessage
+type LambdaInvoker struct {
+	Client aws.LambdaAPI
+}
+
+func (l *LambdaInvoker) Execute(ctx context.Context, resource string, input interface{}) (interface{}, error) {
+	name, payload, err := lambdaInvocation(resource, input)
+	if err != nil {
+		return nil, err
+	}
+
+	raw, err := json.Marshal(payload)
+	if err != nil {
+		return nil, err
+	}
+
+	out, err := l.Client.InvokeWithContext(ctx, &lambda.InvokeInput{
+		FunctionName: &name,
+		Payload:      raw,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if out.FunctionError != nil {
+		return nil, lambdaFunctionError(out.Payload, *out.FunctionError)
+	}
+
+	var result interface{}
+	if len(out.Payload) > 0 {
+		if err := json.Unmarshal(out.Payload, &result); err != nil {
+			return nil, err
+		}
+	}
+
+	return lambdaResult(resource, result)
+}
+
+// lambdaInvocation returns the function name and payload,
+// arn:aws:states:::lambda:invoke takes them from the FunctionName and Payload Parameters
+func lambdaInvocation(resource string, input interface{}) (string, interface{}, error) {
+	if !isLambdaInvoke(resource) {
+		return lambdaName(resource), input, nil
+	}
+
+	params, _ := input.(map[string]interface{})
+	name, _ := params["FunctionName"].(string)
+	if name == "" {
+		return "", nil, fmt.Errorf("%v requires FunctionName", lambdaInvokeResource)
+	}
+
+	return lambdaName(name), params["Payload"], nil
+}
+
+func isLambdaInvoke(resource string) bool {
+	return strings.HasPrefix(resource, lambdaInvokeResource)
+}
+
+// lambdaName returns the function name from a name, function ARN or partial ARN
+func lambdaName(nameOrArn string) string {
+	parts := strings.Split(nameOrArn, ":")
+	for i, part := range parts {
+		if part == "function" && i+1 < len(parts) {
+			return parts[i+1]
+		}
+	}
+	return nameOrArn
+}
+
+// lambdaResult wraps the result like the lambda:invoke integration does
+func lambdaResult(resource string, result interface{}) (interface{}, error) {
+	if !isLambdaInvoke(resource) {
+		return result, nil
+	}
+
+	return map[string]interface{}{
+		"ExecutedVersion": "$LATEST",
+		"Payload":         result,
+		"StatusCode":      200.0,
+	}, nil
+}
+
+func lambdaFunctionError(payload []byte, functionError string) error {
+	var body struct {
+		ErrorType    string `json:"errorType"`
+		ErrorMessage string `json:"errorMessage"`
+	}
+
+	if err := json.Unmarshal(payload, &body); err != nil || body.ErrorType == "" {
+		return &statesError{"Lambda.Unknown", fmt.Sprintf("%v %v", functionError, string(payload))}
+	}
+
+	return &statesError{body.ErrorType, body.ErrorMessage}
+}
+
+////////
+// Fakes
+////////
+
+// Fake is an Executor for service integrations that returns Output or Error,
+// recording every call so tests can assert on them
+type Fake struct {
+	Output interface{}
+	Error  error
+
+	mu    sync.Mutex
+	calls []FakeCall
+}
+
+// FakeCall is a Resource and input a Fake was called with
+type FakeCall struct {
+	Resource string
+	Input    interface{}
+}
+
+func (f *Fake) Execute(_ context.Context, resource string, input interface{}) (interface{}, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	// Copy the input so later changes to it do not change the recorded call
+	recorded, err := to.FromJSON(input)
+	if err != nil {
+		return nil, err
+	}
+
+	f.calls = append(f.calls, FakeCall{resource, recorded})
+	if f.Error != nil {
+		return nil, f.Error
+	}
+
+	return to.FromJSON(f.Output)
+}
+
+// Calls returns the calls made to the Fake in order
+func (f *Fake) Calls() []FakeCall {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return append([]FakeCall{}, f.calls...)
+}
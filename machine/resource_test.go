@@ -0,0 +1,192 @@
+package machine
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/service/lambda"
+	"github.com/cleardataeng/step/aws/mocks"
+	"github.com/cleardataeng/step/utils/to"
+	"github.com/stretchr/testify/assert"
+)
+
+func resourceMachine(t *testing.T) *StateMachine {
+	sm, err := FromJSON([]byte(`{
+    "StartAt": "Create",
+    "States": {
+      "Create": {
+        "Type": "Task",
+        "Resource": "arn:aws:lambda:{{aws_region}}:{{aws_account}}:function:{{lambda_name}}",
+        "ResultPath": "$.created",
+        "Next": "Invoke"
+      },
+      "Invoke": {
+        "Type": "Task",
+        "Resource": "arn:aws:states:::lambda:invoke",
+        "Parameters": {"FunctionName": "notify", "Payload": {"id.$": "$.created.id"}},
+        "ResultSelector": {"sent.$": "$.Payload.sent"},
+        "ResultPath": "$.notify",
+        "Next": "Save"
+      },
+      "Save": {
+        "Type": "Task",
+        "Resource": "arn:aws:states:::dynamodb:putItem",
+        "Parameters": {"TableName": "items", "Item": {"id": {"S.$": "$.notify.sent"}}},
+        "ResultPath": "$.saved",
+        "End": true
+      }
+    }
+  }`))
+	assert.NoError(t, err)
+	return sm
+}
+
+func Test_Resources_Execute(t *testing.T) {
+	sm := resourceMachine(t)
+
+	dynamodb := &Fake{Output: map[string]interface{}{}}
+
+	resources := NewResources()
+	resources.LambdaName = "create"
+	resources.
+		Handle("arn:aws:lambda:*", GoHandlers{
+			"create": func(ctx context.Context, input map[string]interface{}) (interface{}, error) {
+				// The handler gets a Lambda context for its function
+				arn, err := to.LambdaArnFromContext(ctx)
+				assert.NoError(t, err)
+				assert.Regexp(t, "function:create$", arn)
+				return map[string]interface{}{"id": "a"}, nil
+			},
+		}).
+		Handle("arn:aws:states:::lambda:invoke", GoHandlers{
+			"notify": func(_ context.Context, input map[string]interface{}) (interface{}, error) {
+				return map[string]interface{}{"sent": input["id"]}, nil
+			},
+		}).
+		Handle("arn:aws:states:::dynamodb:*", dynamodb)
+
+	sm.SetResourceResolver(resources)
+
+	exec, err := sm.Execute(map[string]interface{}{})
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]interface{}{}, exec.Output["saved"])
+
+	assert.Equal(t, []FakeCall{{
+		Resource: "arn:aws:states:::dynamodb:putItem",
+		Input: map[string]interface{}{
+			"TableName": "items",
+			"Item":      map[string]interface{}{"id": map[string]interface{}{"S": "a"}},
+		},
+	}}, dynamodb.Calls())
+}
+
+func Test_Resources_TaskHandler_Precedence(t *testing.T) {
+	sm := resourceMachine(t)
+
+	fake := &Fake{Output: map[string]interface{}{"id": "fake", "Payload": map[string]interface{}{"sent": "fake"}}}
+	resources := NewResources().Handle("*", fake)
+	sm.SetResourceResolver(resources)
+	sm.SetTaskHandler("Create", func(_ context.Context, _ interface{}) (interface{}, error) {
+		return map[string]interface{}{"id": "handler"}, nil
+	})
+
+	exec, err := sm.Execute(map[string]interface{}{})
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]interface{}{"id": "fake", "Payload": map[string]interface{}{"sent": "fake"}}, exec.Output["saved"])
+
+	// Create called its handler, only Invoke and Save called the Fake
+	calls := fake.Calls()
+	assert.Equal(t, 2, len(calls))
+	assert.Equal(t, map[string]interface{}{"id": "handler"}, calls[0].Input.(map[string]interface{})["Payload"])
+}
+
+func Test_Resources_Unresolved(t *testing.T) {
+	sm := resourceMachine(t)
+	sm.SetResourceResolver(NewResources().Handle("arn:aws:lambda:*", GoHandlers{}))
+
+	_, err := sm.Execute(map[string]interface{}{})
+	assert.Error(t, err)
+	assert.Regexp(t, `No Go handler for Lambda "\{\{lambda_name\}\}"`, err.Error())
+
+	resources := NewResources()
+	resources.LambdaName = "create"
+	sm.SetResourceResolver(resources)
+
+	_, err = sm.Execute(map[string]interface{}{})
+	assert.Error(t, err)
+	assert.Regexp(t, `No Executor for Resource "arn:aws:lambda:us-east-1:000000000000:function:create"`, err.Error())
+}
+
+func Test_Resources_Fake_Error_Caught(t *testing.T) {
+	sm, err := FromJSON([]byte(`{
+    "StartAt": "Send",
+    "States": {
+      "Send": {
+        "Type": "Task",
+        "Resource": "arn:aws:states:::sqs:sendMessage",
+        "Catch": [{"ErrorEquals": ["SQS.QueueDoesNotExist"], "Next": "Caught"}],
+        "End": true
+      },
+      "Caught": {"Type": "Pass", "End": true}
+    }
+  }`))
+	assert.NoError(t, err)
+
+	sm.SetResourceResolver(NewResources().Handle("arn:aws:states:::sqs:*", &Fake{
+		Error: &statesError{"SQS.QueueDoesNotExist", "no queue"},
+	}))
+
+	exec, err := sm.Execute(map[string]interface{}{})
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"Send", "Caught"}, exec.Path())
+}
+
+func Test_LambdaInvoker(t *testing.T) {
+	client := &mocks.MockLambdaClient{InvokeResp: &lambda.InvokeOutput{Payload: []byte(`{"sent": "a"}`)}}
+	invoker := &LambdaInvoker{Client: client}
+
+	result, err := invoker.Execute(nil, "arn:aws:lambda:us-east-1:000000000000:function:notify", map[string]interface{}{"id": "a"})
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]interface{}{"sent": "a"}, result)
+	assert.Equal(t, "notify", *client.InvokeInputs[0].FunctionName)
+	assert.JSONEq(t, `{"id": "a"}`, string(client.InvokeInputs[0].Payload))
+
+	// lambda:invoke uses FunctionName and Payload and wraps the result
+	result, err = invoker.Execute(nil, "arn:aws:states:::lambda:invoke", map[string]interface{}{
+		"FunctionName": "arn:aws:lambda:us-east-1:000000000000:function:other:live",
+		"Payload":      map[string]interface{}{"id": "b"},
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]interface{}{"sent": "a"}, result.(map[string]interface{})["Payload"])
+	assert.Equal(t, "other", *client.InvokeInputs[1].FunctionName)
+	assert.JSONEq(t, `{"id": "b"}`, string(client.InvokeInputs[1].Payload))
+
+	_, err = invoker.Execute(nil, "arn:aws:states:::lambda:invoke", map[string]interface{}{})
+	assert.Error(t, err)
+
+	// Function errors keep the errorType as the error name
+	client.InvokeResp = &lambda.InvokeOutput{
+		FunctionError: to.Strp("Unhandled"),
+		Payload:       []byte(`{"errorType": "NotFound", "errorMessage": "missing"}`),
+	}
+	_, err = invoker.Execute(nil, "arn:aws:lambda:us-east-1:000000000000:function:notify", map[string]interface{}{})
+	assert.Error(t, err)
+	assert.Equal(t, "NotFound", errorName(err))
+	assert.Equal(t, "missing", errorCause(err))
+
+	client.InvokeError = fmt.Errorf("throttled")
+	_, err = invoker.Execute(nil, "arn:aws:lambda:us-east-1:000000000000:function:notify", map[string]interface{}{})
+	assert.Error(t, err)
+}
+
+func Test_lambdaName(t *testing.T) {
+	for nameOrArn, expected := range map[string]string{
+		"notify": "notify",
+		"arn:aws:lambda:us-east-1:000000000000:function:notify":      "notify",
+		"arn:aws:lambda:us-east-1:000000000000:function:notify:live": "notify",
+		"000000000000:function:notify":                               "notify",
+	} {
+		assert.Equal(t, expected, lambdaName(nameOrArn), nameOrArn)
+	}
+}
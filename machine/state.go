@@ -2,9 +2,15 @@ package machine
 
 import (
 	"context"
+	"encoding/json"
+	goerrors "errors"
 	"fmt"
+	"math"
+	"math/rand"
 	"strings"
+	"time"
 
+	"github.com/cleardataeng/step/errors"
 	"github.com/cleardataeng/step/jsonpath"
 	"github.com/cleardataeng/step/utils/is"
 	"github.com/cleardataeng/step/utils/to"
@@ -40,11 +46,135 @@ type Retrier struct {
 	IntervalSeconds *int      `json:",omitempty"`
 	MaxAttempts     *int      `json:",omitempty"`
 	BackoffRate     *float64  `json:",omitempty"`
-	attempts        int       `json:"-"` // Used to remember attempts
+	MaxDelaySeconds *int      `json:",omitempty"`
+	JitterStrategy  *string   `json:",omitempty"`
+}
+
+// retryJitter returns the random fraction of the interval waited with JitterStrategy FULL
+var retryJitter = rand.Float64
+
+func (r *Retrier) maxAttempts() int {
+	if r.MaxAttempts == nil {
+		// Default retries is 3
+		return 3
+	}
+	return *r.MaxAttempts
+}
+
+// interval returns how long to wait before the retry attempt (starting at 0) like Step Functions,
+// IntervalSeconds * BackoffRate^attempt capped at MaxDelaySeconds, with JitterStrategy FULL a random part of that
+func (r *Retrier) interval(attempt int) time.Duration {
+	intervalSeconds := 1
+	if r.IntervalSeconds != nil {
+		intervalSeconds = *r.IntervalSeconds
+	}
+
+	backoffRate := 2.0
+	if r.BackoffRate != nil {
+		backoffRate = *r.BackoffRate
+	}
+
+	seconds := float64(intervalSeconds) * math.Pow(backoffRate, float64(attempt))
+	if r.MaxDelaySeconds != nil && seconds > float64(*r.MaxDelaySeconds) {
+		seconds = float64(*r.MaxDelaySeconds)
+	}
+
+	if r.JitterStrategy != nil && *r.JitterStrategy == "FULL" {
+		seconds = seconds * retryJitter()
+	}
+
+	return time.Duration(seconds * float64(time.Second))
+}
+
+// statesError is an error raised by the interpreter itself, with a States.* error name
+type statesError struct {
+	name  string
+	cause string
+}
+
+func (e *statesError) Error() string {
+	return fmt.Sprintf("%v: %v", e.name, e.cause)
+}
+
+func (e *statesError) ErrorName() string {
+	return e.name
+}
+
+func (e *statesError) ErrorCause() string {
+	return e.cause
+}
+
+// NewError returns an error with an ASL error name and cause,
+// Retry and Catch ErrorEquals match its name
+func NewError(name string, cause string) error {
+	return &statesError{name, cause}
+}
+
+// stateError prefixes the error of a State with the State, keeping its name and cause
+type stateError struct {
+	prefix string
+	err    error
+}
+
+func (e *stateError) Error() string {
+	return fmt.Sprintf("%v %v", e.prefix, e.err.Error())
+}
+
+func (e *stateError) Unwrap() error {
+	return e.err
+}
+
+func (e *stateError) ErrorName() string {
+	return errorName(e.err)
+}
+
+func (e *stateError) ErrorCause() string {
+	return errorCause(e.err)
+}
+
+// stateFailure is the error of a Task handler or Parallel Branch, ErrorEquals match its own name
+// and the wildcard of the failure, States.TaskFailed or States.BranchFailed
+type stateFailure struct {
+	wildcard string
+	err      error
+}
+
+func (e *stateFailure) Error() string {
+	return e.err.Error()
+}
+
+func (e *stateFailure) Unwrap() error {
+	return e.err
+}
+
+func (e *stateFailure) ErrorName() string {
+	return errorName(e.err)
+}
+
+func (e *stateFailure) ErrorCause() string {
+	return errorCause(e.err)
+}
+
+// failureWildcard returns the wildcard of the outermost stateFailure of err
+func failureWildcard(err error) string {
+	var failure *stateFailure
+	if goerrors.As(err, &failure) {
+		return failure.wildcard
+	}
+	return ""
+}
+
+// errorName is the ASL error name of err, see errors.StepError
+func errorName(err error) string {
+	return errors.ErrorName(err)
+}
+
+func errorCause(err error) string {
+	return errors.ErrorCause(err)
 }
 
 func errorOutputFromError(err error) map[string]interface{} {
-	return errorOutput(to.Strp(to.ErrorType(err)), to.Strp(err.Error()))
+	return errorOutput(to.Strp(errorName(err)), to.Strp(errorCause(err)))
 }
 
 func errorOutput(err *string, cause *string) map[string]interface{} {
@@ -62,11 +192,30 @@ func errorOutput(err *string, cause *string) map[string]interface{} {
 	}
 }
 
+// errorIncluded returns whether ErrorEquals matches err, States.ALL matches every error except
+// States.DataLimitExceeded, and States.Runtime errors are never retried or caught
 func errorIncluded(errorEquals []*string, err error) bool {
-	error_type := to.ErrorType(err)
+	error_type := errorName(err)
+	if error_type == "States.Runtime" {
+		return false
+	}
 
 	for _, et := range errorEquals {
-		if *et == "States.ALL" || *et == error_type {
+		if *et == error_type {
+			return true
+		}
+
+		if *et == "States.ALL" && error_type != "States.DataLimitExceeded" {
+			return true
+		}
+
+		// States.Timeout also covers missed heartbeats
+		if *et == "States.Timeout" && error_type == "States.HeartbeatTimeout" {
+			return true
+		}
+
+		// States.TaskFailed and States.BranchFailed match the failures of a Task or Parallel Branch
+		if *et == failureWildcard(err) {
 			return true
 		}
 	}
@@ -84,6 +233,14 @@ func (s *stateStr) SetName(name *string) {
 	s.name = name
 }
 
+// defaultType sets the Type of a state only if it differs,
+// so validating a shared StateMachine from many executions does not write to it
+func defaultType(s State, t string) {
+	if st := s.GetType(); st == nil || *st != t {
+		s.SetType(&t)
+	}
+}
+
 func nextState(next *string, end *bool) *string {
 	if next != nil {
 		return next
@@ -98,38 +255,47 @@ func nextState(next *string, end *bool) *string {
 // Shared Methods
 //////
 
-func processRetrier(retryName *string, retriers []*Retrier, exec ExecutionFn) ExecutionFn {
+// processRetrier retries the State inside its execution, so a retry is an attempt of the same State
+// and not a new transition. Each Retrier counts its own attempts
+func processRetrier(retriers []*Retrier, exec ExecutionFn) ExecutionFn {
 	return func(ctx context.Context, input interface{}) (interface{}, *string, error) {
-		// Simulate Retry once, not actually waiting
-		output, next, err := exec(ctx, input)
-		if len(retriers) == 0 || err == nil {
-			return output, next, err
-		}
+		attempts := map[*Retrier]int{}
 
-		// Is Error in a Retrier
-		for _, retrier := range retriers {
-			// If the error type is defined in the retrier AND we have not attempted the retry yet
-			if retrier.MaxAttempts == nil {
-				// Default retries is 3
-				retrier.MaxAttempts = to.Intp(3)
+		for retryCount := 0; ; retryCount++ {
+			output, next, err := exec(withRetryCount(ctx, retryCount), input)
+			if err == nil {
+				return output, next, nil
 			}
 
 			// Match on first retrier
-			if errorIncluded(retrier.ErrorEquals, err) {
-				if retrier.attempts < *retrier.MaxAttempts {
-					retrier.attempts++
-					// Returns the name of the state to the state-machine to re-execute
-					return input, retryName, nil
-				} else {
-					// Finished retrying so continue
-					return output, next, err
-				}
+			retrier := matchingRetrier(retriers, err)
+			if retrier == nil || attempts[retrier] >= retrier.maxAttempts() {
+				return output, next, err
 			}
+
+			event := newStateEvent(currentState(ctx), input)
+			event.Error, event.Attempt = err, attempts[retrier]+1
+			if err := hooksFrom(ctx).onRetry(ctx, event); err != nil {
+				return nil, nil, err
+			}
+
+			// Wait the backoff interval on the executions clock
+			if err := clockFrom(ctx).Sleep(ctx, retrier.interval(attempts[retrier])); err != nil {
+				return nil, nil, err
+			}
+			attempts[retrier]++
 		}
+	}
+}
 
-		// Otherwise, just return
-		return output, next, err
+// matchingRetrier returns the first Retrier that includes the error
+func matchingRetrier(retriers []*Retrier, err error) *Retrier {
+	for _, retrier := range retriers {
+		if errorIncluded(retrier.ErrorEquals, err) {
+			return retrier
+		}
 	}
+	return nil
 }
 
 func processCatcher(catchers []*Catcher, exec ExecutionFn) ExecutionFn {
@@ -140,13 +306,28 @@ func processCatcher(catchers []*Catcher, exec ExecutionFn) ExecutionFn {
 			return output, next, err
 		}
 
+		// Aborted Executions cannot be caught
+		hooks := hooksFrom(ctx)
+		if hooks.abortedErr() != nil {
+			return output, next, err
+		}
+
 		for _, catcher := range catchers {
 			if errorIncluded(catcher.ErrorEquals, err) {
 
 				eo := errorOutputFromError(err)
-				output, err := catcher.ResultPath.Set(input, eo)
+				output, setErr := catcher.ResultPath.Set(input, eo)
+				if setErr != nil {
+					return output, catcher.Next, &statesError{"States.ResultPathMatchFailure", setErr.Error()}
+				}
 
-				return output, catcher.Next, err
+				event := newStateEvent(currentState(ctx), input)
+				event.Output, event.Next, event.Error = output, catcher.Next, err
+				if err := hooks.onCatch(ctx, event); err != nil {
+					return nil, nil, err
+				}
+
+				return event.Output, event.Next, nil
 			}
 		}
 
@@ -160,19 +341,22 @@ func processError(s State, exec ExecutionFn) ExecutionFn {
 		output, next, err := exec(ctx, input)
 
 		if err != nil {
-			return nil, nil, fmt.Errorf("%v %v", errorPrefix(s), err.Error())
+			return nil, nil, &stateError{errorPrefix(s), err}
 		}
 		return output, next, nil
 	}
 }
+
 func inputOutput(inputPath *jsonpath.Path, outputPath *jsonpath.Path, exec ExecutionFn) ExecutionFn {
 	return func(ctx context.Context, input interface{}) (interface{}, *string, error) {
 		input, err := inputPath.Get(input)
 
 		if err != nil {
-			return nil, nil, fmt.Errorf("Input Error: %v", err)
+			return nil, nil, &statesError{"States.Runtime", fmt.Sprintf("Input Error: %v", err)}
 		}
 
+		input = hooksFrom(ctx).stage(ctx, "InputPath", input)
+
 		output, next, err := exec(ctx, input)
 
 		if err != nil {
@@ -182,29 +366,57 @@ func inputOutput(inputPath *jsonpath.Path, outputPath *jsonpath.Path, exec Execu
 		output, err = outputPath.Get(output)
 
 		if err != nil {
-			return nil, nil, fmt.Errorf("Output Error: %v", err)
+			return nil, nil, &statesError{"States.Runtime", fmt.Sprintf("Output Error: %v", err)}
+		}
+
+		if err := dataLimit(output); err != nil {
+			return nil, nil, err
 		}
 
+		output = hooksFrom(ctx).stage(ctx, "OutputPath", output)
+
 		return output, next, nil
 	}
 }
 
+// MaxPayloadBytes is the largest output of a State, a larger output fails with States.DataLimitExceeded
+const MaxPayloadBytes = 256 * 1024
+
+func dataLimit(output interface{}) error {
+	raw, err := json.Marshal(output)
+	if err != nil {
+		return &statesError{"States.Runtime", fmt.Sprintf("Output Error: %v", err)}
+	}
+
+	if len(raw) > MaxPayloadBytes {
+		return &statesError{
+			"States.DataLimitExceeded",
+			fmt.Sprintf("output of %v bytes exceeds the maximum of %v bytes", len(raw), MaxPayloadBytes),
+		}
+	}
+	return nil
+}
+
 func withParams(params interface{}, exec ExecutionFn) ExecutionFn {
 	return func(ctx context.Context, input interface{}) (interface{}, *string, error) {
 		if params == nil {
 			return exec(ctx, input)
 		}
 		// Loop through the input replace values with JSON paths
-		input, err := replaceParamsJSONPath(params, input)
+		input, err := replaceParamsJSONPath(ctx, params, input)
 		if err != nil {
 			return nil, nil, err
 		}
 
+		input = hooksFrom(ctx).stage(ctx, "Parameters", input)
+
 		return exec(ctx, input)
 	}
 }
 
-func replaceParamsJSONPath(params interface{}, input interface{}) (interface{}, error) {
+// replaceParamsJSONPath replaces keys ending in ".$" with the value of their JSON path or Intrinsic Function
+// paths starting with "$$" are resolved against the Context Object
+func replaceParamsJSONPath(ctx context.Context, params interface{}, input interface{}) (interface{}, error) {
 
 	switch params.(type) {
 	case map[string]interface{}:
@@ -214,24 +426,19 @@ func replaceParamsJSONPath(params interface{}, input interface{}) (interface{},
 		for key, value := range params.(map[string]interface{}) {
 			if strings.HasSuffix(key, ".$") {
 				key = key[:len(key)-len(".$")]
-				// value must be a JSON path string!
+				// value must be a JSON path or Intrinsic Function string!
 				switch value.(type) {
 				case string:
 				default:
-					return nil, fmt.Errorf("value to key %q is not string", key)
-				}
-				valueStr := value.(string)
-				path, err := jsonpath.NewPath(valueStr)
-				if err != nil {
-					return nil, err
+					return nil, &statesError{"States.ParameterPathFailure", fmt.Sprintf("value to key %q is not string", key)}
 				}
-				newValue, err := path.Get(input)
+				newValue, err := evaluateParamValue(ctx, value.(string), input)
 				if err != nil {
 					return nil, err
 				}
 				newParams[key] = newValue
 			} else {
-				newValue, err := replaceParamsJSONPath(value, input)
+				newValue, err := replaceParamsJSONPath(ctx, value, input)
 				if err != nil {
 					return nil, err
 				}
@@ -243,6 +450,51 @@ func replaceParamsJSONPath(params interface{}, input interface{}) (interface{},
 	return params, nil
 }
 
+// withResultSelector reshapes the raw result of a state, with the same semantics as Parameters
+func withResultSelector(resultSelector interface{}, exec ExecutionFn) ExecutionFn {
+	return func(ctx context.Context, input interface{}) (interface{}, *string, error) {
+		result, next, err := exec(ctx, input)
+
+		if err != nil || resultSelector == nil {
+			return result, next, err
+		}
+
+		result, err = replaceParamsJSONPath(ctx, resultSelector, result)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		result = hooksFrom(ctx).stage(ctx, "ResultSelector", result)
+
+		return result, next, nil
+	}
+}
+
+// evaluateParamValue returns the value for a ".$" key, either a JSON path or an Intrinsic Function
+func evaluateParamValue(ctx context.Context, value string, input interface{}) (interface{}, error) {
+	resolve := func(pathStr string) (interface{}, error) {
+		path, err := jsonpath.NewPath(pathStr)
+		if err != nil {
+			return nil, err
+		}
+		return path.Get(pathInput(ctx, path, input))
+	}
+
+	if isIntrinsic(value) {
+		result, err := evaluateIntrinsic(value, resolve)
+		if err != nil {
+			return nil, &statesError{"States.IntrinsicFailure", err.Error()}
+		}
+		return result, nil
+	}
+
+	result, err := resolve(value)
+	if err != nil {
+		return nil, &statesError{"States.ParameterPathFailure", fmt.Sprintf("path %q %v", value, err)}
+	}
+	return result, nil
+}
+
 func result(resultPath *jsonpath.Path, exec ExecutionFn) ExecutionFn {
 	return func(ctx context.Context, input interface{}) (interface{}, *string, error) {
 		result, next, err := exec(ctx, input)
@@ -251,17 +503,22 @@ func result(resultPath *jsonpath.Path, exec ExecutionFn) ExecutionFn {
 			return nil, nil, err
 		}
 
+		// The result replaces the input, whatever its type
+		if result != nil && (resultPath == nil || resultPath.String() == "$") {
+			return hooksFrom(ctx).stage(ctx, "ResultPath", result), next, nil
+		}
+
 		if result != nil {
 			input, err := resultPath.Set(input, result)
 
 			if err != nil {
-				return nil, nil, err
+				return nil, nil, &statesError{"States.ResultPathMatchFailure", err.Error()}
 			}
 
-			return input, next, nil
+			return hooksFrom(ctx).stage(ctx, "ResultPath", input), next, nil
 		}
 
-		return input, next, nil
+		return hooksFrom(ctx).stage(ctx, "ResultPath", input), next, nil
 	}
 }
 
@@ -305,6 +562,15 @@ func ValidateNameAndType(s State) error {
 	return nil
 }
 
+// resultPathValid ResultPath must be a Reference Path, i.e. identify a single node
+func resultPathValid(resultPath *jsonpath.Path) error {
+	if !resultPath.IsReferencePath() {
+		return fmt.Errorf("ResultPath %v must be a Reference Path", resultPath)
+	}
+
+	return nil
+}
+
 func retryValid(retry []*Retrier) error {
 	if retry == nil {
 		return nil
@@ -314,6 +580,35 @@ func retryValid(retry []*Retrier) error {
 		if err := errorEqualsValid(r.ErrorEquals, len(retry)-1 == i); err != nil {
 			return err
 		}
+
+		if err := r.validate(); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// validate checks the values are in the ranges Step Functions allows
+func (r *Retrier) validate() error {
+	if r.IntervalSeconds != nil && (*r.IntervalSeconds < 1 || *r.IntervalSeconds > 99999999) {
+		return fmt.Errorf("Retrier IntervalSeconds must be between 1 and 99999999")
+	}
+
+	if r.MaxAttempts != nil && (*r.MaxAttempts < 0 || *r.MaxAttempts > 99999999) {
+		return fmt.Errorf("Retrier MaxAttempts must be between 0 and 99999999")
+	}
+
+	if r.BackoffRate != nil && *r.BackoffRate < 1.0 {
+		return fmt.Errorf("Retrier BackoffRate must be at least 1.0")
+	}
+
+	if r.MaxDelaySeconds != nil && (*r.MaxDelaySeconds < 1 || *r.MaxDelaySeconds > 31622400) {
+		return fmt.Errorf("Retrier MaxDelaySeconds must be between 1 and 31622400")
+	}
+
+	if r.JitterStrategy != nil && *r.JitterStrategy != "FULL" && *r.JitterStrategy != "NONE" {
+		return fmt.Errorf("Retrier JitterStrategy must be FULL or NONE")
 	}
 
 	return nil
@@ -332,6 +627,10 @@ func catchValid(catch []*Catcher) error {
 		if is.EmptyStr(c.Next) {
 			return fmt.Errorf("Catcher requires Next")
 		}
+
+		if err := resultPathValid(c.ResultPath); err != nil {
+			return err
+		}
 	}
 	return nil
 }
@@ -348,11 +647,17 @@ func errorEqualsValid(errorEquals []*string, last bool) error {
 			case
 				"States.ALL",
 				"States.Timeout",
+				"States.HeartbeatTimeout",
 				"States.TaskFailed",
 				"States.Permissions",
 				"States.ResultPathMatchFailure",
+				"States.ParameterPathFailure",
 				"States.BranchFailed",
-				"States.NoChoiceMatched":
+				"States.NoChoiceMatched",
+				"States.IntrinsicFailure",
+				"States.DataLimitExceeded",
+				"States.Runtime",
+				"States.ExceedToleratedFailureThreshold":
 			default:
 				return fmt.Errorf("Unknown States.* error found %q", *e)
 			}
@@ -1,8 +1,11 @@
 package machine
 
 import (
+	"context"
 	"encoding/json"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/cleardataeng/step/utils/to"
 	"github.com/stretchr/testify/assert"
@@ -13,6 +16,13 @@ type stateTestData struct {
 	Output map[string]interface{}
 	Error  *string
 	Next   *string
+	Ctx    context.Context // shares an execution between calls, e.g. for retries
+}
+
+// executionContext returns a context for a fresh Execution, retries wait on a virtual clock
+func executionContext() context.Context {
+	ctx := withExecution(context.Background(), &Execution{})
+	return withClock(ctx, NewVirtualClock(time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)))
 }
 
 func testState(state State, std stateTestData, t *testing.T) {
@@ -25,7 +35,7 @@ func testState(state State, std stateTestData, t *testing.T) {
 		std.Input = map[string]interface{}{}
 	}
 
-	output, next, err := state.Execute(nil, std.Input)
+	output, next, err := state.Execute(std.Ctx, std.Input)
 
 	// expecting error?
 	if std.Error != nil {
@@ -95,3 +105,204 @@ func parseMapState(b []byte, t *testing.T) *MapState {
 	p.SetType(to.Strp("Map"))
 	return &p
 }
+
+func parseParallelState(b []byte, t *testing.T) *ParallelState {
+	var p ParallelState
+	err := json.Unmarshal(b, &p)
+	assert.NoError(t, err)
+	p.SetName(to.Strp("TestState"))
+	p.SetType(to.Strp("Parallel"))
+	return &p
+}
+
+func Test_State_ErrorIncluded_States_Errors(t *testing.T) {
+	all := []*string{to.Strp("States.ALL")}
+
+	assert.True(t, errorIncluded(all, &TestError{}))
+	assert.False(t, errorIncluded(all, NewError("States.Runtime", "")))
+	assert.False(t, errorIncluded([]*string{to.Strp("States.Runtime")}, NewError("States.Runtime", "")))
+	assert.False(t, errorIncluded(all, NewError("States.DataLimitExceeded", "")))
+	assert.True(t, errorIncluded([]*string{to.Strp("States.DataLimitExceeded")}, NewError("States.DataLimitExceeded", "")))
+
+	taskFailed := []*string{to.Strp("States.TaskFailed")}
+	assert.True(t, errorIncluded(taskFailed, taskFailure(&TestError{})))
+	assert.False(t, errorIncluded(taskFailed, taskFailure(NewError("States.Timeout", ""))))
+	assert.False(t, errorIncluded(taskFailed, &TestError{}))
+}
+
+func Test_State_TaskFailed(t *testing.T) {
+	state := parseValidTaskState([]byte(`{
+		"Next": "Pass",
+		"Resource": "test",
+		"Catch": [{"ErrorEquals": ["States.TaskFailed"], "Next": "Fail"}]
+	}`), ThrowTestErrorHandler, t)
+
+	testState(state, stateTestData{
+		Output: map[string]interface{}{"Error": "TestError", "Cause": "This is a Test Error"},
+		Next:   to.Strp("Fail"),
+	}, t)
+}
+
+func Test_State_Runtime_Not_Caught(t *testing.T) {
+	calls := 0
+	state := parseValidTaskState([]byte(`{
+		"Next": "Pass",
+		"Resource": "test",
+		"OutputPath": "$.missing",
+		"Retry": [{"ErrorEquals": ["States.ALL"]}],
+		"Catch": [{"ErrorEquals": ["States.ALL"], "Next": "Fail"}]
+	}`), func(_ context.Context, input interface{}) (interface{}, error) {
+		calls++
+		return input, nil
+	}, t)
+
+	_, _, err := state.Execute(executionContext(), map[string]interface{}{})
+	assert.Equal(t, "States.Runtime", errorName(err))
+	assert.Equal(t, 1, calls)
+}
+
+func Test_State_Errors_Names(t *testing.T) {
+	big := strings.Repeat("a", MaxPayloadBytes)
+	returnInput := func(_ context.Context, input interface{}) (interface{}, error) {
+		return input, nil
+	}
+
+	tests := map[string]struct {
+		definition string
+		handler    interface{}
+	}{
+		"States.ParameterPathFailure": {
+			`{"Parameters": {"a.$": "$.missing"}}`, returnInput,
+		},
+		"States.IntrinsicFailure": {
+			`{"Parameters": {"a.$": "States.ArrayGetItem(States.Array(1), 5)"}}`, returnInput,
+		},
+		"States.ResultPathMatchFailure": {
+			`{"ResultPath": "$.a[0]"}`, returnInput,
+		},
+		"States.DataLimitExceeded": {
+			`{}`, func(_ context.Context, _ interface{}) (interface{}, error) {
+				return map[string]interface{}{"a": big}, nil
+			},
+		},
+	}
+
+	for name, test := range tests {
+		state := parseTaskState([]byte(test.definition), t)
+		state.Resource, state.Next = to.Strp("test"), to.Strp("Pass")
+		state.Catch = []*Catcher{{ErrorEquals: []*string{to.Strp(name)}, Next: to.Strp("Caught")}}
+		state.SetTaskHandler(test.handler)
+		assert.NoError(t, state.Validate(), name)
+
+		output, next, err := state.Execute(executionContext(), map[string]interface{}{"a": "string"})
+		if assert.NoError(t, err, name) {
+			assert.Equal(t, "Caught", *next, name)
+			assert.Equal(t, name, output.(map[string]interface{})["Error"], name)
+		}
+	}
+}
+
+func Test_State_NoChoiceMatched(t *testing.T) {
+	state := parseChoiceState([]byte(`{
+		"Choices": [{"Variable": "$.a", "BooleanEquals": true, "Next": "A"}]
+	}`), t)
+
+	_, _, err := state.Execute(nil, map[string]interface{}{"a": false})
+	assert.Equal(t, "States.NoChoiceMatched", errorName(err))
+}
+
+func Test_State_BranchFailed(t *testing.T) {
+	state := parseParallelState([]byte(`{
+		"Next": "Pass",
+		"Catch": [{"ErrorEquals": ["States.TaskFailed"], "Next": "Task"}, {"ErrorEquals": ["States.BranchFailed"], "Next": "Branch"}],
+		"Branches": [
+			{ "StartAt": "Broken", "States": {"Broken": {"Type": "Task", "Resource": "broken", "End": true}}}
+		]
+	}`), t)
+	state.Branches[0].SetTaskHandler("Broken", ThrowTestErrorHandler)
+
+	testState(state, stateTestData{
+		Output: map[string]interface{}{"Error": "TestError", "Cause": "This is a Test Error"},
+		Next:   to.Strp("Branch"),
+	}, t)
+}
+
+func Test_State_Retrier_Interval(t *testing.T) {
+	r := &Retrier{IntervalSeconds: to.Intp(3), BackoffRate: to.Float64p(2.5), MaxDelaySeconds: to.Intp(20)}
+	assert.Equal(t, 3*time.Second, r.interval(0))
+	assert.Equal(t, 7500*time.Millisecond, r.interval(1))
+	assert.Equal(t, 18750*time.Millisecond, r.interval(2))
+	assert.Equal(t, 20*time.Second, r.interval(3))
+
+	// Defaults are an IntervalSeconds of 1 and a BackoffRate of 2.0
+	assert.Equal(t, 4*time.Second, (&Retrier{}).interval(2))
+
+	defer func(jitter func() float64) { retryJitter = jitter }(retryJitter)
+	retryJitter = func() float64 { return 0.25 }
+
+	r.JitterStrategy = to.Strp("FULL")
+	assert.Equal(t, 750*time.Millisecond, r.interval(0))
+	assert.Equal(t, 5*time.Second, r.interval(3))
+
+	r.JitterStrategy = to.Strp("NONE")
+	assert.Equal(t, 3*time.Second, r.interval(0))
+}
+
+func Test_State_Retry_MaxAttempts_Zero(t *testing.T) {
+	th, calls := countCalls(ThrowTestErrorHandler)
+
+	state := parseValidTaskState([]byte(`{
+		"Next": "Pass",
+		"Resource": "test",
+		"Retry": [{"ErrorEquals": ["TestError"], "MaxAttempts": 0}, {"ErrorEquals": ["States.ALL"]}]
+	}`), th, t)
+
+	// The first matching Retrier is used even if it never retries
+	_, _, err := state.Execute(executionContext(), map[string]interface{}{})
+	assert.Error(t, err)
+	assert.Equal(t, 1, *calls)
+}
+
+func Test_State_Retry_Waits(t *testing.T) {
+	th, calls := countCalls(ThrowTestErrorHandler)
+
+	state := parseValidTaskState([]byte(`{
+		"Next": "Pass",
+		"Resource": "test",
+		"Retry": [{"ErrorEquals": ["TestError"], "IntervalSeconds": 2, "MaxAttempts": 3, "MaxDelaySeconds": 5}]
+	}`), th, t)
+
+	start := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	clock := NewVirtualClock(start)
+
+	_, _, err := state.Execute(withClock(context.Background(), clock), map[string]interface{}{})
+	assert.Error(t, err)
+	assert.Equal(t, 4, *calls)
+
+	// Waits of 2 + 4 + 5 seconds
+	assert.Equal(t, start.Add(11*time.Second), clock.Now())
+}
+
+func Test_State_Retry_Validate(t *testing.T) {
+	invalid := map[string]string{
+		"IntervalSeconds 0":     `{"ErrorEquals": ["States.ALL"], "IntervalSeconds": 0}`,
+		"IntervalSeconds Large": `{"ErrorEquals": ["States.ALL"], "IntervalSeconds": 100000000}`,
+		"MaxAttempts Negative":  `{"ErrorEquals": ["States.ALL"], "MaxAttempts": -1}`,
+		"MaxAttempts Large":     `{"ErrorEquals": ["States.ALL"], "MaxAttempts": 100000000}`,
+		"BackoffRate Small":     `{"ErrorEquals": ["States.ALL"], "BackoffRate": 0.5}`,
+		"MaxDelaySeconds 0":     `{"ErrorEquals": ["States.ALL"], "MaxDelaySeconds": 0}`,
+		"MaxDelaySeconds Large": `{"ErrorEquals": ["States.ALL"], "MaxDelaySeconds": 31622401}`,
+		"JitterStrategy":        `{"ErrorEquals": ["States.ALL"], "JitterStrategy": "PARTIAL"}`,
+	}
+
+	for name, retrier := range invalid {
+		state := parseTaskState([]byte(`{"Resource": "test", "End": true, "Retry": [`+retrier+`]}`), t)
+		assert.Error(t, state.Validate(), name)
+	}
+
+	state := parseTaskState([]byte(`{"Resource": "test", "End": true, "Retry": [{
+		"ErrorEquals": ["States.ALL"], "IntervalSeconds": 1, "MaxAttempts": 0,
+		"BackoffRate": 1.0, "MaxDelaySeconds": 31622400, "JitterStrategy": "FULL"
+	}]}`), t)
+	assert.NoError(t, state.Validate())
+}
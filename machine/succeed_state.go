@@ -5,7 +5,6 @@ import (
 	"fmt"
 
 	"github.com/cleardataeng/step/jsonpath"
-	"github.com/cleardataeng/step/utils/to"
 )
 
 type SucceedState struct {
@@ -33,7 +32,7 @@ func (s *SucceedState) Execute(ctx context.Context, input interface{}) (output i
 }
 
 func (s *SucceedState) Validate() error {
-	s.SetType(to.Strp("Succeed"))
+	defaultType(s, "Succeed")
 
 	if err := ValidateNameAndType(s); err != nil {
 		return fmt.Errorf("%v %v", errorPrefix(s), err)
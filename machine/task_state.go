@@ -3,6 +3,7 @@ package machine
 import (
 	"context"
 	"fmt"
+	"time"
 
 	"github.com/cleardataeng/step/handler"
 	"github.com/cleardataeng/step/jsonpath"
@@ -20,6 +21,8 @@ type TaskState struct {
 	ResultPath *jsonpath.Path `json:",omitempty"`
 	Parameters interface{}    `json:",omitempty"`
 
+	ResultSelector interface{} `json:",omitempty"`
+
 	Resource *string `json:",omitempty"`
 
 	Catch []*Catcher `json:",omitempty"`
@@ -31,41 +34,202 @@ type TaskState struct {
 	Next *string `json:",omitempty"`
 	End  *bool   `json:",omitempty"`
 
+	// TimeoutSeconds and HeartbeatSeconds always use real time, not the Executions Clock,
+	// as handlers run in real time and a VirtualClock would time them out instantly
 	TimeoutSeconds   int `json:",omitempty"`
 	HeartbeatSeconds int `json:",omitempty"`
 }
 
+// DefaultTaskTimeoutSeconds is the TimeoutSeconds used when a Task does not define one
+const DefaultTaskTimeoutSeconds = 60
+
 func (s *TaskState) SetTaskHandler(resourcefn interface{}) {
 	s.TaskHandler = resourcefn
 }
 
-func (s *TaskState) process(ctx context.Context, input interface{}) (interface{}, *string, error) {
-	result, err := handler.CallHandlerFunction(s.TaskHandler, ctx, input)
+func (s *TaskState) timeoutSeconds() int {
+	if s.TimeoutSeconds == 0 {
+		return DefaultTaskTimeoutSeconds
+	}
+	return s.TimeoutSeconds
+}
+
+type handlerResponse struct {
+	result interface{}
+	err    error
+}
+
+// callHandler calls the TaskHandler enforcing TimeoutSeconds and HeartbeatSeconds in real time,
+// a callback Task then waits for its token to be completed, a nil TaskHandler is skipped
+func (s *TaskState) callHandler(parent context.Context, input interface{}) (interface{}, error) {
+	if parent == nil {
+		parent = context.Background()
+	}
+
+	// A mocked Task returns its mocked response without calling its handler or waiting for its token
+	if result, err, ok := mocksFrom(parent).call(*s.Name()); ok {
+		return result, err
+	}
+
+	ctx, cancel := context.WithTimeout(parent, time.Duration(s.timeoutSeconds())*time.Second)
+	defer cancel()
+
+	ctx, heartbeats := handler.WithHeartbeat(ctx)
+
+	// Registered before the handler is called as it may complete the token immediately
+	callback := &taskWaiter{}
+	if s.waitForTaskToken() {
+		ec := executionContextFrom(parent)
+		token := taskToken(parent)
+		if ec == nil || token == "" {
+			return nil, fmt.Errorf("%v requires an Execution", waitForTaskTokenSuffix)
+		}
+
+		callback = ec.tokens.register(token)
+		defer ec.tokens.remove(token)
+	}
 
+	call, err := s.resolve(parent)
 	if err != nil {
-		return nil, nil, err
+		return nil, err
 	}
 
-	result, err = to.FromJSON(result)
+	// Buffered so a handler that finishes after the timeout does not leak blocked
+	done := make(chan handlerResponse, 1)
+	if call != nil {
+		go func() {
+			result, err := call(ctx, input)
+			done <- handlerResponse{result, err}
+		}()
+	}
+
+	var heartbeatTimeout <-chan time.Time
+	var heartbeatTimer *time.Timer
+	if s.HeartbeatSeconds > 0 {
+		heartbeatTimer = time.NewTimer(time.Duration(s.HeartbeatSeconds) * time.Second)
+		defer heartbeatTimer.Stop()
+		heartbeatTimeout = heartbeatTimer.C
+	}
 
+	for {
+		select {
+		case res := <-done:
+			if res.err != nil || !s.waitForTaskToken() {
+				return res.result, res.err
+			}
+			s.events(parent).submitted(res.result)
+			done = nil // the handlers result is ignored, wait for the token
+		case res := <-callback.done:
+			return res.result, res.err
+		case <-callback.heartbeats:
+			s.resetHeartbeat(heartbeatTimer)
+		case <-heartbeats:
+			s.resetHeartbeat(heartbeatTimer)
+		case <-heartbeatTimeout:
+			return nil, &statesError{
+				"States.HeartbeatTimeout",
+				fmt.Sprintf("no heartbeat received for %v seconds", s.HeartbeatSeconds),
+			}
+		case <-ctx.Done():
+			if err := parent.Err(); err != nil {
+				return nil, err
+			}
+			return nil, &statesError{
+				"States.Timeout",
+				fmt.Sprintf("task timed out after %v seconds", s.timeoutSeconds()),
+			}
+		}
+	}
+}
+
+// resolve returns the TaskHandler, otherwise the Executor for the Resource,
+// a callback Task with neither only waits for its token
+func (s *TaskState) resolve(ctx context.Context) (func(context.Context, interface{}) (interface{}, error), error) {
+	if s.TaskHandler != nil {
+		return func(ctx context.Context, input interface{}) (interface{}, error) {
+			return handler.CallHandlerFunction(s.TaskHandler, ctx, input)
+		}, nil
+	}
+
+	resolver := resolverFrom(ctx)
+	if resolver == nil {
+		if s.waitForTaskToken() {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("Handler nil")
+	}
+
+	executor, err := resolver.Resolve(*s.Resource)
 	if err != nil {
-		return nil, nil, err
+		if s.waitForTaskToken() {
+			return nil, nil
+		}
+		return nil, err
 	}
 
+	return func(ctx context.Context, input interface{}) (interface{}, error) {
+		return executor.Execute(ctx, *s.Resource, input)
+	}, nil
+}
+
+func (s *TaskState) resetHeartbeat(timer *time.Timer) {
+	if timer == nil {
+		return
+	}
+
+	if !timer.Stop() {
+		<-timer.C
+	}
+	timer.Reset(time.Duration(s.HeartbeatSeconds) * time.Second)
+}
+
+func (s *TaskState) process(ctx context.Context, input interface{}) (interface{}, *string, error) {
+	// A Replay gives the Task the input it was recorded with
+	input = replayFrom(ctx).taskInput(*s.Name(), input)
+
+	events := s.events(ctx)
+	events.scheduled(input)
+	events.started()
+
+	result, err := s.callHandler(ctx, input)
+
+	if err == nil {
+		result, err = to.FromJSON(result)
+	}
+
+	if err != nil {
+		events.failed(err)
+		return nil, nil, taskFailure(err)
+	}
+
+	events.succeeded(result)
+
 	return result, nextState(s.Next, s.End), nil
 }
 
+// taskFailure makes the error of a Task match States.TaskFailed, except timeouts and States.Runtime
+func taskFailure(err error) error {
+	switch errorName(err) {
+	case "States.Timeout", "States.HeartbeatTimeout", "States.Runtime":
+		return err
+	}
+	return &stateFailure{"States.TaskFailed", err}
+}
+
 // Input must include the Task name in $.Task
 func (s *TaskState) Execute(ctx context.Context, input interface{}) (output interface{}, next *string, err error) {
 	return processError(s,
 		processCatcher(s.Catch,
-			processRetrier(s.Name(), s.Retry,
-				inputOutput(
-					s.InputPath,
-					s.OutputPath,
-					withParams(
-						s.Parameters,
-						result(s.ResultPath, s.process),
+			processRetrier(s.Retry,
+				s.withNewTaskToken(
+					inputOutput(
+						s.InputPath,
+						s.OutputPath,
+						// InputPath, Parameters, ResultSelector, ResultPath then OutputPath,
+						// ResultPath places the result in the state input, not the Parameters
+						result(s.ResultPath,
+							withParams(s.Parameters, withResultSelector(s.ResultSelector, s.process)),
+						),
 					),
 				),
 			),
@@ -73,19 +237,45 @@ func (s *TaskState) Execute(ctx context.Context, input interface{}) (output inte
 	)(ctx, input)
 }
 
+// withNewTaskToken gives every attempt of a callback Task a new token
+func (s *TaskState) withNewTaskToken(exec ExecutionFn) ExecutionFn {
+	return func(ctx context.Context, input interface{}) (interface{}, *string, error) {
+		if s.waitForTaskToken() && ctx != nil {
+			ctx = withTaskToken(ctx, to.UUID())
+		}
+		return exec(ctx, input)
+	}
+}
+
 func (s *TaskState) Validate() error {
-	s.SetType(to.Strp("Task"))
+	defaultType(s, "Task")
 
 	if err := ValidateNameAndType(s); err != nil {
 		return fmt.Errorf("%v %v", errorPrefix(s), err)
 	}
 
 	if err := endValid(s.Next, s.End); err != nil {
-		return fmt.Errorf("%v %v", errorPrefix(s), err)
+		return invalidField(s, "Next", err)
+	}
+
+	if err := resultPathValid(s.ResultPath); err != nil {
+		return invalidField(s, "ResultPath", err)
 	}
 
 	if s.Resource == nil {
-		return fmt.Errorf("%v Requires Resource", errorPrefix(s))
+		return invalidField(s, "Resource", fmt.Errorf("Requires Resource"))
+	}
+
+	if s.TimeoutSeconds < 0 {
+		return invalidField(s, "TimeoutSeconds", fmt.Errorf("TimeoutSeconds must be positive"))
+	}
+
+	if s.HeartbeatSeconds < 0 {
+		return invalidField(s, "HeartbeatSeconds", fmt.Errorf("HeartbeatSeconds must be positive"))
+	}
+
+	if s.HeartbeatSeconds >= s.timeoutSeconds() {
+		return invalidField(s, "HeartbeatSeconds", fmt.Errorf("HeartbeatSeconds must be smaller than TimeoutSeconds"))
 	}
 
 	if s.TaskHandler != nil {
@@ -95,11 +285,11 @@ func (s *TaskState) Validate() error {
 	}
 
 	if err := catchValid(s.Catch); err != nil {
-		return err
+		return invalidField(s, "Catch", err)
 	}
 
 	if err := retryValid(s.Retry); err != nil {
-		return err
+		return invalidField(s, "Retry", err)
 	}
 
 	return nil
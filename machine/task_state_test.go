@@ -2,8 +2,12 @@ package machine
 
 import (
 	"context"
+	"fmt"
 	"testing"
+	"time"
 
+	"github.com/cleardataeng/step/errors"
+	"github.com/cleardataeng/step/handler"
 	"github.com/cleardataeng/step/utils/to"
 	"github.com/stretchr/testify/assert"
 )
@@ -110,6 +114,34 @@ func Test_TaskState_Catch_Works(t *testing.T) {
 	}, t)
 }
 
+func Test_TaskState_Catch_Wrapped_StepError(t *testing.T) {
+	state := parseValidTaskState([]byte(`{
+		"Next": "Pass",
+		"Resource": "test",
+		"Catch": [{
+			"ErrorEquals": ["LockExistsError"],
+			"Next": "Fail"
+		}]
+	}`), func(_ context.Context, _ interface{}) (interface{}, error) {
+		return nil, fmt.Errorf("locking: %w", errors.LockExistsError{Cause: "locked"})
+	}, t)
+
+	testState(state, stateTestData{
+		Input:  map[string]interface{}{"a": "c"},
+		Output: map[string]interface{}{"Error": "LockExistsError", "Cause": "LockExistsError: locked"},
+		Next:   to.Strp("Fail"),
+	}, t)
+}
+
+func Test_TaskState_Error_Keeps_Name(t *testing.T) {
+	state := parseValidTaskState([]byte(`{"Next": "Pass", "Resource": "test"}`), ThrowTestErrorHandler, t)
+
+	_, _, err := state.Execute(nil, map[string]interface{}{})
+	assert.Regexp(t, `^TaskState\(TestState\) Error: This is a Test Error`, err.Error())
+	assert.Equal(t, "TestError", errors.ErrorName(err))
+	assert.Equal(t, "This is a Test Error", errors.ErrorCause(err))
+}
+
 func Test_TaskState_Catch_Doesnt_Catch(t *testing.T) {
 	state := parseValidTaskState([]byte(`{
 		"Next": "Pass",
@@ -138,18 +170,12 @@ func Test_TaskState_Retry_Works(t *testing.T) {
 		}]
 	}`), th, t)
 
-	testState(state, stateTestData{
-		Input: map[string]interface{}{"a": "c"},
-		Next:  state.Name(),
-	}, t)
-
-	testState(state, stateTestData{
-		Input: map[string]interface{}{"a": "c"},
-		Next:  state.Name(),
-	}, t)
+	ctx := executionContext()
 
+	// Retries happen inside the State, so one Execute fails after all attempts
 	testState(state, stateTestData{
 		Input: map[string]interface{}{"a": "c"},
+		Ctx:   ctx,
 		Error: to.Strp("This is a Test Error"),
 	}, t)
 
@@ -173,13 +199,11 @@ func Test_TaskState_Catch_AND_Retry_Works(t *testing.T) {
 		}]
 	}`), th, t)
 
-	testState(state, stateTestData{
-		Input: map[string]interface{}{"a": "c"},
-		Next:  state.Name(),
-	}, t)
+	ctx := executionContext()
 
 	testState(state, stateTestData{
 		Input: map[string]interface{}{"a": "c"},
+		Ctx:   ctx,
 		Next:  to.Strp("Fail"),
 	}, t)
 
@@ -202,13 +226,11 @@ func Test_TaskState_Catch_AND_Retry_StateAll(t *testing.T) {
 		}]
 	}`), th, t)
 
-	testState(state, stateTestData{
-		Input: map[string]interface{}{"a": "c"},
-		Next:  state.Name(),
-	}, t)
+	ctx := executionContext()
 
 	testState(state, stateTestData{
 		Input: map[string]interface{}{"a": "c"},
+		Ctx:   ctx,
 		Next:  to.Strp("Fail"),
 	}, t)
 
@@ -233,13 +255,11 @@ func Test_TaskState_Catch_AND_Dont_Retry(t *testing.T) {
 		}]
 	}`), th, t)
 
-	testState(state, stateTestData{
-		Input: map[string]interface{}{"a": "c"},
-		Next:  state.Name(),
-	}, t)
+	ctx := executionContext()
 
 	testState(state, stateTestData{
 		Input: map[string]interface{}{"a": "c"},
+		Ctx:   ctx,
 		Next:  to.Strp("Fail"),
 	}, t)
 
@@ -272,3 +292,160 @@ func Test_TaskState_InputPath_and_Parameters(t *testing.T) {
 		Output: map[string]interface{}{"Task": "Noop", "Input": "AHAH"},
 	}, t)
 }
+
+func Test_TaskState_Parameters_and_ResultPath(t *testing.T) {
+	state := parseValidTaskState([]byte(`{
+		"Next": "Pass",
+		"Resource": "test",
+		"Parameters": {"Input.$": "$.x"},
+		"ResultPath": "$.result"
+	}`), ReturnInputHandler, t)
+
+	testState(state, stateTestData{
+		Input: map[string]interface{}{"x": "AHAH", "y": "kept"},
+		Output: map[string]interface{}{
+			"x":      "AHAH",
+			"y":      "kept",
+			"result": map[string]interface{}{"Input": "AHAH"},
+		},
+	}, t)
+}
+
+func Test_TaskState_Validate_Timeouts(t *testing.T) {
+	state := parseTaskState([]byte(`{"Resource": "asd", "Next": "Pass", "TimeoutSeconds": -1}`), t)
+	assert.Error(t, state.Validate())
+
+	state = parseTaskState([]byte(`{"Resource": "asd", "Next": "Pass", "TimeoutSeconds": 10, "HeartbeatSeconds": 10}`), t)
+	assert.Error(t, state.Validate())
+
+	state = parseTaskState([]byte(`{"Resource": "asd", "Next": "Pass", "HeartbeatSeconds": 120}`), t)
+	assert.Error(t, state.Validate())
+
+	state = parseTaskState([]byte(`{"Resource": "asd", "Next": "Pass", "TimeoutSeconds": 10, "HeartbeatSeconds": 5}`), t)
+	assert.NoError(t, state.Validate())
+}
+
+func Test_TaskState_Timeout(t *testing.T) {
+	hung := func(ctx context.Context, input interface{}) (interface{}, error) {
+		<-ctx.Done()
+		return nil, ctx.Err()
+	}
+
+	state := parseValidTaskState([]byte(`{
+		"Next": "Pass",
+		"Resource": "test",
+		"TimeoutSeconds": 1
+	}`), hung, t)
+
+	testState(state, stateTestData{
+		Input: map[string]interface{}{"a": "c"},
+		Error: to.Strp("States.Timeout"),
+	}, t)
+}
+
+func Test_TaskState_Timeout_Catch(t *testing.T) {
+	hung := func(ctx context.Context, input interface{}) (interface{}, error) {
+		<-ctx.Done()
+		return nil, ctx.Err()
+	}
+
+	state := parseValidTaskState([]byte(`{
+		"Next": "Pass",
+		"Resource": "test",
+		"TimeoutSeconds": 1,
+		"Catch": [{
+			"ErrorEquals": ["States.Timeout"],
+			"Next": "Fail"
+		}]
+	}`), hung, t)
+
+	testState(state, stateTestData{
+		Input:  map[string]interface{}{"a": "c"},
+		Output: map[string]interface{}{"Error": "States.Timeout", "Cause": "task timed out after 1 seconds"},
+		Next:   to.Strp("Fail"),
+	}, t)
+}
+
+func Test_TaskState_Heartbeat(t *testing.T) {
+	beating := func(ctx context.Context, input interface{}) (interface{}, error) {
+		for i := 0; i < 6; i++ {
+			time.Sleep(250 * time.Millisecond)
+			handler.SendHeartbeat(ctx)
+		}
+		return map[string]interface{}{"z": "y"}, nil
+	}
+
+	state := parseValidTaskState([]byte(`{
+		"Next": "Pass",
+		"Resource": "test",
+		"TimeoutSeconds": 3,
+		"HeartbeatSeconds": 1
+	}`), beating, t)
+
+	testState(state, stateTestData{
+		Input:  map[string]interface{}{"a": "c"},
+		Output: map[string]interface{}{"z": "y"},
+	}, t)
+}
+
+func Test_TaskState_HeartbeatTimeout(t *testing.T) {
+	silent := func(ctx context.Context, input interface{}) (interface{}, error) {
+		<-ctx.Done()
+		return nil, ctx.Err()
+	}
+
+	state := parseValidTaskState([]byte(`{
+		"Next": "Pass",
+		"Resource": "test",
+		"TimeoutSeconds": 3,
+		"HeartbeatSeconds": 1,
+		"Catch": [{
+			"ErrorEquals": ["States.HeartbeatTimeout"],
+			"Next": "Fail"
+		}]
+	}`), silent, t)
+
+	testState(state, stateTestData{
+		Input: map[string]interface{}{"a": "c"},
+		Next:  to.Strp("Fail"),
+	}, t)
+}
+
+func Test_TaskState_ResultSelector(t *testing.T) {
+	resultHandler := func(_ context.Context, input interface{}) (interface{}, error) {
+		return map[string]interface{}{
+			"Payload":    map[string]interface{}{"id": "abc", "ignored": true},
+			"StatusCode": 200,
+		}, nil
+	}
+
+	state := parseValidTaskState([]byte(`{
+		"Next": "Pass",
+		"Resource": "test",
+		"ResultSelector": {
+			"id.$": "$.Payload.id",
+			"status.$": "States.Format('HTTP {}', $.StatusCode)",
+			"static": "value"
+		},
+		"ResultPath": "$.result",
+		"OutputPath": "$.result"
+	}`), resultHandler, t)
+
+	testState(state, stateTestData{
+		Input:  map[string]interface{}{"a": "c"},
+		Output: map[string]interface{}{"id": "abc", "status": "HTTP 200", "static": "value"},
+	}, t)
+}
+
+func Test_TaskState_ResultSelector_Error(t *testing.T) {
+	state := parseValidTaskState([]byte(`{
+		"Next": "Pass",
+		"Resource": "test",
+		"ResultSelector": {"id.$": "$.missing"}
+	}`), ReturnMapTestHandler, t)
+
+	testState(state, stateTestData{
+		Input: map[string]interface{}{"a": "c"},
+		Error: to.Strp("Not Found"),
+	}, t)
+}
@@ -0,0 +1,169 @@
+package machine
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// Callback Tasks, with a Resource ending in .waitForTaskToken, pause after calling their handler
+// until the token in $$.Task.Token is completed with SendTaskSuccess or SendTaskFailure.
+
+const waitForTaskTokenSuffix = "waitForTaskToken"
+
+type taskTokenKey struct{}
+
+// taskTokens are the callback Tasks waiting in an Execution
+type taskTokens struct {
+	mu      sync.Mutex
+	waiting map[string]*taskWaiter
+}
+
+// taskWaiter receives the result of a callback Task and its heartbeats
+type taskWaiter struct {
+	done       chan handlerResponse
+	heartbeats chan struct{}
+}
+
+func newTaskTokens() *taskTokens {
+	return &taskTokens{waiting: map[string]*taskWaiter{}}
+}
+
+func withTaskToken(ctx context.Context, token string) context.Context {
+	return context.WithValue(ctx, taskTokenKey{}, token)
+}
+
+// taskToken returns the token of the current callback Task, or ""
+func taskToken(ctx context.Context) string {
+	if ctx == nil {
+		return ""
+	}
+	token, _ := ctx.Value(taskTokenKey{}).(string)
+	return token
+}
+
+// waitForTaskToken is true for Resources like arn:aws:states:::lambda:invoke.waitForTaskToken
+func (s *TaskState) waitForTaskToken() bool {
+	if s.Resource == nil || !strings.HasSuffix(*s.Resource, waitForTaskTokenSuffix) {
+		return false
+	}
+
+	resource := strings.TrimSuffix(*s.Resource, waitForTaskTokenSuffix)
+	return strings.HasSuffix(resource, ".") || strings.HasSuffix(resource, ":")
+}
+
+func (t *taskTokens) register(token string) *taskWaiter {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	w := &taskWaiter{
+		done:       make(chan handlerResponse, 1),
+		heartbeats: make(chan struct{}, 1),
+	}
+	t.waiting[token] = w
+	return w
+}
+
+func (t *taskTokens) remove(token string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	delete(t.waiting, token)
+}
+
+func (t *taskTokens) waiter(token string) (*taskWaiter, error) {
+	if t == nil {
+		return nil, fmt.Errorf("TaskDoesNotExist: Task Token %q does not exist", token)
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	w, ok := t.waiting[token]
+	if !ok {
+		return nil, fmt.Errorf("TaskDoesNotExist: Task Token %q does not exist", token)
+	}
+	return w, nil
+}
+
+// complete sends the result to the Task, a token can only be completed once
+func (t *taskTokens) complete(token string, res handlerResponse) error {
+	w, err := t.take(token)
+	if err != nil {
+		return err
+	}
+
+	w.done <- res
+	return nil
+}
+
+// take removes the waiter of token, so concurrent completions of a token cannot both find it
+func (t *taskTokens) take(token string) (*taskWaiter, error) {
+	if t == nil {
+		return nil, fmt.Errorf("TaskDoesNotExist: Task Token %q does not exist", token)
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	w, ok := t.waiting[token]
+	if !ok {
+		return nil, fmt.Errorf("TaskDoesNotExist: Task Token %q does not exist", token)
+	}
+	delete(t.waiting, token)
+	return w, nil
+}
+
+func (t *taskTokens) heartbeat(token string) error {
+	w, err := t.waiter(token)
+	if err != nil {
+		return err
+	}
+
+	select {
+	case w.heartbeats <- struct{}{}:
+	default: // a heartbeat is already pending
+	}
+	return nil
+}
+
+func (t *taskTokens) tokens() []string {
+	if t == nil {
+		return []string{}
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	tokens := []string{}
+	for token := range t.waiting {
+		tokens = append(tokens, token)
+	}
+	sort.Strings(tokens)
+	return tokens
+}
+
+////////
+// Execution Task Token API
+////////
+
+// SendTaskSuccess completes the callback Task waiting on token with output
+func (sm *Execution) SendTaskSuccess(token string, output interface{}) error {
+	return sm.tokens.complete(token, handlerResponse{result: output})
+}
+
+// SendTaskFailure fails the callback Task waiting on token, the error can be retried and caught
+func (sm *Execution) SendTaskFailure(token string, errorName string, cause string) error {
+	return sm.tokens.complete(token, handlerResponse{err: &statesError{errorName, cause}})
+}
+
+// SendTaskHeartbeat resets the HeartbeatSeconds of the callback Task waiting on token
+func (sm *Execution) SendTaskHeartbeat(token string) error {
+	return sm.tokens.heartbeat(token)
+}
+
+// WaitingTaskTokens returns the tokens of the callback Tasks currently waiting
+func (sm *Execution) WaitingTaskTokens() []string {
+	return sm.tokens.tokens()
+}
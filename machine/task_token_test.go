@@ -0,0 +1,214 @@
+package machine
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func callbackMachine(t *testing.T, task string) *StateMachine {
+	sm, err := FromJSON([]byte(`{
+    "StartAt": "Approve",
+    "States": {
+      "Approve": ` + task + `,
+      "Rejected": {"Type": "Pass", "End": true}
+    }
+  }`))
+	assert.NoError(t, err)
+	return sm
+}
+
+// tokenHandler sends the token it is given to tokens
+func tokenHandler(tokens chan string) func(context.Context, map[string]interface{}) (interface{}, error) {
+	return func(_ context.Context, input map[string]interface{}) (interface{}, error) {
+		tokens <- input["token"].(string)
+		return map[string]interface{}{"ignored": true}, nil
+	}
+}
+
+// waitingToken polls for the first waiting token
+func waitingToken(t *testing.T, exec *Execution) string {
+	for i := 0; i < 100; i++ {
+		if tokens := exec.WaitingTaskTokens(); len(tokens) > 0 {
+			return tokens[0]
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	assert.Fail(t, "no task token")
+	return ""
+}
+
+func Test_TaskToken_SendTaskSuccess(t *testing.T) {
+	sm := callbackMachine(t, `{
+    "Type": "Task",
+    "Resource": "arn:aws:states:::lambda:invoke.waitForTaskToken",
+    "Parameters": {"token.$": "$$.Task.Token", "id.$": "$.id"},
+    "ResultPath": "$.approval",
+    "Catch": [{"ErrorEquals": ["Rejected"], "Next": "Rejected"}],
+    "End": true
+  }`)
+
+	tokens := make(chan string, 1)
+	sm.SetTaskHandler("Approve", tokenHandler(tokens))
+
+	exec, err := sm.Start(map[string]interface{}{"id": "a"})
+	assert.NoError(t, err)
+
+	token := <-tokens
+	assert.Equal(t, token, waitingToken(t, exec))
+
+	go func() {
+		assert.NoError(t, exec.SendTaskHeartbeat(token))
+		assert.NoError(t, exec.SendTaskSuccess(token, map[string]interface{}{"approved": true}))
+	}()
+
+	assert.NoError(t, exec.Wait())
+	assert.Equal(t, map[string]interface{}{"approved": true}, exec.Output["approval"])
+
+	// The token can only be used once
+	assert.Error(t, exec.SendTaskSuccess(token, map[string]interface{}{}))
+	assert.Error(t, exec.SendTaskHeartbeat(token))
+	assert.Equal(t, []string{}, exec.WaitingTaskTokens())
+}
+
+func Test_TaskToken_SendTaskFailure(t *testing.T) {
+	sm := callbackMachine(t, `{
+    "Type": "Task",
+    "Resource": "arn:aws:states:::sqs:sendMessage.waitForTaskToken",
+    "Catch": [{"ErrorEquals": ["Rejected"], "ResultPath": "$.error", "Next": "Rejected"}],
+    "End": true
+  }`)
+
+	// Without a handler the Task only waits for the token
+	exec, err := sm.Start(map[string]interface{}{})
+	assert.NoError(t, err)
+
+	token := waitingToken(t, exec)
+	assert.NoError(t, exec.SendTaskFailure(token, "Rejected", "not approved"))
+
+	assert.NoError(t, exec.Wait())
+	assert.Equal(t, []string{"Approve", "Rejected"}, exec.Path())
+	assert.Equal(t, map[string]interface{}{"Error": "Rejected", "Cause": "not approved"}, exec.Output["error"])
+}
+
+func Test_TaskToken_Retry_NewToken(t *testing.T) {
+	sm := callbackMachine(t, `{
+    "Type": "Task",
+    "Resource": "arn:aws:states:::lambda:invoke.waitForTaskToken",
+    "Parameters": {"token.$": "$$.Task.Token"},
+    "Retry": [{"ErrorEquals": ["Retry"]}],
+    "Catch": [{"ErrorEquals": ["Rejected"], "Next": "Rejected"}],
+    "End": true
+  }`)
+
+	tokens := make(chan string, 2)
+	sm.SetTaskHandler("Approve", tokenHandler(tokens))
+	sm.SetClock(NewVirtualClock(time.Now()))
+
+	exec, err := sm.Start(map[string]interface{}{})
+	assert.NoError(t, err)
+
+	first := <-tokens
+	assert.NoError(t, exec.SendTaskFailure(first, "Retry", "try again"))
+
+	second := <-tokens
+	assert.NotEqual(t, first, second)
+	assert.NoError(t, exec.SendTaskSuccess(second, map[string]interface{}{"done": true}))
+
+	assert.NoError(t, exec.Wait())
+	assert.Equal(t, map[string]interface{}{"done": true}, exec.Output)
+}
+
+func Test_TaskToken_HeartbeatTimeout(t *testing.T) {
+	sm := callbackMachine(t, `{
+    "Type": "Task",
+    "Resource": "arn:aws:states:::lambda:invoke.waitForTaskToken",
+    "HeartbeatSeconds": 1,
+    "TimeoutSeconds": 5,
+    "Catch": [{"ErrorEquals": ["Rejected"], "Next": "Rejected"}],
+    "End": true
+  }`)
+
+	exec, err := sm.Start(map[string]interface{}{})
+	assert.NoError(t, err)
+
+	err = exec.Wait()
+	assert.Error(t, err)
+	assert.Regexp(t, "States.HeartbeatTimeout", err.Error())
+}
+
+func Test_TaskToken_Concurrent_Completions(t *testing.T) {
+	sm := callbackMachine(t, `{
+    "Type": "Task",
+    "Resource": "arn:aws:states:::lambda:invoke.waitForTaskToken",
+    "Catch": [{"ErrorEquals": ["Rejected"], "Next": "Rejected"}],
+    "End": true
+  }`)
+
+	exec, err := sm.Start(map[string]interface{}{})
+	assert.NoError(t, err)
+	token := waitingToken(t, exec)
+
+	// Both completions race for the token, only the first completes the Task
+	start := make(chan struct{})
+	errs := make(chan error, 2)
+	for i := 0; i < 2; i++ {
+		go func() {
+			<-start
+			errs <- exec.SendTaskSuccess(token, map[string]interface{}{})
+		}()
+	}
+	close(start)
+
+	first, second := <-errs, <-errs
+	if first != nil {
+		first, second = second, first
+	}
+	assert.NoError(t, first)
+	assert.Error(t, second)
+	assert.Regexp(t, "TaskDoesNotExist", second.Error())
+
+	assert.NoError(t, exec.Wait())
+}
+
+func Test_TaskToken_Errors(t *testing.T) {
+	sm := callbackMachine(t, `{
+    "Type": "Task",
+    "Resource": "arn:aws:states:::lambda:invoke.waitForTaskToken",
+    "Catch": [{"ErrorEquals": ["Rejected"], "Next": "Rejected"}],
+    "End": true
+  }`)
+
+	exec, err := sm.Start(map[string]interface{}{})
+	assert.NoError(t, err)
+
+	err = exec.SendTaskSuccess("unknown", nil)
+	assert.Error(t, err)
+	assert.Regexp(t, "TaskDoesNotExist", err.Error())
+
+	assert.NoError(t, exec.SendTaskSuccess(waitingToken(t, exec), map[string]interface{}{}))
+	assert.NoError(t, exec.Wait())
+
+	// A callback Task outside of an Execution cannot be completed
+	state := parseTaskState([]byte(`{"Resource": "arn:aws:states:::lambda:invoke.waitForTaskToken", "End": true}`), t)
+	_, _, err = state.Execute(nil, map[string]interface{}{})
+	assert.Error(t, err)
+
+	// Start returns validation errors
+	_, err = (&StateMachine{}).Start(map[string]interface{}{})
+	assert.Error(t, err)
+}
+
+func Test_TaskState_waitForTaskToken(t *testing.T) {
+	for resource, expected := range map[string]bool{
+		"arn:aws:states:::lambda:invoke.waitForTaskToken":                         true,
+		"arn:aws:lambda:us-east-1:000000000000:function:approve:waitForTaskToken": true,
+		"arn:aws:states:::lambda:invoke":                                          false,
+		"arn:aws:lambda:us-east-1:000000000000:function:approve-waitForTaskToken": false,
+	} {
+		state := &TaskState{Resource: &resource}
+		assert.Equal(t, expected, state.waitForTaskToken(), resource)
+	}
+}
@@ -0,0 +1,281 @@
+package machine
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// ValidationError is a single problem with a State Machine definition
+type ValidationError struct {
+	State   string // empty if the error is for the State Machine itself
+	Field   string
+	Message string
+}
+
+func (e ValidationError) Error() string {
+	location := e.State
+	if e.Field != "" {
+		if location != "" {
+			location += "."
+		}
+		location += e.Field
+	}
+
+	if location == "" {
+		return e.Message
+	}
+
+	return fmt.Sprintf("%v: %v", location, e.Message)
+}
+
+// ValidationErrors is every problem found when validating a State Machine
+type ValidationErrors []ValidationError
+
+func (e ValidationErrors) Error() string {
+	strs := []string{}
+	for _, err := range e {
+		strs = append(strs, err.Error())
+	}
+	return fmt.Sprintf("State Machine Errors %q", strs)
+}
+
+// fieldError is an error in a field of a State, with the same message as the errors of other State Validate checks
+type fieldError struct {
+	prefix string // errorPrefix of the State
+	field  string
+	err    error
+}
+
+func (e *fieldError) Error() string {
+	return fmt.Sprintf("%v %v", e.prefix, e.err)
+}
+
+// invalidField returns err as an error of the State field
+func invalidField(s State, field string, err error) error {
+	return &fieldError{errorPrefix(s), field, err}
+}
+
+// stateValidationError returns the error of the State name as a ValidationError of its field,
+// without the error prefix as ValidationError already names the State
+func stateValidationError(name string, s State, err error) ValidationError {
+	if fe, ok := err.(*fieldError); ok {
+		return ValidationError{name, fe.field, fe.err.Error()}
+	}
+
+	return ValidationError{name, "", strings.TrimPrefix(err.Error(), errorPrefix(s)+" ")}
+}
+
+// maxStateNameLength is the longest State name AWS allows
+const maxStateNameLength = 80
+
+// transition is a field of a State that names the next State
+type transition struct {
+	field string
+	next  string
+}
+
+// transitions returns all the States a State can move to
+func transitions(s State) []transition {
+	ts := []transition{}
+
+	add := func(field string, next *string) {
+		if next != nil {
+			ts = append(ts, transition{field, *next})
+		}
+	}
+
+	addCatch := func(catch []*Catcher) {
+		for i, c := range catch {
+			add(fmt.Sprintf("Catch[%v].Next", i), c.Next)
+		}
+	}
+
+	switch state := s.(type) {
+	case *PassState:
+		add("Next", state.Next)
+	case *WaitState:
+		add("Next", state.Next)
+	case *TaskState:
+		add("Next", state.Next)
+		addCatch(state.Catch)
+	case *MapState:
+		add("Next", state.Next)
+		addCatch(state.Catch)
+	case *ParallelState:
+		add("Next", state.Next)
+		addCatch(state.Catch)
+	case *ChoiceState:
+		for i, c := range state.Choices {
+			add(fmt.Sprintf("Choices[%v].Next", i), c.Next)
+		}
+		add("Default", state.Default)
+	}
+
+	return ts
+}
+
+// terminal returns true if an execution can end in this State
+func terminal(s State) bool {
+	switch state := s.(type) {
+	case *SucceedState, *FailState:
+		return true
+	case *PassState:
+		return state.End != nil
+	case *WaitState:
+		return state.End != nil
+	case *TaskState:
+		return state.End != nil
+	case *MapState:
+		return state.End != nil
+	case *ParallelState:
+		return state.End != nil
+	}
+	return false
+}
+
+// stateNameValid checks the length and characters AWS allows in State names
+func stateNameValid(name string) error {
+	if strings.TrimSpace(name) == "" {
+		return fmt.Errorf("State name must not be blank")
+	}
+
+	if utf8.RuneCountInString(name) > maxStateNameLength {
+		return fmt.Errorf("State name must be at most %v characters", maxStateNameLength)
+	}
+
+	for _, r := range name {
+		if unicode.IsControl(r) {
+			return fmt.Errorf("State name contains control character %q", r)
+		}
+	}
+
+	return nil
+}
+
+// Warnings returns problems that do not make a State Machine invalid, e.g. a Choice State without a Default
+// fails with States.NoChoiceMatched only if no Choice matches at runtime.
+// Warnings of Parallel Branches and Map Iterators are reported on the Parallel or Map State
+func (sm *StateMachine) Warnings() ValidationErrors {
+	warnings := ValidationErrors{}
+
+	names := []string{}
+	for name := range sm.States {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		switch state := sm.States[name].(type) {
+		case *ChoiceState:
+			if state.Default == nil {
+				warnings = append(warnings, ValidationError{name, "Default", "Choice State has no Default"})
+			}
+		case *ParallelState:
+			for i, branch := range state.Branches {
+				if branch != nil {
+					warnings = append(warnings, nestedWarnings(name, fmt.Sprintf("Branches[%v]", i), branch)...)
+				}
+			}
+		case *MapState:
+			if iterator := state.iterator(); iterator != nil {
+				warnings = append(warnings, nestedWarnings(name, state.iteratorField(), iterator)...)
+			}
+		}
+	}
+
+	return warnings
+}
+
+// nestedWarnings returns the Warnings of the State Machine in field of the State name,
+// e.g. the Field Branches[0].States.Choice.Default of a Parallel State
+func nestedWarnings(name string, field string, sm *StateMachine) ValidationErrors {
+	warnings := ValidationErrors{}
+	for _, w := range sm.Warnings() {
+		nested := fmt.Sprintf("%v.States.%v", field, w.State)
+		if w.Field != "" {
+			nested += "." + w.Field
+		}
+		warnings = append(warnings, ValidationError{name, nested, w.Message})
+	}
+	return warnings
+}
+
+// graphErrors finds dangling transitions, unreachable States
+// and States that can never reach a terminal State
+func (sm *StateMachine) graphErrors() ValidationErrors {
+	errs := ValidationErrors{}
+
+	names := []string{}
+	for name := range sm.States {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	// Dangling transitions
+	for _, name := range names {
+		s := sm.States[name]
+
+		for _, t := range transitions(s) {
+			if _, ok := sm.States[t.next]; !ok {
+				errs = append(errs, ValidationError{name, t.field, fmt.Sprintf("Unknown State %q", t.next)})
+			}
+		}
+	}
+
+	// Reachable from StartAt
+	reachable := map[string]bool{}
+	queue := []string{*sm.StartAt}
+	for len(queue) > 0 {
+		name := queue[0]
+		queue = queue[1:]
+
+		s, ok := sm.States[name]
+		if !ok || reachable[name] {
+			continue
+		}
+
+		reachable[name] = true
+		for _, t := range transitions(s) {
+			queue = append(queue, t.next)
+		}
+	}
+
+	for _, name := range names {
+		if !reachable[name] {
+			errs = append(errs, ValidationError{name, "", "State is unreachable from StartAt"})
+		}
+	}
+
+	// Can reach a terminal State, iterate until no more States are added
+	terminates := map[string]bool{}
+	for changed := true; changed; {
+		changed = false
+		for _, name := range names {
+			if terminates[name] {
+				continue
+			}
+
+			s := sm.States[name]
+			ok := terminal(s)
+			for _, t := range transitions(s) {
+				ok = ok || terminates[t.next]
+			}
+
+			if ok {
+				terminates[name] = true
+				changed = true
+			}
+		}
+	}
+
+	for _, name := range names {
+		if !terminates[name] {
+			errs = append(errs, ValidationError{name, "", "State can never reach an End, Succeed or Fail State"})
+		}
+	}
+
+	return errs
+}
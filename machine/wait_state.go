@@ -6,7 +6,6 @@ import (
 	"time"
 
 	"github.com/cleardataeng/step/jsonpath"
-	"github.com/cleardataeng/step/utils/to"
 )
 
 type WaitState struct {
@@ -29,28 +28,42 @@ type WaitState struct {
 }
 
 func (s *WaitState) process(ctx context.Context, input interface{}) (interface{}, *string, error) {
+	clock := clockFrom(ctx)
 
-	if s.SecondsPath != nil {
-		// Validate the path exists
-		_, err := s.SecondsPath.GetNumber(input)
+	var duration time.Duration
+
+	if s.Seconds != nil {
+		duration = secondsDuration(*s.Seconds)
+	} else if s.SecondsPath != nil {
+		seconds, err := s.SecondsPath.GetNumber(input)
 		if err != nil {
 			return nil, nil, err
 		}
-
+		duration = secondsDuration(*seconds)
+	} else if s.Timestamp != nil {
+		duration = s.Timestamp.Sub(clock.Now())
 	} else if s.TimestampPath != nil {
-		// Validate the path exists
-		_, err := s.TimestampPath.GetTime(input)
+		timestamp, err := s.TimestampPath.GetTime(input)
 		if err != nil {
 			return nil, nil, err
 		}
+		duration = timestamp.Sub(clock.Now())
 	}
 
-	// Always sleep the same amount of time, as this is a simulation
-	time.Sleep(50 * time.Millisecond)
+	// Timestamps in the past do not wait
+	if duration > 0 {
+		if err := clock.Sleep(ctx, duration); err != nil {
+			return nil, nil, err
+		}
+	}
 
 	return input, nextState(s.Next, s.End), nil
 }
 
+func secondsDuration(seconds float64) time.Duration {
+	return time.Duration(seconds * float64(time.Second))
+}
+
 func (s *WaitState) Execute(ctx context.Context, input interface{}) (output interface{}, next *string, err error) {
 	return processError(s,
 		inputOutput(
@@ -62,7 +75,7 @@ func (s *WaitState) Execute(ctx context.Context, input interface{}) (output inte
 }
 
 func (s *WaitState) Validate() error {
-	s.SetType(to.Strp("Wait"))
+	defaultType(s, "Wait")
 
 	if err := ValidateNameAndType(s); err != nil {
 		return fmt.Errorf("%v %v", errorPrefix(s), err)
@@ -70,7 +83,7 @@ func (s *WaitState) Validate() error {
 
 	// Next xor End
 	if err := endValid(s.Next, s.End); err != nil {
-		return fmt.Errorf("%v %v", errorPrefix(s), err)
+		return invalidField(s, "Next", err)
 	}
 
 	exactly_one := []bool{
@@ -88,7 +101,7 @@ func (s *WaitState) Validate() error {
 	}
 
 	if count != 1 {
-		return fmt.Errorf("%v Exactly One (Seconds,SecondsPath,TimeStamp,TimeStampPath)", errorPrefix(s))
+		return invalidField(s, "Seconds", fmt.Errorf("Exactly One (Seconds,SecondsPath,TimeStamp,TimeStampPath)"))
 	}
 
 	return nil
@@ -1,7 +1,9 @@
 package machine
 
 import (
+	"context"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
 )
@@ -34,3 +36,32 @@ func Test_WaitState_SecondsPath(t *testing.T) {
 	_, _, err = state.Execute(nil, map[string]interface{}{})
 	assert.Error(t, err)
 }
+
+func Test_WaitState_AdvancesClock(t *testing.T) {
+	start := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	state := parseWaitState([]byte(`{"Seconds": 10, "Next": "Public"}`), t)
+	clock := NewVirtualClock(start)
+	_, _, err := state.Execute(withClock(context.Background(), clock), map[string]interface{}{})
+	assert.NoError(t, err)
+	assert.Equal(t, start.Add(10*time.Second), clock.Now())
+
+	state = parseWaitState([]byte(`{"SecondsPath": "$.wait", "Next": "Public"}`), t)
+	clock = NewVirtualClock(start)
+	_, _, err = state.Execute(withClock(context.Background(), clock), map[string]interface{}{"wait": 2.5})
+	assert.NoError(t, err)
+	assert.Equal(t, start.Add(2500*time.Millisecond), clock.Now())
+
+	state = parseWaitState([]byte(`{"TimestampPath": "$.until", "Next": "Public"}`), t)
+	clock = NewVirtualClock(start)
+	_, _, err = state.Execute(withClock(context.Background(), clock), map[string]interface{}{"until": "2020-01-01T01:00:00Z"})
+	assert.NoError(t, err)
+	assert.Equal(t, start.Add(time.Hour), clock.Now())
+
+	// Timestamp in the past does not wait
+	state = parseWaitState([]byte(`{"Timestamp": "2019-01-01T00:00:00Z", "Next": "Public"}`), t)
+	clock = NewVirtualClock(start)
+	_, _, err = state.Execute(withClock(context.Background(), clock), map[string]interface{}{})
+	assert.NoError(t, err)
+	assert.Equal(t, start, clock.Now())
+}
@@ -0,0 +1,537 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/sfn"
+	"github.com/cleardataeng/step/machine"
+	"github.com/cleardataeng/step/utils/to"
+)
+
+// StartSyncExecutionInput is the StartSyncExecution request, which this version of the SDK predates
+type StartSyncExecutionInput struct {
+	Input           *string `locationName:"input" type:"string"`
+	Name            *string `locationName:"name" type:"string"`
+	StateMachineArn *string `locationName:"stateMachineArn" type:"string"`
+}
+
+// StartSyncExecutionOutput is the StartSyncExecution response
+type StartSyncExecutionOutput struct {
+	Cause           *string    `locationName:"cause" type:"string"`
+	Error           *string    `locationName:"error" type:"string"`
+	ExecutionArn    *string    `locationName:"executionArn" type:"string"`
+	Input           *string    `locationName:"input" type:"string"`
+	Name            *string    `locationName:"name" type:"string"`
+	Output          *string    `locationName:"output" type:"string"`
+	StartDate       *time.Time `locationName:"startDate" type:"timestamp"`
+	StateMachineArn *string    `locationName:"stateMachineArn" type:"string"`
+	Status          *string    `locationName:"status" type:"string"`
+	StopDate        *time.Time `locationName:"stopDate" type:"timestamp"`
+}
+
+const defaultMaxResults = 100
+
+////////
+// State Machines
+////////
+
+func (s *Server) CreateStateMachine(in *sfn.CreateStateMachineInput) (*sfn.CreateStateMachineOutput, error) {
+	if err := validDefinition(in.Definition); err != nil {
+		return nil, err
+	}
+
+	smType := sfn.StateMachineTypeStandard
+	if in.Type != nil {
+		smType = *in.Type
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	arn := fmt.Sprintf("arn:aws:states:%v:%v:stateMachine:%v", s.Region, s.AccountID, to.Strs(in.Name))
+	if existing, ok := s.machines[arn]; ok {
+		// Creating an identical State Machine is idempotent
+		if existing.definition != to.Strs(in.Definition) || existing.smType != smType {
+			return nil, awserr.New(sfn.ErrCodeStateMachineAlreadyExists, fmt.Sprintf("State Machine Already Exists: '%v'", arn), nil)
+		}
+		return &sfn.CreateStateMachineOutput{StateMachineArn: &existing.arn, CreationDate: &existing.created}, nil
+	}
+
+	sm := &stateMachine{
+		arn:        arn,
+		name:       to.Strs(in.Name),
+		definition: to.Strs(in.Definition),
+		roleArn:    to.Strs(in.RoleArn),
+		smType:     smType,
+		created:    time.Now(),
+	}
+	s.machines[arn] = sm
+
+	return &sfn.CreateStateMachineOutput{StateMachineArn: &sm.arn, CreationDate: &sm.created}, nil
+}
+
+func (s *Server) UpdateStateMachine(in *sfn.UpdateStateMachineInput) (*sfn.UpdateStateMachineOutput, error) {
+	if in.Definition != nil {
+		if err := validDefinition(in.Definition); err != nil {
+			return nil, err
+		}
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	sm, err := s.findStateMachine(in.StateMachineArn)
+	if err != nil {
+		return nil, err
+	}
+
+	if in.Definition != nil {
+		sm.definition = *in.Definition
+	}
+
+	if in.RoleArn != nil {
+		sm.roleArn = *in.RoleArn
+	}
+
+	return &sfn.UpdateStateMachineOutput{UpdateDate: to.Timep(time.Now())}, nil
+}
+
+func (s *Server) DescribeStateMachine(in *sfn.DescribeStateMachineInput) (*sfn.DescribeStateMachineOutput, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	sm, err := s.findStateMachine(in.StateMachineArn)
+	if err != nil {
+		return nil, err
+	}
+
+	return &sfn.DescribeStateMachineOutput{
+		StateMachineArn: to.Strp(sm.arn),
+		Name:            to.Strp(sm.name),
+		Definition:      to.Strp(sm.definition),
+		RoleArn:         to.Strp(sm.roleArn),
+		Type:            to.Strp(sm.smType),
+		Status:          to.Strp(sfn.StateMachineStatusActive),
+		CreationDate:    to.Timep(sm.created),
+	}, nil
+}
+
+// findStateMachine returns the State Machine with arn, s.mu must be held
+func (s *Server) findStateMachine(arn *string) (*stateMachine, error) {
+	sm, ok := s.machines[to.Strs(arn)]
+	if !ok {
+		return nil, awserr.New(sfn.ErrCodeStateMachineDoesNotExist, fmt.Sprintf("State Machine Does Not Exist: '%v'", to.Strs(arn)), nil)
+	}
+	return sm, nil
+}
+
+func validDefinition(definition *string) error {
+	sm, err := machine.FromJSON([]byte(to.Strs(definition)))
+	if err == nil {
+		err = sm.Validate()
+	}
+
+	if err != nil {
+		return awserr.New(sfn.ErrCodeInvalidDefinition, fmt.Sprintf("Invalid State Machine Definition: '%v'", err), nil)
+	}
+	return nil
+}
+
+////////
+// Executions
+////////
+
+func (s *Server) StartExecution(in *sfn.StartExecutionInput) (*sfn.StartExecutionOutput, error) {
+	e, err := s.start(in.StateMachineArn, in.Name, in.Input)
+	if err != nil {
+		return nil, err
+	}
+
+	return &sfn.StartExecutionOutput{ExecutionArn: to.Strp(e.arn), StartDate: to.Timep(e.startDate)}, nil
+}
+
+// StartSyncExecution runs an Express State Machine and returns once its Execution completes
+func (s *Server) StartSyncExecution(in *StartSyncExecutionInput) (*StartSyncExecutionOutput, error) {
+	smArn, _ := splitTestCase(in.StateMachineArn)
+
+	s.mu.Lock()
+	sm, err := s.findStateMachine(smArn)
+	s.mu.Unlock()
+	if err != nil {
+		return nil, err
+	}
+
+	if sm.smType != sfn.StateMachineTypeExpress {
+		return nil, awserr.New(sfn.ErrCodeStateMachineTypeNotSupported, "StartSyncExecution requires an EXPRESS State Machine", nil)
+	}
+
+	e, err := s.start(in.StateMachineArn, in.Name, in.Input)
+	if err != nil {
+		return nil, err
+	}
+	<-e.done
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	output, errorName, cause := result(e)
+	return &StartSyncExecutionOutput{
+		ExecutionArn:    to.Strp(e.arn),
+		StateMachineArn: to.Strp(sm.arn),
+		Name:            to.Strp(e.name),
+		Input:           to.Strp(e.input),
+		Output:          output,
+		Error:           errorName,
+		Cause:           cause,
+		Status:          to.Strp(e.status),
+		StartDate:       to.Timep(e.startDate),
+		StopDate:        to.Timep(e.stopDate),
+	}, nil
+}
+
+// splitTestCase splits a Step Functions Local "<stateMachineArn>#<TestCase>" into its ARN and test case
+func splitTestCase(arn *string) (*string, string) {
+	parts := strings.SplitN(to.Strs(arn), "#", 2)
+	if len(parts) < 2 {
+		return arn, ""
+	}
+	return to.Strp(parts[0]), parts[1]
+}
+
+// start starts an Execution of a State Machine in the background
+func (s *Server) start(smArn *string, name *string, input *string) (*localExecution, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	smArn, testCase := splitTestCase(smArn)
+	sm, err := s.findStateMachine(smArn)
+	if err != nil {
+		return nil, err
+	}
+
+	if name == nil {
+		name = to.Strp(to.UUID())
+	}
+
+	arn := fmt.Sprintf("arn:aws:states:%v:%v:execution:%v:%v", s.Region, s.AccountID, sm.name, *name)
+	if _, ok := s.executions[arn]; ok {
+		return nil, awserr.New(sfn.ErrCodeExecutionAlreadyExists, fmt.Sprintf("Execution Already Exists: '%v'", arn), nil)
+	}
+
+	if input == nil {
+		input = to.Strp("{}")
+	}
+
+	var parsed map[string]interface{}
+	if err := json.Unmarshal([]byte(*input), &parsed); err != nil {
+		return nil, awserr.New(sfn.ErrCodeInvalidExecutionInput, fmt.Sprintf("Invalid Execution Input: '%v'", err), nil)
+	}
+
+	// A new StateMachine for every Execution so updates apply to the next Execution
+	local, err := machine.FromJSON([]byte(sm.definition))
+	if err != nil {
+		return nil, awserr.New(sfn.ErrCodeInvalidDefinition, err.Error(), nil)
+	}
+
+	if s.Resolver != nil {
+		local.SetResourceResolver(s.Resolver)
+	}
+
+	if testCase != "" {
+		if err := s.setMocks(local, sm.name, testCase); err != nil {
+			return nil, err
+		}
+	}
+
+	exec, err := local.StartWithOptions(context.Background(), parsed, &machine.ExecutionOptions{
+		Name:             *name,
+		ID:               arn,
+		StateMachineName: sm.name,
+		Lambda:           machine.LambdaOptions{Region: s.Region, AccountID: s.AccountID},
+		Clock:            machine.RealClock{}, // served Executions wait like in AWS
+	})
+	if err != nil {
+		return nil, awserr.New(sfn.ErrCodeInvalidExecutionInput, err.Error(), nil)
+	}
+
+	e := &localExecution{
+		arn:          arn,
+		name:         *name,
+		stateMachine: sm,
+		input:        *input,
+		exec:         exec,
+		status:       sfn.ExecutionStatusRunning,
+		startDate:    time.Now(),
+		done:         make(chan struct{}),
+	}
+	s.executions[arn] = e
+	s.started = append(s.started, e)
+
+	go s.wait(e)
+
+	return e, nil
+}
+
+// setMocks mocks the Tasks of a State Machine with the responses of a test case in Mocks
+func (s *Server) setMocks(local *machine.StateMachine, name string, testCase string) error {
+	if s.Mocks == nil {
+		return awserr.New("ValidationException", fmt.Sprintf("No Mock Config for TestCase '%v'", testCase), nil)
+	}
+
+	mocks, err := s.Mocks.TestCase(name, testCase)
+	if err == nil {
+		err = local.SetMocks(mocks)
+	}
+
+	if err != nil {
+		return awserr.New("ValidationException", err.Error(), nil)
+	}
+	return nil
+}
+
+// wait records the status of an Execution once it completes
+func (s *Server) wait(e *localExecution) {
+	err := e.exec.Wait()
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	e.stopDate = time.Now()
+	switch {
+	case e.exec.Aborted():
+		e.status = sfn.ExecutionStatusAborted
+	case e.exec.TimedOut():
+		e.status = sfn.ExecutionStatusTimedOut
+	case err != nil:
+		e.status = sfn.ExecutionStatusFailed
+	default:
+		e.status = sfn.ExecutionStatusSucceeded
+	}
+	close(e.done)
+}
+
+// result returns the output, or error and cause, of a completed Execution from its history
+func result(e *localExecution) (output *string, errorName *string, cause *string) {
+	if e.status == sfn.ExecutionStatusRunning {
+		return nil, nil, nil
+	}
+
+	for _, event := range e.exec.History() {
+		switch {
+		case event.ExecutionSucceededEventDetails != nil:
+			return event.ExecutionSucceededEventDetails.Output, nil, nil
+		case event.ExecutionFailedEventDetails != nil:
+			return nil, event.ExecutionFailedEventDetails.Error, event.ExecutionFailedEventDetails.Cause
+		case event.ExecutionAbortedEventDetails != nil:
+			return nil, event.ExecutionAbortedEventDetails.Error, event.ExecutionAbortedEventDetails.Cause
+		case event.ExecutionTimedOutEventDetails != nil:
+			return nil, event.ExecutionTimedOutEventDetails.Error, event.ExecutionTimedOutEventDetails.Cause
+		}
+	}
+	return nil, nil, nil
+}
+
+// findExecution returns the Execution with arn, s.mu must be held
+func (s *Server) findExecution(arn *string) (*localExecution, error) {
+	e, ok := s.executions[to.Strs(arn)]
+	if !ok {
+		return nil, awserr.New(sfn.ErrCodeExecutionDoesNotExist, fmt.Sprintf("Execution Does Not Exist: '%v'", to.Strs(arn)), nil)
+	}
+	return e, nil
+}
+
+func (s *Server) DescribeExecution(in *sfn.DescribeExecutionInput) (*sfn.DescribeExecutionOutput, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	e, err := s.findExecution(in.ExecutionArn)
+	if err != nil {
+		return nil, err
+	}
+
+	out := &sfn.DescribeExecutionOutput{
+		ExecutionArn:    to.Strp(e.arn),
+		StateMachineArn: to.Strp(e.stateMachine.arn),
+		Name:            to.Strp(e.name),
+		Input:           to.Strp(e.input),
+		Status:          to.Strp(e.status),
+		StartDate:       to.Timep(e.startDate),
+	}
+
+	if e.status != sfn.ExecutionStatusRunning {
+		out.StopDate = to.Timep(e.stopDate)
+		out.Output, _, _ = result(e)
+	}
+
+	return out, nil
+}
+
+// ListExecutions lists the most recent Executions first
+func (s *Server) ListExecutions(in *sfn.ListExecutionsInput) (*sfn.ListExecutionsOutput, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, err := s.findStateMachine(in.StateMachineArn); err != nil {
+		return nil, err
+	}
+
+	items := []*sfn.ExecutionListItem{}
+	for i := len(s.started) - 1; i >= 0; i-- {
+		e := s.started[i]
+		if e.stateMachine.arn != *in.StateMachineArn {
+			continue
+		}
+
+		if in.StatusFilter != nil && *in.StatusFilter != e.status {
+			continue
+		}
+
+		item := &sfn.ExecutionListItem{
+			ExecutionArn:    to.Strp(e.arn),
+			StateMachineArn: to.Strp(e.stateMachine.arn),
+			Name:            to.Strp(e.name),
+			Status:          to.Strp(e.status),
+			StartDate:       to.Timep(e.startDate),
+		}
+		if e.status != sfn.ExecutionStatusRunning {
+			item.StopDate = to.Timep(e.stopDate)
+		}
+		items = append(items, item)
+	}
+
+	start, end, next, err := page(len(items), in.MaxResults, in.NextToken)
+	if err != nil {
+		return nil, err
+	}
+
+	return &sfn.ListExecutionsOutput{Executions: items[start:end], NextToken: next}, nil
+}
+
+func (s *Server) GetExecutionHistory(in *sfn.GetExecutionHistoryInput) (*sfn.GetExecutionHistoryOutput, error) {
+	s.mu.Lock()
+	e, err := s.findExecution(in.ExecutionArn)
+	s.mu.Unlock()
+	if err != nil {
+		return nil, err
+	}
+
+	events := e.exec.History()
+	if in.ReverseOrder != nil && *in.ReverseOrder {
+		for i, j := 0, len(events)-1; i < j; i, j = i+1, j-1 {
+			events[i], events[j] = events[j], events[i]
+		}
+	}
+
+	start, end, next, err := page(len(events), in.MaxResults, in.NextToken)
+	if err != nil {
+		return nil, err
+	}
+
+	return &sfn.GetExecutionHistoryOutput{Events: events[start:end], NextToken: next}, nil
+}
+
+// StopExecution aborts a running Execution and waits for it to end
+func (s *Server) StopExecution(in *sfn.StopExecutionInput) (*sfn.StopExecutionOutput, error) {
+	s.mu.Lock()
+	e, err := s.findExecution(in.ExecutionArn)
+	s.mu.Unlock()
+	if err != nil {
+		return nil, err
+	}
+
+	// Executions that have already completed keep their status
+	e.exec.Stop(to.Strs(in.Error), to.Strs(in.Cause))
+	<-e.done
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return &sfn.StopExecutionOutput{StopDate: to.Timep(e.stopDate)}, nil
+}
+
+// page returns the range of a page of n results, and the NextToken of the next page
+func page(n int, maxResults *int64, nextToken *string) (int, int, *string, error) {
+	start := 0
+	if nextToken != nil {
+		var err error
+		start, err = strconv.Atoi(*nextToken)
+		if err != nil || start < 0 || start > n {
+			return 0, 0, nil, awserr.New(sfn.ErrCodeInvalidToken, fmt.Sprintf("Invalid Token: '%v'", *nextToken), nil)
+		}
+	}
+
+	size := defaultMaxResults
+	if maxResults != nil && *maxResults > 0 {
+		size = int(*maxResults)
+	}
+
+	end := start + size
+	if end >= n {
+		return start, n, nil, nil
+	}
+	return start, end, to.Strp(strconv.Itoa(end)), nil
+}
+
+////////
+// Task Tokens
+////////
+
+func (s *Server) SendTaskSuccess(in *sfn.SendTaskSuccessInput) (*sfn.SendTaskSuccessOutput, error) {
+	var output interface{}
+	if err := json.Unmarshal([]byte(to.Strs(in.Output)), &output); err != nil {
+		return nil, awserr.New(sfn.ErrCodeInvalidOutput, fmt.Sprintf("Invalid Output: '%v'", err), nil)
+	}
+
+	err := s.sendTask(in.TaskToken, func(exec *machine.Execution, token string) error {
+		return exec.SendTaskSuccess(token, output)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &sfn.SendTaskSuccessOutput{}, nil
+}
+
+func (s *Server) SendTaskFailure(in *sfn.SendTaskFailureInput) (*sfn.SendTaskFailureOutput, error) {
+	err := s.sendTask(in.TaskToken, func(exec *machine.Execution, token string) error {
+		return exec.SendTaskFailure(token, to.Strs(in.Error), to.Strs(in.Cause))
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &sfn.SendTaskFailureOutput{}, nil
+}
+
+func (s *Server) SendTaskHeartbeat(in *sfn.SendTaskHeartbeatInput) (*sfn.SendTaskHeartbeatOutput, error) {
+	err := s.sendTask(in.TaskToken, func(exec *machine.Execution, token string) error {
+		return exec.SendTaskHeartbeat(token)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &sfn.SendTaskHeartbeatOutput{}, nil
+}
+
+// sendTask calls send with the running Execution waiting on the token
+func (s *Server) sendTask(token *string, send func(*machine.Execution, string) error) error {
+	s.mu.Lock()
+	running := []*machine.Execution{}
+	for _, e := range s.started {
+		if e.status == sfn.ExecutionStatusRunning {
+			running = append(running, e.exec)
+		}
+	}
+	s.mu.Unlock()
+
+	for _, exec := range running {
+		if send(exec, to.Strs(token)) == nil {
+			return nil
+		}
+	}
+
+	return awserr.New(sfn.ErrCodeTaskDoesNotExist, fmt.Sprintf("Task Does Not Exist: '%v'", to.Strs(token)), nil)
+}
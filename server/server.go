@@ -0,0 +1,161 @@
+// Package server serves the Step Functions API, the AWS JSON 1.0 AWSStepFunctions.* protocol,
+// for State Machines executed by the machine package. SDK clients like sfniface.SFNAPI can use
+// it as their endpoint instead of the Step Functions Local jar.
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"reflect"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/private/protocol/json/jsonutil"
+	"github.com/cleardataeng/step/machine"
+)
+
+const (
+	targetPrefix = "AWSStepFunctions."
+	contentType  = "application/x-amz-json-1.0"
+)
+
+// operations are the methods of Server served as AWSStepFunctions.<Operation>
+var operations = map[string]bool{
+	"CreateStateMachine":   true,
+	"UpdateStateMachine":   true,
+	"DescribeStateMachine": true,
+	"StartExecution":       true,
+	"StartSyncExecution":   true,
+	"DescribeExecution":    true,
+	"ListExecutions":       true,
+	"GetExecutionHistory":  true,
+	"StopExecution":        true,
+	"SendTaskSuccess":      true,
+	"SendTaskFailure":      true,
+	"SendTaskHeartbeat":    true,
+}
+
+// Server is an in memory Step Functions API, its methods can also be called in process
+type Server struct {
+	Region    string
+	AccountID string
+
+	// Resolver resolves the Task Resources of every State Machine, e.g. machine.Resources with machine.GoHandlers
+	Resolver machine.ResourceResolver
+
+	// Mocks are the test cases of Executions started with a "<stateMachineArn>#<TestCase>" ARN
+	Mocks *machine.MockConfig
+
+	mu         sync.Mutex
+	machines   map[string]*stateMachine   // by ARN
+	executions map[string]*localExecution // by ARN
+	started    []*localExecution          // in the order they started
+}
+
+type stateMachine struct {
+	arn        string
+	name       string
+	definition string
+	roleArn    string
+	smType     string
+	created    time.Time
+}
+
+type localExecution struct {
+	arn          string
+	name         string
+	stateMachine *stateMachine
+	input        string
+
+	exec      *machine.Execution
+	status    string
+	startDate time.Time
+	stopDate  time.Time
+	done      chan struct{} // closed once status is final
+}
+
+// New returns a Server with the default local Region and AccountID
+func New(resolver machine.ResourceResolver) *Server {
+	return &Server{
+		Region:     "us-east-1",
+		AccountID:  "000000000000",
+		Resolver:   resolver,
+		machines:   map[string]*stateMachine{},
+		executions: map[string]*localExecution{},
+	}
+}
+
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeError(w, awserr.New("UnknownOperationException", "Step Functions requests must be POST", nil))
+		return
+	}
+
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		writeError(w, awserr.New("SerializationException", err.Error(), nil))
+		return
+	}
+
+	out, err := s.call(strings.TrimPrefix(r.Header.Get("X-Amz-Target"), targetPrefix), body)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	raw, err := jsonutil.BuildJSON(out)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", contentType)
+	w.Write(raw)
+}
+
+// call decodes the body into the input of the operations method and calls it
+func (s *Server) call(operation string, body []byte) (interface{}, error) {
+	if !operations[operation] {
+		return nil, awserr.New("UnknownOperationException", fmt.Sprintf("Unknown operation %q", operation), nil)
+	}
+
+	method := reflect.ValueOf(s).MethodByName(operation)
+	in := reflect.New(method.Type().In(0).Elem())
+
+	if len(bytes.TrimSpace(body)) > 0 {
+		if err := jsonutil.UnmarshalJSON(in.Interface(), bytes.NewReader(body)); err != nil {
+			return nil, awserr.New("SerializationException", err.Error(), nil)
+		}
+	}
+
+	if v, ok := in.Interface().(interface{ Validate() error }); ok {
+		if err := v.Validate(); err != nil {
+			return nil, awserr.New("ValidationException", err.Error(), nil)
+		}
+	}
+
+	results := method.Call([]reflect.Value{in})
+	if err, _ := results[1].Interface().(error); err != nil {
+		return nil, err
+	}
+	return results[0].Interface(), nil
+}
+
+// writeError writes an AWS JSON error, the SDK reads the code from __type
+func writeError(w http.ResponseWriter, err error) {
+	status, code, message := http.StatusInternalServerError, "InternalFailure", err.Error()
+	if aerr, ok := err.(awserr.Error); ok {
+		status, code, message = http.StatusBadRequest, aerr.Code(), aerr.Message()
+	}
+
+	raw, _ := json.Marshal(map[string]string{"__type": code, "message": message})
+
+	w.Header().Set("Content-Type", contentType)
+	w.WriteHeader(status)
+	w.Write(raw)
+}
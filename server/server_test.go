@@ -0,0 +1,401 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/sfn"
+	"github.com/cleardataeng/step/execution"
+	"github.com/cleardataeng/step/machine"
+	"github.com/cleardataeng/step/utils/to"
+	"github.com/stretchr/testify/assert"
+)
+
+const definition = `{
+  "StartAt": "Approve",
+  "States": {
+    "Approve": {
+      "Type": "Task",
+      "Resource": "arn:aws:states:::lambda:invoke.waitForTaskToken",
+      "Parameters": {"FunctionName": "tokens", "Payload": {"token.$": "$$.Task.Token"}},
+      "ResultPath": "$.approval",
+      "Next": "Double"
+    },
+    "Double": {
+      "Type": "Task",
+      "Resource": "arn:aws:lambda:us-east-1:000000000000:function:double",
+      "ResultPath": "$.count",
+      "End": true
+    }
+  }
+}`
+
+// testServer serves a Server with Go handlers, sending the task tokens it is given to tokens
+func testServer(t *testing.T) (*sfn.SFN, *httptest.Server, chan string) {
+	tokens := make(chan string, 10)
+
+	resources := machine.NewResources().Handle("*", machine.GoHandlers{
+		"tokens": func(_ context.Context, input map[string]interface{}) (interface{}, error) {
+			tokens <- input["token"].(string)
+			return nil, nil
+		},
+		"double": func(_ context.Context, input map[string]interface{}) (interface{}, error) {
+			return input["count"].(float64) * 2, nil
+		},
+	})
+
+	ts := httptest.NewServer(New(resources))
+
+	sess, err := session.NewSession(&aws.Config{
+		Region:      aws.String("us-east-1"),
+		Endpoint:    aws.String(ts.URL),
+		Credentials: credentials.NewStaticCredentials("local", "local", ""),
+	})
+	assert.NoError(t, err)
+
+	return sfn.New(sess), ts, tokens
+}
+
+func createStateMachine(t *testing.T, client *sfn.SFN, smType string) *string {
+	out, err := client.CreateStateMachine(&sfn.CreateStateMachineInput{
+		Name:       aws.String("test"),
+		Definition: aws.String(definition),
+		RoleArn:    aws.String("arn:aws:iam::000000000000:role/test"),
+		Type:       aws.String(smType),
+	})
+	assert.NoError(t, err)
+	return out.StateMachineArn
+}
+
+func errorCode(err error) string {
+	if aerr, ok := err.(awserr.Error); ok {
+		return aerr.Code()
+	}
+	return ""
+}
+
+func Test_Server_Execution(t *testing.T) {
+	client, ts, tokens := testServer(t)
+	defer ts.Close()
+
+	arn := createStateMachine(t, client, sfn.StateMachineTypeStandard)
+	assert.Equal(t, "arn:aws:states:us-east-1:000000000000:stateMachine:test", *arn)
+
+	described, err := client.DescribeStateMachine(&sfn.DescribeStateMachineInput{StateMachineArn: arn})
+	assert.NoError(t, err)
+	assert.Equal(t, definition, *described.Definition)
+	assert.Equal(t, "STANDARD", *described.Type)
+
+	exec, err := execution.StartExecution(client, arn, aws.String("first"), map[string]interface{}{"count": 2})
+	assert.NoError(t, err)
+	assert.Equal(t, "arn:aws:states:us-east-1:000000000000:execution:test:first", *exec.ExecutionArn)
+
+	running, err := client.DescribeExecution(&sfn.DescribeExecutionInput{ExecutionArn: exec.ExecutionArn})
+	assert.NoError(t, err)
+	assert.Equal(t, "RUNNING", *running.Status)
+	assert.Nil(t, running.StopDate)
+
+	_, err = client.SendTaskSuccess(&sfn.SendTaskSuccessInput{TaskToken: aws.String(<-tokens), Output: aws.String(`{"approved": true}`)})
+	assert.NoError(t, err)
+
+	exec.WaitForExecution(client, 0, func(_ *execution.Execution, _ *execution.StateDetails, err error) error {
+		return err
+	})
+	assert.Equal(t, "SUCCEEDED", *exec.Status)
+	assert.JSONEq(t, `{"count": 4, "approval": {"approved": true}}`, *exec.Output)
+
+	details, err := exec.GetStateDetails(client)
+	assert.NoError(t, err)
+	assert.Equal(t, "Double", *details.LastStateName)
+
+	// Paginated history
+	events := []*sfn.HistoryEvent{}
+	err = client.GetExecutionHistoryPages(&sfn.GetExecutionHistoryInput{
+		ExecutionArn: exec.ExecutionArn,
+		MaxResults:   aws.Int64(3),
+	}, func(page *sfn.GetExecutionHistoryOutput, _ bool) bool {
+		events = append(events, page.Events...)
+		return true
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, "ExecutionStarted", *events[0].Type)
+	assert.Equal(t, "ExecutionSucceeded", *events[len(events)-1].Type)
+	assert.Equal(t, int64(len(events)), *events[len(events)-1].Id)
+
+	// Updates apply to the next Execution
+	_, err = client.UpdateStateMachine(&sfn.UpdateStateMachineInput{
+		StateMachineArn: arn,
+		Definition:      aws.String(`{"StartAt": "Pass", "States": {"Pass": {"Type": "Pass", "End": true}}}`),
+	})
+	assert.NoError(t, err)
+
+	second, err := execution.StartExecution(client, arn, nil, map[string]interface{}{})
+	assert.NoError(t, err)
+
+	executions, err := execution.ExecutionsAfter(client, arn, nil, *exec.StartDate)
+	assert.NoError(t, err)
+	assert.Equal(t, 2, len(executions))
+	assert.Equal(t, *second.ExecutionArn, *executions[0].ExecutionArn)
+}
+
+func Test_Server_ListExecutions(t *testing.T) {
+	client, ts, _ := testServer(t)
+	defer ts.Close()
+
+	arn := createStateMachine(t, client, sfn.StateMachineTypeStandard)
+	for _, name := range []string{"a", "b", "c"} {
+		_, err := client.StartExecution(&sfn.StartExecutionInput{StateMachineArn: arn, Name: aws.String(name)})
+		assert.NoError(t, err)
+	}
+
+	names := []string{}
+	err := client.ListExecutionsPages(&sfn.ListExecutionsInput{
+		StateMachineArn: arn,
+		StatusFilter:    aws.String("RUNNING"),
+		MaxResults:      aws.Int64(2),
+	}, func(page *sfn.ListExecutionsOutput, _ bool) bool {
+		for _, e := range page.Executions {
+			names = append(names, *e.Name)
+		}
+		return true
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"c", "b", "a"}, names)
+
+	out, err := client.ListExecutions(&sfn.ListExecutionsInput{StateMachineArn: arn, StatusFilter: aws.String("SUCCEEDED")})
+	assert.NoError(t, err)
+	assert.Equal(t, 0, len(out.Executions))
+
+	_, err = client.ListExecutions(&sfn.ListExecutionsInput{StateMachineArn: arn, NextToken: aws.String("x")})
+	assert.Equal(t, "InvalidToken", errorCode(err))
+}
+
+func Test_Server_StopExecution(t *testing.T) {
+	client, ts, tokens := testServer(t)
+	defer ts.Close()
+
+	arn := createStateMachine(t, client, sfn.StateMachineTypeStandard)
+	started, err := client.StartExecution(&sfn.StartExecutionInput{StateMachineArn: arn})
+	assert.NoError(t, err)
+	token := <-tokens
+
+	_, err = client.SendTaskHeartbeat(&sfn.SendTaskHeartbeatInput{TaskToken: aws.String(token)})
+	assert.NoError(t, err)
+
+	_, err = client.StopExecution(&sfn.StopExecutionInput{
+		ExecutionArn: started.ExecutionArn,
+		Error:        aws.String("Stopped"),
+		Cause:        aws.String("by test"),
+	})
+	assert.NoError(t, err)
+
+	described, err := client.DescribeExecution(&sfn.DescribeExecutionInput{ExecutionArn: started.ExecutionArn})
+	assert.NoError(t, err)
+	assert.Equal(t, "ABORTED", *described.Status)
+	assert.NotNil(t, described.StopDate)
+
+	history, err := client.GetExecutionHistory(&sfn.GetExecutionHistoryInput{ExecutionArn: started.ExecutionArn, ReverseOrder: aws.Bool(true)})
+	assert.NoError(t, err)
+	assert.Equal(t, "ExecutionAborted", *history.Events[0].Type)
+	assert.Equal(t, "by test", *history.Events[0].ExecutionAbortedEventDetails.Cause)
+
+	// The token is no longer waiting
+	_, err = client.SendTaskFailure(&sfn.SendTaskFailureInput{TaskToken: aws.String(token)})
+	assert.Equal(t, "TaskDoesNotExist", errorCode(err))
+}
+
+func Test_Server_Errors(t *testing.T) {
+	client, ts, _ := testServer(t)
+	defer ts.Close()
+
+	_, err := client.CreateStateMachine(&sfn.CreateStateMachineInput{
+		Name:       aws.String("invalid"),
+		Definition: aws.String(`{"StartAt": "Missing", "States": {}}`),
+		RoleArn:    aws.String("arn:aws:iam::000000000000:role/test"),
+	})
+	assert.Equal(t, "InvalidDefinition", errorCode(err))
+
+	arn := createStateMachine(t, client, sfn.StateMachineTypeStandard)
+
+	// Creating the same State Machine again is idempotent
+	assert.Equal(t, arn, createStateMachine(t, client, sfn.StateMachineTypeStandard))
+
+	_, err = client.CreateStateMachine(&sfn.CreateStateMachineInput{
+		Name:       aws.String("test"),
+		Definition: aws.String(`{"StartAt": "Pass", "States": {"Pass": {"Type": "Pass", "End": true}}}`),
+		RoleArn:    aws.String("arn:aws:iam::000000000000:role/test"),
+	})
+	assert.Equal(t, "StateMachineAlreadyExists", errorCode(err))
+
+	_, err = client.DescribeStateMachine(&sfn.DescribeStateMachineInput{StateMachineArn: aws.String("arn:aws:states:us-east-1:000000000000:stateMachine:missing")})
+	assert.Equal(t, "StateMachineDoesNotExist", errorCode(err))
+
+	_, err = client.StartExecution(&sfn.StartExecutionInput{StateMachineArn: arn, Input: aws.String("not json")})
+	assert.Equal(t, "InvalidExecutionInput", errorCode(err))
+
+	_, err = client.StartExecution(&sfn.StartExecutionInput{StateMachineArn: arn, Name: aws.String("a")})
+	assert.NoError(t, err)
+
+	_, err = client.StartExecution(&sfn.StartExecutionInput{StateMachineArn: arn, Name: aws.String("a")})
+	assert.Equal(t, "ExecutionAlreadyExists", errorCode(err))
+
+	_, err = client.DescribeExecution(&sfn.DescribeExecutionInput{ExecutionArn: aws.String("arn:aws:states:us-east-1:000000000000:execution:test:missing")})
+	assert.Equal(t, "ExecutionDoesNotExist", errorCode(err))
+
+	_, err = client.SendTaskSuccess(&sfn.SendTaskSuccessInput{TaskToken: aws.String("unknown"), Output: aws.String("not json")})
+	assert.Equal(t, "InvalidOutput", errorCode(err))
+
+	_, err = client.SendTaskSuccess(&sfn.SendTaskSuccessInput{TaskToken: aws.String("unknown"), Output: aws.String("{}")})
+	assert.Equal(t, "TaskDoesNotExist", errorCode(err))
+
+	out := post(t, ts, "StartSyncExecution", `{"stateMachineArn": "`+*arn+`"}`)
+	assert.Equal(t, "StateMachineTypeNotSupported", out["__type"])
+}
+
+func Test_Server_StartSyncExecution(t *testing.T) {
+	client, ts, tokens := testServer(t)
+	defer ts.Close()
+
+	arn := createStateMachine(t, client, sfn.StateMachineTypeExpress)
+
+	// Callback Tasks complete while the request waits
+	go func() {
+		client.SendTaskSuccess(&sfn.SendTaskSuccessInput{TaskToken: aws.String(<-tokens), Output: aws.String(`true`)})
+	}()
+
+	out := post(t, ts, "StartSyncExecution", `{"stateMachineArn": "`+*arn+`", "input": "{\"count\": 3}"}`)
+	assert.Equal(t, "SUCCEEDED", out["status"])
+	assert.JSONEq(t, `{"count": 6, "approval": true}`, out["output"].(string))
+	assert.IsType(t, 0.0, out["startDate"])
+
+	out = post(t, ts, "Unknown", `{}`)
+	assert.Equal(t, "UnknownOperationException", out["__type"])
+}
+
+// post calls an operation without the SDK, which predates StartSyncExecution
+func post(t *testing.T, ts *httptest.Server, operation string, body string) map[string]interface{} {
+	req, err := http.NewRequest(http.MethodPost, ts.URL, strings.NewReader(body))
+	assert.NoError(t, err)
+	req.Header.Set("X-Amz-Target", targetPrefix+operation)
+	req.Header.Set("Content-Type", contentType)
+
+	resp, err := http.DefaultClient.Do(req)
+	assert.NoError(t, err)
+	defer resp.Body.Close()
+
+	raw, err := ioutil.ReadAll(resp.Body)
+	assert.NoError(t, err)
+
+	out := map[string]interface{}{}
+	assert.NoError(t, json.Unmarshal(raw, &out))
+	return out
+}
+
+func Test_Server_InProcess(t *testing.T) {
+	s := New(nil)
+
+	_, err := s.CreateStateMachine(&sfn.CreateStateMachineInput{
+		Name:       aws.String("pass"),
+		Definition: aws.String(`{"StartAt": "Pass", "States": {"Pass": {"Type": "Pass", "Result": "done", "End": true}}}`),
+		Type:       aws.String(sfn.StateMachineTypeExpress),
+	})
+	assert.NoError(t, err)
+
+	out, err := s.StartSyncExecution(&StartSyncExecutionInput{StateMachineArn: to.Strp("arn:aws:states:us-east-1:000000000000:stateMachine:pass")})
+	assert.NoError(t, err)
+	assert.Equal(t, "SUCCEEDED", *out.Status)
+	assert.Equal(t, `"done"`, *out.Output)
+}
+
+func Test_Server_Mocks_TestCase(t *testing.T) {
+	client, ts, _ := testServer(t)
+	defer ts.Close()
+
+	config, err := machine.MockConfigFromJSON([]byte(`{
+    "StateMachines": {"test": {"TestCases": {"Approved": {"Approve": "Approved", "Double": "Doubled"}}}},
+    "MockedResponses": {
+      "Approved": {"0": {"Return": {"approved": true}}},
+      "Doubled": {"0": {"Return": 42}}
+    }
+  }`))
+	assert.NoError(t, err)
+	ts.Config.Handler.(*Server).Mocks = config
+
+	arn := createStateMachine(t, client, sfn.StateMachineTypeStandard)
+
+	// The mocked Approve does not wait for its task token
+	start, err := client.StartExecution(&sfn.StartExecutionInput{StateMachineArn: aws.String(*arn + "#Approved")})
+	assert.NoError(t, err)
+
+	exec := &execution.Execution{ExecutionArn: start.ExecutionArn}
+	exec.WaitForExecution(client, 0, func(_ *execution.Execution, _ *execution.StateDetails, err error) error {
+		return err
+	})
+	assert.Equal(t, "SUCCEEDED", *exec.Status)
+	assert.JSONEq(t, `{"approval": {"approved": true}, "count": 42}`, *exec.Output)
+
+	_, err = client.StartExecution(&sfn.StartExecutionInput{StateMachineArn: aws.String(*arn + "#Unknown")})
+	assert.Equal(t, "ValidationException", errorCode(err))
+}
+
+func Test_Server_Wait_RealClock(t *testing.T) {
+	client, ts, _ := testServer(t)
+	defer ts.Close()
+
+	out, err := client.CreateStateMachine(&sfn.CreateStateMachineInput{
+		Name:       aws.String("wait"),
+		Definition: aws.String(`{"StartAt": "Wait", "States": {"Wait": {"Type": "Wait", "Seconds": 1, "End": true}}}`),
+		RoleArn:    aws.String("arn:aws:iam::000000000000:role/test"),
+	})
+	assert.NoError(t, err)
+
+	started := time.Now()
+	exec, err := execution.StartExecution(client, out.StateMachineArn, aws.String("wait"), map[string]interface{}{})
+	assert.NoError(t, err)
+
+	// Served Executions actually wait instead of using a virtual clock
+	described, err := client.DescribeExecution(&sfn.DescribeExecutionInput{ExecutionArn: exec.ExecutionArn})
+	assert.NoError(t, err)
+	assert.Equal(t, "RUNNING", *described.Status)
+
+	exec.WaitForExecution(client, 0, func(_ *execution.Execution, _ *execution.StateDetails, err error) error {
+		return err
+	})
+	assert.Equal(t, "SUCCEEDED", *exec.Status)
+	assert.True(t, time.Since(started) >= time.Second)
+}
+
+func Test_Server_TimedOut(t *testing.T) {
+	client, ts, _ := testServer(t)
+	defer ts.Close()
+
+	out, err := client.CreateStateMachine(&sfn.CreateStateMachineInput{
+		Name:       aws.String("timeout"),
+		Definition: aws.String(`{"TimeoutSeconds": 1, "StartAt": "Wait", "States": {"Wait": {"Type": "Wait", "Seconds": 5, "End": true}}}`),
+		RoleArn:    aws.String("arn:aws:iam::000000000000:role/test"),
+	})
+	assert.NoError(t, err)
+
+	exec, err := execution.StartExecution(client, out.StateMachineArn, aws.String("slow"), map[string]interface{}{})
+	assert.NoError(t, err)
+
+	exec.WaitForExecution(client, 0, func(_ *execution.Execution, _ *execution.StateDetails, err error) error {
+		return err
+	})
+	assert.Equal(t, "TIMED_OUT", *exec.Status)
+
+	history, err := client.GetExecutionHistory(&sfn.GetExecutionHistoryInput{ExecutionArn: exec.ExecutionArn, ReverseOrder: aws.Bool(true)})
+	assert.NoError(t, err)
+	assert.Equal(t, "ExecutionTimedOut", *history.Events[0].Type)
+	assert.Equal(t, "States.Timeout", *history.Events[0].ExecutionTimedOutEventDetails.Error)
+}
@@ -3,14 +3,22 @@ package main
 import (
 	"flag"
 	"fmt"
+	"io/ioutil"
 	"os"
+	"strings"
 	"time"
 
+	"github.com/aws/aws-sdk-go/service/sfn"
 	"github.com/cleardataeng/step/machine"
 
+	"github.com/cleardataeng/step/aws"
 	"github.com/cleardataeng/step/bifrost"
 	"github.com/cleardataeng/step/client"
 	"github.com/cleardataeng/step/deployer"
+	"github.com/cleardataeng/step/execution"
+	"github.com/cleardataeng/step/handler"
+	"github.com/cleardataeng/step/integrations"
+	"github.com/cleardataeng/step/server"
 	"github.com/cleardataeng/step/utils/run"
 	"github.com/cleardataeng/step/utils/to"
 )
@@ -29,6 +37,23 @@ func main() {
 	dotCommand := flag.NewFlagSet("dot", flag.ExitOnError)
 	dotStates := dotCommand.String("states", "{}", "State Machine JSON")
 
+	debugCommand := flag.NewFlagSet("debug", flag.ExitOnError)
+	debugStates := debugCommand.String("states", "{}", "State Machine JSON")
+	debugInput := debugCommand.String("input", "{}", "Execution input JSON")
+	debugBreak := debugCommand.String("break", "", "comma separated States to pause at (default pause at every State)")
+
+	replayCommand := flag.NewFlagSet("replay", flag.ExitOnError)
+	replayStates := replayCommand.String("states", "", "State Machine JSON (default the Step Deployer)")
+	replayExecution := replayCommand.String("execution", "", "execution arn to download the history of")
+	replayHistory := replayCommand.String("history", "", "file with the JSON output of get-execution-history")
+
+	serveCommand := flag.NewFlagSet("serve", flag.ExitOnError)
+	serveAddr := serveCommand.String("addr", ":8083", "address to serve the Step Functions API on")
+	serveLambda := serveCommand.String("lambda", default_name, "lambda name the Step Deployer Tasks are served as")
+	serveRegion := serveCommand.String("region", "us-east-1", "AWS region of the served ARNs")
+	serveAccount := serveCommand.String("account", "000000000000", "AWS account id of the served ARNs")
+	serveMocks := serveCommand.String("mocks", "", "Step Functions Local MockConfigFile for <stateMachineArn>#<TestCase> executions")
+
 	// Other Subcommands
 	bootstrapCommand := flag.NewFlagSet("bootstrap", flag.ExitOnError)
 	deployCommand := flag.NewFlagSet("deploy", flag.ExitOnError)
@@ -67,16 +92,28 @@ func main() {
 		jsonCommand.Parse(os.Args[2:])
 	case "dot":
 		dotCommand.Parse(os.Args[2:])
+	case "debug":
+		debugCommand.Parse(os.Args[2:])
+	case "replay":
+		replayCommand.Parse(os.Args[2:])
+	case "serve":
+		serveCommand.Parse(os.Args[2:])
 	case "bootstrap":
 		bootstrapCommand.Parse(os.Args[2:])
 	case "deploy":
 		deployCommand.Parse(os.Args[2:])
 	default:
-		fmt.Println("Usage of step: step <json|bootstrap|deploy|dot> <args> (No args starts Lambda)")
+		fmt.Println("Usage of step: step <json|bootstrap|deploy|dot|debug|replay|serve> <args> (No args starts Lambda)")
 		fmt.Println("json")
 		jsonCommand.PrintDefaults()
 		fmt.Println("dot")
 		dotCommand.PrintDefaults()
+		fmt.Println("debug")
+		debugCommand.PrintDefaults()
+		fmt.Println("replay")
+		replayCommand.PrintDefaults()
+		fmt.Println("serve")
+		serveCommand.PrintDefaults()
 		fmt.Println("bootstrap")
 		bootstrapCommand.PrintDefaults()
 		fmt.Println("deploy")
@@ -89,6 +126,12 @@ func main() {
 		run.JSON(deployer.StateMachine())
 	} else if dotCommand.Parsed() {
 		run.Dot(machine.FromJSON([]byte(*dotStates)))
+	} else if debugCommand.Parsed() {
+		debugRun(debugStates, debugInput, debugBreak)
+	} else if replayCommand.Parsed() {
+		replayRun(replayStates, replayExecution, replayHistory)
+	} else if serveCommand.Parsed() {
+		serveRun(serveAddr, serveLambda, serveRegion, serveAccount, serveMocks)
 	} else if bootstrapCommand.Parsed() {
 		r := newRelease(
 			bootstrapProject,
@@ -130,6 +173,91 @@ func check(err error) {
 	os.Exit(1)
 }
 
+func debugRun(states *string, input *string, breakpoints *string) {
+	stateMachine, err := machine.FromJSON([]byte(*states))
+	if err == nil {
+		// Tasks have no handlers outside of the Lambda
+		stateMachine.SetDefaultHandler()
+	}
+
+	var names []string
+	if *breakpoints != "" {
+		names = strings.Split(*breakpoints, ",")
+	}
+
+	run.Debug(stateMachine, err)(input, names)
+}
+
+func replayRun(states *string, execution_arn *string, history_file *string) {
+	events, err := replayEvents(execution_arn, history_file)
+	check(err)
+
+	stateMachine, err := deployer.StateMachine()
+	if *states != "" {
+		stateMachine, err = machine.FromJSON([]byte(*states))
+	}
+
+	if err == nil {
+		// Tasks are replayed against the handlers of this Lambda
+		err = stateMachine.SetTaskFnHandlers(deployer.TaskHandlers())
+	}
+
+	run.Replay(stateMachine, err)(events)
+}
+
+// replayEvents reads the history from a file, otherwise downloads the history of the execution
+func replayEvents(execution_arn *string, history_file *string) ([]*sfn.HistoryEvent, error) {
+	if *history_file != "" {
+		raw, err := ioutil.ReadFile(*history_file)
+		if err != nil {
+			return nil, err
+		}
+		return machine.HistoryFromJSON(raw)
+	}
+
+	if *execution_arn == "" {
+		return nil, fmt.Errorf("replay requires -execution or -history")
+	}
+
+	awsc := &aws.Clients{}
+	return (&execution.Execution{ExecutionArn: execution_arn}).GetHistory(awsc.SFNClient(nil, nil, nil))
+}
+
+// serveRun serves the Step Functions API with the Step Deployer created,
+// its Tasks and service integrations run in process
+func serveRun(addr *string, lambda *string, region *string, account_id *string, mocks *string) {
+	taskHandler, err := handler.CreateHandler(deployer.TaskHandlers())
+	check(err)
+
+	resources := machine.NewResources()
+	resources.Region, resources.AccountID, resources.LambdaName = *region, *account_id, *lambda
+	integrations.New().Handle(resources)
+	resources.Handle("*", machine.GoHandlers{*lambda: taskHandler})
+
+	srv := server.New(resources)
+	srv.Region, srv.AccountID = *region, *account_id
+
+	if *mocks != "" {
+		srv.Mocks, err = machine.ParseMockConfigFile(*mocks)
+		check(err)
+	}
+
+	stateMachine, err := deployer.StateMachine()
+	check(err)
+
+	definition, err := to.PrettyJSON(stateMachine)
+	check(err)
+
+	_, err = srv.CreateStateMachine(&sfn.CreateStateMachineInput{
+		Name:       lambda,
+		Definition: to.Strp(definition),
+		RoleArn:    to.Strp(fmt.Sprintf("arn:aws:iam::%v:role/%v", *account_id, *lambda)),
+	})
+	check(err)
+
+	run.Serve(srv, *addr)
+}
+
 func bootstrapRun(release *deployer.Release, zip *string) {
 	err := client.Bootstrap(release, zip)
 	check(err)
@@ -0,0 +1,174 @@
+package run
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
+
+	"github.com/cleardataeng/step/machine"
+	"github.com/cleardataeng/step/utils/is"
+	"github.com/cleardataeng/step/utils/to"
+)
+
+// Step through a State Machine one State at a time
+
+const debugHelp = `Commands:
+  <enter>, n, next    execute the State and pause at the next one
+  c, continue         continue until the next breakpoint
+  b, break <State>    add a breakpoint at a State
+  q, quit             abort the execution
+  h, help             show this help`
+
+// Debug returns a function that executes the state machine pausing before each State
+// breakpoints, if any, are the only States paused at
+func Debug(state_machine *machine.StateMachine, err error) func(input *string, breakpoints []string) {
+	if err != nil {
+		return func(input *string, breakpoints []string) {
+			fmt.Println("ERROR", err)
+			os.Exit(1)
+		}
+	}
+
+	return func(input *string, breakpoints []string) {
+		if is.EmptyStr(input) {
+			input = to.Strp("{}")
+		}
+
+		printWarnings(os.Stderr, state_machine)
+
+		d := newDebugger(os.Stdin, os.Stdout, breakpoints)
+		state_machine.SetHooks(d.hooks())
+
+		exec, err := state_machine.Execute(input)
+		if err != nil {
+			fmt.Println("ERROR", err)
+			os.Exit(1)
+		}
+
+		fmt.Println("Execution Succeeded")
+		fmt.Println(exec.OutputJSON)
+		os.Exit(0)
+	}
+}
+
+type debugger struct {
+	mu sync.Mutex // Parallel Branches call hooks concurrently
+
+	in  *bufio.Scanner
+	out io.Writer
+
+	stepping    bool
+	breakpoints map[string]bool
+}
+
+func newDebugger(in io.Reader, out io.Writer, breakpoints []string) *debugger {
+	d := &debugger{
+		in:          bufio.NewScanner(in),
+		out:         out,
+		stepping:    len(breakpoints) == 0,
+		breakpoints: map[string]bool{},
+	}
+
+	for _, b := range breakpoints {
+		d.breakpoints[b] = true
+	}
+
+	return d
+}
+
+func (d *debugger) hooks() *machine.Hooks {
+	return &machine.Hooks{
+		BeforeState: d.beforeState,
+		AfterState: func(_ context.Context, e *machine.StateEvent) error {
+			next := "End"
+			if e.Next != nil {
+				next = *e.Next
+			}
+			d.printf("  Output: %v\n  Next: %v\n", d.json(e.Output), next)
+			return nil
+		},
+		OnError: func(_ context.Context, e *machine.StateEvent) error {
+			d.printf("  Error: %v\n", e.Error)
+			return nil
+		},
+		OnRetry: func(_ context.Context, e *machine.StateEvent) error {
+			d.printf("  Retry %v: %v\n", e.Attempt, e.Error)
+			return nil
+		},
+		OnCatch: func(_ context.Context, e *machine.StateEvent) error {
+			d.printf("  Caught: %v\n  Next: %v\n", e.Error, *e.Next)
+			return nil
+		},
+		OnStage: func(_ context.Context, e *machine.StageEvent) {
+			d.printf("  %v: %v\n", e.Stage, d.json(e.Value))
+		},
+	}
+}
+
+func (d *debugger) beforeState(_ context.Context, e *machine.StateEvent) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	fmt.Fprintf(d.out, "==> %v (%v)\n  Input: %v\n", e.Name, e.Type, d.json(e.Input))
+
+	if !d.stepping && !d.breakpoints[e.Name] {
+		return nil
+	}
+
+	for {
+		fmt.Fprint(d.out, "(step) ")
+
+		if !d.in.Scan() {
+			// No more input so run to the end
+			d.stepping = false
+			d.breakpoints = map[string]bool{}
+			fmt.Fprintln(d.out)
+			return nil
+		}
+
+		fields := strings.Fields(d.in.Text())
+		command := ""
+		if len(fields) > 0 {
+			command = fields[0]
+		}
+
+		switch command {
+		case "", "n", "next":
+			d.stepping = true
+			return nil
+		case "c", "continue":
+			d.stepping = false
+			return nil
+		case "b", "break":
+			if len(fields) < 2 {
+				fmt.Fprintln(d.out, "break requires a State name")
+				continue
+			}
+			name := strings.Join(fields[1:], " ")
+			d.breakpoints[name] = true
+			fmt.Fprintf(d.out, "Breakpoint at %v\n", name)
+		case "q", "quit":
+			return fmt.Errorf("Debugger Quit")
+		default:
+			fmt.Fprintln(d.out, debugHelp)
+		}
+	}
+}
+
+func (d *debugger) printf(format string, a ...interface{}) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	fmt.Fprintf(d.out, format, a...)
+}
+
+func (d *debugger) json(v interface{}) string {
+	str, err := to.PrettyJSON(v)
+	if err != nil {
+		return fmt.Sprintf("%v", v)
+	}
+	return strings.Replace(str, "\n", "\n  ", -1)
+}
@@ -0,0 +1,87 @@
+package run
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/cleardataeng/step/machine"
+	"github.com/stretchr/testify/assert"
+)
+
+// debugRun executes a State Machine of the Pass States A, B and C with the debugger reading script,
+// returning the States it paused at, its output and the Execution error
+func debugRun(t *testing.T, script string, breakpoints []string) ([]string, string, error) {
+	sm, err := machine.FromJSON([]byte(`{
+    "StartAt": "A",
+    "States": {
+      "A": {"Type": "Pass", "Result": "a", "ResultPath": "$.a", "Next": "B"},
+      "B": {"Type": "Pass", "Result": "b", "ResultPath": "$.b", "Next": "C"},
+      "C": {"Type": "Pass", "Result": "c", "ResultPath": "$.c", "End": true}
+    }
+  }`))
+	assert.NoError(t, err)
+
+	var out bytes.Buffer
+	sm.SetHooks(newDebugger(strings.NewReader(script), &out, breakpoints).hooks())
+
+	_, err = sm.Execute(map[string]interface{}{})
+
+	// A State is paused at if the prompt is printed before the next State
+	paused := []string{}
+	for _, entered := range strings.Split(out.String(), "==> ")[1:] {
+		if strings.Contains(entered, "(step) ") {
+			paused = append(paused, strings.Fields(entered)[0])
+		}
+	}
+
+	return paused, out.String(), err
+}
+
+func Test_Debug_Step(t *testing.T) {
+	paused, out, err := debugRun(t, "\nn\nnext\n", nil)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"A", "B", "C"}, paused)
+
+	assert.Contains(t, out, "==> A (Pass)\n  Input: {}\n(step) ")
+	assert.Contains(t, out, "  Next: B\n")
+	assert.Contains(t, out, "  Next: End\n")
+}
+
+func Test_Debug_Continue(t *testing.T) {
+	paused, _, err := debugRun(t, "c\n", nil)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"A"}, paused)
+}
+
+func Test_Debug_Breakpoints(t *testing.T) {
+	paused, out, err := debugRun(t, "c\n", []string{"B"})
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"B"}, paused)
+	assert.Contains(t, out, "==> A (Pass)")
+
+	// A breakpoint added while paused
+	paused, out, err = debugRun(t, "b C\ncontinue\nc\n", nil)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"A", "C"}, paused)
+	assert.Contains(t, out, "Breakpoint at C\n")
+}
+
+func Test_Debug_Quit(t *testing.T) {
+	paused, out, err := debugRun(t, "\nq\n", nil)
+	assert.Error(t, err)
+	assert.Regexp(t, "Debugger Quit", err.Error())
+	assert.Equal(t, []string{"A", "B"}, paused)
+	assert.NotContains(t, out, "==> C")
+}
+
+func Test_Debug_Help_And_EOF(t *testing.T) {
+	paused, out, err := debugRun(t, "unknown\nb\n", nil)
+	assert.NoError(t, err)
+
+	// Out of input the execution runs to the end
+	assert.Equal(t, []string{"A"}, paused)
+	assert.Contains(t, out, debugHelp)
+	assert.Contains(t, out, "break requires a State name\n")
+	assert.Contains(t, out, "==> C (Pass)")
+}
@@ -18,6 +18,8 @@ func Dot(stateMachine *machine.StateMachine, err error) {
 		os.Exit(1)
 	}
 
+	printWarnings(os.Stderr, stateMachine)
+
 	dotStr := toDot(stateMachine)
 	fmt.Println(dotStr)
 	os.Exit(0)
@@ -74,6 +76,18 @@ func orderStates(start string, states map[string]machine.State) []machine.State
 				}
 			}
 
+			if stateNode.Next != nil {
+				connectedStates = append(connectedStates, states[*stateNode.Next])
+			}
+		case *machine.ParallelState:
+			stateNode := stateNode.(*machine.ParallelState)
+
+			if stateNode.Catch != nil {
+				for _, catch := range stateNode.Catch {
+					connectedStates = append(connectedStates, states[*catch.Next])
+				}
+			}
+
 			if stateNode.Next != nil {
 				connectedStates = append(connectedStates, states[*stateNode.Next])
 			}
@@ -136,6 +150,23 @@ func processState(stateNode machine.State) string {
 			lines = append(lines, fmt.Sprintf(`%q -> %q [weight=100];`, name, *stateNode.Next))
 		}
 
+		if stateNode.End != nil {
+			lines = append(lines, fmt.Sprintf(`%q -> _End;`, name))
+		}
+	case *machine.ParallelState:
+		stateNode := stateNode.(*machine.ParallelState)
+		lines = append(lines, fmt.Sprintf(`%q [shape=box3d, fillcolor="#FBFBFB"];`, name))
+
+		if stateNode.Catch != nil {
+			for _, catch := range stateNode.Catch {
+				lines = append(lines, fmt.Sprintf(`%q -> %q [color="#949494", style=solid];`, name, *catch.Next))
+			}
+		}
+
+		if stateNode.Next != nil {
+			lines = append(lines, fmt.Sprintf(`%q -> %q [weight=100];`, name, *stateNode.Next))
+		}
+
 		if stateNode.End != nil {
 			lines = append(lines, fmt.Sprintf(`%q -> _End;`, name))
 		}
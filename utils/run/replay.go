@@ -0,0 +1,43 @@
+package run
+
+import (
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/service/sfn"
+	"github.com/cleardataeng/step/machine"
+)
+
+// Replay returns a function that replays a recorded execution history against the state machine,
+// it prints the first divergence and exits 1 if the local execution differs from the recording
+func Replay(state_machine *machine.StateMachine, err error) func(events []*sfn.HistoryEvent) {
+	if err != nil {
+		return func(events []*sfn.HistoryEvent) {
+			fmt.Println("ERROR", err)
+			os.Exit(1)
+		}
+	}
+
+	return func(events []*sfn.HistoryEvent) {
+		replay, err := state_machine.Replay(events)
+		if err != nil {
+			fmt.Println("ERROR", err)
+			os.Exit(1)
+		}
+
+		fmt.Printf("Replayed %v events\n", len(events))
+		fmt.Printf("Path: %v\n", strings.Join(replay.Execution.Path(), " -> "))
+		if replay.Execution.Error != nil {
+			fmt.Printf("Error: %v\n", replay.Execution.Error)
+		}
+
+		if replay.Divergence != nil {
+			fmt.Println(replay.Divergence)
+			os.Exit(1)
+		}
+
+		fmt.Println("No Divergence")
+		os.Exit(0)
+	}
+}
@@ -3,6 +3,7 @@ package run
 
 import (
 	"fmt"
+	"io"
 	"os"
 
 	"github.com/aws/aws-lambda-go/lambda"
@@ -24,6 +25,7 @@ func Exec(state_machine *machine.StateMachine, err error) func(*string) {
 	}
 
 	return func(input *string) {
+		printWarnings(os.Stderr, state_machine)
 
 		if is.EmptyStr(input) {
 			input = to.Strp("{}")
@@ -49,6 +51,8 @@ func JSON(state_machine *machine.StateMachine, err error) {
 		os.Exit(1)
 	}
 
+	printWarnings(os.Stderr, state_machine)
+
 	json, err := to.PrettyJSON(state_machine)
 
 	if err != nil {
@@ -60,6 +64,14 @@ func JSON(state_machine *machine.StateMachine, err error) {
 	os.Exit(0)
 }
 
+// printWarnings prints the problems that do not stop a state machine from running,
+// e.g. a Choice State without a Default
+func printWarnings(w io.Writer, state_machine *machine.StateMachine) {
+	for _, warning := range state_machine.Warnings() {
+		fmt.Fprintln(w, "WARNING", warning)
+	}
+}
+
 // LambdaTasks takes task functions and and executes as a lambda
 func LambdaTasks(task_functions *handler.TaskHandlers) {
 	handler, err := handler.CreateHandler(task_functions)
@@ -0,0 +1,31 @@
+package run
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/cleardataeng/step/machine"
+	"github.com/cleardataeng/step/utils/to"
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_PrintWarnings(t *testing.T) {
+	sm, err := machine.FromJSON([]byte(`{
+    "StartAt": "Choice",
+    "States": {
+      "Choice": {"Type": "Choice", "Choices": [{"Variable": "$.a", "IsPresent": true, "Next": "Done"}]},
+      "Done": {"Type": "Succeed"}
+    }
+  }`))
+	assert.NoError(t, err)
+
+	var out bytes.Buffer
+	printWarnings(&out, sm)
+	assert.Equal(t, "WARNING Choice.Default: Choice State has no Default\n", out.String())
+
+	// No warnings prints nothing
+	out.Reset()
+	sm.States["Choice"].(*machine.ChoiceState).Default = to.Strp("Done")
+	printWarnings(&out, sm)
+	assert.Equal(t, "", out.String())
+}
@@ -0,0 +1,19 @@
+package run
+
+import (
+	"fmt"
+	"net/http"
+	"os"
+
+	"github.com/cleardataeng/step/server"
+)
+
+// Serve serves the Step Functions API on addr, SDK clients use http://<addr> as their endpoint
+func Serve(srv *server.Server, addr string) {
+	fmt.Printf("Serving Step Functions on %v\n", addr)
+
+	err := http.ListenAndServe(addr, srv)
+
+	fmt.Println("ERROR", err)
+	os.Exit(1)
+}
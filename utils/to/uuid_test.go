@@ -0,0 +1,13 @@
+package to
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_to_UUID(t *testing.T) {
+	uuid := UUID()
+	assert.Regexp(t, "^[0-9a-f]{8}-[0-9a-f]{4}-4[0-9a-f]{3}-[89ab][0-9a-f]{3}-[0-9a-f]{12}$", uuid)
+	assert.NotEqual(t, uuid, UUID())
+}